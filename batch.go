@@ -0,0 +1,142 @@
+package mbserver
+
+import "errors"
+
+// ErrInputsNotWritable is returned by ApplyUpdates for the updates of the
+// discrete inputs or input registers when the Store of the server does
+// not implement InputStore.
+var ErrInputsNotWritable = errors.New("mbserver: the store can not write the discrete inputs and input registers")
+
+// Table is one of the four tables of the Modbus memory of a server.
+type Table int
+
+const (
+	CoilsTable Table = iota
+	DiscreteInputsTable
+	InputRegistersTable
+	HoldingRegistersTable
+)
+
+// RegisterUpdate is a change of the values of the Modbus memory of a
+// server, starting at the address given, applied together with the other
+// updates of a batch by ApplyUpdates.
+type RegisterUpdate struct {
+	Table   Table
+	Address int
+	// Bits holds the values of the coils or discrete inputs, with one
+	// byte for each coil, which is 1 when the coil is on.
+	Bits []byte
+	// Words holds the values of the input or holding registers.
+	Words []uint16
+}
+
+// ApplyUpdates writes all the updates given to the Modbus memory of the
+// server while holding the lock of the server once, so a simulation loop
+// updating many registers at a high rate does not take the lock for each
+// of them, and the clients see either none or all of the changes. The
+// updates are written through the Store of the server when set, where
+// the discrete inputs and input registers need a store implementing
+// InputStore. If any of the updates is outside the memory nothing is
+// written, and IllegalDataAddress is returned. With a Store the addresses
+// are checked by the store as the updates are written in order, and the
+// first error is returned. The UpdateHook is called once with all the
+// updates after the lock is released. The server must not be locked by
+// the caller.
+func (s *Server) ApplyUpdates(updates []RegisterUpdate) error {
+	s.mu.Lock()
+	err := s.applyUpdates(updates)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if s.UpdateHook != nil && len(updates) > 0 {
+		s.UpdateHook(updates)
+	}
+
+	return nil
+}
+
+// ApplyUpdatesLocked writes all the updates given like ApplyUpdates, but
+// with the server locked by the caller with Lock, so the updates of
+// several servers can be applied while holding the locks of all of them.
+// The UpdateHook is not called, which is left to the caller after the
+// lock is released.
+func (s *Server) ApplyUpdatesLocked(updates []RegisterUpdate) error {
+	return s.applyUpdates(updates)
+}
+
+// CheckUpdates returns IllegalDataAddress if any of the updates given is
+// outside the Modbus memory of the server, so a batch spanning several
+// servers can be checked before any of it is written. With a Store only
+// the tables are checked, and ErrInputsNotWritable is returned for the
+// updates of the discrete inputs or input registers when the store does
+// not implement InputStore. The server must be locked by the caller with
+// Lock.
+func (s *Server) CheckUpdates(updates []RegisterUpdate) error {
+	if s.Store != nil {
+		_, inputs := s.Store.(InputStore)
+		for _, u := range updates {
+			switch u.Table {
+			case CoilsTable, HoldingRegistersTable:
+			case DiscreteInputsTable, InputRegistersTable:
+				if !inputs {
+					return ErrInputsNotWritable
+				}
+			default:
+				return IllegalDataAddress
+			}
+		}
+		return nil
+	}
+
+	for _, u := range updates {
+		var n, size int
+		switch u.Table {
+		case CoilsTable:
+			n, size = len(u.Bits), cap(s.Coils)
+		case DiscreteInputsTable:
+			n, size = len(u.Bits), cap(s.DiscreteInputs)
+		case InputRegistersTable:
+			n, size = len(u.Words), cap(s.InputRegisters)
+		case HoldingRegistersTable:
+			n, size = len(u.Words), cap(s.HoldingRegisters)
+		default:
+			return IllegalDataAddress
+		}
+		if u.Address < 0 || u.Address+n > size {
+			return IllegalDataAddress
+		}
+	}
+
+	return nil
+}
+
+// applyUpdates will check that all the updates are within the memory, and
+// then write them through the store of the server. The server must be
+// locked.
+func (s *Server) applyUpdates(updates []RegisterUpdate) error {
+	if err := s.CheckUpdates(updates); err != nil {
+		return err
+	}
+
+	store := s.store()
+	for _, u := range updates {
+		var err error
+		switch u.Table {
+		case CoilsTable:
+			err = store.WriteCoils(u.Address, u.Bits)
+		case DiscreteInputsTable:
+			err = store.(InputStore).WriteDiscreteInputs(u.Address, u.Bits)
+		case InputRegistersTable:
+			err = store.(InputStore).WriteInputRegisters(u.Address, u.Words)
+		case HoldingRegistersTable:
+			err = store.WriteHoldingRegisters(u.Address, u.Words)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package mbserver
+
+import "testing"
+
+func TestApplyUpdates(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	var batches [][]RegisterUpdate
+	s.UpdateHook = func(updates []RegisterUpdate) {
+		batches = append(batches, updates)
+	}
+
+	err := s.ApplyUpdates([]RegisterUpdate{
+		{Table: CoilsTable, Address: 3, Bits: []byte{1, 0, 1}},
+		{Table: DiscreteInputsTable, Address: 7, Bits: []byte{1}},
+		{Table: InputRegistersTable, Address: 10, Words: []uint16{1, 2}},
+		{Table: HoldingRegistersTable, Address: 20, Words: []uint16{3}},
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if !isEqual([]byte{1, 0, 1}, s.Coils[3:6]) || s.DiscreteInputs[7] != 1 {
+		t.Errorf("expected the coils and discrete inputs written, got %v %v", s.Coils[3:6], s.DiscreteInputs[7])
+	}
+	if !isEqual([]uint16{1, 2}, s.InputRegisters[10:12]) || s.HoldingRegisters[20] != 3 {
+		t.Errorf("expected the registers written, got %v %v", s.InputRegisters[10:12], s.HoldingRegisters[20])
+	}
+	if len(batches) != 1 || len(batches[0]) != 4 {
+		t.Errorf("expected 1 notification with 4 updates, got %v", batches)
+	}
+
+	// Nothing is written when an update is outside the memory.
+	err = s.ApplyUpdates([]RegisterUpdate{
+		{Table: HoldingRegistersTable, Address: 20, Words: []uint16{4}},
+		{Table: HoldingRegistersTable, Address: 65535, Words: []uint16{1, 2}},
+	})
+	if err != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", err)
+	}
+	if s.HoldingRegisters[20] != 3 {
+		t.Errorf("expected 3, got %v", s.HoldingRegisters[20])
+	}
+	if len(batches) != 1 {
+		t.Errorf("expected no notification for a failed batch, got %v", len(batches))
+	}
+}
+
+func TestApplyUpdatesLocked(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	hooked := false
+	s.UpdateHook = func(updates []RegisterUpdate) {
+		hooked = true
+	}
+
+	outside := []RegisterUpdate{{Table: HoldingRegistersTable, Address: 65535, Words: []uint16{1, 2}}}
+	inside := []RegisterUpdate{{Table: HoldingRegistersTable, Address: 20, Words: []uint16{5}}}
+
+	s.Lock()
+	errOutside := s.CheckUpdates(outside)
+	errInside := s.CheckUpdates(inside)
+	err := s.ApplyUpdatesLocked(inside)
+	s.Unlock()
+
+	if errOutside != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", errOutside)
+	}
+	if errInside != nil || err != nil {
+		t.Errorf("expected nil, got %v and %v", errInside, err)
+	}
+	if s.HoldingRegisters[20] != 5 {
+		t.Errorf("expected 5, got %v", s.HoldingRegisters[20])
+	}
+	if hooked {
+		t.Errorf("expected the hook not to be called")
+	}
+}
+
+func TestApplyUpdatesStore(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	store := &sparseStore{holding: make(map[int]uint16)}
+	s.Store = store
+
+	// The updates are written to the store, and not to the memory.
+	err := s.ApplyUpdates([]RegisterUpdate{{Table: HoldingRegistersTable, Address: 1000, Words: []uint16{7, 8}}})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if store.holding[1000] != 7 || store.holding[1001] != 8 || s.HoldingRegisters[1000] != 0 {
+		t.Errorf("expected the update in the store only, got %v", store.holding)
+	}
+
+	// The errors of the store are returned.
+	err = s.ApplyUpdates([]RegisterUpdate{{Table: CoilsTable, Address: 3, Bits: []byte{1}}})
+	if err != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", err)
+	}
+
+	// The input registers can not be written to a store without
+	// InputStore.
+	err = s.ApplyUpdates([]RegisterUpdate{{Table: InputRegistersTable, Address: 3, Words: []uint16{1}}})
+	if err != ErrInputsNotWritable {
+		t.Errorf("expected ErrInputsNotWritable, got %v", err)
+	}
+}
+
+func BenchmarkApplyUpdates(b *testing.B) {
+	s := NewServer()
+	defer s.Close()
+
+	updates := make([]RegisterUpdate, 100)
+	for i := range updates {
+		updates[i] = RegisterUpdate{Table: HoldingRegistersTable, Address: i * 2, Words: []uint16{uint16(i), 0}}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ApplyUpdates(updates)
+	}
+}
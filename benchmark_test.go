@@ -2,7 +2,9 @@ package mbserver
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"testing"
 	"time"
 
@@ -136,6 +138,88 @@ func BenchmarkModbusRead125HoldingRegisters(b *testing.B) {
 	}
 }
 
+// benchmarkHandle will benchmark the handling of the request given by the
+// handler, without the network, writing the responses to io.Discard.
+func benchmarkHandle(b *testing.B, function uint8, setData func(Framer)) {
+	s := NewServer()
+	defer s.Close()
+
+	frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: function}
+	setData(frame)
+	request := &Request{frame: frame}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.mu.Lock()
+		response := s.handle(request)
+		s.mu.Unlock()
+		s.write(io.Discard, response)
+	}
+}
+
+func BenchmarkHandleRead125HoldingRegisters(b *testing.B) {
+	benchmarkHandle(b, 3, func(frame Framer) {
+		SetDataWithRegisterAndNumber(frame, 1, 125)
+	})
+}
+
+func BenchmarkHandleRead2000Coils(b *testing.B) {
+	benchmarkHandle(b, 1, func(frame Framer) {
+		SetDataWithRegisterAndNumber(frame, 0, 2000)
+	})
+}
+
+func BenchmarkHandleWriteHoldingRegister(b *testing.B) {
+	benchmarkHandle(b, 6, func(frame Framer) {
+		SetDataWithRegisterAndNumber(frame, 1, 7)
+	})
+}
+
+func BenchmarkHandleWrite123MultipleRegisters(b *testing.B) {
+	benchmarkHandle(b, 16, func(frame Framer) {
+		SetDataWithRegisterAndNumberAndValues(frame, 0, 123, make([]uint16, 123))
+	})
+}
+
+// BenchmarkTCPRead125HoldingRegisters will benchmark the requests of a
+// TCP connection, with a client writing the request and reading the
+// response without allocating, so the allocations are the ones of the
+// server.
+func BenchmarkTCPRead125HoldingRegisters(b *testing.B) {
+	s := NewServer()
+	defer s.Close()
+	addr := getFreePort()
+	err := s.ListenTCP(addr)
+	if err != nil {
+		b.Fatalf("expected nil, got %v\n", err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		b.Fatalf("expected nil, got %v\n", err)
+	}
+	defer conn.Close()
+
+	frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 3}
+	SetDataWithRegisterAndNumber(frame, 1, 125)
+	request := frame.Bytes()
+	response := make([]byte, 9+125*2)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := conn.Write(request)
+		if err != nil {
+			b.Fatalf("expected nil, got %v\n", err)
+		}
+		_, err = io.ReadFull(conn, response)
+		if err != nil {
+			b.Fatalf("expected nil, got %v\n", err)
+		}
+	}
+}
+
 // Start a Modbus server and use a client to write to and read from the serer.
 func Example() {
 	// Start the server.
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// auditChange is a change of a register made through the HTTP server.
+// The values are given as text, so NaN and infinity can be recorded.
+type auditChange struct {
+	Device   string `json:"device,omitempty"`
+	Unit     int    `json:"unit,omitempty"`
+	Register string `json:"register"`
+	Address  int    `json:"address"`
+	Type     string `json:"type"`
+	Old      string `json:"old"`
+	New      string `json:"new"`
+}
+
+// auditRecord is a request to the HTTP server changing anything, written
+// as a line of JSON to the audit log.
+type auditRecord struct {
+	Time time.Time `json:"time"`
+	// Interface is the listener the request came in on, which is http,
+	// https or unix.
+	Interface string `json:"interface"`
+	Remote    string `json:"remote,omitempty"`
+	// Who is the user of the basic auth, or the role of the token used.
+	// It is empty when the HTTP server has no auth.
+	Who       string        `json:"who,omitempty"`
+	Role      string        `json:"role,omitempty"`
+	UserAgent string        `json:"userAgent,omitempty"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Query     string        `json:"query,omitempty"`
+	Status    int           `json:"status"`
+	Changes   []auditChange `json:"changes,omitempty"`
+}
+
+// auditKey is the key of the audit record in the context of a request.
+type auditKey struct{}
+
+// auditFromContext will return the audit record of the request, or nil
+// when the request is not audited.
+func auditFromContext(ctx context.Context) *auditRecord {
+	rec, _ := ctx.Value(auditKey{}).(*auditRecord)
+	return rec
+}
+
+// auditLog writes a record of every request to the HTTP server changing
+// anything to an append only file, for traceability of the changes made
+// during a test.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newAuditLog(w io.Writer) *auditLog {
+	return &auditLog{w: w}
+}
+
+// write will write the record as a line of JSON. The characters of the
+// query like & are not escaped, so the log is easy to read.
+func (al *auditLog) write(rec *auditRecord) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(rec)
+	if err != nil {
+		log.Printf("error: audit: %v\n", err)
+		return
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	_, err = al.w.Write(buf.Bytes())
+	if err != nil {
+		log.Printf("error: audit: %v\n", err)
+	}
+}
+
+// statusRecorder records the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// handler will return a handler recording the requests other than GET
+// and HEAD to next in the audit log, including the requests that are not
+// authorized. The interface is the name of the listener of the handler.
+// Auditing is disabled when the audit log is nil.
+func (al *auditLog) handler(next http.Handler, iface string) http.Handler {
+	if al == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &auditRecord{
+			Time:      time.Now(),
+			Interface: iface,
+			Remote:    r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Query:     r.URL.RawQuery,
+		}
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sr, r.WithContext(context.WithValue(r.Context(), auditKey{}, rec)))
+		rec.Status = sr.status
+
+		al.write(rec)
+	})
+}
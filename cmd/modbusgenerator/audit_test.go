@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestAuditLog(t *testing.T) {
+	serv := mbserver.NewServer()
+	serv.HoldingRegisters[199] = 3
+	rc := &registerControl{devices: []*device{{serv: serv}}, addrOffset: -1}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/registers", rc.handleRegisters)
+	auth, _ := newHTTPAuth("", "admin:pass", "", "viewer:pass")
+
+	var buf bytes.Buffer
+	al := newAuditLog(&buf)
+	handler := al.handler(auth.handler(mux), "unix")
+
+	for _, v := range []struct {
+		method string
+		user   string
+	}{
+		{"GET", "admin"},
+		{"POST", "admin"},
+		{"POST", "viewer"},
+	} {
+		req := httptest.NewRequest(v.method, "/registers?register=holding&address=200&value=5", nil)
+		req.SetBasicAuth(v.user, "pass")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The read is not recorded, but the denied write is.
+	var records []auditRecord
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec auditRecord
+		err := dec.Decode(&rec)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %v", records)
+	}
+
+	rec := records[0]
+	if rec.Who != "admin" || rec.Role != "admin" || rec.Interface != "unix" || rec.Status != 200 {
+		t.Errorf("expected admin on unix with 200, got %+v", rec)
+	}
+	expect := []auditChange{{Register: "holding", Address: 200, Type: "uint16BigEndian", Old: "3", New: "5"}}
+	if !isEqual(expect, rec.Changes) {
+		t.Errorf("expected %v, got %v", expect, rec.Changes)
+	}
+
+	rec = records[1]
+	if rec.Who != "viewer" || rec.Status != 403 || len(rec.Changes) != 0 {
+		t.Errorf("expected viewer denied with 403, got %+v", rec)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// role is the access a credential gives to the HTTP server.
+type role int
+
+const (
+	// noRole is given to requests without valid credentials.
+	noRole role = iota
+	// viewerRole can read the values and the statistics, but not change
+	// anything.
+	viewerRole
+	// adminRole can also change the values and the state of the
+	// simulation.
+	adminRole
+)
+
+// String will return the name of the role.
+func (r role) String() string {
+	switch r {
+	case viewerRole:
+		return "viewer"
+	case adminRole:
+		return "admin"
+	}
+	return "none"
+}
+
+// credential is a bearer token or a basic auth user and password giving
+// a role.
+type credential struct {
+	role     role
+	token    string
+	user     string
+	password string
+}
+
+// httpAuth protects the endpoints of the HTTP server with bearer tokens
+// and/or basic auth, where each credential gives either the viewer or
+// the admin role.
+type httpAuth struct {
+	credentials []credential
+}
+
+// unauthenticatedPaths is the paths that can be used without credentials,
+// since they are used as probes by orchestrators and tell nothing about
+// the registers.
+var unauthenticatedPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// newHTTPAuth will return the auth for the admin token and basic auth
+// given as user:password, and the viewer token and basic auth. Auth is
+// disabled when all are empty.
+func newHTTPAuth(adminToken string, adminBasicAuth string, viewerToken string, viewerBasicAuth string) (*httpAuth, error) {
+	a := &httpAuth{}
+	for _, v := range []struct {
+		role      role
+		token     string
+		basicAuth string
+	}{
+		{adminRole, adminToken, adminBasicAuth},
+		{viewerRole, viewerToken, viewerBasicAuth},
+	} {
+		if v.token != "" {
+			a.credentials = append(a.credentials, credential{role: v.role, token: v.token})
+		}
+		if v.basicAuth != "" {
+			user, password, ok := strings.Cut(v.basicAuth, ":")
+			if !ok || user == "" || password == "" {
+				return nil, fmt.Errorf("basic auth of the %v must be given as user:password", v.role)
+			}
+			a.credentials = append(a.credentials, credential{role: v.role, user: user, password: password})
+		}
+	}
+
+	return a, nil
+}
+
+// enabled will return true if credentials are needed for the requests.
+func (a *httpAuth) enabled() bool {
+	return len(a.credentials) > 0
+}
+
+// credential will return the credential matching the request, or nil if
+// none match. The admin credentials are checked first, so a credential
+// given both roles is admin.
+func (a *httpAuth) credential(r *http.Request) *credential {
+	token, hasToken := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	user, password, hasBasicAuth := r.BasicAuth()
+
+	for i, c := range a.credentials {
+		if c.token != "" && hasToken && secureEqual(token, c.token) {
+			return &a.credentials[i]
+		}
+		if c.user != "" && hasBasicAuth && secureEqual(user, c.user) && secureEqual(password, c.password) {
+			return &a.credentials[i]
+		}
+	}
+
+	return nil
+}
+
+// who will return the user of the credential, or the role of a token.
+func (c *credential) who() string {
+	if c.user != "" {
+		return c.user
+	}
+	return c.role.String() + " token"
+}
+
+// secureEqual will compare the strings in constant time, so the time of
+// the comparison do not tell how much of a credential is right.
+func secureEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// handler will return a handler answering the requests without valid
+// credentials with 401 Unauthorized, and the requests changing anything
+// from a viewer with 403 Forbidden, and passing the rest to next. All the
+// endpoints changing anything use POST, so the viewers are only allowed
+// GET and HEAD requests.
+func (a *httpAuth) handler(next http.Handler) http.Handler {
+	if !a.enabled() {
+		return next
+	}
+
+	hasBasicAuth := false
+	for _, c := range a.credentials {
+		if c.user != "" {
+			hasBasicAuth = true
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unauthenticatedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		c := a.credential(r)
+		rl := noRole
+		if c != nil {
+			rl = c.role
+		}
+		if rec := auditFromContext(r.Context()); rec != nil {
+			rec.Role = rl.String()
+			if c != nil {
+				rec.Who = c.who()
+			} else if user, _, ok := r.BasicAuth(); ok {
+				rec.Who = user
+			}
+		}
+
+		switch rl {
+		case noRole:
+			if hasBasicAuth {
+				w.Header().Set("WWW-Authenticate", `Basic realm="modbusgenerator"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		case viewerRole:
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				http.Error(w, "forbidden, the viewer role can only read", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loadTLSConfig will return the TLS config with the certificate and key
+// given, or nil when no certificate is given.
+func loadTLSConfig(certFile string, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both the certificate and the key must be given for TLS")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
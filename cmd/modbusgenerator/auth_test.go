@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestHTTPAuth(t *testing.T) {
+	auth, err := newHTTPAuth("secret", "admin:pass", "view", "viewer:pass")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	handler := auth.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		method string
+		path   string
+		header string
+		user   string
+		code   int
+	}{
+		{"GET", "/registers", "", "", 401},
+		{"GET", "/registers", "Bearer secret", "", 200},
+		{"GET", "/registers", "Bearer wrong", "", 401},
+		{"GET", "/registers", "", "admin:pass", 200},
+		{"GET", "/registers", "", "admin:wrong", 401},
+		{"POST", "/registers", "Bearer secret", "", 200},
+		{"POST", "/registers", "", "admin:pass", 200},
+		// The viewers can only read.
+		{"GET", "/registers", "Bearer view", "", 200},
+		{"GET", "/registers", "", "viewer:pass", 200},
+		{"POST", "/registers", "Bearer view", "", 403},
+		{"POST", "/units/offline", "", "viewer:pass", 403},
+		// The health probes are always allowed.
+		{"GET", "/healthz", "", "", 200},
+		{"GET", "/readyz", "", "", 200},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		if tt.user != "" {
+			user, password, _ := strings.Cut(tt.user, ":")
+			req.SetBasicAuth(user, password)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("%v %v %q %q: expected %v, got %v", tt.method, tt.path, tt.header, tt.user, tt.code, rec.Code)
+		}
+	}
+
+	for _, v := range []string{"admin", ":pass", "admin:"} {
+		_, err := newHTTPAuth("", v, "", "")
+		if err == nil {
+			t.Errorf("expected error for basic auth %q, got nil", v)
+		}
+	}
+}
+
+// writeTestCert will write a self signed certificate for 127.0.0.1 and
+// its key to the directory given, and return the filenames.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "modbusgenerator test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return certFile, keyFile
+}
+
+func TestHTTPServerTLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+	tlsConfig, err := loadTLSConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	_, err = loadTLSConfig(certFile, "")
+	if err == nil {
+		t.Errorf("expected error without a key, got nil")
+	}
+
+	rc := &registerControl{devices: []*device{{serv: mbserver.NewServer()}}, addrOffset: -1}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/registers", rc.handleRegisters)
+	auth, _ := newHTTPAuth("secret", "", "", "")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	srv, err := startHTTPServer(addr, auth.handler(mux), tlsConfig)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer srv.Close()
+
+	q := url.Values{"register": {"holding"}, "address": {"100"}}
+	var values []registerValue
+
+	c, err := newCtlClient("https://"+addr, "secret", "", certFile)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	err = c.request(http.MethodGet, "/registers", q, &values)
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+
+	// Without the token the request is not authorized.
+	c, _ = newCtlClient("https://"+addr, "", "", certFile)
+	err = c.request(http.MethodGet, "/registers", q, &values)
+	if err == nil {
+		t.Errorf("expected error without the token, got nil")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// bannerLength is the number of input registers of the banner region.
+const bannerLength = 16
+
+// bannerVersionWords is the number of registers holding the version as
+// text at the start of the banner region.
+const bannerVersionWords = 8
+
+// configHash will return a hash of the registers of the server and the
+// units loaded from the config files and the blocks config of the profile,
+// so a client can check which config the simulator is running with.
+func configHash(serv *mbserver.Server, p profile) [sha256.Size]byte {
+	h := sha256.New()
+
+	write := func(s *mbserver.Server) {
+		h.Write(s.Coils[:cap(s.Coils)])
+		h.Write(s.DiscreteInputs[:cap(s.DiscreteInputs)])
+		binary.Write(h, binary.BigEndian, s.InputRegisters[:cap(s.InputRegisters)])
+		binary.Write(h, binary.BigEndian, s.HoldingRegisters[:cap(s.HoldingRegisters)])
+	}
+	serv.Lock()
+	write(serv)
+	serv.Unlock()
+	for _, id := range p.unitIDs() {
+		h.Write([]byte{id})
+		write(p.units[id])
+	}
+
+	js, _ := json.Marshal(p.blocksRawData)
+	h.Write(js)
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// bannerWords will return the registers of the banner region, with the
+// version as text, the build time as 32-bit unix seconds, the first 64
+// bits of the config hash, and the uptime in seconds.
+func bannerWords(bi buildInfo, hash [sha256.Size]byte, uptime time.Duration) []uint16 {
+	words := make([]uint16, bannerLength)
+
+	text := []byte(bi.version)
+	if len(text) > bannerVersionWords*2 {
+		text = text[:bannerVersionWords*2]
+	}
+	for i, c := range text {
+		words[i/2] |= uint16(c) << (8 * (1 - i%2))
+	}
+
+	var t uint32
+	if !bi.buildTime.IsZero() {
+		t = uint32(bi.buildTime.Unix())
+	}
+	words[8] = uint16(t >> 16)
+	words[9] = uint16(t)
+
+	for i := 0; i < 4; i++ {
+		words[10+i] = binary.BigEndian.Uint16(hash[i*2:])
+	}
+
+	u := uint32(uptime / time.Second)
+	words[14] = uint16(u >> 16)
+	words[15] = uint16(u)
+
+	return words
+}
+
+// startBanner will write the banner into the input registers of the
+// server at the address given, and update the uptime every second.
+func startBanner(serv *mbserver.Server, addr int, bi buildInfo, hash [sha256.Size]byte) {
+	start := time.Now()
+	write := func() {
+		regs := serv.InputRegisters[:cap(serv.InputRegisters)]
+		copy(regs[addr:], bannerWords(bi, hash, time.Since(start)))
+	}
+
+	serv.Lock()
+	write()
+	serv.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			serv.Lock()
+			write()
+			serv.Unlock()
+		}
+	}()
+}
+
+// formatHash will return the first 64 bits of the hash in hex, which is
+// the part of the hash in the banner.
+func formatHash(hash [sha256.Size]byte) string {
+	return fmt.Sprintf("%x", hash[:8])
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestBannerWords(t *testing.T) {
+	bi := buildInfo{version: "v1.2.3", buildTime: time.Unix(0x12345678, 0)}
+	var hash [sha256.Size]byte
+	for i := range hash {
+		hash[i] = byte(i)
+	}
+
+	got := bannerWords(bi, hash, time.Second*70000)
+	expect := []uint16{
+		0x7631, 0x2e32, 0x2e33, 0, 0, 0, 0, 0,
+		0x1234, 0x5678,
+		0x0001, 0x0203, 0x0405, 0x0607,
+		0x0001, 0x1170,
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %x, got %x", expect, got)
+	}
+
+	// A long version is cut to the 16 characters of the banner.
+	got = bannerWords(buildInfo{version: "v1.2.3-0.20240102100000-abcdef"}, hash, 0)
+	if got[7] != 0x3130 {
+		t.Errorf("expected the 16th character in the last word, got %x", got[:8])
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	a := mbserver.NewServer()
+	b := mbserver.NewServer()
+	if configHash(a, profile{}) != configHash(b, profile{}) {
+		t.Errorf("expected the same hash for the same registers")
+	}
+
+	b.HoldingRegisters[10] = 1
+	if configHash(a, profile{}) == configHash(b, profile{}) {
+		t.Errorf("expected a different hash for different registers")
+	}
+	p := profile{blocksRawData: []map[string]interface{}{{"block": "tank"}}}
+	if configHash(a, profile{}) == configHash(a, p) {
+		t.Errorf("expected a different hash for different blocks")
+	}
+}
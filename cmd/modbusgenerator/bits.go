@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// bit is a single coil or discrete input, set to exactly the number 0 or
+// 1, unlike the word types where the two bytes of the word are put into
+// two coils.
+type bit struct {
+	Type    string
+	Number  float64
+	RegAddr float64
+}
+
+// Encode will encode the bit as a word holding 0 or 1.
+func (b bit) Encode() []uint16 {
+	if b.Number != 0 {
+		return []uint16{1}
+	}
+	return []uint16{0}
+}
+
+func (b bit) Address() int {
+	return int(b.RegAddr)
+}
+
+// Decode will decode the word produced by Encode back into 0 or 1, or 0
+// if u is empty.
+func (b bit) Decode(u []uint16) float64 {
+	v, _ := mbserver.DecodeBit(u)
+	return v
+}
+
+// validate will check that the number is 0 or 1.
+func (b bit) validate() error {
+	if b.Number != 0 && b.Number != 1 {
+		return fmt.Errorf("number %v of a bit must be 0 or 1", b.Number)
+	}
+	return nil
+}
+
+// NewBit will assert the struct fields to it's correct type, and return
+// the concrete type.
+func NewBit(m map[string]interface{}) *bit {
+	return &bit{
+		Type:    m["type"].(string),
+		Number:  m["number"].(float64),
+		RegAddr: m["regAddr"].(float64),
+	}
+}
+
+// parseBoolNumber will return the number for the boolean literals that
+// can be given as the number of a coil or discrete input, which are true
+// and false, or the strings "on" and "off". It returns false if the value
+// is not a boolean literal.
+func parseBoolNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		switch strings.ToLower(v) {
+		case "on":
+			return 1, true
+		case "off":
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// coilCount will return the number of coils or discrete inputs the entry
+// is put into, which is one for a bit, and two for each word of the other
+// types, made from the two bytes of the word.
+func coilCount(e encoder) int {
+	if _, ok := e.(*bit); ok {
+		return 1
+	}
+	return len(e.Encode()) * 2
+}
+
+// wordToCoils will return the values of the coils or discrete inputs to
+// set for the word of the entry given.
+func wordToCoils(e encoder, word uint16) []byte {
+	if _, ok := e.(*bit); ok {
+		return []byte{byte(word)}
+	}
+	return uint16ToByteSlice(word)
+}
+
+// coilsToWords will return the words of the entry given made from the
+// values of its coils or discrete inputs, the same way as setRegister
+// puts them into the register. The coils must hold at least coilCount of
+// the entry values.
+func coilsToWords(e encoder, coils []byte) []uint16 {
+	if _, ok := e.(*bit); ok {
+		return []uint16{uint16(coils[0])}
+	}
+
+	var words []uint16
+	for i := 0; i+1 < len(coils) && i < coilCount(e); i += 2 {
+		words = append(words, uint16(coils[i])<<8|uint16(coils[i+1]))
+	}
+	return words
+}
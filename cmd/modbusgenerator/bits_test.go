@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestBitEntries(t *testing.T) {
+	coilFile := filepath.Join(t.TempDir(), "coil.json")
+	os.WriteFile(coilFile, []byte(`[
+		{"type": "bit", "number": true, "regAddr": 3},
+		{"type": "bit", "number": "off", "regAddr": 4},
+		{"type": "bit", "number": "ON", "regAddr": 5},
+		{"type": "bit", "number": 0, "regAddr": 6}]`), 0644)
+
+	serv := mbserver.NewServer()
+	p, configErrors := loadProfile(serv, []registerFile{{filename: coilFile, registerType: coilType}}, "", -1, false, false)
+	if configErrors != 0 {
+		t.Fatalf("expected no errors, got %v", configErrors)
+	}
+
+	// Each bit sets exactly one coil.
+	expect := []byte{1, 0, 1, 0}
+	if !isEqual(expect, serv.Coils[2:6]) {
+		t.Errorf("expected %v, got %v", expect, serv.Coils[2:6])
+	}
+
+	var got []float64
+	for _, e := range p.entries[coilType] {
+		v, _ := entryValue(serv, coilType, e, -1)
+		got = append(got, v)
+	}
+	if !isEqual([]float64{1, 0, 1, 0}, got) {
+		t.Errorf("expected %v, got %v", []float64{1, 0, 1, 0}, got)
+	}
+
+	// Writing a bit only changes its own coil.
+	writeEntry(serv, coilType, p.entries[coilType][1], 1, -1)
+	expect = []byte{1, 1, 1, 0}
+	if !isEqual(expect, serv.Coils[2:6]) {
+		t.Errorf("expected %v, got %v", expect, serv.Coils[2:6])
+	}
+}
+
+func TestParseBoolNumber(t *testing.T) {
+	tests := []struct {
+		v      interface{}
+		number float64
+		ok     bool
+	}{
+		{true, 1, true},
+		{false, 0, true},
+		{"on", 1, true},
+		{"Off", 0, true},
+		{"NaN", 0, false},
+		{1.0, 0, false},
+	}
+
+	for _, tt := range tests {
+		n, ok := parseBoolNumber(tt.v)
+		if n != tt.number || ok != tt.ok {
+			t.Errorf("%v: expected %v %v, got %v %v", tt.v, tt.number, tt.ok, n, ok)
+		}
+	}
+}
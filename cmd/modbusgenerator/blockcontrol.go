@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// blockControl makes the simulation blocks of the devices stale and
+// back good at runtime.
+type blockControl struct {
+	devices []*device
+}
+
+// blockStatus is the status of a simulation block returned by the block
+// endpoints.
+type blockStatus struct {
+	Device string `json:"device,omitempty"`
+	Index  int    `json:"index"`
+	Name   string `json:"name,omitempty"`
+	Block  string `json:"block"`
+	Stale  bool   `json:"stale"`
+}
+
+// blockStatuses will return the status of the blocks of all the devices.
+// The blocks are the same for each unit of a device, so the status of
+// the blocks of the server of the device is returned.
+func (bc *blockControl) blockStatuses() []blockStatus {
+	blocks := []blockStatus{}
+	for _, d := range bc.devices {
+		if len(d.blocks) == 0 {
+			continue
+		}
+
+		d.serv.Lock()
+		for i, b := range d.blocks[0] {
+			blocks = append(blocks, blockStatus{
+				Device: d.name,
+				Index:  i,
+				Name:   b.name,
+				Block:  b.kind,
+				Stale:  b.stale,
+			})
+		}
+		d.serv.Unlock()
+	}
+
+	return blocks
+}
+
+// handleBlocks answers with the simulation blocks and if they are stale.
+func (bc *blockControl) handleBlocks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, bc.blockStatuses())
+}
+
+// handleStale will make the block given with the block query parameter,
+// by name or by its index in the blocks file, stale or good again as
+// given with the stale query parameter, in all the units of the devices,
+// e.g. /blocks/stale?block=tank1&stale=true. The device query parameter
+// selects the device of a fleet, and all the devices are selected if not
+// given.
+func (bc *blockControl) handleStale(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stale, err := strconv.ParseBool(r.URL.Query().Get("stale"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("stale must be true or false, got %q", r.URL.Query().Get("stale")), http.StatusBadRequest)
+		return
+	}
+
+	devices, err := selectDevices(bc.devices, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	name := r.URL.Query().Get("block")
+	index, err := strconv.Atoi(name)
+	if err != nil {
+		index = -1
+	}
+
+	found := false
+	for _, d := range devices {
+		d.serv.Lock()
+		for _, blocks := range d.blocks {
+			for i, b := range blocks {
+				if (b.name != "" && b.name == name) || i == index {
+					b.stale = stale
+					found = true
+				}
+			}
+		}
+		d.serv.Unlock()
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("no block named %q", name), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, bc.blockStatuses())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestBlockControl(t *testing.T) {
+	d := &device{
+		name: "d1",
+		serv: mbserver.NewServer(),
+		blocks: [][]*simBlock{
+			{{name: "tank1", kind: "tank"}, {kind: "motor"}},
+			{{name: "tank1", kind: "tank"}, {kind: "motor"}},
+		},
+	}
+	bc := &blockControl{devices: []*device{d}}
+
+	for _, url := range []string{"/blocks/stale?block=tank1&stale=true", "/blocks/stale?block=1&stale=true"} {
+		rec := httptest.NewRecorder()
+		bc.handleStale(rec, httptest.NewRequest("POST", url, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%v: expected %v, got %v", url, http.StatusOK, rec.Code)
+		}
+	}
+
+	// The blocks are stale in all the units.
+	for i, blocks := range d.blocks {
+		for _, b := range blocks {
+			if !b.stale {
+				t.Errorf("unit %v: expected block %v to be stale", i, b.kind)
+			}
+		}
+	}
+
+	expect := []blockStatus{
+		{Device: "d1", Index: 0, Name: "tank1", Block: "tank", Stale: true},
+		{Device: "d1", Index: 1, Block: "motor", Stale: true},
+	}
+	got := bc.blockStatuses()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	tests := map[string]int{
+		"/blocks/stale?block=tank2&stale=true": http.StatusNotFound,
+		"/blocks/stale?block=tank1&stale=foo":  http.StatusBadRequest,
+	}
+	for url, code := range tests {
+		rec := httptest.NewRecorder()
+		bc.handleStale(rec, httptest.NewRequest("POST", url, nil))
+		if rec.Code != code {
+			t.Errorf("%v: expected %v, got %v", url, code, rec.Code)
+		}
+	}
+}
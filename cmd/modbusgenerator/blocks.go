@@ -0,0 +1,751 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// block is a simulation block, like a tank or a motor, whose inputs and
+// outputs are bound to registers of the server.
+type block interface {
+	// step will advance the simulation of the block by dt, reading the
+	// inputs from and writing the outputs to the registers of the server.
+	// The server must be locked by the caller.
+	step(serv *mbserver.Server, dt time.Duration)
+	// state will return a copy of the state of the block, and setState
+	// will set the block back to a state returned by state, so the
+	// simulation can be rewound to a snapshot.
+	state() any
+	setState(s any)
+}
+
+// simBlock is a block of the simulation, with the state all the kinds of
+// blocks have. While stale, the block is not stepped, so its values
+// freeze like those of a failed sensor, and the quality register is set
+// to the stale number instead of the good number. A block is stale while
+// set stale with the blocks endpoint, or while the stale coil is on.
+type simBlock struct {
+	block
+	// name is the name of the block given with the "name" field.
+	name string
+	// kind is the kind of block given with the "block" field.
+	kind         string
+	stale        bool
+	hasStaleCoil bool
+	staleCoil    bitRef
+	hasQuality   bool
+	quality      valueRef
+	qualityGood  float64
+	qualityStale float64
+}
+
+func (b *simBlock) step(serv *mbserver.Server, dt time.Duration) {
+	stale := b.stale || (b.hasStaleCoil && b.staleCoil.get(serv))
+
+	if b.hasQuality {
+		q := b.qualityGood
+		if stale {
+			q = b.qualityStale
+		}
+		b.quality.set(serv, q)
+	}
+
+	if stale {
+		return
+	}
+	b.block.step(serv, dt)
+}
+
+// valueRef is a value of a block bound to a holding or input register
+// entry, so the value is encoded with the type of the entry.
+type valueRef struct {
+	rt         registerType
+	entry      configEntry
+	addrOffset int
+}
+
+// get will return the value decoded from the registers of the entry.
+func (r valueRef) get(serv *mbserver.Server) float64 {
+	regs := r.registers(serv)
+	addr := r.entry.enc.Address() + r.addrOffset
+	size := len(r.entry.enc.Encode())
+	if addr+size > len(regs) {
+		return 0
+	}
+
+	return r.entry.enc.Decode(regs[addr : addr+size])
+}
+
+// set will encode the value into the registers of the entry, unless it
+// is within the deadband of the entry.
+func (r valueRef) set(serv *mbserver.Server, v float64) {
+	writeValue(r.registers(serv), r.entry.enc.Address()+r.addrOffset, r.entry, v)
+}
+
+// reset will encode the value into the registers of the entry, ignoring
+// the deadband of the entry.
+func (r valueRef) reset(serv *mbserver.Server, v float64) {
+	regs := r.registers(serv)
+	addr := r.entry.enc.Address() + r.addrOffset
+	if addr >= len(regs) {
+		return
+	}
+	copy(regs[addr:], encodeNumber(r.entry, v))
+}
+
+// registers will return the registers of the server the entry is in.
+func (r valueRef) registers(serv *mbserver.Server) []uint16 {
+	if r.rt == holdingType {
+		return serv.HoldingRegisters
+	}
+	return serv.InputRegisters
+}
+
+// bitRef is an on/off value of a block bound to a coil or discrete input.
+type bitRef struct {
+	rt   registerType
+	addr int
+}
+
+// get will return true if the coil or discrete input is on.
+func (r bitRef) get(serv *mbserver.Server) bool {
+	b := serv.Coils[:cap(serv.Coils)]
+	if r.rt == discreteType {
+		b = serv.DiscreteInputs[:cap(serv.DiscreteInputs)]
+	}
+	if r.addr < 0 || r.addr >= len(b) {
+		return false
+	}
+
+	return b[r.addr] != 0
+}
+
+// set will turn the coil or discrete input on or off.
+func (r bitRef) set(serv *mbserver.Server, on bool) {
+	b := serv.Coils[:cap(serv.Coils)]
+	if r.rt == discreteType {
+		b = serv.DiscreteInputs[:cap(serv.DiscreteInputs)]
+	}
+	if r.addr < 0 || r.addr >= len(b) {
+		return
+	}
+
+	b[r.addr] = 0
+	if on {
+		b[r.addr] = 1
+	}
+}
+
+// tankBlock simulates the level of a tank, rising while the inflow coil
+// is on, and falling while the outflow coil is on.
+type tankBlock struct {
+	inflow      bitRef
+	outflow     bitRef
+	level       valueRef
+	inflowRate  float64
+	outflowRate float64
+	min         float64
+	max         float64
+	value       float64
+}
+
+func (b *tankBlock) step(serv *mbserver.Server, dt time.Duration) {
+	if b.inflow.get(serv) {
+		b.value += b.inflowRate * dt.Seconds()
+	}
+	if b.outflow.get(serv) {
+		b.value -= b.outflowRate * dt.Seconds()
+	}
+	b.value = math.Max(b.min, math.Min(b.value, b.max))
+
+	b.level.set(serv, b.value)
+}
+
+// motorBlock simulates a motor that is started and stopped with two
+// coils working like push buttons, which are turned off again when
+// handled. While running the running discrete input is on, and the
+// runtime counter is increased with the seconds run.
+type motorBlock struct {
+	start   bitRef
+	stop    bitRef
+	running bitRef
+	runtime valueRef
+	on      bool
+	seconds float64
+}
+
+func (b *motorBlock) step(serv *mbserver.Server, dt time.Duration) {
+	if b.start.get(serv) {
+		b.on = true
+		b.start.set(serv, false)
+	}
+	// Stop takes precedence over start if both are pushed.
+	if b.stop.get(serv) {
+		b.on = false
+		b.stop.set(serv, false)
+	}
+
+	if b.on {
+		b.seconds += dt.Seconds()
+	}
+
+	b.running.set(serv, b.on)
+	b.runtime.set(serv, b.seconds)
+}
+
+// pidBlock simulates a process controlled by a PID controller, where
+// the controller output is limited to 0-100 percent, and the process
+// value follows the output multiplied with the process gain as a first
+// order lag with the time constant given.
+type pidBlock struct {
+	setpoint     valueRef
+	processValue valueRef
+	output       valueRef
+	kp           float64
+	ki           float64
+	kd           float64
+	gain         float64
+	timeConstant time.Duration
+	pv           float64
+	integral     float64
+	prevErr      float64
+}
+
+func (b *pidBlock) step(serv *mbserver.Server, dt time.Duration) {
+	sp := b.setpoint.get(serv)
+	if math.IsNaN(sp) || math.IsInf(sp, 0) {
+		return
+	}
+
+	e := sp - b.pv
+	b.integral += e * dt.Seconds()
+	derivative := (e - b.prevErr) / dt.Seconds()
+	b.prevErr = e
+
+	out := b.kp*e + b.ki*b.integral + b.kd*derivative
+	if out < 0 || out > 100 {
+		// Stop integrating while the output is saturated, so the
+		// integral do not wind up.
+		b.integral -= e * dt.Seconds()
+		out = math.Max(0, math.Min(out, 100))
+	}
+
+	b.pv = stepToward(b.pv, out*b.gain, 0, b.timeConstant, dt)
+
+	b.output.set(serv, out)
+	b.processValue.set(serv, b.pv)
+}
+
+// alarmBlock simulates an alarm that is raised when a value goes above
+// the high limit or below the low limit, and cleared when the value is
+// back within the limits by the hysteresis. While raised the bit of the
+// alarm word and the discrete input are on. A latched alarm stays raised
+// until the reset coil is turned on after the value is back within the
+// limits, and the reset coil is turned off again when handled.
+type alarmBlock struct {
+	value      valueRef
+	high       float64
+	low        float64
+	hysteresis float64
+	alarmWord  int
+	bit        uint
+	discrete   bitRef
+	latch      bool
+	reset      bitRef
+	hasWord    bool
+	hasBit     bool
+	hasReset   bool
+	active     bool
+	raised     bool
+}
+
+func (b *alarmBlock) step(serv *mbserver.Server, dt time.Duration) {
+	v := b.value.get(serv)
+
+	switch {
+	case v > b.high || v < b.low:
+		b.active = true
+	case v < b.high-b.hysteresis && v > b.low+b.hysteresis:
+		b.active = false
+	}
+
+	switch {
+	case b.active:
+		b.raised = true
+	case !b.latch:
+		b.raised = false
+	}
+
+	if b.hasReset && b.reset.get(serv) {
+		if !b.active {
+			b.raised = false
+		}
+		b.reset.set(serv, false)
+	}
+
+	if b.hasWord && b.alarmWord >= 0 && b.alarmWord < len(serv.InputRegisters) {
+		if b.raised {
+			serv.InputRegisters[b.alarmWord] |= 1 << b.bit
+		} else {
+			serv.InputRegisters[b.alarmWord] &^= 1 << b.bit
+		}
+	}
+	if b.hasBit {
+		b.discrete.set(serv, b.raised)
+	}
+}
+
+// scheduleBlock writes the number of a schedule entry to a value when
+// the cron expression of the entry matches the time of the simulation
+// clock, like a nighttime setback of a setpoint. At start the number of
+// the entry that matched last within the past week is written.
+type scheduleBlock struct {
+	value   valueRef
+	entries []scheduleEntry
+	now     func() time.Time
+	last    time.Time
+}
+
+// scheduleEntry is a single entry of a schedule block.
+type scheduleEntry struct {
+	cron   cronSchedule
+	number float64
+}
+
+func (b *scheduleBlock) step(serv *mbserver.Server, dt time.Duration) {
+	now := b.now().Truncate(time.Minute)
+	if !now.After(b.last) {
+		return
+	}
+
+	// Find the entry to write. At start, or if the clock has moved more
+	// than a week, it is the entry that matched last within the past
+	// week, and after that the last entry matching any of the minutes
+	// since the last step, so no minutes are missed when the clock is
+	// stepped. Later entries take precedence when several match the
+	// same minute.
+	week := time.Hour * 24 * 7
+	var latest time.Time
+	var number float64
+	found := false
+	for _, e := range b.entries {
+		var t time.Time
+		var ok bool
+		if b.last.IsZero() || now.Sub(b.last) > week {
+			t, ok = e.cron.prev(now, week)
+		} else {
+			t, ok = e.cron.prev(now, now.Sub(b.last)-time.Minute)
+		}
+		if ok && !t.Before(latest) {
+			latest = t
+			number = e.number
+			found = true
+		}
+	}
+	b.last = now
+
+	if found {
+		b.value.set(serv, number)
+	}
+}
+
+// randomBlock writes a random value between min and max. With walk set
+// the value does a random walk, changing at most walk units per second,
+// and without walk a new uniform random value is written at every step.
+type randomBlock struct {
+	value valueRef
+	min   float64
+	max   float64
+	walk  float64
+	rand  *rand.Rand
+	v     float64
+}
+
+func (b *randomBlock) step(serv *mbserver.Server, dt time.Duration) {
+	if b.walk > 0 {
+		b.v += (b.rand.Float64()*2 - 1) * b.walk * dt.Seconds()
+	} else {
+		b.v = b.min + b.rand.Float64()*(b.max-b.min)
+	}
+	b.v = math.Max(b.min, math.Min(b.v, b.max))
+
+	b.value.set(serv, b.v)
+}
+
+// counterBlock counts up with rate units per second to the input register
+// value, like a pulse counter or an energy total. A total block is a
+// counter counting up with the value of the register rateValue times
+// scale per the duration per, so it integrates the rate register over
+// time, like the energy of a meter accumulating from its power. How the
+// counter handles passing max is given with wrap, where "zero" continues
+// from 0 like a counter register rolling over, "saturate" stays at max,
+// and "resetOnRead" stays at max and is reset to 0 each time a client
+// reads it, so it counts since the last read.
+type counterBlock struct {
+	value        valueRef
+	rate         float64
+	hasRateValue bool
+	rateValue    valueRef
+	scale        float64
+	per          time.Duration
+	max          float64
+	wrap         string
+	v            float64
+}
+
+func (b *counterBlock) step(serv *mbserver.Server, dt time.Duration) {
+	inc := b.rate * dt.Seconds()
+	if b.hasRateValue {
+		// A negative or invalid rate, like a meter exporting power,
+		// does not count.
+		r := b.rateValue.get(serv) * b.scale
+		if !(r > 0) || math.IsInf(r, 0) {
+			r = 0
+		}
+		inc = r * dt.Seconds() / b.per.Seconds()
+	}
+
+	b.v += inc
+	if b.wrap == "zero" {
+		// The counter has the values 0 to max, so it rolls over to 0
+		// after max like an integer register.
+		b.v = math.Mod(b.v, b.max+1)
+	} else {
+		b.v = math.Min(b.v, b.max)
+	}
+
+	b.value.set(serv, b.v)
+}
+
+// resetOnRead will reset the counter to 0 after every read request from
+// the clients that reads any of its registers.
+func (b *counterBlock) resetOnRead(serv *mbserver.Server) {
+	watchReads(serv, func(rt registerType, start int, count int) {
+		if rt == b.value.rt && entryInRange(rt, b.value.entry, start, count, b.value.addrOffset) {
+			b.v = 0
+			b.value.reset(serv, 0)
+		}
+	})
+}
+
+// typeMax will return the largest value the type of the entry given can
+// hold, or 16777215 for the float types, which is the largest whole
+// number a float32 holds exactly.
+func typeMax(e configEntry) float64 {
+	if isFloatEntry(e.raw) {
+		return 1<<24 - 1
+	}
+	// The integer types clamp the numbers to their range when encoded.
+	return e.enc.Decode(encodeNumber(e, math.MaxFloat64))
+}
+
+// blockParser will look up the fields of the raw config of a block, and
+// keep the first error found so the fields can be looked up without
+// checking the error of each.
+type blockParser struct {
+	raw        map[string]interface{}
+	entries    map[registerType][]configEntry
+	addrOffset int
+	err        error
+}
+
+// number will return the number of the field with the name given, or
+// the default value if the field is not set.
+func (p *blockParser) number(name string, def float64) float64 {
+	v, ok := p.raw[name]
+	if !ok {
+		return def
+	}
+
+	n, ok := v.(float64)
+	if !ok && p.err == nil {
+		p.err = fmt.Errorf("%v must be a number, got %v", name, v)
+	}
+	return n
+}
+
+// duration will return the duration of the field with the name given,
+// or the default value if the field is not set.
+func (p *blockParser) duration(name string, def time.Duration) time.Duration {
+	v, ok := p.raw[name]
+	if !ok {
+		return def
+	}
+
+	s, _ := v.(string)
+	d, err := time.ParseDuration(s)
+	if (err != nil || d <= 0) && p.err == nil {
+		p.err = fmt.Errorf("%v must be a duration like 5s, got %v", name, v)
+	}
+	return d
+}
+
+// address will return the address of the required field with the name
+// given.
+func (p *blockParser) address(name string) int {
+	v, ok := p.raw[name]
+	if !ok {
+		if p.err == nil {
+			p.err = fmt.Errorf("missing field %v", name)
+		}
+		return 0
+	}
+
+	n, ok := v.(float64)
+	if !ok && p.err == nil {
+		p.err = fmt.Errorf("%v must be an address, got %v", name, v)
+	}
+	return int(n)
+}
+
+// valueRegister will return the register type given with the
+// "valueRegister" field, which is input by default or holding.
+func (p *blockParser) valueRegister() registerType {
+	if p.raw["valueRegister"] == string(holdingType) {
+		return holdingType
+	}
+
+	return inputType
+}
+
+// has will return true if the field with the name given is set.
+func (p *blockParser) has(name string) bool {
+	_, ok := p.raw[name]
+	return ok
+}
+
+// bit will return a reference to the coil or discrete input at the
+// address of the field with the name given.
+func (p *blockParser) bit(name string, rt registerType) bitRef {
+	return bitRef{rt: rt, addr: p.address(name) + p.addrOffset}
+}
+
+// value will return a reference to the holding or input register entry
+// at the address of the field with the name given.
+func (p *blockParser) value(name string, rt registerType) valueRef {
+	addr := p.address(name)
+	for _, v := range p.entries[rt] {
+		if v.enc.Address() == addr {
+			return valueRef{rt: rt, entry: v, addrOffset: p.addrOffset}
+		}
+	}
+
+	if p.err == nil {
+		p.err = fmt.Errorf("%v: no %v register entry found at address %v", name, rt, addr)
+	}
+	return valueRef{}
+}
+
+// blockError will return the error of the raw block given with the index
+// i, with the position of the block in the blocks file when known.
+func blockError(raw map[string]interface{}, i int, err error) error {
+	if src, ok := sourceOf(raw); ok {
+		return &entryError{src: src, err: err}
+	}
+	return fmt.Errorf("block %v: %v", i, err)
+}
+
+// parseBlocks will return a block for each of the raw block configs
+// given, bound to the registers of the entries given. The "block" field
+// of each config gives the kind of block, which is one of tank, motor,
+// pid, alarm, schedule, random, counter or total. The random blocks derive
+// their source of randomness from the seed given.
+func parseBlocks(blocksRawData []map[string]interface{}, entries map[registerType][]configEntry, addrOffset int, clk *simClock, seed int64) ([]*simBlock, error) {
+	var blocks []*simBlock
+	for i, raw := range blocksRawData {
+		p := &blockParser{raw: raw, entries: entries, addrOffset: addrOffset}
+
+		var b block
+		switch raw["block"] {
+		case "tank":
+			t := &tankBlock{
+				inflow:      p.bit("inflowCoil", coilType),
+				outflow:     p.bit("outflowCoil", coilType),
+				level:       p.value("level", inputType),
+				inflowRate:  p.number("inflowRate", 1),
+				outflowRate: p.number("outflowRate", 1),
+				min:         p.number("min", 0),
+				max:         p.number("max", 100),
+			}
+			if p.err == nil {
+				t.value = t.level.entry.enc.Decode(t.level.entry.enc.Encode())
+			}
+			b = t
+		case "motor":
+			b = &motorBlock{
+				start:   p.bit("startCoil", coilType),
+				stop:    p.bit("stopCoil", coilType),
+				running: p.bit("running", discreteType),
+				runtime: p.value("runtime", inputType),
+			}
+		case "pid":
+			pb := &pidBlock{
+				setpoint:     p.value("setpoint", holdingType),
+				processValue: p.value("processValue", inputType),
+				output:       p.value("output", inputType),
+				kp:           p.number("kp", 1),
+				ki:           p.number("ki", 0),
+				kd:           p.number("kd", 0),
+				gain:         p.number("gain", 1),
+				timeConstant: p.duration("timeConstant", time.Second*10),
+			}
+			if p.err == nil {
+				pb.pv = pb.processValue.entry.enc.Decode(pb.processValue.entry.enc.Encode())
+			}
+			b = pb
+		case "alarm":
+			a := &alarmBlock{
+				value:      p.value("value", p.valueRegister()),
+				high:       p.number("high", math.Inf(1)),
+				low:        p.number("low", math.Inf(-1)),
+				hysteresis: p.number("hysteresis", 0),
+				latch:      raw["latch"] == true,
+				hasWord:    p.has("alarmWord"),
+				hasBit:     p.has("discrete"),
+				hasReset:   p.has("resetCoil"),
+			}
+			if a.hasWord {
+				a.alarmWord = p.address("alarmWord") + addrOffset
+				bit := p.number("bit", 0)
+				if (bit < 0 || bit > 15 || bit != float64(int(bit))) && p.err == nil {
+					p.err = fmt.Errorf("bit must be 0 to 15, got %v", bit)
+				}
+				a.bit = uint(bit)
+			}
+			if a.hasBit {
+				a.discrete = p.bit("discrete", discreteType)
+			}
+			if a.hasReset {
+				a.reset = p.bit("resetCoil", coilType)
+			}
+			if !p.has("high") && !p.has("low") && p.err == nil {
+				p.err = fmt.Errorf("missing field high or low")
+			}
+			b = a
+		case "schedule":
+			sb := &scheduleBlock{
+				value: p.value("value", p.valueRegister()),
+				now:   clk.Now,
+			}
+			entries, _ := raw["schedule"].([]interface{})
+			if len(entries) == 0 && p.err == nil {
+				p.err = fmt.Errorf("schedule must be a list of entries with a cron expression and a number")
+			}
+			for _, v := range entries {
+				m, _ := v.(map[string]interface{})
+				expr, _ := m["cron"].(string)
+				number, ok := m["number"].(float64)
+				cs, err := parseCron(expr)
+				if err == nil && !ok {
+					err = fmt.Errorf("schedule entry %v must have a number", v)
+				}
+				if err != nil && p.err == nil {
+					p.err = err
+				}
+				sb.entries = append(sb.entries, scheduleEntry{cron: cs, number: number})
+			}
+			b = sb
+		case "random":
+			rb := &randomBlock{
+				value: p.value("value", p.valueRegister()),
+				min:   p.number("min", 0),
+				max:   p.number("max", 100),
+				walk:  p.number("walk", 0),
+				// Each block gets its own source derived from the seed,
+				// so the values are the same for every run with the same
+				// seed no matter the order the blocks are stepped in.
+				rand: rand.New(rand.NewSource(seed + int64(i))),
+			}
+			if rb.min > rb.max && p.err == nil {
+				p.err = fmt.Errorf("min %v is larger than max %v", rb.min, rb.max)
+			}
+			if p.err == nil {
+				rb.v = math.Max(rb.min, math.Min(rb.value.entry.enc.Decode(rb.value.entry.enc.Encode()), rb.max))
+			}
+			b = rb
+		case "counter", "total":
+			cb := &counterBlock{wrap: "zero"}
+			if raw["block"] == "total" {
+				cb.value = p.value("total", p.valueRegister())
+				rt := inputType
+				if raw["rateRegister"] == string(holdingType) {
+					rt = holdingType
+				}
+				cb.hasRateValue = true
+				cb.rateValue = p.value("rate", rt)
+				cb.scale = p.number("scale", 1)
+				cb.per = p.duration("per", time.Hour)
+			} else {
+				cb.value = p.value("value", p.valueRegister())
+				cb.rate = p.number("rate", 1)
+			}
+			if w, ok := raw["wrap"]; ok {
+				cb.wrap, _ = w.(string)
+			}
+			if cb.wrap != "zero" && cb.wrap != "saturate" && cb.wrap != "resetOnRead" && p.err == nil {
+				p.err = fmt.Errorf("unknown wrap %v, valid wraps are zero|saturate|resetOnRead", raw["wrap"])
+			}
+			if p.err == nil {
+				cb.max = p.number("max", typeMax(cb.value.entry))
+				cb.v = cb.value.entry.enc.Decode(cb.value.entry.enc.Encode())
+			}
+			if cb.max <= 0 && p.err == nil {
+				p.err = fmt.Errorf("max must be larger than 0, got %v", cb.max)
+			}
+			b = cb
+		default:
+			return nil, blockError(raw, i, fmt.Errorf("unknown block %v, valid blocks are tank|motor|pid|alarm|schedule|random|counter|total", raw["block"]))
+		}
+
+		sb := &simBlock{block: b, kind: fmt.Sprint(raw["block"])}
+		sb.name, _ = raw["name"].(string)
+		if p.has("name") && sb.name == "" && p.err == nil {
+			p.err = fmt.Errorf("name must be a string, got %v", raw["name"])
+		}
+		if p.has("staleCoil") {
+			sb.hasStaleCoil = true
+			sb.staleCoil = p.bit("staleCoil", coilType)
+		}
+		if p.has("quality") {
+			rt := inputType
+			if raw["qualityRegister"] == string(holdingType) {
+				rt = holdingType
+			}
+			sb.hasQuality = true
+			sb.quality = p.value("quality", rt)
+			sb.qualityGood = p.number("qualityGood", 0)
+			sb.qualityStale = p.number("qualityStale", 1)
+		}
+
+		if p.err != nil {
+			return nil, blockError(raw, i, fmt.Errorf("%v: %v", raw["block"], p.err))
+		}
+		blocks = append(blocks, sb)
+	}
+
+	return blocks, nil
+}
+
+// startBlocks will start stepping the simulation of the blocks given at
+// every interval of the simulation clock.
+func startBlocks(serv *mbserver.Server, blocks []*simBlock, interval time.Duration, clk *simClock) {
+	if len(blocks) == 0 {
+		return
+	}
+
+	for _, b := range blocks {
+		if c, ok := b.block.(*counterBlock); ok && c.wrap == "resetOnRead" {
+			c.resetOnRead(serv)
+		}
+	}
+
+	go runSteps(serv, clk, interval, func(dt time.Duration) {
+		for _, b := range blocks {
+			b.step(serv, dt)
+		}
+	})
+}
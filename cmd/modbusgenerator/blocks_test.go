@@ -0,0 +1,409 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// testEntries will return the config entries of the raw config data given.
+func testEntries(raw []map[string]interface{}) []configEntry {
+	var entries []configEntry
+	for _, v := range raw {
+		entries = append(entries, configEntry{enc: NewEncoder(v), raw: v})
+	}
+
+	return entries
+}
+
+func TestBlocks(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		inputType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 50.0, "regAddr": 101.0},
+			{"type": "float32BigWordBigEndian", "number": 0.0, "regAddr": 103.0},
+		}),
+	}
+	blocksRawData := []map[string]interface{}{
+		{"block": "tank", "inflowCoil": 301.0, "outflowCoil": 302.0, "level": 101.0, "inflowRate": 2.0},
+		{"block": "motor", "startCoil": 303.0, "stopCoil": 304.0, "running": 401.0, "runtime": 103.0},
+	}
+
+	blocks, err := parseBlocks(blocksRawData, entries, -1, nil, 0)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	serv.Coils[300] = 1
+	serv.Coils[302] = 1
+
+	for i := 0; i < 2; i++ {
+		for _, b := range blocks {
+			b.step(serv, time.Second)
+		}
+	}
+
+	level := entries[inputType][0].enc.Decode(serv.InputRegisters[100:102])
+	if level != 54 {
+		t.Errorf("expected tank level %v, got %v", 54, level)
+	}
+	runtime := entries[inputType][1].enc.Decode(serv.InputRegisters[102:104])
+	if runtime != 2 {
+		t.Errorf("expected motor runtime %v, got %v", 2, runtime)
+	}
+	if serv.DiscreteInputs[400] != 1 || serv.Coils[302] != 0 {
+		t.Errorf("expected motor running and start coil reset, got running %v and start coil %v", serv.DiscreteInputs[400], serv.Coils[302])
+	}
+
+	// Stopping the motor.
+	serv.Coils[303] = 1
+	blocks[1].step(serv, time.Second)
+	if serv.DiscreteInputs[400] != 0 {
+		t.Errorf("expected motor stopped, got running %v", serv.DiscreteInputs[400])
+	}
+}
+
+func TestPIDBlock(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 40.0, "regAddr": 201.0},
+		}),
+		inputType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 20.0, "regAddr": 101.0},
+			{"type": "float32BigWordBigEndian", "number": 0.0, "regAddr": 103.0},
+		}),
+	}
+	blocksRawData := []map[string]interface{}{
+		{"block": "pid", "setpoint": 201.0, "processValue": 101.0, "output": 103.0, "kp": 2.0, "ki": 0.5, "timeConstant": "5s"},
+	}
+
+	blocks, err := parseBlocks(blocksRawData, entries, -1, nil, 0)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	setRegister(serv, []encoder{entries[holdingType][0].enc}, "holding", -1)
+
+	for i := 0; i < 600; i++ {
+		blocks[0].step(serv, time.Millisecond*100)
+	}
+
+	pv := entries[inputType][0].enc.Decode(serv.InputRegisters[100:102])
+	if pv < 39 || pv > 41 {
+		t.Errorf("expected process value close to %v, got %v", 40, pv)
+	}
+}
+
+func TestAlarmBlock(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		inputType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 50.0, "regAddr": 101.0},
+		}),
+	}
+	blocksRawData := []map[string]interface{}{
+		{"block": "alarm", "value": 101.0, "high": 80.0, "hysteresis": 5.0, "alarmWord": 110.0, "bit": 3.0, "discrete": 401.0, "latch": true, "resetCoil": 305.0},
+	}
+
+	blocks, err := parseBlocks(blocksRawData, entries, -1, nil, 0)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	level := entries[inputType][0]
+
+	tests := []struct {
+		value  float64
+		reset  bool
+		expect bool
+	}{
+		{50, false, false},
+		{85, false, true},
+		// Within the hysteresis the alarm is still active, so a reset
+		// has no effect.
+		{78, true, true},
+		// Latched until reset.
+		{70, false, true},
+		{70, true, false},
+	}
+
+	for _, tt := range tests {
+		copy(serv.InputRegisters[100:], encodeNumber(level, tt.value))
+		if tt.reset {
+			serv.Coils[304] = 1
+		}
+		blocks[0].step(serv, time.Second)
+
+		raised := serv.InputRegisters[109]&(1<<3) != 0
+		if raised != tt.expect || (serv.DiscreteInputs[400] == 1) != tt.expect {
+			t.Errorf("value %v reset %v: expected raised %v, got alarm word %016b and discrete %v", tt.value, tt.reset, tt.expect, serv.InputRegisters[109], serv.DiscreteInputs[400])
+		}
+		if serv.Coils[304] != 0 {
+			t.Errorf("value %v: expected reset coil to be turned off, got %v", tt.value, serv.Coils[304])
+		}
+	}
+}
+
+func TestScheduleBlock(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 21.0, "regAddr": 201.0},
+		}),
+	}
+	blocksRawData := []map[string]interface{}{
+		{"block": "schedule", "value": 201.0, "valueRegister": "holding", "schedule": []interface{}{
+			map[string]interface{}{"cron": "0 6 * * *", "number": 21.0},
+			map[string]interface{}{"cron": "0 22 * * *", "number": 16.0},
+		}},
+	}
+
+	blocks, err := parseBlocks(blocksRawData, entries, -1, nil, 0)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	sb := blocks[0].block.(*scheduleBlock)
+	now := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	sb.now = func() time.Time { return now }
+
+	serv := mbserver.NewServer()
+	setpoint := entries[holdingType][0]
+
+	tests := []struct {
+		t      time.Time
+		expect float64
+	}{
+		// At start the value of the last entry matched is written.
+		{time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC), 16},
+		{time.Date(2024, 1, 1, 6, 0, 0, 0, time.UTC), 21},
+		{time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC), 16},
+	}
+
+	for _, tt := range tests {
+		now = tt.t
+		sb.step(serv, time.Second)
+		v := setpoint.enc.Decode(serv.HoldingRegisters[200:202])
+		if v != tt.expect {
+			t.Errorf("%v: expected %v, got %v", tt.t, tt.expect, v)
+		}
+	}
+
+	// When the clock is stepped past an entry, the entry is still
+	// written.
+	now = time.Date(2024, 1, 2, 7, 0, 0, 0, time.UTC)
+	sb.step(serv, time.Second)
+	if v := setpoint.enc.Decode(serv.HoldingRegisters[200:202]); v != 21 {
+		t.Errorf("expected %v, got %v", 21, v)
+	}
+
+	// A value written by a client is kept until the next entry matches.
+	copy(serv.HoldingRegisters[200:], encodeNumber(setpoint, 18))
+	now = now.Add(time.Minute)
+	sb.step(serv, time.Second)
+	if v := setpoint.enc.Decode(serv.HoldingRegisters[200:202]); v != 18 {
+		t.Errorf("expected %v, got %v", 18, v)
+	}
+}
+
+func TestRandomBlock(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		inputType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 50.0, "regAddr": 101.0},
+		}),
+	}
+	blocksRawData := []map[string]interface{}{
+		{"block": "random", "value": 101.0, "min": 40.0, "max": 60.0, "walk": 5.0},
+	}
+
+	// run will step a random block with the seed given, and return the
+	// values written.
+	run := func(seed int64) []float64 {
+		blocks, err := parseBlocks(blocksRawData, entries, -1, nil, seed)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		serv := mbserver.NewServer()
+		var values []float64
+		for i := 0; i < 100; i++ {
+			blocks[0].step(serv, time.Second)
+			v := entries[inputType][0].enc.Decode(serv.InputRegisters[100:102])
+			if v < 40 || v > 60 {
+				t.Fatalf("expected value between 40 and 60, got %v", v)
+			}
+			values = append(values, v)
+		}
+		return values
+	}
+
+	if !isEqual(run(42), run(42)) {
+		t.Errorf("expected the same values with the same seed")
+	}
+	if isEqual(run(42), run(43)) {
+		t.Errorf("expected different values with different seeds")
+	}
+}
+
+func TestCounterBlock(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		inputType: testEntries([]map[string]interface{}{
+			{"type": "uint16", "number": 65530.0, "regAddr": 1.0},
+			{"type": "int16", "number": 0.0, "regAddr": 2.0},
+			{"type": "float32BigWordBigEndian", "number": 990.0, "regAddr": 3.0},
+			{"type": "uint16", "number": 0.0, "regAddr": 5.0},
+		}),
+	}
+	blocksRawData := []map[string]interface{}{
+		{"block": "counter", "value": 1.0, "rate": 2.0},
+		{"block": "counter", "value": 2.0, "rate": 10000.0, "wrap": "saturate"},
+		{"block": "counter", "value": 3.0, "rate": 4.0, "max": 999.0},
+		{"block": "counter", "value": 5.0, "rate": 3.0, "wrap": "resetOnRead"},
+	}
+	blocks, err := parseBlocks(blocksRawData, entries, -1, nil, 0)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	serv.InputRegisters = make([]uint16, 10)
+	startBlocks(serv, blocks[3:], time.Hour, newSimClock(time.Now(), 0))
+	for i := 0; i < 4; i++ {
+		for _, b := range blocks {
+			b.step(serv, time.Second)
+		}
+	}
+
+	// The uint16 counter rolls over to 0 after 65535, and the float
+	// counter after the max given.
+	if v := serv.InputRegisters[0]; v != 2 {
+		t.Errorf("expected %v, got %v", 2, v)
+	}
+	if v := serv.InputRegisters[1]; v != 32767 {
+		t.Errorf("expected %v, got %v", 32767, v)
+	}
+	if v := entries[inputType][2].enc.Decode(serv.InputRegisters[2:4]); v != 6 {
+		t.Errorf("expected %v, got %v", 6, v)
+	}
+
+	// A read resets the counter.
+	err = serv.ListenRTUTCP("127.0.0.1:3410")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v", err)
+	}
+	defer serv.Close()
+	c, err := newClient("127.0.0.1:3410", 1, time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v", err)
+	}
+	defer c.Close()
+
+	for _, expected := range []uint16{12, 0} {
+		values, err := c.read(inputType, 4, 1)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if !isEqual([]uint16{expected}, values) {
+			t.Errorf("expected %v, got %v", []uint16{expected}, values)
+		}
+	}
+}
+
+func TestTotalBlock(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		inputType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 1500.0, "regAddr": 1.0},
+			{"type": "float32BigWordBigEndian", "number": 10.0, "regAddr": 3.0},
+		}),
+	}
+	blocksRawData := []map[string]interface{}{
+		{"block": "total", "rate": 1.0, "total": 3.0, "scale": 0.001, "per": "1h"},
+	}
+	blocks, err := parseBlocks(blocksRawData, entries, -1, nil, 0)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	// 1500 W for 2 hours adds 3 kWh to the total.
+	serv := mbserver.NewServer()
+	serv.InputRegisters = make([]uint16, 10)
+	copy(serv.InputRegisters[0:], encodeNumber(entries[inputType][0], 1500))
+	for i := 0; i < 120; i++ {
+		blocks[0].step(serv, time.Minute)
+	}
+	if v := entries[inputType][1].enc.Decode(serv.InputRegisters[2:4]); math.Abs(v-13) > 1e-4 {
+		t.Errorf("expected %v, got %v", 13, v)
+	}
+
+	// A negative rate does not count.
+	copy(serv.InputRegisters[0:], encodeNumber(entries[inputType][0], -1500))
+	blocks[0].step(serv, time.Hour)
+	if v := entries[inputType][1].enc.Decode(serv.InputRegisters[2:4]); math.Abs(v-13) > 1e-4 {
+		t.Errorf("expected %v, got %v", 13, v)
+	}
+}
+
+func TestStaleBlock(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		inputType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 50.0, "regAddr": 101.0},
+			{"type": "float32BigWordBigEndian", "number": 0.0, "regAddr": 103.0},
+		}),
+	}
+	blocksRawData := []map[string]interface{}{
+		{"block": "random", "name": "sensor", "value": 101.0, "min": 0.0, "max": 100.0, "staleCoil": 310.0, "quality": 103.0, "qualityGood": 192.0, "qualityStale": 24.0},
+	}
+
+	blocks, err := parseBlocks(blocksRawData, entries, -1, nil, 0)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if blocks[0].name != "sensor" {
+		t.Errorf("expected %v, got %v", "sensor", blocks[0].name)
+	}
+
+	serv := mbserver.NewServer()
+	value, quality := entries[inputType][0], entries[inputType][1]
+
+	blocks[0].step(serv, time.Second)
+	if q := quality.enc.Decode(serv.InputRegisters[102:104]); q != 192 {
+		t.Errorf("expected quality %v, got %v", 192, q)
+	}
+
+	// While stale the value is frozen, and the quality is stale.
+	for _, staleCoil := range []bool{true, false} {
+		blocks[0].stale = !staleCoil
+		serv.Coils[309] = 0
+		if staleCoil {
+			serv.Coils[309] = 1
+		}
+
+		frozen := value.enc.Decode(serv.InputRegisters[100:102])
+		for i := 0; i < 10; i++ {
+			blocks[0].step(serv, time.Second)
+		}
+		if v := value.enc.Decode(serv.InputRegisters[100:102]); v != frozen {
+			t.Errorf("stale coil %v: expected frozen value %v, got %v", staleCoil, frozen, v)
+		}
+		if q := quality.enc.Decode(serv.InputRegisters[102:104]); q != 24 {
+			t.Errorf("stale coil %v: expected quality %v, got %v", staleCoil, 24, q)
+		}
+	}
+}
+
+func TestParseBlocksInvalid(t *testing.T) {
+	for _, raw := range []map[string]interface{}{
+		{"block": "pump"},
+		{"block": "alarm", "value": 101.0},
+		{"block": "tank", "inflowCoil": 301.0, "outflowCoil": 302.0},
+		{"block": "pid", "setpoint": 201.0, "processValue": 101.0, "output": 103.0},
+		{"block": "counter"},
+		{"block": "total", "total": 101.0},
+	} {
+		_, err := parseBlocks([]map[string]interface{}{raw}, nil, -1, nil, 0)
+		if err == nil {
+			t.Errorf("%v: expected error not nil, got %v", raw, err)
+		}
+	}
+}
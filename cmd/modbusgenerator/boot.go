@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// bootConfig holds the settings for simulating a device that is slow
+// to boot, where the registers are populated progressively.
+type bootConfig struct {
+	// duration is how long the device is booting before the
+	// registers starts to be populated. 0 disables boot simulation.
+	duration time.Duration
+	// response is how requests are answered while booting, either
+	// "busy" for the Slave Device Busy exception, or "zeros" for
+	// answering with the unpopulated registers.
+	response string
+	// blockSize is the number of registers populated in each step
+	// after the boot duration. 0 populates all the registers at once.
+	blockSize int
+	// blockInterval is the time between each block being populated.
+	blockInterval time.Duration
+}
+
+// registerSnapshot holds a copy of all the register tables of a server.
+type registerSnapshot struct {
+	coils            []byte
+	discreteInputs   []byte
+	holdingRegisters []uint16
+	inputRegisters   []uint16
+}
+
+// addrSpan is the range of addresses in the register a config entry is
+// encoded into, from the first address to the address after the last.
+type addrSpan struct {
+	from int
+	to   int
+}
+
+// entrySpans will return the ranges of addresses of the entries given by
+// register type. Each word of a coil or discrete entry is put into two
+// coils, and a bit into one.
+func entrySpans(entries map[registerType][]configEntry, addrOffset int) map[registerType][]addrSpan {
+	spans := make(map[registerType][]addrSpan)
+	for rt, es := range entries {
+		for _, e := range es {
+			size := len(e.enc.Encode())
+			if rt == coilType || rt == discreteType {
+				size = coilCount(e.enc)
+			}
+			from := e.enc.Address() + addrOffset
+			spans[rt] = append(spans[rt], addrSpan{from: from, to: from + size})
+		}
+	}
+
+	return spans
+}
+
+// startBoot will take the registers populated from the config files out
+// of the server, and put them back progressively in blocks after the boot
+// duration. While booting the requests are answered as specified with the
+// boot response. The spans are the ranges of the entries, which are never
+// split between two blocks, so a client never reads a value that is half
+// populated.
+func startBoot(serv *mbserver.Server, bc bootConfig, spans map[registerType][]addrSpan) error {
+	if bc.response != "busy" && bc.response != "zeros" {
+		return fmt.Errorf("unknown boot response %v, valid responses are busy|zeros", bc.response)
+	}
+
+	// Take a copy of the registers populated from the config, and
+	// clear the registers of the server.
+	serv.Lock()
+	img := registerSnapshot{
+		coils:            append([]byte{}, serv.Coils[:cap(serv.Coils)]...),
+		discreteInputs:   append([]byte{}, serv.DiscreteInputs[:cap(serv.DiscreteInputs)]...),
+		holdingRegisters: append([]uint16{}, serv.HoldingRegisters...),
+		inputRegisters:   append([]uint16{}, serv.InputRegisters...),
+	}
+	serv.Coils = make([]byte, len(img.coils))
+	serv.DiscreteInputs = make([]byte, len(img.discreteInputs))
+	serv.HoldingRegisters = make([]uint16, len(img.holdingRegisters))
+	serv.InputRegisters = make([]uint16, len(img.inputRegisters))
+	serv.Unlock()
+
+	var booting atomic.Bool
+	booting.Store(true)
+
+	if bc.response == "busy" {
+		// Wrap all the supported functions so they answer with Slave
+		// Device Busy while booting.
+		for fc := 0; fc < 256; fc++ {
+			function := serv.FunctionHandler(uint8(fc))
+			if function == nil {
+				continue
+			}
+
+			serv.RegisterFunctionHandler(uint8(fc), func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+				if booting.Load() {
+					return []byte{}, &mbserver.SlaveDeviceBusy
+				}
+				return function(s, frame)
+			})
+		}
+	}
+
+	go func() {
+		log.Printf("info: booting for %v\n", bc.duration)
+		time.Sleep(bc.duration)
+		booting.Store(false)
+
+		populateBlocks(serv, img.coils, serv.Coils, bc, spans[coilType])
+		populateBlocks(serv, img.discreteInputs, serv.DiscreteInputs, bc, spans[discreteType])
+		populateBlocks(serv, img.holdingRegisters, serv.HoldingRegisters, bc, spans[holdingType])
+		populateBlocks(serv, img.inputRegisters, serv.InputRegisters, bc, spans[inputType])
+
+		log.Printf("info: boot done, all registers populated\n")
+	}()
+
+	return nil
+}
+
+// populateBlocks will copy the registers from src into dst in blocks of
+// the boot block size, waiting the block interval between each block.
+// A block ending inside one of the spans given is made longer to include
+// the rest of the span. Blocks with only zero values are skipped since
+// there is nothing to populate.
+func populateBlocks[T byte | uint16](serv *mbserver.Server, src []T, dst []T, bc bootConfig, spans []addrSpan) {
+	blockSize := bc.blockSize
+	if blockSize <= 0 {
+		blockSize = len(src)
+	}
+
+	for start := 0; start < len(src); {
+		end := blockEnd(start+blockSize, len(src), spans)
+		block := src[start:end]
+		dstBlock := dst[start:end]
+		start = end
+
+		if isZero(block) {
+			continue
+		}
+
+		serv.Lock()
+		copy(dstBlock, block)
+		serv.Unlock()
+
+		time.Sleep(bc.blockInterval)
+	}
+}
+
+// blockEnd will return the end of a block, which is moved past the end
+// of the span it falls within, and is at most the length of the register.
+func blockEnd(end int, length int, spans []addrSpan) int {
+	for _, sp := range spans {
+		if sp.from < end && end < sp.to {
+			end = sp.to
+		}
+	}
+	if end > length {
+		end = length
+	}
+
+	return end
+}
+
+// isZero will return true if all the values of the slice are zero.
+func isZero[T byte | uint16](values []T) bool {
+	for _, v := range values {
+		if v != 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestStartBoot(t *testing.T) {
+	serv := mbserver.NewServer()
+	serv.HoldingRegisters[10] = 1
+	serv.HoldingRegisters[20] = 2
+
+	bc := bootConfig{
+		duration:      time.Millisecond * 50,
+		response:      "busy",
+		blockSize:     10,
+		blockInterval: time.Millisecond * 50,
+	}
+	err := startBoot(serv, bc, nil)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	var frame mbserver.TCPFrame
+	frame.Function = 3
+	mbserver.SetDataWithRegisterAndNumber(&frame, 10, 11)
+
+	// While booting the request should be answered with busy, and
+	// the registers should not be populated.
+	serv.Lock()
+	_, exception := serv.FunctionHandler(3)(serv, &frame)
+	serv.Unlock()
+	if *exception != mbserver.SlaveDeviceBusy {
+		t.Errorf("expected SlaveDeviceBusy, got %v", exception.String())
+	}
+
+	// After the boot duration the first block should be populated, and
+	// the second block populated after the block interval.
+	time.Sleep(time.Millisecond * 75)
+	serv.Lock()
+	got := []uint16{serv.HoldingRegisters[10], serv.HoldingRegisters[20]}
+	serv.Unlock()
+	expect := []uint16{1, 0}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	time.Sleep(time.Millisecond * 50)
+	serv.Lock()
+	data, exception := serv.FunctionHandler(3)(serv, &frame)
+	serv.Unlock()
+	if *exception != mbserver.Success {
+		t.Errorf("expected Success, got %v", exception.String())
+	}
+	if data[2] != 1 || data[22] != 2 {
+		t.Errorf("expected registers to be populated, got %v", data)
+	}
+}
+
+func TestStartBootUnknownResponse(t *testing.T) {
+	err := startBoot(mbserver.NewServer(), bootConfig{duration: time.Second, response: "foo"}, nil)
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v", err)
+	}
+}
+
+func TestBlockEnd(t *testing.T) {
+	spans := []addrSpan{{from: 9, to: 11}, {from: 19, to: 21}}
+
+	// A block ending inside a float is made longer, so the float is
+	// never half populated.
+	got := []int{blockEnd(10, 100, spans), blockEnd(9, 100, spans), blockEnd(21, 100, spans), blockEnd(20, 15, spans)}
+	expect := []int{11, 9, 21, 15}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// bound holds the range of values that clients are allowed to write
+// to the holding registers of a config entry, given with the "min"
+// and "max" fields of the entry.
+type bound struct {
+	entry configEntry
+	min   float64
+	max   float64
+}
+
+// parseBounds will return a bound for each of the config entries that
+// have a "min" or "max" field. An entry with only one of the fields is
+// unbounded in the other direction.
+func parseBounds(entries []configEntry) ([]bound, error) {
+	var bounds []bound
+	for _, v := range entries {
+		b := bound{entry: v, min: math.Inf(-1), max: math.Inf(1)}
+		_, hasMin := v.raw["min"]
+		_, hasMax := v.raw["max"]
+		if !hasMin && !hasMax {
+			continue
+		}
+
+		if hasMin {
+			min, ok := v.raw["min"].(float64)
+			if !ok {
+				return nil, v.errorf("min must be a number, got %v", v.raw["min"])
+			}
+			b.min = min
+		}
+		if hasMax {
+			max, ok := v.raw["max"].(float64)
+			if !ok {
+				return nil, v.errorf("max must be a number, got %v", v.raw["max"])
+			}
+			b.max = max
+		}
+		if b.min > b.max {
+			return nil, v.errorf("min %v is larger than max %v", b.min, b.max)
+		}
+
+		bounds = append(bounds, b)
+	}
+
+	return bounds, nil
+}
+
+// limitBounds will wrap the write holding register functions of the
+// server given, so a value written outside the bounds of an entry is
+// handled as given with the policy. With the "reject" policy the request
+// is answered with Illegal Data Value and nothing is written, and with
+// the "clamp" policy the value is written, and then clamped to the
+// nearest bound.
+func limitBounds(serv *mbserver.Server, bounds []bound, addrOffset int, policy string) error {
+	if policy != "reject" && policy != "clamp" {
+		return fmt.Errorf("unknown bounds policy %v, valid policies are reject|clamp", policy)
+	}
+	if len(bounds) == 0 {
+		return nil
+	}
+
+	for _, fc := range []uint8{6, 16} {
+		function := serv.FunctionHandler(fc)
+		if function == nil {
+			continue
+		}
+
+		fc := fc
+		serv.RegisterFunctionHandler(fc, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+			start, values, ok := writtenRegisters(fc, frame.GetData())
+			if !ok {
+				return function(s, frame)
+			}
+
+			// Find the value each bounded entry touched by the request
+			// will have after the write.
+			var clamped []bound
+			var clampValues []float64
+			for _, b := range bounds {
+				addr := b.entry.enc.Address() + addrOffset
+				size := len(b.entry.enc.Encode())
+				if addr+size <= start || addr >= start+len(values) || addr+size > len(s.HoldingRegisters) {
+					continue
+				}
+
+				words := append([]uint16{}, s.HoldingRegisters[addr:addr+size]...)
+				for i := range words {
+					if j := addr + i - start; j >= 0 && j < len(values) {
+						words[i] = values[j]
+					}
+				}
+
+				v := b.entry.enc.Decode(words)
+				if v >= b.min && v <= b.max {
+					continue
+				}
+				if policy == "reject" {
+					return []byte{}, &mbserver.IllegalDataValue
+				}
+
+				c := math.Max(b.min, math.Min(v, b.max))
+				if math.IsNaN(v) {
+					c = math.Max(b.min, math.Min(0, b.max))
+				}
+				clamped = append(clamped, b)
+				clampValues = append(clampValues, c)
+			}
+
+			res, exception := function(s, frame)
+			if exception != &mbserver.Success {
+				return res, exception
+			}
+
+			for i, b := range clamped {
+				addr := b.entry.enc.Address() + addrOffset
+				copy(s.HoldingRegisters[addr:], encodeNumber(b.entry, clampValues[i]))
+			}
+
+			return res, exception
+		})
+	}
+
+	return nil
+}
+
+// writtenRegisters will return the start address and the values written
+// by a write single register (6) or write multiple registers (16) request.
+// It returns false if the request data is malformed, so it can be left to
+// the function to answer.
+func writtenRegisters(fc uint8, data []byte) (int, []uint16, bool) {
+	if len(data) < 4 {
+		return 0, nil, false
+	}
+	start := int(binary.BigEndian.Uint16(data[0:2]))
+
+	if fc == 6 {
+		return start, []uint16{binary.BigEndian.Uint16(data[2:4])}, true
+	}
+
+	count := int(binary.BigEndian.Uint16(data[2:4]))
+	if len(data) < 5+count*2 {
+		return 0, nil, false
+	}
+	values := make([]uint16, count)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(data[5+i*2:])
+	}
+
+	return start, values, true
+}
+
+// encodeNumber will encode the number given with the type of the config
+// entry.
+func encodeNumber(e configEntry, n float64) []uint16 {
+	m := make(map[string]interface{}, len(e.raw))
+	for k, v := range e.raw {
+		m[k] = v
+	}
+	m["number"] = n
+
+	return NewEncoder(m).Encode()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestLimitBounds(t *testing.T) {
+	raw := []map[string]interface{}{
+		{"type": "float32BigWordBigEndian", "number": 20.0, "regAddr": 201.0, "min": 10.0, "max": 30.0},
+		{"type": "wordInt16LittleEndian", "number": 5.0, "regAddr": 203.0, "max": 100.0},
+	}
+	var entries []configEntry
+	for _, v := range raw {
+		entries = append(entries, configEntry{enc: NewEncoder(v), raw: v})
+	}
+	bounds, err := parseBounds(entries)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	tests := []struct {
+		policy string
+		value  float32
+		expect mbserver.Exception
+		stored float64
+	}{
+		{"reject", 25, mbserver.Success, 25},
+		{"reject", 35, mbserver.IllegalDataValue, 20},
+		{"clamp", 35, mbserver.Success, 30},
+		{"clamp", 5, mbserver.Success, 10},
+	}
+
+	for _, tt := range tests {
+		serv := mbserver.NewServer()
+		setRegister(serv, []encoder{entries[0].enc, entries[1].enc}, "holding", -1)
+		err := limitBounds(serv, bounds, -1, tt.policy)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		e := NewEncoder(map[string]interface{}{"type": "float32BigWordBigEndian", "number": float64(tt.value), "regAddr": 201.0})
+		var frame mbserver.TCPFrame
+		frame.Function = 16
+		mbserver.SetDataWithRegisterAndNumberAndValues(&frame, 200, 2, e.Encode())
+
+		_, exception := serv.FunctionHandler(16)(serv, &frame)
+		if *exception != tt.expect {
+			t.Errorf("%v %v: expected %v, got %v", tt.policy, tt.value, tt.expect.String(), exception.String())
+		}
+		stored := entries[0].enc.Decode(serv.HoldingRegisters[200:202])
+		if stored != tt.stored {
+			t.Errorf("%v %v: expected %v, got %v", tt.policy, tt.value, tt.stored, stored)
+		}
+	}
+
+	// Writing a single register is checked against the entry it belongs to.
+	serv := mbserver.NewServer()
+	limitBounds(serv, bounds, -1, "reject")
+	var frame mbserver.TCPFrame
+	frame.Function = 6
+	mbserver.SetDataWithRegisterAndNumber(&frame, 202, uint16ToLittleEndian(101))
+	_, exception := serv.FunctionHandler(6)(serv, &frame)
+	if *exception != mbserver.IllegalDataValue {
+		t.Errorf("expected %v, got %v", mbserver.IllegalDataValue.String(), exception.String())
+	}
+}
+
+func TestParseBoundsInvalid(t *testing.T) {
+	for _, raw := range []map[string]interface{}{
+		{"type": "wordInt16LittleEndian", "number": 5.0, "regAddr": 203.0, "min": "foo"},
+		{"type": "wordInt16LittleEndian", "number": 5.0, "regAddr": 203.0, "min": 10.0, "max": 5.0},
+	} {
+		_, err := parseBounds([]configEntry{{enc: NewEncoder(raw), raw: raw}})
+		if err == nil {
+			t.Errorf("%v: expected error not nil, got %v", raw, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// client is a minimal modbus RTU over TCP client used to talk to a
+// running modbus generator.
+type client struct {
+	conn    net.Conn
+	unitID  uint8
+	timeout time.Duration
+}
+
+// newClient will connect to the modbus RTU over TCP server at the
+// address given, and return a client using the unit ID given in
+// the requests.
+func newClient(address string, unitID uint8, timeout time.Duration) (*client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := client{
+		conn:    conn,
+		unitID:  unitID,
+		timeout: timeout,
+	}
+	return &c, nil
+}
+
+// Close will close the connection to the server.
+func (c *client) Close() error {
+	return c.conn.Close()
+}
+
+// exceptionError is returned when the server responds to a request
+// with a modbus exception.
+type exceptionError struct {
+	exception mbserver.Exception
+}
+
+func (e exceptionError) Error() string {
+	return fmt.Sprintf("modbus exception %v: %v", uint8(e.exception), e.exception.String())
+}
+
+// request will send a request with the function code and data given,
+// and return the data of the response.
+func (c *client) request(function uint8, data []byte) ([]byte, error) {
+	req := mbserver.RTUFrame{
+		Address:  c.unitID,
+		Function: function,
+		Data:     data,
+	}
+
+	err := c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.conn.Write(req.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep reading until we have a complete frame with a valid CRC,
+	// or the deadline is reached.
+	var packet []byte
+	buf := make([]byte, 512)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		packet = append(packet, buf[:n]...)
+
+		resp, err := mbserver.NewRTUFrame(packet)
+		if err != nil {
+			continue
+		}
+
+		if resp.Function&0x80 != 0 {
+			return nil, exceptionError{exception: mbserver.Exception(resp.Data[0])}
+		}
+		return resp.Data, nil
+	}
+}
+
+// read will read count values starting at the address given from the
+// register type given. For coil and discrete registers each value in
+// the returned slice is the 0 or 1 value of a single coil or discrete
+// input.
+func (c *client) read(rt registerType, addr int, count int) ([]uint16, error) {
+	var function uint8
+	switch rt {
+	case coilType:
+		function = 1
+	case discreteType:
+		function = 2
+	case holdingType:
+		function = 3
+	case inputType:
+		function = 4
+	default:
+		return nil, fmt.Errorf("unknown register type %v", rt)
+	}
+
+	var frame mbserver.RTUFrame
+	mbserver.SetDataWithRegisterAndNumber(&frame, uint16(addr), uint16(count))
+
+	data, err := c.request(function, frame.Data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 1 || len(data) != int(data[0])+1 {
+		return nil, fmt.Errorf("malformed response data: %v", data)
+	}
+
+	var values []uint16
+	switch rt {
+	case coilType, discreteType:
+		for i := 0; i < count; i++ {
+			if i/8 >= int(data[0]) {
+				return nil, fmt.Errorf("response too short for %v values", count)
+			}
+			values = append(values, uint16(data[1+i/8]>>(uint(i)%8))&0x1)
+		}
+	default:
+		if int(data[0]) != count*2 {
+			return nil, fmt.Errorf("response too short for %v values", count)
+		}
+		values = mbserver.BytesToUint16(data[1:])
+	}
+
+	return values, nil
+}
+
+// write will write the values given starting at the address given into
+// the register type given, which must be coil or holding. A single value
+// is written with Write Single Coil or Write Single Register, and several
+// values with Write Multiple Coils or Write Multiple Registers. For coil
+// registers each value is the 0 or 1 value of a single coil.
+func (c *client) write(rt registerType, addr int, values []uint16) error {
+	var frame mbserver.RTUFrame
+	var function uint8
+	switch {
+	case rt == coilType && len(values) == 1:
+		function = 5
+		v := uint16(0)
+		if values[0] != 0 {
+			v = 0xff00
+		}
+		frame.SetData(mbserver.Uint16ToBytes([]uint16{uint16(addr), v}))
+	case rt == coilType:
+		function = 15
+		coils := make([]byte, (len(values)+7)/8)
+		for i, v := range values {
+			if v != 0 {
+				coils[i/8] |= 1 << (uint(i) % 8)
+			}
+		}
+		mbserver.SetDataWithRegisterAndNumberAndBytes(&frame, uint16(addr), uint16(len(values)), coils)
+	case rt == holdingType && len(values) == 1:
+		function = 6
+		frame.SetData(mbserver.Uint16ToBytes([]uint16{uint16(addr), values[0]}))
+	case rt == holdingType:
+		function = 16
+		mbserver.SetDataWithRegisterAndNumberAndValues(&frame, uint16(addr), uint16(len(values)), values)
+	default:
+		return fmt.Errorf("register type %v can not be written", rt)
+	}
+
+	_, err := c.request(function, frame.Data)
+	return err
+}
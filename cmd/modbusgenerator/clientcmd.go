@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// exceptionExitCode is added to the exception code of a Modbus exception
+// answered by the server to give the exit code of the client subcommand,
+// e.g. 12 for Illegal Data Address.
+const exceptionExitCode = 10
+
+// clientValue is the value of a single coil or register read or written
+// by the client subcommand.
+type clientValue struct {
+	Address int    `json:"address"`
+	Value   uint16 `json:"value"`
+}
+
+// runClient implements the client subcommand, which reads and writes the
+// registers of a Modbus RTU over TCP server with the built-in client, and
+// writes the values as a table, CSV or JSON so the output can be used in
+// shell test scripts. The assert command checks the value of a register,
+// and the wait-for command polls a register until it has the value
+// expected. The detect command lists the types decoding the registers at
+// an address to the value given, to find the word and byte order of a
+// device.
+// It returns the exit code, which is 0 on success, 1 if the request
+// failed or the value was not as expected, 2 on invalid usage, and 10
+// plus the exception code when the server answered with a Modbus
+// exception.
+func runClient(args []string) int {
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Read and write the registers of a Modbus RTU over TCP server.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator client [flags] read <coil|discrete|input|holding> <address> [count]\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator client [flags] write <coil|holding> <address> <value>...\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator client [flags] assert <coil|discrete|input|holding> <address> <expected>\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator client [flags] wait-for <coil|discrete|input|holding> <address> <expected>\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator client [flags] detect <input|holding> <address> <value>\n\n")
+		fmt.Fprintf(os.Stderr, "The expected value is a number with an optional ==, !=, <, <=, > or >= before it, e.g. '>=100'.\n")
+		fmt.Fprintf(os.Stderr, "detect lists the types and word orders that decode the registers at the address to the value given.\n\n")
+		fs.PrintDefaults()
+	}
+	server := fs.String("server", "localhost:5502", "Address of the RTU over TCP server")
+	unitID := fs.Uint("unitID", 1, "The unit ID to use in the requests to the server")
+	timeout := fs.Duration("timeout", time.Second*5, "The timeout of the connection and of each request")
+	registerStartOffset := fs.Int("registerStartOffset", -1, "The register start offset used by the server, see the main flags. The address is given as in the config files")
+	output := fs.String("output", "table", "The format of the values written to stdout, table, csv or json")
+	quiet := fs.Bool("quiet", false, "Don't write the values or the errors of the requests, only set the exit code")
+	waitTimeout := fs.Duration("waitTimeout", time.Second*30, "How long wait-for polls the register before giving up")
+	pollInterval := fs.Duration("pollInterval", time.Second, "The interval between each read of the register by wait-for")
+	tolerance := fs.Float64("tolerance", 0, "The largest difference from the expected value of assert, wait-for and detect that is still equal. 0 allows for the precision of a float32 with detect")
+
+	// The flags can also be given after the command and its arguments,
+	// like client read holding 100 2 --output json.
+	var pos []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if *output != "table" && *output != "csv" && *output != "json" {
+		log.Printf("error: output must be table, csv or json, got %q\n", *output)
+		return 2
+	}
+	if *unitID > 255 {
+		log.Printf("error: unitID must be from 0 to 255, got %v\n", *unitID)
+		return 2
+	}
+
+	var rt registerType
+	var addr int
+	var values []uint16
+	var expect expectation
+	var detectValue float64
+	var err error
+	switch {
+	case len(pos) >= 3 && pos[0] == "read" && len(pos) <= 4:
+		rt = registerType(pos[1])
+		count := 1
+		if len(pos) == 4 {
+			count, err = strconv.Atoi(pos[3])
+			// The number of values of a single request is limited by
+			// the specification.
+			max := 125
+			if rt == coilType || rt == discreteType {
+				max = 2000
+			}
+			if err != nil || count < 1 || count > max {
+				log.Printf("error: count must be from 1 to %v, got %q\n", max, pos[3])
+				return 2
+			}
+		}
+		values = make([]uint16, count)
+	case len(pos) >= 4 && pos[0] == "write":
+		rt = registerType(pos[1])
+		for _, s := range pos[3:] {
+			v, err := parseClientValue(rt, s)
+			if err != nil {
+				log.Printf("error: %v\n", err)
+				return 2
+			}
+			values = append(values, v)
+		}
+	case len(pos) == 4 && (pos[0] == "assert" || pos[0] == "wait-for"):
+		rt = registerType(pos[1])
+		expect, err = parseExpectation(pos[3], *tolerance)
+		if err != nil {
+			log.Printf("error: %v\n", err)
+			return 2
+		}
+		values = make([]uint16, 1)
+	case len(pos) == 4 && pos[0] == "detect":
+		rt = registerType(pos[1])
+		if rt != inputType && rt != holdingType {
+			log.Printf("error: register type must be input or holding, got %q\n", rt)
+			return 2
+		}
+		detectValue, err = strconv.ParseFloat(pos[3], 64)
+		if err != nil {
+			log.Printf("error: value must be a number, got %q\n", pos[3])
+			return 2
+		}
+		// The value can be up to two registers.
+		values = make([]uint16, 2)
+	default:
+		fs.Usage()
+		return 2
+	}
+	switch {
+	case pos[0] != "write" && rt != coilType && rt != discreteType && rt != inputType && rt != holdingType:
+		log.Printf("error: register type must be coil, discrete, input or holding, got %q\n", rt)
+		return 2
+	case pos[0] == "write" && rt != coilType && rt != holdingType:
+		log.Printf("error: register type must be coil or holding, got %q\n", rt)
+		return 2
+	}
+	addr, err = strconv.Atoi(pos[2])
+	if err != nil || addr+*registerStartOffset < 0 || addr+*registerStartOffset+len(values) > 65536 {
+		log.Printf("error: invalid address %q\n", pos[2])
+		return 2
+	}
+
+	var detections []detection
+	switch pos[0] {
+	case "detect":
+		var c *client
+		c, err = newClient(*server, uint8(*unitID), *timeout)
+		if err != nil {
+			break
+		}
+		defer c.Close()
+		values, err = c.read(rt, addr+*registerStartOffset, len(values))
+		if err != nil {
+			break
+		}
+		detections = detectTypes(values, detectValue, *tolerance)
+		if len(detections) == 0 {
+			err = fmt.Errorf("none of the types decode %04x %04x to %v", values[0], values[1], pos[3])
+		}
+	case "read", "write":
+		var c *client
+		c, err = newClient(*server, uint8(*unitID), *timeout)
+		if err != nil {
+			break
+		}
+		defer c.Close()
+		if pos[0] == "read" {
+			values, err = c.read(rt, addr+*registerStartOffset, len(values))
+		} else {
+			err = c.write(rt, addr+*registerStartOffset, values)
+		}
+	default:
+		// A new connection is made for each read, so wait-for can wait
+		// for a server that is starting or restarting.
+		read := func() ([]float64, error) {
+			c, err := newClient(*server, uint8(*unitID), *timeout)
+			if err != nil {
+				return nil, err
+			}
+			defer c.Close()
+			words, err := c.read(rt, addr+*registerStartOffset, 1)
+			if err != nil {
+				return nil, err
+			}
+			values = words
+			return []float64{float64(words[0])}, nil
+		}
+		wt := *waitTimeout
+		if pos[0] == "assert" {
+			wt = 0
+		}
+		_, err = waitFor(read, expect, wt, *pollInterval)
+	}
+	if err != nil {
+		if !*quiet {
+			log.Printf("error: %v\n", err)
+		}
+		var e exceptionError
+		if errors.As(err, &e) {
+			return exceptionExitCode + int(e.exception)
+		}
+		return 1
+	}
+
+	if !*quiet && pos[0] == "detect" {
+		printDetections(os.Stdout, *output, detections)
+		return 0
+	}
+	if !*quiet {
+		var cvs []clientValue
+		for i, v := range values {
+			cvs = append(cvs, clientValue{Address: addr + i, Value: v})
+		}
+		printClientValues(os.Stdout, *output, cvs)
+	}
+
+	return 0
+}
+
+// parseClientValue will parse a value to write into the register type
+// given, which is 0 or 1 for a coil, and a number from -32768 to 65535
+// for a holding register, where the negative numbers are written as
+// int16.
+func parseClientValue(rt registerType, s string) (uint16, error) {
+	if rt == coilType {
+		switch s {
+		case "0", "false":
+			return 0, nil
+		case "1", "true":
+			return 1, nil
+		}
+		return 0, fmt.Errorf("coil value must be 0 or 1, got %q", s)
+	}
+
+	n, err := strconv.ParseInt(s, 0, 32)
+	if err != nil || n < -32768 || n > 65535 {
+		return 0, fmt.Errorf("register value must be from -32768 to 65535, got %q", s)
+	}
+	return uint16(n), nil
+}
+
+// printClientValues will write the values given in the format given,
+// which is table, csv or json.
+func printClientValues(w io.Writer, format string, values []clientValue) {
+	switch format {
+	case "json":
+		if values == nil {
+			values = []clientValue{}
+		}
+		json.NewEncoder(w).Encode(values)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"address", "value"})
+		for _, v := range values {
+			cw.Write([]string{strconv.Itoa(v.Address), strconv.Itoa(int(v.Value))})
+		}
+		cw.Flush()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ADDRESS\tVALUE")
+		for _, v := range values {
+			fmt.Fprintf(tw, "%v\t%v\n", v.Address, v.Value)
+		}
+		tw.Flush()
+	}
+}
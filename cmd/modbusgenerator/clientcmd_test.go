@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestClientWrite(t *testing.T) {
+	serv := mbserver.NewServer()
+	addr := "127.0.0.1:3402"
+	err := serv.ListenRTUTCP(addr)
+	if err != nil {
+		t.Fatalf("failed to listen, got %v", err)
+	}
+	defer serv.Close()
+	time.Sleep(time.Millisecond)
+
+	c, err := newClient(addr, 1, time.Second)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		rt     registerType
+		values []uint16
+	}{
+		{holdingType, []uint16{7}},
+		{holdingType, []uint16{1, 2, 65535}},
+		{coilType, []uint16{1}},
+		{coilType, []uint16{0, 1, 1, 0, 1, 0, 0, 0, 1}},
+	}
+	for _, tt := range tests {
+		err := c.write(tt.rt, 100, tt.values)
+		if err != nil {
+			t.Fatalf("%v: expected nil, got %v", tt.rt, err)
+		}
+		got, err := c.read(tt.rt, 100, len(tt.values))
+		if err != nil {
+			t.Fatalf("%v: expected nil, got %v", tt.rt, err)
+		}
+		if !isEqual(tt.values, got) {
+			t.Errorf("%v: expected %v, got %v", tt.rt, tt.values, got)
+		}
+	}
+
+	// The exit code holds the exception answered by the server.
+	serv.Lock()
+	serv.RegisterFunctionHandler(3, nil)
+	serv.Unlock()
+	code := runClient([]string{"-server", addr, "-quiet", "read", "holding", "101", "2"})
+	if code != exceptionExitCode+int(mbserver.IllegalFunction) {
+		t.Errorf("expected %v, got %v", exceptionExitCode+int(mbserver.IllegalFunction), code)
+	}
+	code = runClient([]string{"-server", addr, "-quiet", "read", "input", "101", "2"})
+	if code != 0 {
+		t.Errorf("expected %v, got %v", 0, code)
+	}
+	code = runClient([]string{"-server", addr, "-quiet", "assert", "coil", "102", "1"})
+	if code != 0 {
+		t.Errorf("expected %v, got %v", 0, code)
+	}
+	code = runClient([]string{"-server", addr, "-quiet", "-waitTimeout", "50ms", "-pollInterval", "10ms", "wait-for", "coil", "102", "0"})
+	if code != 1 {
+		t.Errorf("expected %v, got %v", 1, code)
+	}
+	code = runClient([]string{"-server", addr, "-quiet", "-output", "xml", "read", "input", "101"})
+	if code != 2 {
+		t.Errorf("expected %v, got %v", 2, code)
+	}
+	code = runClient([]string{"-server", addr, "-quiet", "detect", "input", "101", "5"})
+	if code != 1 {
+		t.Errorf("expected %v, got %v", 1, code)
+	}
+	code = runClient([]string{"-server", addr, "-quiet", "detect", "coil", "101", "1"})
+	if code != 2 {
+		t.Errorf("expected %v, got %v", 2, code)
+	}
+}
+
+func TestPrintClientValues(t *testing.T) {
+	values := []clientValue{{Address: 101, Value: 7}, {Address: 102, Value: 65535}}
+	tests := map[string]string{
+		"json":  "[{\"address\":101,\"value\":7},{\"address\":102,\"value\":65535}]\n",
+		"csv":   "address,value\n101,7\n102,65535\n",
+		"table": "ADDRESS  VALUE\n101      7\n102      65535\n",
+	}
+	for format, expect := range tests {
+		var buf bytes.Buffer
+		printClientValues(&buf, format, values)
+		if expect != buf.String() {
+			t.Errorf("%v: expected %q, got %q", format, expect, buf.String())
+		}
+	}
+}
+
+func TestParseClientValue(t *testing.T) {
+	tests := []struct {
+		rt     registerType
+		s      string
+		expect uint16
+		err    bool
+	}{
+		{coilType, "1", 1, false},
+		{coilType, "false", 0, false},
+		{coilType, "2", 0, true},
+		{holdingType, "65535", 65535, false},
+		{holdingType, "-1", 65535, false},
+		{holdingType, "0x10", 16, false},
+		{holdingType, "65536", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseClientValue(tt.rt, tt.s)
+		if (err != nil) != tt.err || got != tt.expect {
+			t.Errorf("%v %v: expected %v and error %v, got %v and %v", tt.rt, tt.s, tt.expect, tt.err, got, err)
+		}
+	}
+}
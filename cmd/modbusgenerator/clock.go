@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// simClock is the clock the simulation runs by, which can run faster or
+// slower than real time, and be stepped forward, so long scenarios like
+// 24 hour load profiles can run in minutes.
+type simClock struct {
+	mu sync.Mutex
+	// start is the simulated time at the real time realStart.
+	start     time.Time
+	realStart time.Time
+	// speed is how many times faster than real time the clock runs,
+	// where 0 means the clock only moves when stepped.
+	speed float64
+	// stepped is the total duration the clock has been stepped.
+	stepped time.Duration
+	// paused is true while the clock stands still, since the real time
+	// pausedAt. pausedTotal is the real time the clock stood still
+	// before it was last resumed.
+	paused      bool
+	pausedAt    time.Time
+	pausedTotal time.Duration
+	// realNow returns the real time.
+	realNow func() time.Time
+}
+
+// newSimClock will return a clock starting at the time given, running
+// with the speed given.
+func newSimClock(start time.Time, speed float64) *simClock {
+	return &simClock{
+		start:     start,
+		realStart: time.Now(),
+		speed:     speed,
+		realNow:   time.Now,
+	}
+}
+
+// Now will return the current simulated time.
+func (c *simClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now()
+}
+
+// now will return the current simulated time. The clock must be locked
+// by the caller.
+func (c *simClock) now() time.Time {
+	realNow := c.realNow()
+	if c.paused {
+		realNow = c.pausedAt
+	}
+	elapsed := time.Duration(float64(realNow.Sub(c.realStart)-c.pausedTotal) * c.speed)
+	return c.start.Add(elapsed + c.stepped)
+}
+
+// Step will move the clock forward with the duration given.
+func (c *simClock) Step(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stepped += d
+}
+
+// Rewind will set the clock back to the time given, like the time a
+// snapshot was taken, and the clock continues from there.
+func (c *simClock) Rewind(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stepped += t.Sub(c.now())
+}
+
+// Pause will make the clock stand still until resumed, so everything run
+// by the clock stops changing. The clock can still be stepped while
+// paused.
+func (c *simClock) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.paused {
+		c.paused = true
+		c.pausedAt = c.realNow()
+	}
+}
+
+// Resume will make the paused clock run again from where it was paused.
+func (c *simClock) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.paused {
+		c.paused = false
+		c.pausedTotal += c.realNow().Sub(c.pausedAt)
+	}
+}
+
+// status will return the current simulated time, the speed, and if the
+// clock is paused.
+func (c *simClock) status() clockStatus {
+	now := c.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return clockStatus{Time: now, Speed: c.speed, Paused: c.paused}
+}
+
+// maxClockStep is the longest duration the clock can be stepped with at
+// once, since the simulation catches up with every interval of it.
+const maxClockStep = time.Hour * 24 * 7
+
+// maxStepsLocked is the max number of steps called in a row with the
+// server locked, so the requests are still answered while the simulation
+// catches up with a large step of the clock.
+const maxStepsLocked = 1000
+
+// runSteps will call step with the server locked for every interval of
+// simulated time passed on the clock, checking the clock every interval
+// of real time. When the clock runs faster than real time, or is
+// stepped, step is called several times in a row, so the simulation
+// moves in steps of the same size no matter the speed of the clock. The
+// server is unlocked after every maxStepsLocked steps in a row. When the
+// clock is rewound, the steps continue from the time it was set back to.
+func runSteps(serv *mbserver.Server, clk *simClock, interval time.Duration, step func(dt time.Duration)) {
+	prev := clk.Now()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := clk.Now()
+		if now.Before(prev) {
+			prev = now
+		}
+
+		for now.Sub(prev) >= interval {
+			prev = stepBatch(serv, prev, now, interval, step)
+		}
+	}
+}
+
+// stepBatch will call step with the server locked for every interval
+// from prev to now, at most maxStepsLocked times, and return the time
+// stepped to.
+func stepBatch(serv *mbserver.Server, prev time.Time, now time.Time, interval time.Duration, step func(dt time.Duration)) time.Time {
+	serv.Lock()
+	defer serv.Unlock()
+
+	for i := 0; i < maxStepsLocked && now.Sub(prev) >= interval; i++ {
+		step(interval)
+		prev = prev.Add(interval)
+	}
+	return prev
+}
+
+// clockStatus is the JSON body returned by the clock endpoints.
+type clockStatus struct {
+	Time   time.Time `json:"time"`
+	Speed  float64   `json:"speed"`
+	Paused bool      `json:"paused"`
+}
+
+// handleClock answers with the current simulated time.
+func (c *simClock) handleClock(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, c.status())
+}
+
+// handleClockStep will step the clock forward with the duration given
+// with the duration query parameter, e.g. /clock/step?duration=1h, and
+// answer with the new simulated time. The duration can be at most
+// maxClockStep.
+func (c *simClock) handleClockStep(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	d, err := time.ParseDuration(r.URL.Query().Get("duration"))
+	if err != nil || d < 0 {
+		http.Error(w, fmt.Sprintf("duration must be a positive duration like 1h, got %q", r.URL.Query().Get("duration")), http.StatusBadRequest)
+		return
+	}
+	if d > maxClockStep {
+		http.Error(w, fmt.Sprintf("duration can be at most %v, got %v", maxClockStep, d), http.StatusBadRequest)
+		return
+	}
+
+	c.Step(d)
+	writeJSON(w, http.StatusOK, c.status())
+}
+
+// handleClockPause will pause the clock, so the simulation blocks, the
+// linked process values and the schedules stop changing the registers,
+// e.g. for taking stable screenshots of a HMI, and answer with the
+// simulated time the clock stands still at.
+func (c *simClock) handleClockPause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c.Pause()
+	st := c.status()
+	log.Printf("info: simulation paused at %v\n", st.Time.Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, st)
+}
+
+// handleClockResume will make the paused clock run again, and answer
+// with the simulated time.
+func (c *simClock) handleClockResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c.Resume()
+	st := c.status()
+	log.Printf("info: simulation resumed at %v\n", st.Time.Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, st)
+}
+
+// printClockStatus will write the status of the clock given as a table.
+func printClockStatus(w io.Writer, st clockStatus) {
+	state := "running"
+	if st.Paused {
+		state = "paused"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSPEED\tSTATE")
+	fmt.Fprintf(tw, "%v\t%v\t%v\n", st.Time.Format(time.RFC3339), st.Speed, state)
+	tw.Flush()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestSimClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	realNow := time.Now()
+
+	tests := []struct {
+		speed   float64
+		elapsed time.Duration
+		step    time.Duration
+		expect  time.Time
+	}{
+		{1, time.Second, 0, start.Add(time.Second)},
+		{60, time.Second, 0, start.Add(time.Minute)},
+		{0, time.Hour, 0, start},
+		{0, time.Hour, time.Hour * 2, start.Add(time.Hour * 2)},
+		{0.5, time.Second * 10, time.Second, start.Add(time.Second * 6)},
+	}
+
+	for _, tt := range tests {
+		c := newSimClock(start, tt.speed)
+		c.realStart = realNow
+		c.realNow = func() time.Time { return realNow.Add(tt.elapsed) }
+		c.Step(tt.step)
+
+		if now := c.Now(); !now.Equal(tt.expect) {
+			t.Errorf("speed %v elapsed %v step %v: expected %v, got %v", tt.speed, tt.elapsed, tt.step, tt.expect, now)
+		}
+	}
+}
+
+func TestClockStepEndpoint(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newSimClock(start, 0)
+
+	rec := httptest.NewRecorder()
+	c.handleClockStep(rec, httptest.NewRequest("POST", "/clock/step?duration=1h", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected %v, got %v", http.StatusOK, rec.Code)
+	}
+	if now := c.Now(); !now.Equal(start.Add(time.Hour)) {
+		t.Errorf("expected %v, got %v", start.Add(time.Hour), now)
+	}
+
+	for _, d := range []string{"foo", "8760h"} {
+		rec = httptest.NewRecorder()
+		c.handleClockStep(rec, httptest.NewRequest("POST", "/clock/step?duration="+d, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected %v, got %v", d, http.StatusBadRequest, rec.Code)
+		}
+	}
+	if now := c.Now(); !now.Equal(start.Add(time.Hour)) {
+		t.Errorf("expected %v, got %v", start.Add(time.Hour), now)
+	}
+}
+
+func TestStepBatch(t *testing.T) {
+	serv := mbserver.NewServer()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(time.Millisecond * (maxStepsLocked*2 + 500))
+
+	// The steps are done in batches of at most maxStepsLocked, with the
+	// server unlocked between them.
+	var batches []int
+	prev := start
+	for now.Sub(prev) >= time.Millisecond {
+		steps := 0
+		prev = stepBatch(serv, prev, now, time.Millisecond, func(dt time.Duration) {
+			steps++
+		})
+		batches = append(batches, steps)
+
+		serv.Lock()
+		serv.Unlock()
+	}
+
+	expect := []int{maxStepsLocked, maxStepsLocked, 500}
+	if !isEqual(expect, batches) {
+		t.Errorf("expected %v, got %v", expect, batches)
+	}
+	if !prev.Equal(now) {
+		t.Errorf("expected %v, got %v", now, prev)
+	}
+}
+
+func TestClockPause(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	realNow := time.Now()
+	elapsed := time.Duration(0)
+
+	c := newSimClock(start, 2)
+	c.realStart = realNow
+	c.realNow = func() time.Time { return realNow.Add(elapsed) }
+
+	elapsed = time.Second
+	rec := httptest.NewRecorder()
+	c.handleClockPause(rec, httptest.NewRequest("POST", "/clock/pause", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected %v, got %v", http.StatusOK, rec.Code)
+	}
+
+	// The clock stands still while paused, but can still be stepped.
+	elapsed = time.Second * 10
+	if now := c.Now(); !now.Equal(start.Add(time.Second * 2)) {
+		t.Errorf("expected %v, got %v", start.Add(time.Second*2), now)
+	}
+	c.Step(time.Minute)
+	c.Pause()
+	if st := c.status(); !st.Paused || !st.Time.Equal(start.Add(time.Minute+time.Second*2)) {
+		t.Errorf("expected the clock paused at %v, got %+v", start.Add(time.Minute+time.Second*2), st)
+	}
+
+	// The clock runs again from where it was paused.
+	rec = httptest.NewRecorder()
+	c.handleClockResume(rec, httptest.NewRequest("POST", "/clock/resume", nil))
+	elapsed = time.Second * 12
+	if now := c.Now(); !now.Equal(start.Add(time.Minute + time.Second*6)) {
+		t.Errorf("expected %v, got %v", start.Add(time.Minute+time.Second*6), now)
+	}
+	if c.status().Paused {
+		t.Errorf("expected the clock running, got paused")
+	}
+
+	var buf bytes.Buffer
+	printClockStatus(&buf, clockStatus{Time: start, Speed: 2, Paused: true})
+	expectTable := "TIME                  SPEED  STATE\n" +
+		"2024-01-01T00:00:00Z  2      paused\n"
+	if expectTable != buf.String() {
+		t.Errorf("expected %q, got %q", expectTable, buf.String())
+	}
+
+	rec = httptest.NewRecorder()
+	c.handleClockPause(rec, httptest.NewRequest("GET", "/clock/pause", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %v, got %v", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestRunStepsRewind(t *testing.T) {
+	serv := mbserver.NewServer()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := newSimClock(start, 0)
+	var steps atomic.Int64
+	go runSteps(serv, clk, time.Millisecond*10, func(dt time.Duration) {
+		steps.Add(1)
+	})
+
+	time.Sleep(time.Millisecond * 20)
+	clk.Step(time.Millisecond * 100)
+	time.Sleep(time.Millisecond * 50)
+	if n := steps.Load(); n != 10 {
+		t.Errorf("expected %v, got %v", 10, n)
+	}
+
+	// The steps continue from the time the clock was set back to.
+	clk.Rewind(start)
+	if now := clk.Now(); !now.Equal(start) {
+		t.Errorf("expected %v, got %v", start, now)
+	}
+	time.Sleep(time.Millisecond * 50)
+	clk.Step(time.Millisecond * 20)
+	time.Sleep(time.Millisecond * 50)
+	if n := steps.Load(); n != 12 {
+		t.Errorf("expected %v, got %v", 12, n)
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// cloneSpec is the variation spec of the clone subcommand, telling how
+// many clones to make of a device, and how they differ from each other.
+type cloneSpec struct {
+	Count int `json:"count"`
+	// Name and Listen are templates for the name and listen address of
+	// each clone, given the instance with the name of the device and
+	// the index of the clone. The default name is the name of the device
+	// followed by the index, and the default listen address is the
+	// listen address of the device with the index added to the port.
+	Name   string `json:"name"`
+	Listen string `json:"listen"`
+	// Seed is the seed of the jitter, where 0 uses a new seed.
+	Seed   int64        `json:"seed"`
+	Jitter []jitterRule `json:"jitter"`
+	// Params is the templates of the instance parameters of the entries
+	// by the name of the parameter, given the instance with the name
+	// and index of the clone, like a pattern for the serial numbers.
+	Params map[string]string `json:"params"`
+
+	name   *template.Template
+	listen *template.Template
+	params map[string]*template.Template
+}
+
+// jitterRule adds a random value to the numbers of the entries of a
+// register type starting within the address range given, which is up to
+// plus or minus Range, and Percent percent of the number.
+type jitterRule struct {
+	RegisterType registerType `json:"registerType"`
+	// RegAddr is a single address, or a list with the first and last
+	// address of the range.
+	RegAddr interface{} `json:"regAddr"`
+	Range   float64     `json:"range"`
+	Percent float64     `json:"percent"`
+
+	first int
+	last  int
+}
+
+// intRanges is the range of the values of the integer types, which the
+// numbers are kept within when jittered.
+var intRanges = map[string][2]float64{
+	"int16BigEndian":        {math.MinInt16, math.MaxInt16},
+	"int16LittleEndian":     {math.MinInt16, math.MaxInt16},
+	"uint16BigEndian":       {0, math.MaxUint16},
+	"wordInt16LittleEndian": {0, math.MaxUint16},
+}
+
+// runClone implements the clone subcommand, which makes a number of
+// clones of a device with varied values, serial numbers and listen
+// addresses, e.g. for standing up a fleet of devices that do not all
+// look the same. The config files of each clone are written to a
+// directory with the name of the clone, and either a fleet config with
+// all the clones is written, or the command line for running each
+// clone is printed.
+// It returns the exit code, which is 0 on success and 2 on errors.
+func runClone(args []string) int {
+	fs := flag.NewFlagSet("clone", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Make clones of a device with varied values, serial numbers and listen addresses.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator clone [flags] device.json spec.json\n\n")
+		fmt.Fprintf(os.Stderr, "The device is given like a device of a fleet config, and the spec tells how the clones vary.\n\n")
+		fs.PrintDefaults()
+	}
+	outDir := fs.String("outDir", ".", "The directory to write the config files of the clones to, with a directory for each clone")
+	fleet := fs.Bool("fleet", false, "Write a fleet config with all the clones to fleet.json in outDir, instead of printing the command line for each clone")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	base, err := loadCloneDevice(fs.Arg(0))
+	if err != nil {
+		log.Printf("error: %v\n", err)
+		return 2
+	}
+	spec, err := loadCloneSpec(fs.Arg(1))
+	if err != nil {
+		log.Printf("error: %v\n", err)
+		return 2
+	}
+	if spec.Seed == 0 {
+		spec.Seed = time.Now().UnixNano()
+		log.Printf("info: using seed %v\n", spec.Seed)
+	}
+
+	clones, err := cloneDevice(base, fs.Arg(0), spec, *outDir)
+	if err != nil {
+		log.Printf("error: %v\n", err)
+		return 2
+	}
+
+	if *fleet {
+		filename := filepath.Join(*outDir, "fleet.json")
+		js, err := json.MarshalIndent(clones, "", "    ")
+		if err != nil {
+			log.Printf("error: %v\n", err)
+			return 2
+		}
+		err = os.WriteFile(filename, append(js, '\n'), 0644)
+		if err != nil {
+			log.Printf("error: %v\n", err)
+			return 2
+		}
+		fmt.Printf("modbusgenerator -fleet %v\n", filename)
+		return 0
+	}
+
+	printCloneCommands(os.Stdout, clones, *outDir)
+	return 0
+}
+
+// loadCloneDevice will load the device to clone, which is a single
+// device given like in a fleet config.
+func loadCloneDevice(filename string) (fleetDevice, error) {
+	var d fleetDevice
+	js, err := readConfig(filename)
+	if err != nil {
+		return d, err
+	}
+	js, err = expandEnv(stripJSONC(js))
+	if err != nil {
+		return d, fmt.Errorf("%v: %v", filename, err)
+	}
+	err = json.Unmarshal(js, &d)
+	if err != nil {
+		return d, fmt.Errorf("%v: decoding json: %v", filename, err)
+	}
+
+	if d.ConfigDir != "" || len(d.Layers) > 0 {
+		return d, fmt.Errorf("%v: configDir and layers can not be cloned, give the config files with jsonCoil, jsonDiscrete, jsonInput and jsonHolding", filename)
+	}
+	if d.JSONCoil == "" && d.JSONDiscrete == "" && d.JSONInput == "" && d.JSONHolding == "" {
+		return d, fmt.Errorf("%v: no config files given", filename)
+	}
+
+	return d, nil
+}
+
+// loadCloneSpec will load and check the variation spec given.
+func loadCloneSpec(filename string) (cloneSpec, error) {
+	var spec cloneSpec
+	js, err := readConfig(filename)
+	if err != nil {
+		return spec, err
+	}
+	js, err = expandEnv(stripJSONC(js))
+	if err != nil {
+		return spec, fmt.Errorf("%v: %v", filename, err)
+	}
+	err = json.Unmarshal(js, &spec)
+	if err != nil {
+		return spec, fmt.Errorf("%v: decoding json: %v", filename, err)
+	}
+
+	err = spec.parse()
+	if err != nil {
+		return spec, fmt.Errorf("%v: %v", filename, err)
+	}
+	return spec, nil
+}
+
+// parse will check the spec, and parse the templates and address ranges.
+func (s *cloneSpec) parse() error {
+	if s.Count < 1 {
+		return fmt.Errorf("count must be a positive integer, got %v", s.Count)
+	}
+
+	var err error
+	if s.Name == "" {
+		s.Name = "{{.Device}}-{{.Index}}"
+	}
+	s.name, err = template.New("name").Funcs(paramFuncs).Parse(s.Name)
+	if err != nil {
+		return fmt.Errorf("name: %v", err)
+	}
+	if s.Listen != "" {
+		s.listen, err = template.New("listen").Funcs(paramFuncs).Parse(s.Listen)
+		if err != nil {
+			return fmt.Errorf("listen: %v", err)
+		}
+	}
+	s.params = make(map[string]*template.Template)
+	for name, text := range s.Params {
+		s.params[name], err = template.New(name).Funcs(paramFuncs).Parse(text)
+		if err != nil {
+			return fmt.Errorf("param %v: %v", name, err)
+		}
+	}
+
+	for i := range s.Jitter {
+		r := &s.Jitter[i]
+		if r.RegisterType != inputType && r.RegisterType != holdingType {
+			return fmt.Errorf("jitter %v: registerType must be input or holding, got %q", i, r.RegisterType)
+		}
+		if r.Range < 0 || r.Percent < 0 || (r.Range == 0 && r.Percent == 0) {
+			return fmt.Errorf("jitter %v: range or percent must be given as a positive number", i)
+		}
+		switch v := r.RegAddr.(type) {
+		case float64:
+			r.first, r.last = int(v), int(v)
+		case []interface{}:
+			var first, last float64
+			ok := len(v) == 2
+			if ok {
+				first, ok = v[0].(float64)
+			}
+			if ok {
+				last, ok = v[1].(float64)
+			}
+			if !ok || first > last {
+				return fmt.Errorf("jitter %v: regAddr must be an address or a list with the first and last address, got %v", i, r.RegAddr)
+			}
+			r.first, r.last = int(first), int(last)
+		default:
+			return fmt.Errorf("jitter %v: regAddr must be an address or a list with the first and last address, got %v", i, r.RegAddr)
+		}
+	}
+
+	return nil
+}
+
+// cloneDevice will write the config files of each of the clones of the
+// device given to a directory with the name of the clone in outDir, and
+// return the clones as devices of a fleet config in outDir. The filename
+// is the file the device was loaded from, which the config files are
+// relative to.
+func cloneDevice(base fleetDevice, filename string, spec cloneSpec, outDir string) ([]fleetDevice, error) {
+	baseFiles := make(map[registerType][]map[string]interface{})
+	for rt, f := range map[registerType]string{
+		coilType:     base.JSONCoil,
+		discreteType: base.JSONDiscrete,
+		inputType:    base.JSONInput,
+		holdingType:  base.JSONHolding,
+	} {
+		if f == "" {
+			continue
+		}
+		f, err := resolveInclude(filename, f)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := loadConfigFile(f)
+		if err != nil {
+			return nil, err
+		}
+		// The entries with a count are expanded, so the values of
+		// each of the registers are jittered on their own.
+		for i, obj := range raw {
+			if _, ok := obj["type"]; !ok {
+				baseFiles[rt] = append(baseFiles[rt], obj)
+				continue
+			}
+			expanded, err := expandEntry(obj, i)
+			if err != nil {
+				return nil, withFile(f, err)
+			}
+			baseFiles[rt] = append(baseFiles[rt], expanded...)
+		}
+	}
+	for name := range spec.params {
+		if !usesParam(baseFiles, name) {
+			return nil, fmt.Errorf("param %v is not used by any of the entries of the device", name)
+		}
+	}
+
+	// The blocks are the same for all the clones, so the clones refer to
+	// the blocks file of the device.
+	if base.JSONBlocks != "" && !isURL(base.JSONBlocks) {
+		f, err := resolveInclude(filename, base.JSONBlocks)
+		if err != nil {
+			return nil, err
+		}
+		base.JSONBlocks, err = relativePath(outDir, f)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var clones []fleetDevice
+	names := make(map[string]bool)
+	for i := 0; i < spec.Count; i++ {
+		c := base
+		c.JSONCoil, c.JSONDiscrete, c.JSONInput, c.JSONHolding = "", "", "", ""
+
+		var err error
+		c.Name, err = executeTemplate(spec.name, instance{Device: base.Name, Index: i})
+		if err != nil {
+			return nil, fmt.Errorf("name: %v", err)
+		}
+		if c.Name == "" || names[c.Name] || strings.ContainsAny(c.Name, `/\`) {
+			return nil, fmt.Errorf("clone %v: the name %q must be unique, and can not be empty or have slashes", i, c.Name)
+		}
+		names[c.Name] = true
+
+		inst := instance{Device: c.Name, Index: i}
+		c.Listen, err = cloneListen(base.Listen, spec.listen, inst)
+		if err != nil {
+			return nil, fmt.Errorf("clone %v: %v", c.Name, err)
+		}
+
+		dir := filepath.Join(outDir, c.Name)
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+		rng := rand.New(rand.NewSource(spec.Seed + int64(i)))
+		for _, rt := range historyRegisterTypes {
+			raw, ok := baseFiles[rt]
+			if !ok {
+				continue
+			}
+			entries, err := varyEntries(raw, rt, spec, inst, rng)
+			if err != nil {
+				return nil, fmt.Errorf("clone %v: %v", c.Name, err)
+			}
+			js, err := marshalEntries(entries)
+			if err != nil {
+				return nil, err
+			}
+			err = os.WriteFile(filepath.Join(dir, string(rt)+".json"), js, 0644)
+			if err != nil {
+				return nil, err
+			}
+
+			f := filepath.ToSlash(filepath.Join(c.Name, string(rt)+".json"))
+			switch rt {
+			case coilType:
+				c.JSONCoil = f
+			case discreteType:
+				c.JSONDiscrete = f
+			case inputType:
+				c.JSONInput = f
+			case holdingType:
+				c.JSONHolding = f
+			}
+		}
+
+		clones = append(clones, c)
+	}
+
+	return clones, nil
+}
+
+// usesParam will return true if any of the raw entries given has the
+// instance parameter given.
+func usesParam(files map[registerType][]map[string]interface{}, name string) bool {
+	for _, raw := range files {
+		for _, obj := range raw {
+			if obj["param"] == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cloneListen will return the listen address of the clone given, from
+// the listen template of the spec, or else the listen address of the
+// device with the index of the clone added to the port.
+func cloneListen(listen string, t *template.Template, inst instance) (string, error) {
+	if t != nil {
+		return executeTemplate(t, inst)
+	}
+
+	host, p, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("the listen address of the device must have a port when no listen template is given, got %q", listen)
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil || port+inst.Index > 65535 {
+		return "", fmt.Errorf("invalid port of the listen address %q", listen)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port+inst.Index)), nil
+}
+
+// varyEntries will return a copy of the raw entries of the register type
+// given for a clone, with the jitter of the spec added to the numbers,
+// and the instance parameters of the spec filled in as the template of
+// the parameter.
+func varyEntries(raw []map[string]interface{}, rt registerType, spec cloneSpec, inst instance, rng *rand.Rand) ([]map[string]interface{}, error) {
+	var entries []map[string]interface{}
+	for _, obj := range raw {
+		o := make(map[string]interface{}, len(obj))
+		for k, v := range obj {
+			o[k] = v
+		}
+		entries = append(entries, o)
+
+		if name, ok := o["param"].(string); ok {
+			if t, ok := spec.params[name]; ok {
+				v, err := executeTemplate(t, inst)
+				if err != nil {
+					return nil, fmt.Errorf("param %v: %v", name, err)
+				}
+				o["paramTemplate"] = literalTemplate(v)
+			}
+			continue
+		}
+
+		n, ok := o["number"].(float64)
+		addr, hasAddr := o["regAddr"].(float64)
+		if !ok || !hasAddr {
+			continue
+		}
+		jittered := false
+		for _, r := range spec.Jitter {
+			if r.RegisterType != rt || int(addr) < r.first || int(addr) > r.last {
+				continue
+			}
+			n += (rng.Float64()*2 - 1) * r.Range
+			n += n * (rng.Float64()*2 - 1) * r.Percent / 100
+			jittered = true
+		}
+		if jittered {
+			o["number"] = limitJitter(o, n)
+		}
+	}
+
+	return entries, nil
+}
+
+// limitJitter will round the jittered number given to an integer for the
+// integer types, and keep it within the range of the type, and within
+// the min and max of the entry.
+func limitJitter(obj map[string]interface{}, n float64) float64 {
+	if !isFloatEntry(obj) {
+		n = math.Round(n)
+	}
+	typ, _ := obj["type"].(string)
+	if r, ok := intRanges[resolveTypeAlias(typ)]; ok {
+		n = math.Max(r[0], math.Min(r[1], n))
+	}
+	if min, ok := obj["min"].(float64); ok {
+		n = math.Max(min, n)
+	}
+	if max, ok := obj["max"].(float64); ok {
+		n = math.Min(max, n)
+	}
+	return n
+}
+
+// executeTemplate will return the text of the template given for the
+// instance given.
+func executeTemplate(t *template.Template, inst instance) (string, error) {
+	var b strings.Builder
+	err := t.Execute(&b, inst)
+	return b.String(), err
+}
+
+// literalTemplate will return a template giving the value given as it
+// is, which is quoted if it looks like a template itself.
+func literalTemplate(v string) string {
+	if strings.Contains(v, "{{") {
+		return "{{" + strconv.Quote(v) + "}}"
+	}
+	return v
+}
+
+// relativePath will return the path of the file given relative to the
+// directory given, so a fleet config in the directory can refer to it.
+func relativePath(dir string, filename string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absDir, abs)
+	if err != nil {
+		return abs, nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// printCloneCommands will write the command line for running each of the
+// clones given, where the config files are relative to outDir.
+func printCloneCommands(w io.Writer, clones []fleetDevice, outDir string) {
+	for _, c := range clones {
+		args := []string{"modbusgenerator", "-listenRTUTCPPort", c.Listen}
+		for _, v := range []struct {
+			flag string
+			file string
+		}{
+			{"-jsonCoil", c.JSONCoil},
+			{"-jsonDiscrete", c.JSONDiscrete},
+			{"-jsonInput", c.JSONInput},
+			{"-jsonHolding", c.JSONHolding},
+			{"-jsonBlocks", c.JSONBlocks},
+		} {
+			if v.file == "" {
+				continue
+			}
+			f := v.file
+			if !isURL(f) {
+				f = filepath.Join(outDir, f)
+			}
+			args = append(args, v.flag, f)
+		}
+		if c.Units != "" {
+			args = append(args, "-units", c.Units)
+		}
+		fmt.Fprintln(w, strings.Join(args, " "))
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCloneDevice(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "holding.json"), []byte(`[
+    {"type": "uint16", "number": 0, "count": 4, "regAddr": 1, "param": "serial", "paramFormat": "ascii"},
+    {"type": "float32_abcd", "number": 100, "count": 2, "regAddr": 101},
+    {"type": "uint16", "number": 65535, "regAddr": 105},
+    {"type": "uint16", "number": 50, "regAddr": 106, "min": 45, "max": 55},
+    {"type": "uint16", "number": 7, "regAddr": 200}
+]`), 0644)
+	os.WriteFile(filepath.Join(dir, "blocks.json"), []byte(`[]`), 0644)
+	os.WriteFile(filepath.Join(dir, "device.json"), []byte(`{
+    "name": "boiler",
+    "listen": "127.0.0.1:10502",
+    "units": "1,2",
+    "jsonHolding": "holding.json",
+    "jsonBlocks": "blocks.json",
+    "labels": {"site": "north"}
+}`), 0644)
+	os.WriteFile(filepath.Join(dir, "spec.json"), []byte(`{
+    "count": 3,
+    "seed": 1,
+    "jitter": [
+        {"registerType": "holding", "regAddr": [101, 106], "range": 10, "percent": 5}
+    ],
+    "params": {"serial": "SN{{printf \"%04d\" .Index}}"}
+}`), 0644)
+
+	base, err := loadCloneDevice(filepath.Join(dir, "device.json"))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	spec, err := loadCloneSpec(filepath.Join(dir, "spec.json"))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	outDir := filepath.Join(dir, "out")
+	clones, err := cloneDevice(base, filepath.Join(dir, "device.json"), spec, outDir)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	expect := fleetDevice{
+		Name:        "boiler-2",
+		Listen:      "127.0.0.1:10504",
+		Units:       "1,2",
+		JSONHolding: "boiler-2/holding.json",
+		JSONBlocks:  "../blocks.json",
+		Labels:      map[string]string{"site": "north"},
+	}
+	if len(clones) != 3 || !isEqual(expect, clones[2]) {
+		t.Fatalf("expected %v, got %v", expect, clones)
+	}
+
+	numbers := make(map[float64]bool)
+	for i, c := range clones {
+		raw, err := loadConfigFile(filepath.Join(outDir, c.JSONHolding))
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if len(raw) != 9 {
+			t.Fatalf("expected %v entries, got %v", 9, len(raw))
+		}
+
+		for _, n := range []float64{raw[4]["number"].(float64), raw[5]["number"].(float64)} {
+			if n < 85 || n > 116 {
+				t.Errorf("expected a number from 85 to 116, got %v", n)
+			}
+			numbers[n] = true
+		}
+		if n := raw[6]["number"].(float64); n > 65535 || n != float64(int(n)) {
+			t.Errorf("expected an integer up to 65535, got %v", n)
+		}
+		if n := raw[7]["number"].(float64); n < 45 || n > 55 {
+			t.Errorf("expected a number from 45 to 55, got %v", n)
+		}
+		if n := raw[8]["number"].(float64); n != 7 {
+			t.Errorf("expected %v, got %v", 7, n)
+		}
+		serial := []string{"SN0000", "SN0001", "SN0002"}[i]
+		for _, obj := range raw[0:4] {
+			if obj["paramTemplate"] != serial {
+				t.Errorf("expected %v, got %v", serial, obj["paramTemplate"])
+			}
+		}
+	}
+	if len(numbers) != 6 {
+		t.Errorf("expected the floats of each clone to differ, got %v", numbers)
+	}
+
+	// The same seed gives the same clones.
+	again, _ := cloneDevice(base, filepath.Join(dir, "device.json"), spec, filepath.Join(dir, "again"))
+	a, _ := os.ReadFile(filepath.Join(outDir, clones[1].JSONHolding))
+	b, _ := os.ReadFile(filepath.Join(dir, "again", again[1].JSONHolding))
+	if !bytes.Equal(a, b) {
+		t.Errorf("expected %s, got %s", a, b)
+	}
+
+	var buf bytes.Buffer
+	printCloneCommands(&buf, clones[:1], "out")
+	expectCommand := "modbusgenerator -listenRTUTCPPort 127.0.0.1:10502 -jsonHolding out/boiler-0/holding.json -jsonBlocks blocks.json -units 1,2\n"
+	if expectCommand != buf.String() {
+		t.Errorf("expected %q, got %q", expectCommand, buf.String())
+	}
+
+	js, _ := json.Marshal(clones[0])
+	expectJSON := `{"name":"boiler-0","listen":"127.0.0.1:10502","units":"1,2","jsonHolding":"boiler-0/holding.json","jsonBlocks":"../blocks.json","labels":{"site":"north"}}`
+	if expectJSON != string(js) {
+		t.Errorf("expected %v, got %v", expectJSON, string(js))
+	}
+}
+
+func TestCloneSpecInvalid(t *testing.T) {
+	for _, spec := range []cloneSpec{
+		{Count: 0},
+		{Count: 1, Name: "{{.Index"},
+		{Count: 1, Jitter: []jitterRule{{RegisterType: coilType, RegAddr: 1.0, Range: 1}}},
+		{Count: 1, Jitter: []jitterRule{{RegisterType: holdingType, RegAddr: 1.0}}},
+		{Count: 1, Jitter: []jitterRule{{RegisterType: holdingType, RegAddr: []interface{}{2.0, 1.0}, Range: 1}}},
+		{Count: 1, Jitter: []jitterRule{{RegisterType: holdingType, RegAddr: "1", Range: 1}}},
+	} {
+		if err := spec.parse(); err == nil {
+			t.Errorf("expected error for %+v, got nil", spec)
+		}
+	}
+}
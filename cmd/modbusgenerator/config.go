@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// configEntry is a single register entry of a config file, with the
+// encoder for the entry, and the raw config data the encoder was made
+// from so the optional fields of the entry can be looked up.
+type configEntry struct {
+	enc encoder
+	raw map[string]interface{}
+}
+
+// loadEncoders will load the config file given, and return an encoder
+// for each of the register entries in the config.
+func loadEncoders(filename string) ([]encoder, error) {
+	entries, err := loadEntries(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var registryData []encoder
+	for _, v := range entries {
+		registryData = append(registryData, v.enc)
+	}
+
+	return registryData, nil
+}
+
+// loadEntries will load the config file given, and return a config
+// entry for each of the register entries in the config.
+func loadEntries(filename string) ([]configEntry, error) {
+	entries, _, _, err := loadRegisterFile(filename)
+	return entries, err
+}
+
+// loadRegisterFile will load the config file given, and return a config
+// entry for each of the register entries in the config, and the fill
+// directives and consistency groups of the config.
+func loadRegisterFile(filename string) ([]configEntry, []fill, []group, error) {
+	registryRawData, fills, groups, err := readRegisterFile(filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	entries, errs := parseEntries(registryRawData)
+	if len(errs) > 0 {
+		return nil, nil, nil, withFile(filename, errs[0])
+	}
+
+	return entries, fills, groups, nil
+}
+
+// readRegisterFile will load the config file given, and return the raw
+// config data of the register entries, with the fill directives,
+// consistency groups and header taken out.
+func readRegisterFile(filename string) ([]map[string]interface{}, []fill, []group, error) {
+	// Since we are using the routine to unmarshall the JSON, and
+	// we want it unmarshaled into different types, we use a map
+	// with string key and empty interface to store the data values.
+	// The converting to the real type it represents is handled in
+	// the repsective types Encode method when being called upon.
+	registryRawData, err := loadConfigFileSources(filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Take out the fill directives, which fill a range of addresses
+	// instead of being an entry.
+	fills, registryRawData, err := splitFills(registryRawData)
+	if err != nil {
+		return nil, nil, nil, withFile(filename, err)
+	}
+
+	// Take out the header declaring the register type of the file, which
+	// is only used when loading the files of a config directory.
+	_, _, registryRawData, err = splitHeader(registryRawData)
+	if err != nil {
+		return nil, nil, nil, withFile(filename, err)
+	}
+
+	// Take out the consistency groups.
+	groups, registryRawData, err := splitGroups(registryRawData)
+	if err != nil {
+		return nil, nil, nil, withFile(filename, err)
+	}
+
+	return registryRawData, fills, groups, nil
+}
+
+// parseEntries will return a config entry for each of the register
+// entries of the raw config data, and an error for each of the entries
+// that are not valid, which are left out.
+func parseEntries(registryRawData []map[string]interface{}) ([]configEntry, []error) {
+	var entries []configEntry
+	var errs []error
+	for i, obj := range registryRawData {
+		e, err := parseEntry(obj, i)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		entries = append(entries, e...)
+	}
+
+	return entries, errs
+}
+
+// parseEntry will return the config entries of the raw entry given with
+// the index i, which is more than one entry when the entry has a count.
+func parseEntry(obj map[string]interface{}, i int) ([]configEntry, error) {
+	if _, ok := obj["type"].(string); !ok {
+		return nil, errorAt(obj, i, "type must be given as a string, got %v", obj["type"])
+	}
+	if _, ok := obj["regAddr"].(float64); !ok {
+		return nil, errorAt(obj, i, "regAddr must be a number, got %v", obj["regAddr"])
+	}
+
+	// Replace the boolean literals of the coils and discrete inputs,
+	// and the float special values given by name like "NaN".
+	if n, ok := parseBoolNumber(obj["number"]); ok {
+		obj["number"] = n
+	} else if s, ok := obj["number"].(string); ok {
+		n, err := parseSpecialNumber(s)
+		if err != nil {
+			return nil, errorAt(obj, i, "%v", err)
+		}
+		obj["number"] = n
+	}
+	if _, ok := obj["number"].(float64); !ok {
+		return nil, errorAt(obj, i, "number must be a number, got %v", obj["number"])
+	}
+
+	// Expand the entry that should be repeated over several registers
+	// with the "count" field.
+	expanded, err := expandEntry(obj, i)
+	if err != nil {
+		return nil, err
+	}
+
+	// Since encoder is an interface type, we need to figure out
+	// the concrete type each encoder is.
+	// Loop over the expanded entries, and call NewEncoder.
+	// New encoder will check the obj's type field and return an
+	// encoder of the correct concrete type.
+	var entries []configEntry
+	for _, o := range expanded {
+		enc := NewEncoder(o)
+		if enc == nil {
+			return nil, errorAt(o, i, "unknown type %v", o["type"])
+		}
+		if v, ok := enc.(validator); ok {
+			if err := v.validate(); err != nil {
+				return nil, errorAt(o, i, "%v", err)
+			}
+		}
+		if _, err := parseEntryMeta(o); err != nil {
+			return nil, errorAt(o, i, "%v", err)
+		}
+		if _, _, err := parseEntryParam(o); err != nil {
+			return nil, errorAt(o, i, "%v", err)
+		}
+		if _, _, _, err := parseEntryRandomize(o); err != nil {
+			return nil, errorAt(o, i, "%v", err)
+		}
+		entries = append(entries, configEntry{enc: enc, raw: o})
+	}
+
+	return entries, nil
+}
+
+// loadConfigFile will read and decode the config file given, and
+// return the raw config data with each element of the slice
+// representing a register entry.
+// The filename can also be "-" to read the config from stdin, or a
+// http:// or https:// URL to fetch the config from.
+// An entry on the form {"include": "filename"} is replaced with the
+// entries of the config file given, so a config can be assembled
+// from shared fragments. The filename of an include is relative to
+// the directory or URL of the file including it.
+func loadConfigFile(filename string) ([]map[string]interface{}, error) {
+	return loadConfigFileIncludes(filename, map[string]bool{}, false)
+}
+
+// loadConfigFileSources will load the config file given like
+// loadConfigFile, and record the file and line of each entry in the entry
+// with the sourceKey key.
+func loadConfigFileSources(filename string) ([]map[string]interface{}, error) {
+	return loadConfigFileIncludes(filename, map[string]bool{}, true)
+}
+
+// loadConfigFileIncludes does the work for loadConfigFile. The
+// including map holds the files currently being included, so
+// include cycles can be detected.
+func loadConfigFileIncludes(filename string, including map[string]bool, sources bool) ([]map[string]interface{}, error) {
+	key := filename
+	if filename != "-" && !isURL(filename) {
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return nil, err
+		}
+		key = abs
+	}
+	if including[key] {
+		return nil, fmt.Errorf("%v: include cycle detected", filename)
+	}
+	including[key] = true
+	defer delete(including, key)
+
+	js, err := readConfig(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %v: %v", filename, err)
+	}
+
+	// Remove the comments and trailing commas, so the config can be
+	// written as JSONC. A file with nothing in it is an empty register
+	// set.
+	js = stripJSONC(js)
+	if isEmptyJSON(js) {
+		return nil, nil
+	}
+
+	// Replace the ${ENV_VAR} references in the config with the
+	// values of the environment variables.
+	js, err = expandEnv(js)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+
+	registryRawData := []map[string]interface{}{}
+	err = json.Unmarshal(js, &registryRawData)
+	if err != nil {
+		if line := errorLine(js, err); line > 0 {
+			return nil, fmt.Errorf("%v: decoding json: line %v: %v", filename, line, err)
+		}
+		return nil, fmt.Errorf("%v: decoding json: %v", filename, err)
+	}
+
+	// Record the file and line of each entry, so the errors found later
+	// can tell where the entry is.
+	if sources {
+		lines := entryLines(js)
+		for i, obj := range registryRawData {
+			if i < len(lines) {
+				obj[sourceKey] = entrySource{file: filename, entry: i, line: lines[i]}
+			}
+		}
+	}
+
+	var entries []map[string]interface{}
+	for i, obj := range registryRawData {
+		inc, ok := obj["include"]
+		if !ok {
+			entries = append(entries, obj)
+			continue
+		}
+
+		incFile, ok := inc.(string)
+		if !ok {
+			return nil, withFile(filename, errorAt(obj, i, "include must be a filename, got %v", inc))
+		}
+		incFile, err := resolveInclude(filename, incFile)
+		if err != nil {
+			return nil, withFile(filename, errorAt(obj, i, "%v", err))
+		}
+
+		incEntries, err := loadConfigFileIncludes(incFile, including, sources)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, incEntries...)
+	}
+
+	return entries, nil
+}
+
+// sourceKey is the key of the raw config data holding the entrySource of
+// the entry.
+const sourceKey = "_source"
+
+// entrySource is the position of an entry in the config files, with the
+// index of the entry in the file and the line it starts on.
+type entrySource struct {
+	file  string
+	entry int
+	line  int
+}
+
+func (s entrySource) String() string {
+	return fmt.Sprintf("%v entry %v (line %v)", s.file, s.entry, s.line)
+}
+
+// sourceOf will return the position of the raw entry given, and false if
+// the position is not known.
+func sourceOf(obj map[string]interface{}) (entrySource, bool) {
+	s, ok := obj[sourceKey].(entrySource)
+	return s, ok
+}
+
+// source will return the position the entry was loaded from, and false
+// if the position is not known.
+func (e configEntry) source() (entrySource, bool) {
+	return sourceOf(e.raw)
+}
+
+// entryError is an error found in an entry of a config file, with the
+// position of the entry.
+type entryError struct {
+	src entrySource
+	err error
+}
+
+func (e *entryError) Error() string {
+	return fmt.Sprintf("%v: %v", e.src, e.err)
+}
+
+// errorAt will return the error formatted for the raw entry given with
+// the index i, with the position of the entry when known, like
+// holding.json entry 17 (line 203): ..., or else with the index.
+func errorAt(obj map[string]interface{}, i int, format string, a ...interface{}) error {
+	err := fmt.Errorf(format, a...)
+	if src, ok := sourceOf(obj); ok {
+		return &entryError{src: src, err: err}
+	}
+	return fmt.Errorf("entry %v: %v", i, err)
+}
+
+// errorf will return the error formatted for the entry, with the position
+// of the entry when known, or else with the address of the entry.
+func (e configEntry) errorf(format string, a ...interface{}) error {
+	err := fmt.Errorf(format, a...)
+	if src, ok := e.source(); ok {
+		return &entryError{src: src, err: err}
+	}
+	return fmt.Errorf("address %v: %v", e.enc.Address(), err)
+}
+
+// withFile will return the error prefixed with the filename given, unless
+// the error already tells the position of the entry.
+func withFile(filename string, err error) error {
+	var ee *entryError
+	if errors.As(err, &ee) {
+		return err
+	}
+	return fmt.Errorf("%v: %v", filename, err)
+}
+
+// entryLines will return the line number of the start of each of the
+// elements of the JSON array given. The JSON must be valid.
+func entryLines(js []byte) []int {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	if _, err := dec.Token(); err != nil {
+		return nil
+	}
+
+	var lines []int
+	line := 1
+	pos := 0
+	for dec.More() {
+		// The offset is at the end of the previous element, so skip the
+		// comma and the white space to find the start of the element.
+		start := int(dec.InputOffset())
+		for start < len(js) && strings.ContainsRune(", \t\r\n", rune(js[start])) {
+			start++
+		}
+		line += bytes.Count(js[pos:start], []byte("\n"))
+		pos = start
+		lines = append(lines, line)
+
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			break
+		}
+	}
+
+	return lines
+}
+
+// errorLine will return the line of the JSON given where the decoding
+// error given was found, or 0 if the error has no position.
+func errorLine(js []byte, err error) int {
+	var offset int64
+	var se *json.SyntaxError
+	var te *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &se):
+		offset = se.Offset
+	case errors.As(err, &te):
+		offset = te.Offset
+	default:
+		return 0
+	}
+	if offset > int64(len(js)) {
+		offset = int64(len(js))
+	}
+	return bytes.Count(js[:offset], []byte("\n")) + 1
+}
+
+// configHTTPTimeout is the timeout used when fetching a config from
+// an URL.
+const configHTTPTimeout = time.Second * 30
+
+// isURL will return true if the name given is a http or https URL.
+func isURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// readConfig will read the content of the config given, where name
+// is either a filename, "-" for stdin, or a http or https URL.
+func readConfig(name string) ([]byte, error) {
+	switch {
+	case name == "-":
+		return io.ReadAll(os.Stdin)
+	case isURL(name):
+		client := http.Client{Timeout: configHTTPTimeout}
+		resp, err := client.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected http status: %v", resp.Status)
+		}
+		return io.ReadAll(resp.Body)
+	default:
+		b, err := os.ReadFile(name)
+		if err == nil {
+			loadedConfigs.add(name, b)
+		}
+		return b, err
+	}
+}
+
+// resolveInclude will return the name of the config to include, where
+// a relative include is resolved against the directory or URL of the
+// config including it. A config read from stdin includes relative to
+// the current directory.
+func resolveInclude(parent string, include string) (string, error) {
+	if isURL(include) || filepath.IsAbs(include) {
+		return include, nil
+	}
+
+	switch {
+	case parent == "-":
+		return include, nil
+	case isURL(parent):
+		base, err := url.Parse(parent)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(include)
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(ref).String(), nil
+	default:
+		return filepath.Join(filepath.Dir(parent), include), nil
+	}
+}
+
+// envRef matches a ${NAME} or ${NAME:-default} reference to an
+// environment variable.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv will replace all the ${NAME} references in the config
+// data with the value of the environment variable NAME. If the
+// variable is not set the default value given with ${NAME:-default}
+// is used, and if no default value is given an error is returned.
+// Since the replacing is done before the JSON is decoded, references
+// can be used for any value in the config, including numbers like
+// the address and count, e.g. "regAddr": ${BASE_ADDR:-100}.
+func expandEnv(b []byte) ([]byte, error) {
+	var err error
+
+	expanded := envRef.ReplaceAllFunc(b, func(ref []byte) []byte {
+		m := envRef.FindSubmatch(ref)
+		name := string(m[1])
+
+		if v, ok := os.LookupEnv(name); ok {
+			return []byte(v)
+		}
+		if m[2] != nil {
+			return m[3]
+		}
+
+		if err == nil {
+			err = fmt.Errorf("environment variable %v referenced in config is not set", name)
+		}
+		return ref
+	})
+
+	return expanded, err
+}
+
+// expandCount will expand every entry of the raw config data that
+// have a "count" field into count entries placed in consecutive
+// registers, as done by expandEntry.
+// Entries without a "count" field are returned as they are.
+func expandCount(registryRawData []map[string]interface{}) ([]map[string]interface{}, error) {
+	var expanded []map[string]interface{}
+
+	for i, obj := range registryRawData {
+		e, err := expandEntry(obj, i)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, e...)
+	}
+
+	return expanded, nil
+}
+
+// maxEntryCount is the size of the address space of each register type,
+// which is the largest count of an entry, and the last address the
+// expanded entries can reach.
+const maxEntryCount = 65536
+
+// expandEntry will expand the raw entry given with the index i into
+// count entries placed in consecutive registers when it has a "count"
+// field, starting at the address given in "regAddr". The address is
+// incremented with the number of words the type of the entry is encoded
+// into.
+// If the entry also have an "increment" field, the increment is added
+// to the number for each new entry, so an indexed pattern can be made
+// by setting number to 0 and increment to 1.
+// An entry without a "count" field is returned as it is.
+func expandEntry(obj map[string]interface{}, i int) ([]map[string]interface{}, error) {
+	c, ok := obj["count"]
+	if !ok {
+		return []map[string]interface{}{obj}, nil
+	}
+
+	count, ok := c.(float64)
+	if !ok || count < 1 || count != float64(int(count)) {
+		return nil, errorAt(obj, i, "count must be a positive integer, got %v", c)
+	}
+	if count > maxEntryCount {
+		return nil, errorAt(obj, i, "count must be at most %v, got %v", maxEntryCount, c)
+	}
+
+	var increment float64
+	if inc, ok := obj["increment"]; ok {
+		increment, ok = inc.(float64)
+		if !ok {
+			return nil, errorAt(obj, i, "increment must be a number, got %v", inc)
+		}
+	}
+
+	enc := NewEncoder(obj)
+	if enc == nil {
+		return nil, errorAt(obj, i, "unknown type %v", obj["type"])
+	}
+	size := len(enc.Encode())
+
+	// The expanded entries must fit in the address space, so a mistyped
+	// count can not use up the memory when the config is loaded.
+	start, ok := obj["regAddr"].(float64)
+	if !ok {
+		return nil, errorAt(obj, i, "regAddr must be a number, got %v", obj["regAddr"])
+	}
+	if last := start + count*float64(size) - 1; last >= maxEntryCount {
+		return nil, errorAt(obj, i, "count %v of %v words from regAddr %v goes past address %v", c, size, obj["regAddr"], maxEntryCount-1)
+	}
+
+	var expanded []map[string]interface{}
+	for n := 0; n < int(count); n++ {
+		o := make(map[string]interface{}, len(obj))
+		for k, v := range obj {
+			o[k] = v
+		}
+		delete(o, "count")
+		delete(o, "increment")
+
+		// Only add to the number when it changes, so the payload
+		// of a NaN is kept.
+		if n > 0 && increment != 0 {
+			o["number"] = obj["number"].(float64) + float64(n)*increment
+		}
+		o["regAddr"] = start + float64(n*size)
+
+		expanded = append(expanded, o)
+	}
+
+	return expanded, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandCount(t *testing.T) {
+	registryRawData := []map[string]interface{}{
+		{"type": "float32BigWordBigEndian", "number": 10.0, "regAddr": 101.0, "count": 3.0, "increment": 0.5},
+		{"type": "wordInt16LittleEndian", "number": 1.0, "regAddr": 201.0, "count": 2.0},
+		{"type": "wordInt16LittleEndian", "number": 7.0, "regAddr": 300.0},
+	}
+
+	got, err := expandCount(registryRawData)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	expect := []map[string]interface{}{
+		{"type": "float32BigWordBigEndian", "number": 10.0, "regAddr": 101.0},
+		{"type": "float32BigWordBigEndian", "number": 10.5, "regAddr": 103.0},
+		{"type": "float32BigWordBigEndian", "number": 11.0, "regAddr": 105.0},
+		{"type": "wordInt16LittleEndian", "number": 1.0, "regAddr": 201.0},
+		{"type": "wordInt16LittleEndian", "number": 1.0, "regAddr": 202.0},
+		{"type": "wordInt16LittleEndian", "number": 7.0, "regAddr": 300.0},
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestExpandCountBadCount(t *testing.T) {
+	tests := []map[string]interface{}{
+		{"type": "float32BigWordBigEndian", "number": 10.0, "regAddr": 101.0, "count": 1.5},
+		{"type": "uint16BigEndian", "number": 1.0, "regAddr": 0.0, "count": 1e12},
+		// The count fits, but not from the address with 2 words each.
+		{"type": "float32BigWordBigEndian", "number": 10.0, "regAddr": 60000.0, "count": 3000.0},
+		// The last address is 65535.
+		{"type": "uint16BigEndian", "number": 1.0, "regAddr": 65535.0, "count": 2.0},
+		{"type": "uint16BigEndian", "number": 1.0, "regAddr": 1.0, "count": 65536.0},
+	}
+
+	for _, tt := range tests {
+		_, err := expandCount([]map[string]interface{}{tt})
+		if err == nil {
+			t.Errorf("%v: expected error not nil, got %v", tt, err)
+		}
+	}
+
+	// The whole address space can be filled.
+	got, err := expandCount([]map[string]interface{}{
+		{"type": "uint16BigEndian", "number": 1.0, "regAddr": 0.0, "count": 65536.0},
+	})
+	if err != nil || len(got) != 65536 {
+		t.Errorf("expected 65536 entries, got %v and %v", len(got), err)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("MBG_TEST_ADDR", "200")
+
+	got, err := expandEnv([]byte(`{"regAddr": ${MBG_TEST_ADDR}, "number": ${MBG_TEST_UNSET:-1.5}}`))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := `{"regAddr": 200, "number": 1.5}`
+	if expect != string(got) {
+		t.Errorf("expected %v, got %v", expect, string(got))
+	}
+
+	_, err = expandEnv([]byte(`{"regAddr": ${MBG_TEST_UNSET}}`))
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v", err)
+	}
+}
+
+func TestLoadConfigFileInclude(t *testing.T) {
+	dir := t.TempDir()
+
+	common := `[{"type": "wordInt16LittleEndian", "number": 1, "regAddr": 101}]`
+	device := `[{"include": "common.json"}, {"type": "wordInt16LittleEndian", "number": 2, "regAddr": 102}]`
+	os.WriteFile(filepath.Join(dir, "common.json"), []byte(common), 0644)
+	os.WriteFile(filepath.Join(dir, "device.json"), []byte(device), 0644)
+
+	got, err := loadConfigFile(filepath.Join(dir, "device.json"))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := []map[string]interface{}{
+		{"type": "wordInt16LittleEndian", "number": 1.0, "regAddr": 101.0},
+		{"type": "wordInt16LittleEndian", "number": 2.0, "regAddr": 102.0},
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestLoadConfigFileIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+
+	os.WriteFile(filepath.Join(dir, "a.json"), []byte(`[{"include": "b.json"}]`), 0644)
+	os.WriteFile(filepath.Join(dir, "b.json"), []byte(`[{"include": "a.json"}]`), 0644)
+
+	_, err := loadConfigFile(filepath.Join(dir, "a.json"))
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v", err)
+	}
+}
+
+func TestLoadConfigFileURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/configs/device.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"include": "common.json"}]`))
+	})
+	mux.HandleFunc("/configs/common.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"type": "wordInt16LittleEndian", "number": 1, "regAddr": 101}]`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	got, err := loadConfigFile(ts.URL + "/configs/device.json")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := []map[string]interface{}{
+		{"type": "wordInt16LittleEndian", "number": 1.0, "regAddr": 101.0},
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	_, err = loadConfigFile(ts.URL + "/configs/missing.json")
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v", err)
+	}
+}
+
+func TestConfigErrorPosition(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "holding.json")
+	os.WriteFile(filename, []byte(`[
+	{"type": "int16BigEndian", "number": 1, "regAddr": 3},
+	{"fill": [10, 12], "value": 1},
+
+	{"type": "int16BigEndian", "number": 1, "regAddr": 5, "count": 0}
+]`), 0644)
+
+	_, _, _, err := loadRegisterFile(filename)
+	expect := filename + " entry 2 (line 5): count must be a positive integer, got 0"
+	if err == nil || err.Error() != expect {
+		t.Errorf("expected %v, got %v", expect, err)
+	}
+
+	// Entries of an included file are given with the included file.
+	common := filepath.Join(dir, "common.json")
+	os.WriteFile(common, []byte(`[
+	{"type": "nope", "number": 1, "regAddr": 3}]`), 0644)
+	os.WriteFile(filename, []byte(`[{"include": "common.json"}]`), 0644)
+	_, _, _, err = loadRegisterFile(filename)
+	expect = common + " entry 0 (line 2): unknown type nope"
+	if err == nil || err.Error() != expect {
+		t.Errorf("expected %v, got %v", expect, err)
+	}
+
+	// Syntax errors are given with the line.
+	os.WriteFile(filename, []byte("[\n{\"type\": \"int16BigEndian\",\n\"number\": 1 \"regAddr\": 3}]"), 0644)
+	_, _, _, err = loadRegisterFile(filename)
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expected the line of the syntax error, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// splitHeader will take out the header directive of the raw config data,
+// given like {"registerType": "holding", "unitID": 2}, which declares the
+// register type of the entries in the file, and optionally the unit the
+// entries are for. The register type is empty if the config has no header.
+func splitHeader(registryRawData []map[string]interface{}) (registerType, uint8, []map[string]interface{}, error) {
+	var rt registerType
+	var unit uint8
+	var rest []map[string]interface{}
+	found := false
+
+	for i, obj := range registryRawData {
+		v, ok := obj["registerType"]
+		if !ok {
+			rest = append(rest, obj)
+			continue
+		}
+		if found {
+			return "", 0, nil, errorAt(obj, i, "only one registerType header is allowed")
+		}
+		found = true
+
+		s, _ := v.(string)
+		rt = registerType(s)
+		switch rt {
+		case coilType, discreteType, inputType, holdingType:
+		default:
+			return "", 0, nil, errorAt(obj, i, "registerType must be coil, discrete, input or holding, got %v", v)
+		}
+
+		if u, ok := obj["unitID"]; ok {
+			id, ok := u.(float64)
+			if !ok || id < 1 || id > 247 || id != float64(int(id)) {
+				return "", 0, nil, errorAt(obj, i, "invalid unitID %v, valid unit IDs are 1 to 247", u)
+			}
+			unit = uint8(id)
+		}
+	}
+
+	return rt, unit, rest, nil
+}
+
+// loadConfigDir will return the config files of the directory given, or
+// the files matching the glob pattern given, with the register type and
+// unit ID declared in the header of each file. All the .json files of a
+// directory are used. The files are returned sorted by name, so they are
+// always loaded in the same order.
+func loadConfigDir(pattern string) ([]registerFile, error) {
+	if fi, err := os.Stat(pattern); err == nil && fi.IsDir() {
+		pattern = filepath.Join(pattern, "*.json")
+	}
+	names, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", pattern, err)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%v: no config files found", pattern)
+	}
+	sort.Strings(names)
+
+	var files []registerFile
+	for _, name := range names {
+		raw, err := loadConfigFileSources(name)
+		if err != nil {
+			return nil, err
+		}
+		rt, unit, _, err := splitHeader(raw)
+		if err != nil {
+			return nil, withFile(name, err)
+		}
+		if rt == "" {
+			return nil, fmt.Errorf("%v: no registerType header, add one like {\"registerType\": \"holding\"}", name)
+		}
+		files = append(files, registerFile{filename: name, registerType: rt, unit: unit})
+	}
+
+	return files, nil
+}
+
+// unitImage will return the registers loaded for the unit ID given, which
+// start as a copy of the registers of the server the first time the unit
+// is seen.
+func (p *profile) unitImage(serv *mbserver.Server, id uint8) *mbserver.Server {
+	if p.units == nil {
+		p.units = make(map[uint8]*mbserver.Server)
+	}
+	img, ok := p.units[id]
+	if !ok {
+		img = mbserver.NewServer()
+		copyRegisters(img, serv)
+		p.units[id] = img
+	}
+	return img
+}
+
+// unitIDs will return the IDs of the units with their own config files,
+// sorted.
+func (p profile) unitIDs() []uint8 {
+	var ids []uint8
+	for id := range p.units {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// registerLen will return the number of registers of the register type
+// given that the server answers for.
+func registerLen(serv *mbserver.Server, rt registerType) int {
+	switch rt {
+	case coilType:
+		return len(serv.Coils)
+	case discreteType:
+		return len(serv.DiscreteInputs)
+	case inputType:
+		return len(serv.InputRegisters)
+	case holdingType:
+		return len(serv.HoldingRegisters)
+	}
+	return 0
+}
+
+// extendRegister will make the server answer for at least n registers of
+// the register type given, so a config file setting the registers does
+// not cut away the registers set by an earlier file.
+func extendRegister(serv *mbserver.Server, rt registerType, n int) {
+	switch rt {
+	case coilType:
+		if n > len(serv.Coils) && n <= cap(serv.Coils) {
+			serv.Coils = serv.Coils[:n]
+		}
+	case discreteType:
+		if n > len(serv.DiscreteInputs) && n <= cap(serv.DiscreteInputs) {
+			serv.DiscreteInputs = serv.DiscreteInputs[:n]
+		}
+	case inputType:
+		if n > len(serv.InputRegisters) && n <= cap(serv.InputRegisters) {
+			serv.InputRegisters = serv.InputRegisters[:n]
+		}
+	case holdingType:
+		if n > len(serv.HoldingRegisters) && n <= cap(serv.HoldingRegisters) {
+			serv.HoldingRegisters = serv.HoldingRegisters[:n]
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestLoadConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "b.json"), []byte(`[
+		{"registerType": "holding"},
+		{"type": "int16BigEndian", "number": 2, "regAddr": 5}]`), 0644)
+	os.WriteFile(filepath.Join(dir, "a.json"), []byte(`[
+		{"registerType": "holding"},
+		{"type": "int16BigEndian", "number": 1, "regAddr": 3}]`), 0644)
+	os.WriteFile(filepath.Join(dir, "c.json"), []byte(`[
+		{"registerType": "holding", "unitID": 2},
+		{"type": "int16BigEndian", "number": 7, "regAddr": 3}]`), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte(`not a config`), 0644)
+
+	files, err := loadConfigDir(dir)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := []registerFile{
+		{filename: filepath.Join(dir, "a.json"), registerType: holdingType},
+		{filename: filepath.Join(dir, "b.json"), registerType: holdingType},
+		{filename: filepath.Join(dir, "c.json"), registerType: holdingType, unit: 2},
+	}
+	if !isEqual(expect, files) {
+		t.Fatalf("expected %v, got %v", expect, files)
+	}
+
+	serv := mbserver.NewServer()
+	p, configErrors := loadProfile(serv, files, "", -1, false, false)
+	if configErrors != 0 {
+		t.Fatalf("expected no errors, got %v", configErrors)
+	}
+
+	// Both files for the server itself are loaded, without the second
+	// cutting away the registers of the first.
+	if len(p.entries[holdingType]) != 2 {
+		t.Errorf("expected 2 entries, got %v", len(p.entries[holdingType]))
+	}
+	got := serv.HoldingRegisters[2:5]
+	if !isEqual([]uint16{1, 0, 2}, got) {
+		t.Errorf("expected %v, got %v", []uint16{1, 0, 2}, got)
+	}
+
+	// The unit gets the registers of the server, with the values of its
+	// own file on top.
+	if !isEqual([]uint8{2}, p.unitIDs()) {
+		t.Fatalf("expected %v, got %v", []uint8{2}, p.unitIDs())
+	}
+	got = p.units[2].HoldingRegisters[2:5]
+	if !isEqual([]uint16{7, 0, 2}, got) {
+		t.Errorf("expected %v, got %v", []uint16{7, 0, 2}, got)
+	}
+}
+
+func TestLoadConfigDirErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := loadConfigDir(filepath.Join(dir, "*.json"))
+	if err == nil {
+		t.Errorf("expected an error when no files match")
+	}
+
+	os.WriteFile(filepath.Join(dir, "holding.json"), []byte(`[{"type": "int16BigEndian", "number": 1, "regAddr": 1}]`), 0644)
+	_, err = loadConfigDir(dir)
+	if err == nil {
+		t.Errorf("expected an error for a file without a header")
+	}
+
+	os.WriteFile(filepath.Join(dir, "holding.json"), []byte(`[{"registerType": "holding", "unitID": 300}]`), 0644)
+	_, err = loadConfigDir(dir)
+	if err == nil {
+		t.Errorf("expected an error for an invalid unit ID")
+	}
+}
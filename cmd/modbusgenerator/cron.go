@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression with the five fields minute,
+// hour, day of month, month and day of week. Each field holds the set of
+// values the field matches.
+type cronSchedule struct {
+	minute     map[int]bool
+	hour       map[int]bool
+	dayOfMonth map[int]bool
+	month      map[int]bool
+	dayOfWeek  map[int]bool
+	// domAny and dowAny are true if the day of month and day of week
+	// fields are *, since a restricted day field matches on its own.
+	domAny bool
+	dowAny bool
+}
+
+// parseCron will parse a cron expression like "0 22 * * 1-5". Each field
+// can be *, a number, a range like 1-5, a list like 1,3,5, and a step like
+// */15 or 0-30/10. Day of week is 0-6 where 0 is sunday, and 7 is also
+// accepted for sunday.
+func parseCron(expr string) (cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("cron expression %q must have 5 fields, got %v", expr, len(fields))
+	}
+
+	var cs cronSchedule
+	var err error
+	if cs.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return cronSchedule{}, fmt.Errorf("cron expression %q: minute: %v", expr, err)
+	}
+	if cs.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return cronSchedule{}, fmt.Errorf("cron expression %q: hour: %v", expr, err)
+	}
+	if cs.dayOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return cronSchedule{}, fmt.Errorf("cron expression %q: day of month: %v", expr, err)
+	}
+	if cs.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return cronSchedule{}, fmt.Errorf("cron expression %q: month: %v", expr, err)
+	}
+	if cs.dayOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return cronSchedule{}, fmt.Errorf("cron expression %q: day of week: %v", expr, err)
+	}
+	if cs.dayOfWeek[7] {
+		cs.dayOfWeek[0] = true
+	}
+	cs.domAny = fields[2] == "*"
+	cs.dowAny = fields[4] == "*"
+
+	return cs, nil
+}
+
+// parseCronField will parse a single field of a cron expression, and
+// return the set of values between min and max it matches.
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			lo, err = strconv.Atoi(loStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", loStr)
+			}
+			hi = lo
+			if isRange {
+				hi, err = strconv.Atoi(hiStr)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value %q", hiStr)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is outside the range %v-%v", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// matches will return true if the minute of the time given matches the
+// cron schedule. As with cron, when both the day of month and the day of
+// week are restricted, a day matching either of them matches.
+func (cs cronSchedule) matches(t time.Time) bool {
+	if !cs.minute[t.Minute()] || !cs.hour[t.Hour()] || !cs.month[int(t.Month())] {
+		return false
+	}
+
+	dom := cs.dayOfMonth[t.Day()]
+	dow := cs.dayOfWeek[int(t.Weekday())]
+	switch {
+	case cs.domAny && cs.dowAny:
+		return true
+	case cs.domAny:
+		return dow
+	case cs.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// prev will return the last time at or before the time given that
+// matches the cron schedule, searching back at most the duration given.
+// It returns false if no time matched.
+func (cs cronSchedule) prev(t time.Time, within time.Duration) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	for end := t.Add(-within); !t.Before(end); t = t.Add(-time.Minute) {
+		if cs.matches(t) {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	// 2024-01-01 is a monday.
+	monday := time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC)
+	saturday := time.Date(2024, 1, 6, 22, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr   string
+		t      time.Time
+		expect bool
+	}{
+		{"* * * * *", monday, true},
+		{"0 22 * * *", monday, true},
+		{"0 22 * * *", monday.Add(time.Minute), false},
+		{"0 22 * * 1-5", monday, true},
+		{"0 22 * * 1-5", saturday, false},
+		{"*/15 22 * * *", monday.Add(time.Minute * 45), true},
+		{"*/15 22 * * *", monday.Add(time.Minute * 40), false},
+		{"0 22 6 * 1", saturday, true},
+		{"0 22 * 2 *", monday, false},
+		{"0 22 * * 0,7", saturday.Add(time.Hour * 24), true},
+	}
+
+	for _, tt := range tests {
+		cs, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%q: expected nil, got %v", tt.expr, err)
+		}
+		if cs.matches(tt.t) != tt.expect {
+			t.Errorf("%q at %v: expected %v, got %v", tt.expr, tt.t, tt.expect, !tt.expect)
+		}
+	}
+
+	cs, _ := parseCron("30 6 * * *")
+	prev, ok := cs.prev(monday, time.Hour*24)
+	expect := time.Date(2024, 1, 1, 6, 30, 0, 0, time.UTC)
+	if !ok || !prev.Equal(expect) {
+		t.Errorf("expected %v, got %v", expect, prev)
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{"* * * *", "60 * * * *", "* 5-2 * * *", "*/0 * * * *", "foo * * * *"} {
+		_, err := parseCron(expr)
+		if err == nil {
+			t.Errorf("%q: expected error not nil, got %v", expr, err)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ctlTimeout is the timeout of the requests to the running instance.
+const ctlTimeout = time.Second * 10
+
+// runCtl implements the ctl subcommand, which reads and writes the values
+// of a running instance through its HTTP server, so scripts can change
+// the values without making Modbus requests.
+// It returns the exit code, which is 0 on success, 1 if the request
+// failed or the value was not as expected by assert or wait-for, and 2 on
+// invalid usage.
+func runCtl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Read and write the values of a running modbus generator through its HTTP server given with -httpListen or -httpSocket.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] get <coil|discrete|input|holding> <address>\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] set <coil|discrete|input|holding> <address> <value>\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] batch <file|->\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] decode <coil|discrete|input|holding> <first..last> [type]\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] dump\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] status\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] serial <up|slow|down>\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] <pause|resume>\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] step <duration>\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] snapshot [name]\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] rewind <name>\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] quality [<input|holding> <address> <good|uncertain|bad|auto>]\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] assert <coil|discrete|input|holding> <address> <expected>\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] wait-for <coil|discrete|input|holding> <address> <expected>\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator ctl [flags] wait-write <coil|holding> <address> [expected]\n\n")
+		fmt.Fprintf(os.Stderr, "The expected value is a number with an optional ==, !=, <, <=, > or >= before it, e.g. '>=20.5'.\n\n")
+		fs.PrintDefaults()
+	}
+	server := fs.String("url", "http://localhost:8080", "The URL of the HTTP server of the running instance, or unix:///path for the unix socket given with httpSocket")
+	typ := fs.String("type", "", "The type of the value, e.g. float32_be. Empty uses the type of the config entry at the address")
+	deviceName := fs.String("device", "", "The name of the device of a fleet. Empty selects all the devices")
+	unit := fs.String("unit", "", "The unit ID to read or write the values of. Empty uses the server of the device")
+	scaled := fs.Bool("scaled", false, "Give and print the values of get, set, assert, wait-for and batch in the engineering unit of the entries, using their scale and offset")
+	token := fs.String("token", "", "The bearer token given to the running instance with httpToken")
+	basicAuth := fs.String("basicAuth", "", "The user:password given to the running instance with httpBasicAuth")
+	caCert := fs.String("caCert", "", "The CA certificate file to verify the certificate of a https URL with. Empty uses the CAs of the system")
+	waitTimeout := fs.Duration("waitTimeout", time.Second*30, "How long wait-for polls the value, and wait-write waits for a write, before giving up")
+	pollInterval := fs.Duration("pollInterval", time.Second, "The interval between each read of the value by wait-for")
+	tolerance := fs.Float64("tolerance", 0, "The largest difference from the expected value of assert, wait-for and wait-write that is still equal")
+	serialDelay := fs.Duration("serialDelay", 0, "The delay of the serial side set by serial slow and serial down. 0 uses gatewaySerialDelay of the running instance")
+	serialResponse := fs.String("serialResponse", "", "How the requests are answered after serial down, none, timeout or path. Empty uses gatewayDownResponse of the running instance")
+
+	// The flags can also be given after the command and its arguments,
+	// like ctl set holding 100 12.5 --type float32_be.
+	var pos []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(pos) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	c, err := newCtlClient(*server, *token, *basicAuth, *caCert)
+	if err != nil {
+		log.Printf("error: %v\n", err)
+		return 2
+	}
+
+	q := url.Values{}
+	if *deviceName != "" {
+		q.Set("device", *deviceName)
+	}
+	if *unit != "" {
+		q.Set("unit", *unit)
+	}
+
+	if *scaled {
+		switch pos[0] {
+		case "get", "set", "assert", "wait-for", "batch":
+			q.Set("scaled", "true")
+		}
+	}
+
+	switch {
+	case pos[0] == "get" && len(pos) == 3, pos[0] == "set" && len(pos) == 4:
+		q.Set("register", pos[1])
+		q.Set("address", pos[2])
+		if *typ != "" {
+			q.Set("type", *typ)
+		}
+		method := http.MethodGet
+		if pos[0] == "set" {
+			method = http.MethodPost
+			q.Set("value", pos[3])
+		}
+		var values []registerValue
+		err = c.request(method, "/registers", q, &values)
+		if err == nil {
+			printRegisterValues(os.Stdout, values)
+		}
+	case (pos[0] == "assert" || pos[0] == "wait-for") && len(pos) == 4:
+		var expect expectation
+		expect, err = parseExpectation(pos[3], *tolerance)
+		if err != nil {
+			log.Printf("error: %v\n", err)
+			return 2
+		}
+		q.Set("register", pos[1])
+		q.Set("address", pos[2])
+		if *typ != "" {
+			q.Set("type", *typ)
+		}
+		// The value of each of the devices and units selected must be as
+		// expected.
+		var values []registerValue
+		read := func() ([]float64, error) {
+			values = nil
+			err := c.request(http.MethodGet, "/registers", q, &values)
+			if err != nil {
+				return nil, err
+			}
+			var floats []float64
+			for _, v := range values {
+				f := math.NaN()
+				if v.Value != nil {
+					f = *v.Value
+				}
+				floats = append(floats, f)
+			}
+			return floats, nil
+		}
+		wt := *waitTimeout
+		if pos[0] == "assert" {
+			wt = 0
+		}
+		_, err = waitFor(read, expect, wt, *pollInterval)
+		if err == nil {
+			printRegisterValues(os.Stdout, values)
+		}
+	case pos[0] == "wait-write" && (len(pos) == 3 || len(pos) == 4):
+		q.Set("register", pos[1])
+		q.Set("address", pos[2])
+		if *typ != "" {
+			q.Set("type", *typ)
+		}
+		if len(pos) == 4 {
+			_, err = parseExpectation(pos[3], *tolerance)
+			if err != nil {
+				log.Printf("error: %v\n", err)
+				return 2
+			}
+			q.Set("value", pos[3])
+			if *tolerance > 0 {
+				q.Set("tolerance", strconv.FormatFloat(*tolerance, 'g', -1, 64))
+			}
+		}
+		q.Set("timeout", waitTimeout.String())
+		// The request is answered when the register is written, so it
+		// must be allowed to take as long as the wait.
+		c.client.Timeout = *waitTimeout + ctlTimeout
+		var values []registerValue
+		err = c.request(http.MethodGet, "/registers/wait-write", q, &values)
+		if err == nil {
+			printRegisterValues(os.Stdout, values)
+		}
+	case pos[0] == "batch" && len(pos) == 2:
+		// The changes are given as a JSON list in the file given, or
+		// on stdin with -.
+		var body []byte
+		if pos[1] == "-" {
+			body, err = io.ReadAll(os.Stdin)
+		} else {
+			body, err = os.ReadFile(pos[1])
+		}
+		if err != nil {
+			log.Printf("error: %v\n", err)
+			return 2
+		}
+		var values []registerValue
+		err = c.requestBody(http.MethodPost, "/registers/batch", q, bytes.NewReader(body), &values)
+		if err == nil {
+			printRegisterValues(os.Stdout, values)
+		}
+	case pos[0] == "decode" && (len(pos) == 3 || len(pos) == 4):
+		q.Set("register", pos[1])
+		q.Set("range", pos[2])
+		if len(pos) == 4 {
+			q.Set("type", pos[3])
+		} else if *typ != "" {
+			q.Set("type", *typ)
+		}
+		var values []decodedValue
+		err = c.request(http.MethodGet, "/registers/decode", q, &values)
+		if err == nil {
+			printDecodedValues(os.Stdout, values)
+		}
+	case pos[0] == "dump" && len(pos) == 1:
+		var entries []entryStatus
+		err = c.request(http.MethodGet, "/entries", q, &entries)
+		if err == nil {
+			printEntryStatuses(os.Stdout, entries)
+		}
+	case pos[0] == "status" && len(pos) == 1:
+		var statuses []deviceStatus
+		err = c.request(http.MethodGet, "/devices", q, &statuses)
+		if err == nil {
+			printDeviceStatuses(os.Stdout, statuses, time.Now())
+		}
+	case pos[0] == "serial" && len(pos) == 2:
+		q.Set("state", pos[1])
+		if *serialDelay > 0 {
+			q.Set("delay", serialDelay.String())
+		}
+		if *serialResponse != "" {
+			q.Set("response", *serialResponse)
+		}
+		var statuses []gatewayStatus
+		err = c.request(http.MethodPost, "/gateway/serial", q, &statuses)
+		if err == nil {
+			printGatewayStatuses(os.Stdout, statuses)
+		}
+	case pos[0] == "quality" && (len(pos) == 1 || len(pos) == 4):
+		var statuses []qualityStatus
+		if len(pos) == 1 {
+			err = c.request(http.MethodGet, "/quality", q, &statuses)
+		} else {
+			q.Set("register", pos[1])
+			q.Set("address", pos[2])
+			q.Set("state", pos[3])
+			err = c.request(http.MethodPost, "/quality/set", q, &statuses)
+		}
+		if err == nil {
+			printQualityStatuses(os.Stdout, statuses)
+		}
+	case (pos[0] == "pause" || pos[0] == "resume") && len(pos) == 1:
+		var st clockStatus
+		err = c.request(http.MethodPost, "/clock/"+pos[0], nil, &st)
+		if err == nil {
+			printClockStatus(os.Stdout, st)
+		}
+	case pos[0] == "step" && len(pos) == 2:
+		var st clockStatus
+		err = c.request(http.MethodPost, "/clock/step", url.Values{"duration": {pos[1]}}, &st)
+		if err == nil {
+			printClockStatus(os.Stdout, st)
+		}
+	case pos[0] == "snapshot" && len(pos) == 1:
+		var snapshots []snapshotStatus
+		err = c.request(http.MethodGet, "/snapshots", nil, &snapshots)
+		if err == nil {
+			printSnapshots(os.Stdout, snapshots)
+		}
+	case pos[0] == "snapshot" && len(pos) == 2:
+		var st snapshotStatus
+		err = c.request(http.MethodPost, "/snapshots", url.Values{"name": {pos[1]}}, &st)
+		if err == nil {
+			printSnapshots(os.Stdout, []snapshotStatus{st})
+		}
+	case pos[0] == "rewind" && len(pos) == 2:
+		var st clockStatus
+		err = c.request(http.MethodPost, "/snapshots/rewind", url.Values{"name": {pos[1]}}, &st)
+		if err == nil {
+			printClockStatus(os.Stdout, st)
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		log.Printf("error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// ctlClient makes the requests to the HTTP server of a running instance.
+type ctlClient struct {
+	// server is a http or https URL, or the path of a unix socket given
+	// like unix:///path.
+	server   string
+	token    string
+	user     string
+	password string
+	client   http.Client
+}
+
+// newCtlClient will return a client for the server given, using the
+// token and the basic auth given as user:password when not empty. The
+// certificate of a https server is verified with the CA certificate file
+// given, or with the CAs of the system when empty.
+func newCtlClient(server string, token string, basicAuth string, caCert string) (*ctlClient, error) {
+	c := &ctlClient{
+		server: server,
+		token:  token,
+		client: http.Client{Timeout: ctlTimeout},
+	}
+	if basicAuth != "" {
+		user, password, ok := strings.Cut(basicAuth, ":")
+		if !ok {
+			return nil, fmt.Errorf("basic auth must be given as user:password")
+		}
+		c.user = user
+		c.password = password
+	}
+
+	transport := &http.Transport{}
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", caCert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	if socket, ok := strings.CutPrefix(server, "unix://"); ok {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		c.server = "http://unix"
+	}
+	c.client.Transport = transport
+
+	return c, nil
+}
+
+// request will make a request to the path of the server with the query
+// given, and decode the JSON response into v.
+func (c *ctlClient) request(method string, path string, q url.Values, v interface{}) error {
+	return c.requestBody(method, path, q, nil, v)
+}
+
+// requestBody will make a request like request, sending the JSON body
+// given.
+func (c *ctlClient) requestBody(method string, path string, q url.Values, body io.Reader, v interface{}) error {
+	u := strings.TrimSuffix(c.server, "/") + path + "?" + q.Encode()
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", "modbusgenerator-ctl")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// printRegisterValues will write the values, one on each line. The name
+// of the device and the unit ID is written before the value when given,
+// and the engineering unit after the value of a scaled value.
+func printRegisterValues(w io.Writer, values []registerValue) {
+	for _, v := range values {
+		var prefix []string
+		if v.Device != "" {
+			prefix = append(prefix, v.Device)
+		}
+		if v.Unit != 0 {
+			prefix = append(prefix, fmt.Sprintf("unit %v", v.Unit))
+		}
+		if len(prefix) > 0 {
+			fmt.Fprintf(w, "%v: ", strings.Join(prefix, " "))
+		}
+		if v.EngineeringUnit != "" {
+			fmt.Fprintf(w, "%v %v\n", v.Text, v.EngineeringUnit)
+			continue
+		}
+		fmt.Fprintln(w, v.Text)
+	}
+}
+
+// printEntryStatuses will write the entries as a table, with the value in
+// the engineering unit of the entry. The device is only written for the
+// devices of a fleet.
+func printEntryStatuses(w io.Writer, entries []entryStatus) {
+	withDevice := false
+	for _, e := range entries {
+		if e.Device != "" {
+			withDevice = true
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"REGISTER", "ADDRESS", "TYPE", "NAME", "VALUE", "UNIT"}
+	if withDevice {
+		header = append([]string{"DEVICE"}, header...)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, e := range entries {
+		value := "-"
+		if e.Scaled != nil {
+			value = fmt.Sprint(*e.Scaled)
+		}
+		row := []string{e.Register, fmt.Sprint(e.Address), e.Type, e.Name, value, e.EngineeringUnit}
+		if withDevice {
+			row = append([]string{e.Device}, row...)
+		}
+		fmt.Fprintln(tw, strings.TrimRight(strings.Join(row, "\t"), "\t"))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestCtlClient(t *testing.T) {
+	serv := mbserver.NewServer()
+	rc := &registerControl{devices: []*device{{name: "boiler", serv: serv}}, addrOffset: -1}
+	ts := httptest.NewServer(http.HandlerFunc(rc.handleRegisters))
+	defer ts.Close()
+
+	q := url.Values{"register": {"holding"}, "address": {"100"}, "value": {"12.5"}, "type": {"float32_be"}}
+	var values []registerValue
+	c, err := newCtlClient(ts.URL, "", "", "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	err = c.request(http.MethodPost, "/registers", q, &values)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	printRegisterValues(&buf, values)
+	expect := "boiler: 12.5\n"
+	if got := buf.String(); got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+
+	// The error message of the server is returned.
+	q.Set("register", "foo")
+	err = c.request(http.MethodGet, "/registers", q, &values)
+	if err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestCtlDocumentedExample(t *testing.T) {
+	serv := mbserver.NewServer()
+	rc := &registerControl{devices: []*device{{serv: serv}}, addrOffset: -1}
+	ts := httptest.NewServer(http.HandlerFunc(rc.handleRegisters))
+	defer ts.Close()
+
+	// The flags are given after the command as in the README, with the
+	// type given with both the alias and its short form.
+	for _, typ := range []string{"float32_be", "float32be"} {
+		serv.HoldingRegisters[99] = 0
+		code := runCtl([]string{"-url", ts.URL, "set", "holding", "100", "12.5", "--type", typ})
+		if code != 0 {
+			t.Errorf("%v: expected exit code 0, got %v", typ, code)
+		}
+		expect := []uint16{0x4148, 0}
+		if got := serv.HoldingRegisters[99:101]; !isEqual(expect, got) {
+			t.Errorf("%v: expected %v, got %v", typ, expect, got)
+		}
+
+		code = runCtl([]string{"-url", ts.URL, "get", "holding", "100", "--type", typ})
+		if code != 0 {
+			t.Errorf("%v: expected exit code 0, got %v", typ, code)
+		}
+	}
+}
+
+func TestCtlClientSocket(t *testing.T) {
+	rc := &registerControl{devices: []*device{{serv: mbserver.NewServer()}}, addrOffset: -1}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/registers", rc.handleRegisters)
+
+	path := filepath.Join(t.TempDir(), "mbg.sock")
+	// A socket left behind is replaced, but not other files.
+	os.WriteFile(path, nil, 0644)
+	_, err := startHTTPSocket(path, 0600, mux)
+	if err == nil {
+		t.Fatalf("expected error for a file that is not a socket, got nil")
+	}
+	os.Remove(path)
+
+	for i := 0; i < 2; i++ {
+		srv, err := startHTTPSocket(path, 0600, mux)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		defer srv.Close()
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected 0600, got %v", fi.Mode().Perm())
+	}
+
+	q := url.Values{"register": {"holding"}, "address": {"100"}, "value": {"7"}}
+	var values []registerValue
+	c, err := newCtlClient("unix://"+path, "", "", "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	err = c.request(http.MethodPost, "/registers", q, &values)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(values) != 1 || values[0].Text != "7" {
+		t.Errorf("expected 7, got %v", values)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// writePidFile will write the process ID of the generator to the file
+// given.
+func writePidFile(name string) error {
+	return os.WriteFile(name, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// rotatingFile is a log file that is rotated when it reaches the max
+// size. When rotated, the file is renamed with the suffix .1, and any
+// previous rotated files are renamed with the suffix incremented, keeping
+// at most maxBackups rotated files.
+type rotatingFile struct {
+	mu         sync.Mutex
+	name       string
+	maxSize    int64
+	maxBackups int
+	fh         *os.File
+	size       int64
+}
+
+// newRotatingFile will open the log file given for appending, and return
+// a rotatingFile for it. A maxSize of 0 disables the rotation.
+func newRotatingFile(name string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := rotatingFile{
+		name:       name,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// open will open the log file for appending.
+func (r *rotatingFile) open() error {
+	fh, err := os.OpenFile(r.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := fh.Stat()
+	if err != nil {
+		fh.Close()
+		return err
+	}
+
+	r.fh = fh
+	r.size = fi.Size()
+	return nil
+}
+
+// Write will write to the log file, rotating it first if the write would
+// make the file grow beyond the max size.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.fh.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate will close the log file, shift the rotated files, and open a
+// new empty log file.
+func (r *rotatingFile) rotate() error {
+	err := r.fh.Close()
+	if err != nil {
+		return err
+	}
+
+	if r.maxBackups < 1 {
+		os.Remove(r.name)
+	} else {
+		os.Remove(fmt.Sprintf("%v.%v", r.name, r.maxBackups))
+		for i := r.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%v.%v", r.name, i), fmt.Sprintf("%v.%v", r.name, i+1))
+		}
+		os.Rename(r.name, r.name+".1")
+	}
+
+	return r.open()
+}
+
+// Close will close the log file.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.fh.Close()
+}
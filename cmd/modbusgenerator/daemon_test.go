@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "modbusgenerator.log")
+
+	rf, err := newRotatingFile(name, 10, 2)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer rf.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := rf.Write([]byte(line))
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+	}
+
+	expect := map[string]string{
+		name:        "fourth\n",
+		name + ".1": "third\n",
+		name + ".2": "second\n",
+	}
+	for file, content := range expect {
+		got, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if content != string(got) {
+			t.Errorf("%v: expected %q, got %q", file, content, string(got))
+		}
+	}
+
+	if _, err := os.Stat(name + ".3"); err == nil {
+		t.Errorf("expected only 2 rotated files to be kept")
+	}
+}
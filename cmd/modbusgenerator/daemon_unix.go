@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reexecSignal is the signal that makes the generator re-execute its own
+// binary, e.g. after the binary has been upgraded.
+var reexecSignal os.Signal = syscall.SIGUSR2
+
+// reexec will replace the running process with a new execution of the
+// binary, using the same arguments and environment. The process ID is
+// kept, so the pid file stays valid.
+func reexec() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// reexecSignal is nil since re-executing the binary with a signal is
+// not supported on windows.
+var reexecSignal os.Signal
+
+// reexec is not supported on windows.
+func reexec() error {
+	return errors.New("re-exec is not supported on windows")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+)
+
+// checkDeadbands will return an error if the "deadband" field of any of
+// the input and holding register entries is not a positive number, or is
+// given for the other register types.
+func checkDeadbands(entries map[registerType][]configEntry) error {
+	for _, rt := range historyRegisterTypes {
+		for _, v := range entries[rt] {
+			db, ok := v.raw["deadband"]
+			if !ok {
+				continue
+			}
+			if rt != inputType && rt != holdingType {
+				return v.errorf("deadband is only supported for input and holding registers, got %v", rt)
+			}
+
+			n, ok := db.(float64)
+			if !ok || n < 0 || math.IsNaN(n) {
+				return v.errorf("deadband must be a positive number, got %v", db)
+			}
+		}
+	}
+
+	return nil
+}
+
+// deadband will return the deadband given with the "deadband" field of
+// the entry, or 0 if the entry has none.
+func deadband(e configEntry) float64 {
+	n, _ := e.raw["deadband"].(float64)
+	return n
+}
+
+// writeValue will encode the value generated for the entry into the
+// registers at addr, like the process values of the links and blocks.
+// If the entry has a deadband, the value is only written when it has
+// moved more than the deadband from the value in the registers, like a
+// device reporting quantized measurements, so the registers only change
+// when the value has changed enough. It will return true if the value
+// was written.
+func writeValue(regs []uint16, addr int, e configEntry, v float64) bool {
+	if addr < 0 || addr >= len(regs) {
+		return false
+	}
+
+	if db := deadband(e); db > 0 {
+		size := len(e.enc.Encode())
+		if addr+size <= len(regs) {
+			last := e.enc.Decode(regs[addr : addr+size])
+			if math.Abs(v-last) <= db {
+				return false
+			}
+		}
+	}
+
+	copy(regs[addr:], encodeNumber(e, v))
+	return true
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestDeadband(t *testing.T) {
+	holding := map[string]interface{}{"type": "float32BigWordBigEndian", "number": 30.0, "regAddr": 201.0}
+	input := map[string]interface{}{"type": "float32BigWordBigEndian", "number": 20.0, "regAddr": 101.0, "setpoint": 201.0, "rampRate": 1.0, "deadband": 2.5}
+	holdingEntry := configEntry{enc: NewEncoder(holding), raw: holding}
+	inputEntry := configEntry{enc: NewEncoder(input), raw: input}
+
+	links, err := parseLinks([]configEntry{inputEntry}, []configEntry{holdingEntry})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	setRegister(serv, []encoder{holdingEntry.enc}, "holding", -1)
+	setRegister(serv, []encoder{inputEntry.enc}, "input", -1)
+
+	// The process value ramps 1 per second, but the register is only
+	// written when it has moved more than 2.5 from the value last
+	// written.
+	pv := 20.0
+	var got []float64
+	for i := 0; i < 8; i++ {
+		pv = updateLink(serv, links[0], pv, -1, time.Second)
+		got = append(got, inputEntry.enc.Decode(serv.InputRegisters[100:102]))
+	}
+	expect := []float64{20, 20, 23, 23, 23, 26, 26, 26}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// A reset is written even within the deadband.
+	ref := valueRef{rt: inputType, entry: inputEntry, addrOffset: -1}
+	ref.set(serv, 25)
+	if v := ref.get(serv); v != 26 {
+		t.Errorf("expected %v, got %v", 26, v)
+	}
+	ref.reset(serv, 25)
+	if v := ref.get(serv); v != 25 {
+		t.Errorf("expected %v, got %v", 25, v)
+	}
+
+	tests := []struct {
+		rt       registerType
+		deadband interface{}
+		valid    bool
+	}{
+		{inputType, 0.5, true},
+		{holdingType, 0.0, true},
+		{inputType, -1.0, false},
+		{holdingType, "1", false},
+		{coilType, 1.0, false},
+	}
+	for _, tt := range tests {
+		raw := map[string]interface{}{"type": "uint16BigEndian", "number": 1.0, "regAddr": 1.0, "deadband": tt.deadband}
+		err := checkDeadbands(map[registerType][]configEntry{tt.rt: {{enc: NewEncoder(raw), raw: raw}}})
+		if (err == nil) != tt.valid {
+			t.Errorf("%v deadband %v: expected valid %v, got %v", tt.rt, tt.deadband, tt.valid, err)
+		}
+	}
+}
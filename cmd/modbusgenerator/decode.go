@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// maxDecodeCount is the largest number of registers decoded by a single
+// decode request.
+const maxDecodeCount = 10000
+
+// decodedValue is a value decoded from a range of registers by the decode
+// endpoint, with the words it was decoded from.
+type decodedValue struct {
+	registerValue
+	Words []uint16 `json:"words"`
+}
+
+// parseAddressRange will return the first and last address of a range
+// given as first..last, or as a single address.
+func parseAddressRange(s string) (int, int, error) {
+	first, last, isRange := strings.Cut(s, "..")
+	if !isRange {
+		last = first
+	}
+
+	start, err1 := strconv.Atoi(first)
+	end, err2 := strconv.Atoi(last)
+	if err1 != nil || err2 != nil || start < 0 || end > 65535 || start > end {
+		return 0, 0, fmt.Errorf("range must be first..last with addresses from 0 to 65535, like 100..139, got %q", s)
+	}
+	if end-start+1 > maxDecodeCount {
+		return 0, 0, fmt.Errorf("range can have at most %v addresses, got %v", maxDecodeCount, end-start+1)
+	}
+
+	return start, end, nil
+}
+
+// handleDecode answers with the values decoded from a range of registers
+// as the type given, whatever the type of the config entries in the range,
+// e.g. /registers/decode?register=holding&range=100..139&type=float32_cdab.
+// It helps finding out which word and byte order a client expects when it
+// reports other values than configured. The values follow each other from
+// the first address of the range, and the addresses at the end of the
+// range too few for a whole value are left out. The type is
+// uint16BigEndian for the input and holding registers and
+// wordInt16BigEndian for the coil and discrete registers when not given,
+// and the device and unit query parameters are as for /registers.
+func (rc *registerControl) handleDecode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed, use GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	devices, err := selectDevices(rc.devices, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	rt := registerType(r.URL.Query().Get("register"))
+	if _, ok := tables[rt]; !ok {
+		http.Error(w, fmt.Sprintf("register must be one of coil|discrete|input|holding, got %q", rt), http.StatusBadRequest)
+		return
+	}
+
+	start, end, err := parseAddressRange(r.URL.Query().Get("range"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	unit, err := parseUnitQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The entries are made without the config, so the type given is used
+	// for all of them.
+	typ := r.URL.Query().Get("type")
+	if typ == "" {
+		typ = "uint16BigEndian"
+		if rt == coilType || rt == discreteType {
+			typ = "wordInt16BigEndian"
+		}
+	}
+
+	values := []decodedValue{}
+	for _, d := range devices {
+		serv := d.serv
+		if s, ok := d.serv.Units()[uint8(unit)]; ok {
+			serv = s
+		}
+
+		addr := start
+		for {
+			e, err := registerEntry(profile{}, rt, addr, typ, 0)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			size := len(e.enc.Encode())
+			if rt == coilType || rt == discreteType {
+				size = coilCount(e.enc)
+			}
+			if addr+size-1 > end {
+				break
+			}
+
+			d.serv.Lock()
+			words, ok := entryWords(serv, rt, e, rc.addrOffset)
+			words = append([]uint16(nil), words...)
+			d.serv.Unlock()
+			if !ok {
+				http.Error(w, fmt.Sprintf("address %v is outside the %v registers", addr, rt), http.StatusBadRequest)
+				return
+			}
+
+			v := e.enc.Decode(words)
+			dv := decodedValue{
+				registerValue: registerValue{
+					Device:   d.name,
+					Unit:     unit,
+					Register: string(rt),
+					Address:  addr,
+					Type:     entryType(e.enc),
+					Text:     strconv.FormatFloat(v, 'g', -1, 64),
+				},
+				Words: words,
+			}
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				dv.Value = &v
+			}
+			values = append(values, dv)
+
+			addr += size
+		}
+	}
+
+	writeJSON(w, http.StatusOK, values)
+}
+
+// printDecodedValues will write the decoded values as a table with the
+// words in hex, with the name of the device and the unit ID when any of
+// the values has one.
+func printDecodedValues(w io.Writer, values []decodedValue) {
+	withDevice, withUnit := false, false
+	for _, v := range values {
+		if v.Device != "" {
+			withDevice = true
+		}
+		if v.Unit != 0 {
+			withUnit = true
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"ADDRESS", "WORDS", "VALUE"}
+	if withUnit {
+		header = append([]string{"UNIT"}, header...)
+	}
+	if withDevice {
+		header = append([]string{"DEVICE"}, header...)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, v := range values {
+		var words []string
+		for _, word := range v.Words {
+			words = append(words, fmt.Sprintf("%04x", word))
+		}
+		row := []string{strconv.Itoa(v.Address), strings.Join(words, " "), v.Text}
+		if withUnit {
+			row = append([]string{strconv.Itoa(v.Unit)}, row...)
+		}
+		if withDevice {
+			row = append([]string{v.Device}, row...)
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
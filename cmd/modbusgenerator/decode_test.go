@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestHandleDecode(t *testing.T) {
+	serv := mbserver.NewServer()
+	// 12.5 as float32 in the big endian word order at 100, and 1 at 102.
+	copy(serv.HoldingRegisters[99:], []uint16{0x4148, 0, 0x3f80, 0})
+	serv.Coils[9] = 1
+	rc := &registerControl{devices: []*device{{serv: serv}}, addrOffset: -1}
+
+	tests := []struct {
+		query  string
+		code   int
+		expect []string
+	}{
+		{"register=holding&range=100..103&type=float32_abcd", 200, []string{"12.5", "1"}},
+		// The value with too few addresses left in the range is left out.
+		{"register=holding&range=100..104&type=float32_abcd", 200, []string{"12.5", "1"}},
+		{"register=holding&range=100..101&type=float32_cdab", 200, []string{"2.3418499935796343e-41"}},
+		{"register=holding&range=100..101", 200, []string{"16712", "0"}},
+		{"register=coil&range=9..11&type=bit", 200, []string{"0", "1", "0"}},
+		{"register=holding&range=100", 200, []string{"16712"}},
+		{"register=holding&range=101..100", 400, nil},
+		{"register=holding&range=100..70000", 400, nil},
+		{"register=foo&range=100..101", 400, nil},
+		{"register=holding&range=100..101&type=foo", 400, nil},
+		{"register=coil&range=1..2&type=float32_abcd", 400, nil},
+		{"register=holding&range=0..1&type=float32_abcd", 400, nil},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		rc.handleDecode(rec, httptest.NewRequest("GET", "/registers/decode?"+tt.query, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%v: expected %v, got %v", tt.query, tt.code, rec.Code)
+			continue
+		}
+		if tt.code != 200 {
+			continue
+		}
+
+		var values []decodedValue
+		err := json.NewDecoder(rec.Body).Decode(&values)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		var got []string
+		for _, v := range values {
+			got = append(got, v.Text)
+		}
+		if !isEqual(tt.expect, got) {
+			t.Errorf("%v: expected %v, got %v", tt.query, tt.expect, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	rc.handleDecode(rec, httptest.NewRequest("GET", "/registers/decode?register=holding&range=100..103&type=float32_abcd", nil))
+	var values []decodedValue
+	json.NewDecoder(rec.Body).Decode(&values)
+	var buf bytes.Buffer
+	printDecodedValues(&buf, values)
+	expect := "ADDRESS  WORDS      VALUE\n100      4148 0000  12.5\n102      3f80 0000  1\n"
+	if got := buf.String(); got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"text/tabwriter"
+)
+
+// detectScales is the scales tried for the integer types when detecting
+// the type of a value, since many devices hold a decimal value as an
+// integer of tenths or hundredths.
+var detectScales = []float64{1, 0.1, 0.01, 0.001}
+
+// detection is a type and scale decoding the registers read to the value
+// expected.
+type detection struct {
+	Type  string  `json:"type"`
+	Alias string  `json:"alias,omitempty"`
+	Scale float64 `json:"scale"`
+	Value float64 `json:"value"`
+}
+
+// typeAliasOf will return the first alias of the encoder type given, or
+// an empty string if it has none.
+func typeAliasOf(typeName string) string {
+	for _, v := range typeAliases {
+		if v.typeName == typeName {
+			return v.alias
+		}
+	}
+	return ""
+}
+
+// detectTypes will return the types of the input and holding registers
+// that decode the words given, read from the address of the value, to the
+// value expected, with the scales of detectScales tried for the integer
+// types. The values within the tolerance of the value expected are equal,
+// where a tolerance of 0 allows for the precision of a float32.
+func detectTypes(words []uint16, expected float64, tolerance float64) []detection {
+	if tolerance == 0 {
+		tolerance = math.Abs(expected) * 1e-6
+	}
+
+	var detections []detection
+	for _, t := range encoderTypes {
+		if !containsRegisterType(t.registerTypes, holdingType) || t.words > len(words) {
+			continue
+		}
+
+		enc := NewEncoder(map[string]interface{}{"type": t.typeName, "number": 0.0, "regAddr": 0.0})
+		raw := enc.Decode(words[:t.words])
+		scales := detectScales
+		if t.words == 2 {
+			// The shortest decimal of the float32 is shown, so 21.7 is
+			// not shown as 21.700000762939453.
+			raw, _ = strconv.ParseFloat(strconv.FormatFloat(raw, 'g', -1, 32), 64)
+			scales = []float64{1}
+		}
+		for _, scale := range scales {
+			v := roundScaled(raw * scale)
+			if math.Abs(v-expected) <= tolerance {
+				detections = append(detections, detection{Type: t.typeName, Alias: typeAliasOf(t.typeName), Scale: scale, Value: v})
+			}
+		}
+	}
+
+	return detections
+}
+
+// printDetections will write the detections given in the format given,
+// which is table, csv or json.
+func printDetections(w io.Writer, format string, detections []detection) {
+	switch format {
+	case "json":
+		if detections == nil {
+			detections = []detection{}
+		}
+		json.NewEncoder(w).Encode(detections)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"type", "alias", "scale", "value"})
+		for _, d := range detections {
+			cw.Write([]string{d.Type, d.Alias, strconv.FormatFloat(d.Scale, 'g', -1, 64), strconv.FormatFloat(d.Value, 'g', -1, 64)})
+		}
+		cw.Flush()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TYPE\tALIAS\tSCALE\tVALUE")
+		for _, d := range detections {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", d.Type, d.Alias, d.Scale, d.Value)
+		}
+		tw.Flush()
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDetectTypes(t *testing.T) {
+	tests := []struct {
+		words     []uint16
+		expected  float64
+		tolerance float64
+		types     []string
+	}{
+		// 21.7 as float32 in each of the word and byte orders.
+		{[]uint16{0x41ad, 0x999a}, 21.7, 0, []string{"float32BigWordBigEndian"}},
+		{[]uint16{0x999a, 0x41ad}, 21.7, 0, []string{"float32LittleWordBigEndian"}},
+		{[]uint16{0x9a99, 0xad41}, 21.7, 0, []string{"float32LittleWordLittleEndian"}},
+		{[]uint16{0xad41, 0x9a99}, 21.7, 0, []string{"float32BigWordLittleEndian"}},
+		// 217 in tenths, and -5 as int16 only.
+		{[]uint16{217, 0}, 21.7, 0, []string{"uint16BigEndian", "int16BigEndian"}},
+		{[]uint16{0xfffb, 0}, -5, 0, []string{"int16BigEndian"}},
+		{[]uint16{0x4148, 0}, 12.4, 0, nil},
+		{[]uint16{0x4148, 0}, 12.4, 0.2, []string{"float32BigWordBigEndian"}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, d := range detectTypes(tt.words, tt.expected, tt.tolerance) {
+			got = append(got, d.Type)
+		}
+		if len(got) != len(tt.types) {
+			t.Errorf("%04x: expected %v, got %v", tt.words, tt.types, got)
+			continue
+		}
+		for _, typ := range tt.types {
+			found := false
+			for _, g := range got {
+				found = found || g == typ
+			}
+			if !found {
+				t.Errorf("%04x: expected %v, got %v", tt.words, tt.types, got)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	printDetections(&buf, "table", detectTypes([]uint16{215, 0}, 21.5, 0))
+	expect := "TYPE             ALIAS   SCALE  VALUE\nint16BigEndian   int16   0.1    21.5\nuint16BigEndian  uint16  0.1    21.5\n"
+	if got := buf.String(); got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
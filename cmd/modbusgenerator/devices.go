@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// deviceActivity holds the activity of a device reported by the status
+// of the devices. It is updated from the transaction hook of the server,
+// and read by the HTTP handlers, so the fields are atomic.
+type deviceActivity struct {
+	// started is the time the listeners of the device were started, in
+	// unix nanoseconds.
+	started atomic.Int64
+	// requests is the number of requests handled.
+	requests atomic.Uint64
+	// lastRequest is the time the last request was received, in unix
+	// nanoseconds, and 0 if no request has been received.
+	lastRequest atomic.Int64
+}
+
+// setStarted will mark the listeners of the device as started now.
+func (a *deviceActivity) setStarted(now time.Time) {
+	a.started.Store(now.UnixNano())
+}
+
+// watchActivity will count the requests handled by the device given, and
+// keep the time of the last one. The transaction hook already set on the
+// server is still called.
+func watchActivity(d *device) {
+	next := d.serv.TransactionHook
+	d.serv.TransactionHook = func(tr mbserver.Transaction) {
+		d.activity.requests.Add(1)
+		d.activity.lastRequest.Store(tr.Received.UnixNano())
+		if next != nil {
+			next(tr)
+		}
+	}
+}
+
+// deviceStatus is the status of a simulated device answered by the
+// /devices endpoint.
+type deviceStatus struct {
+	Name         string            `json:"name,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Profile      string            `json:"profile,omitempty"`
+	ConfigHash   string            `json:"configHash,omitempty"`
+	Address      string            `json:"address"`
+	Listeners    []string          `json:"listeners"`
+	Connections  int               `json:"connections"`
+	Units        []int             `json:"units"`
+	OfflineUnits []int             `json:"offlineUnits"`
+	Started      *time.Time        `json:"started,omitempty"`
+	Uptime       string            `json:"uptime,omitempty"`
+	Requests     uint64            `json:"requests"`
+	LastActivity *time.Time        `json:"lastActivity,omitempty"`
+}
+
+// deviceControl answers the status of the simulated devices over HTTP.
+type deviceControl struct {
+	devices []*device
+}
+
+// status will return the status of the device given at the time given.
+func (dc *deviceControl) status(d *device, now time.Time) deviceStatus {
+	st := deviceStatus{
+		Name:         d.name,
+		Labels:       d.labels,
+		Profile:      d.profileName,
+		ConfigHash:   d.configHash,
+		Address:      d.address,
+		Listeners:    []string{},
+		Units:        []int{},
+		OfflineUnits: []int{},
+		Requests:     d.activity.requests.Load(),
+	}
+	for _, v := range d.serv.ListenerStats() {
+		st.Listeners = append(st.Listeners, v.Address)
+		st.Connections += v.Connections
+	}
+
+	d.serv.Lock()
+	for id := range d.serv.Units() {
+		st.Units = append(st.Units, int(id))
+	}
+	for id := 0; id < 256; id++ {
+		if _, ok := d.serv.UnitOffline(uint8(id)); ok {
+			st.OfflineUnits = append(st.OfflineUnits, id)
+		}
+	}
+	d.serv.Unlock()
+	sort.Ints(st.Units)
+
+	if started := d.activity.started.Load(); started != 0 {
+		t := time.Unix(0, started)
+		st.Started = &t
+		st.Uptime = now.Sub(t).Round(time.Second).String()
+	}
+	if last := d.activity.lastRequest.Load(); last != 0 {
+		t := time.Unix(0, last)
+		st.LastActivity = &t
+	}
+
+	return st
+}
+
+// handleDevices answers with the status of the simulated devices, with
+// their listeners, units, uptime, number of connections and requests, and
+// the time of the last request. The device query parameter selects the
+// device of a fleet, and all the devices are selected if not given.
+func (dc *deviceControl) handleDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := selectDevices(dc.devices, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	statuses := []deviceStatus{}
+	for _, d := range devices {
+		statuses = append(statuses, dc.status(d, now))
+	}
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// printDeviceStatuses will write the status of the devices as a table,
+// with the time since the last request. The device name is only written
+// for the devices of a fleet.
+func printDeviceStatuses(w io.Writer, statuses []deviceStatus, now time.Time) {
+	withName := false
+	for _, st := range statuses {
+		if st.Name != "" {
+			withName = true
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"ADDRESS", "UNITS", "OFFLINE", "UPTIME", "CONNECTIONS", "REQUESTS", "LAST ACTIVITY"}
+	if withName {
+		header = append([]string{"DEVICE"}, header...)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, st := range statuses {
+		last := "-"
+		if st.LastActivity != nil {
+			last = now.Sub(*st.LastActivity).Round(time.Second).String() + " ago"
+		}
+		uptime := "-"
+		if st.Uptime != "" {
+			uptime = st.Uptime
+		}
+		row := []string{st.Address, formatUnitIDs(st.Units), formatUnitIDs(st.OfflineUnits), uptime, fmt.Sprint(st.Connections), fmt.Sprint(st.Requests), last}
+		if withName {
+			row = append([]string{st.Name}, row...)
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
+
+// formatUnitIDs will format the unit IDs given as a comma separated
+// list, or - when there are none.
+func formatUnitIDs(ids []int) string {
+	if len(ids) == 0 {
+		return "-"
+	}
+
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = fmt.Sprint(id)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestDeviceStatus(t *testing.T) {
+	d1 := &device{name: "d1", address: ":10502", profileName: "boiler", serv: mbserver.NewServer()}
+	d2 := &device{name: "d2", address: ":10503", serv: mbserver.NewServer()}
+	addUnits(d1.serv, []uint8{3, 1})
+	d1.serv.SetUnitOffline(3, nil)
+	watchActivity(d1)
+	watchActivity(d2)
+
+	started := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	d1.activity.setStarted(started)
+	d1.serv.TransactionHook(mbserver.Transaction{Received: started.Add(time.Minute)})
+	d1.serv.TransactionHook(mbserver.Transaction{Received: started.Add(time.Minute * 2)})
+
+	dc := &deviceControl{devices: []*device{d1, d2}}
+	st := dc.status(d1, started.Add(time.Hour))
+	if st.Requests != 2 {
+		t.Errorf("expected %v, got %v", 2, st.Requests)
+	}
+	if !isEqual([]int{1, 3}, st.Units) {
+		t.Errorf("expected %v, got %v", []int{1, 3}, st.Units)
+	}
+	if !isEqual([]int{3}, st.OfflineUnits) {
+		t.Errorf("expected %v, got %v", []int{3}, st.OfflineUnits)
+	}
+	if st.Uptime != "1h0m0s" {
+		t.Errorf("expected %v, got %v", "1h0m0s", st.Uptime)
+	}
+	if st.LastActivity == nil || !st.LastActivity.Equal(started.Add(time.Minute*2)) {
+		t.Errorf("expected %v, got %v", started.Add(time.Minute*2), st.LastActivity)
+	}
+
+	rec := httptest.NewRecorder()
+	dc.handleDevices(rec, httptest.NewRequest("GET", "/devices?device=d2", nil))
+	var statuses []deviceStatus
+	err := json.NewDecoder(rec.Body).Decode(&statuses)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Name != "d2" || statuses[0].Requests != 0 || statuses[0].LastActivity != nil {
+		t.Errorf("unexpected status %v", statuses)
+	}
+
+	rec = httptest.NewRecorder()
+	dc.handleDevices(rec, httptest.NewRequest("GET", "/devices?device=nonexisting", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected %v, got %v", http.StatusNotFound, rec.Code)
+	}
+
+	var buf bytes.Buffer
+	printDeviceStatuses(&buf, []deviceStatus{st}, started.Add(time.Hour))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "DEVICE") {
+		t.Fatalf("unexpected table %q", buf.String())
+	}
+	for _, expect := range []string{"d1", ":10502", "1,3", "1h0m0s", "58m0s ago"} {
+		if !strings.Contains(lines[1], expect) {
+			t.Errorf("expected %v in %q", expect, lines[1])
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// runDiff implements the diff subcommand, which compares two config
+// files, or a config file against the registers of a running server,
+// and reports the registers that were added, removed or changed.
+// It returns the exit code, which is 0 if there were no differences,
+// 1 if there were differences, and 2 on errors.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Compare two config files, or a config file against a running server.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator diff [flags] old.json new.json\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator diff [flags] -server host:port -registerType holding config.json\n\n")
+		fs.PrintDefaults()
+	}
+	server := fs.String("server", "", "Address of a running RTU over TCP server to compare the config file against")
+	rt := fs.String("registerType", "", "The register type of the config file when comparing against a server (coil|discrete|input|holding)")
+	registerStartOffset := fs.Int("registerStartOffset", -1, "The register start offset used by the server, see the main flags")
+	unitID := fs.Uint("unitID", 1, "The unit ID to use in the requests to the server")
+	fs.Parse(args)
+
+	var err error
+	var differ bool
+	switch {
+	case *server != "" && fs.NArg() == 1:
+		differ, err = diffServer(os.Stdout, fs.Arg(0), *server, registerType(*rt), *registerStartOffset, uint8(*unitID))
+	case *server == "" && fs.NArg() == 2:
+		differ, err = diffConfigs(os.Stdout, fs.Arg(0), fs.Arg(1))
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	if err != nil {
+		log.Printf("error: %v\n", err)
+		return 2
+	}
+	if differ {
+		return 1
+	}
+	return 0
+}
+
+// diffConfigs will compare the entries of the two config files given
+// by their address, and write the entries that were added, removed or
+// changed. It returns true if there were any differences.
+func diffConfigs(w io.Writer, oldFile string, newFile string) (bool, error) {
+	oldData, err := loadEncoders(oldFile)
+	if err != nil {
+		return false, err
+	}
+	newData, err := loadEncoders(newFile)
+	if err != nil {
+		return false, err
+	}
+
+	oldEntries := entriesByAddress(oldData)
+	newEntries := entriesByAddress(newData)
+
+	var addrs []int
+	for addr := range oldEntries {
+		addrs = append(addrs, addr)
+	}
+	for addr := range newEntries {
+		if _, ok := oldEntries[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Ints(addrs)
+
+	differ := false
+	for _, addr := range addrs {
+		o, inOld := oldEntries[addr]
+		n, inNew := newEntries[addr]
+
+		switch {
+		case !inOld:
+			fmt.Fprintf(w, "+ %v %v %v\n", addressRange(n), entryType(n), n.Decode(n.Encode()))
+			differ = true
+		case !inNew:
+			fmt.Fprintf(w, "- %v %v %v\n", addressRange(o), entryType(o), o.Decode(o.Encode()))
+			differ = true
+		case entryType(o) != entryType(n) || !isEqualWords(o.Encode(), n.Encode()):
+			fmt.Fprintf(w, "~ %v %v %v -> %v %v %v\n", addressRange(o), entryType(o), o.Decode(o.Encode()), addressRange(n), entryType(n), n.Decode(n.Encode()))
+			differ = true
+		}
+	}
+
+	return differ, nil
+}
+
+// diffServer will compare the entries of the config file given against
+// the registers read from the running server, and write the entries
+// where the value of the server differs from the config. It returns true
+// if there were any differences.
+func diffServer(w io.Writer, filename string, server string, rt registerType, addrOffset int, unitID uint8) (bool, error) {
+	registryData, err := loadEncoders(filename)
+	if err != nil {
+		return false, err
+	}
+
+	c, err := newClient(server, unitID, time.Second*5)
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	differ := false
+	for _, v := range registryData {
+		size := len(v.Encode())
+		addr := v.Address() + addrOffset
+
+		var words []uint16
+		switch rt {
+		case coilType, discreteType:
+			// The words are made from the values of the coils the same
+			// way as setRegister puts them into the register.
+			bits, err := c.read(rt, addr, coilCount(v))
+			if err != nil {
+				return false, fmt.Errorf("reading address %v: %v", v.Address(), err)
+			}
+			var coils []byte
+			for _, b := range bits {
+				coils = append(coils, byte(b))
+			}
+			words = coilsToWords(v, coils)
+		default:
+			words, err = c.read(rt, addr, size)
+			if err != nil {
+				return false, fmt.Errorf("reading address %v: %v", v.Address(), err)
+			}
+		}
+
+		configValue := v.Decode(v.Encode())
+		serverValue := v.Decode(words)
+		if math.Float64bits(configValue) != math.Float64bits(serverValue) {
+			fmt.Fprintf(w, "~ %v %v %v -> %v\n", addressRange(v), entryType(v), configValue, serverValue)
+			differ = true
+		}
+	}
+
+	return differ, nil
+}
+
+// entriesByAddress will return a map of the entries with the address
+// as the key.
+func entriesByAddress(registryData []encoder) map[int]encoder {
+	m := make(map[int]encoder)
+	for _, v := range registryData {
+		m[v.Address()] = v
+	}
+
+	return m
+}
+
+// addressRange will return the address or range of addresses the
+// entry is encoded into, e.g. "201-202".
+func addressRange(e encoder) string {
+	size := len(e.Encode())
+	if size > 1 {
+		return fmt.Sprintf("%v-%v", e.Address(), e.Address()+size-1)
+	}
+
+	return fmt.Sprint(e.Address())
+}
+
+// isEqualWords will return true if the two slices hold the same words.
+func isEqualWords(a []uint16, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
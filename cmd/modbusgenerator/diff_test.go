@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old.json")
+	newFile := filepath.Join(dir, "new.json")
+	os.WriteFile(oldFile, []byte(`[
+		{"type": "float32BigWordBigEndian", "number": 1, "regAddr": 101},
+		{"type": "float32BigWordBigEndian", "number": 2, "regAddr": 103},
+		{"type": "float32BigWordBigEndian", "number": 3, "regAddr": 105}]`), 0644)
+	os.WriteFile(newFile, []byte(`[
+		{"type": "float32BigWordBigEndian", "number": 1, "regAddr": 101},
+		{"type": "float32BigWordBigEndian", "number": 2.5, "regAddr": 103},
+		{"type": "float32BigWordBigEndian", "number": 4, "regAddr": 107}]`), 0644)
+
+	var buf bytes.Buffer
+	differ, err := diffConfigs(&buf, oldFile, newFile)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if !differ {
+		t.Errorf("expected configs to differ")
+	}
+
+	expect := "~ 103-104 float32BigWordBigEndian 2 -> 103-104 float32BigWordBigEndian 2.5\n" +
+		"- 105-106 float32BigWordBigEndian 3\n" +
+		"+ 107-108 float32BigWordBigEndian 4\n"
+	if expect != buf.String() {
+		t.Errorf("expected %q, got %q", expect, buf.String())
+	}
+}
+
+func TestDiffServer(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "holding.json")
+	os.WriteFile(configFile, []byte(`[
+		{"type": "float32BigWordBigEndian", "number": 1, "regAddr": 101},
+		{"type": "float32BigWordBigEndian", "number": 2, "regAddr": 103}]`), 0644)
+
+	registryData, err := loadEncoders(configFile)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	err = setRegister(serv, registryData, string(holdingType), -1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	serv.HoldingRegisters[102] = 0x4080
+
+	addr := "127.0.0.1:3401"
+	err = serv.ListenRTUTCP(addr)
+	if err != nil {
+		t.Fatalf("failed to listen, got %v", err)
+	}
+	defer serv.Close()
+	time.Sleep(time.Millisecond)
+
+	var buf bytes.Buffer
+	differ, err := diffServer(&buf, configFile, addr, holdingType, -1, 1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if !differ {
+		t.Errorf("expected server to differ from config")
+	}
+
+	expect := "~ 103-104 float32BigWordBigEndian 2 -> 4\n"
+	if expect != buf.String() {
+		t.Errorf("expected %q, got %q", expect, buf.String())
+	}
+}
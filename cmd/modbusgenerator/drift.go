@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// configFile is the status of a config file read from disk, with the hash
+// of the content it was loaded with, and the hash of the content on disk
+// when last checked.
+type configFile struct {
+	File        string `json:"file"`
+	Hash        string `json:"hash"`
+	CurrentHash string `json:"currentHash,omitempty"`
+	Drifted     bool   `json:"drifted"`
+}
+
+// configFiles holds the config files read from disk, so the files changed
+// on disk without the generator being reloaded can be detected.
+type configFiles struct {
+	mu    sync.Mutex
+	files map[string]*configFile
+}
+
+// loadedConfigs holds all the config files read from disk by readConfig.
+var loadedConfigs = newConfigFiles()
+
+// newConfigFiles will return an empty set of config files.
+func newConfigFiles() *configFiles {
+	return &configFiles{files: make(map[string]*configFile)}
+}
+
+// hashContent will return the hash of the content of a config file as
+// hex.
+func hashContent(b []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// add will record the content the config file given was loaded with. A
+// file read more than once, like a fragment included by several configs,
+// keeps the hash it was first read with.
+func (c *configFiles) add(name string, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.files[name]; ok {
+		return
+	}
+	c.files[name] = &configFile{File: name, Hash: hashContent(b)}
+}
+
+// check will read the config files from disk again, and log a warning for
+// each file that has changed since it was loaded, and when a changed file
+// is back to the content it was loaded with. A file that can no longer be
+// read counts as changed.
+func (c *configFiles) check() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, f := range c.files {
+		b, err := os.ReadFile(f.File)
+		current := ""
+		if err == nil {
+			current = hashContent(b)
+		}
+		f.CurrentHash = current
+
+		drifted := current != f.Hash
+		switch {
+		case drifted && !f.Drifted && err != nil:
+			log.Printf("warning: config file %v can not be read since it was loaded, restart or reload to use the new config: %v\n", f.File, err)
+		case drifted && !f.Drifted:
+			log.Printf("warning: config file %v has changed since it was loaded, restart or reload to use the new config\n", f.File)
+		case !drifted && f.Drifted:
+			log.Printf("info: config file %v is back to the content it was loaded with\n", f.File)
+		}
+		f.Drifted = drifted
+	}
+}
+
+// list will return the config files sorted by name.
+func (c *configFiles) list() []configFile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files := []configFile{}
+	for _, f := range c.files {
+		files = append(files, *f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].File < files[j].File })
+	return files
+}
+
+// drifted will return true if any of the config files has changed since
+// it was loaded.
+func (c *configFiles) drifted() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, f := range c.files {
+		if f.Drifted {
+			return true
+		}
+	}
+	return false
+}
+
+// logHashes will log the hash of each of the config files, so a test
+// report can pin the exact files used.
+func (c *configFiles) logHashes() {
+	for _, f := range c.list() {
+		log.Printf("info: config file %v sha256 %v\n", f.File, f.Hash)
+	}
+}
+
+// startDriftCheck will check the config files for changes every interval.
+// An interval of 0 disables the checking.
+func startDriftCheck(c *configFiles, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			c.check()
+		}
+	}()
+}
+
+// configStatus is the JSON body returned by the /config endpoint.
+type configStatus struct {
+	Devices []deviceConfigStatus `json:"devices"`
+	Files   []configFile         `json:"files"`
+	Drifted bool                 `json:"drifted"`
+}
+
+// deviceConfigStatus is the hash of the config of a single device, with
+// the name of the device when given in a fleet config.
+type deviceConfigStatus struct {
+	Device     string `json:"device,omitempty"`
+	ConfigHash string `json:"configHash"`
+}
+
+// configControl serves the hashes of the config loaded, and the config
+// files changed since they were loaded.
+type configControl struct {
+	devices []*device
+	files   *configFiles
+}
+
+// handleConfig answers with the hash of the config of each device, and the
+// hash of each config file read from disk, with the files changed since
+// they were loaded marked as drifted.
+func (cc *configControl) handleConfig(w http.ResponseWriter, r *http.Request) {
+	st := configStatus{
+		Devices: []deviceConfigStatus{},
+		Files:   cc.files.list(),
+		Drifted: cc.files.drifted(),
+	}
+	for _, d := range cc.devices {
+		st.Devices = append(st.Devices, deviceConfigStatus{Device: d.name, ConfigHash: d.configHash})
+	}
+
+	writeJSON(w, http.StatusOK, st)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFilesCheck(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "holding.json")
+	err := os.WriteFile(name, []byte(`[]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newConfigFiles()
+	c.add(name, []byte(`[]`))
+	c.check()
+	if c.drifted() {
+		t.Errorf("expected no drift before the file is changed")
+	}
+
+	err = os.WriteFile(name, []byte(`[{"type":"uint16_be","regAddr":1,"number":1}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.check()
+	if !c.drifted() {
+		t.Errorf("expected drift after the file is changed")
+	}
+	files := c.list()
+	if len(files) != 1 || files[0].Hash == files[0].CurrentHash {
+		t.Errorf("expected a changed current hash, got %+v", files)
+	}
+
+	// Changing the file back to what it was loaded with clears the drift.
+	err = os.WriteFile(name, []byte(`[]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.check()
+	if c.drifted() {
+		t.Errorf("expected no drift after the file is changed back")
+	}
+
+	// A removed file counts as changed.
+	os.Remove(name)
+	c.check()
+	if !c.drifted() {
+		t.Errorf("expected drift after the file is removed")
+	}
+}
+
+func TestReadConfigRecordsFiles(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "coil.json")
+	err := os.WriteFile(name, []byte(`[]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readConfig(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, f := range loadedConfigs.list() {
+		if f.File == name && f.Hash == hashContent([]byte(`[]`)) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected %v to be recorded with its hash", name)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// printRegisterImage will write the register image produced by the
+// entries of a register file. For each entry the address range,
+// a hex dump of the register image, and the value decoded from the
+// register image are written. When any of the entries have a name, unit
+// or description, they are written too, so the output can be used as a
+// register dictionary.
+func printRegisterImage(w io.Writer, serv *mbserver.Server, rf registerFile, entries []configEntry, addrOffset int) {
+	if rf.unit != 0 {
+		fmt.Fprintf(w, "%v (%v, unit %v)\n", rf.filename, rf.registerType, rf.unit)
+	} else {
+		fmt.Fprintf(w, "%v (%v)\n", rf.filename, rf.registerType)
+	}
+
+	withMeta := hasMeta(entries)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"ADDRESS", "HEX", "TYPE", "VALUE"}
+	if withMeta {
+		header = append(header, "NAME", "UNIT", "DESCRIPTION")
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, e := range entries {
+		v := e.enc
+		addr := v.Address() + addrOffset
+		words := registerImage(serv, rf.registerType, v, addr)
+
+		var hex []string
+		for _, word := range words {
+			hex = append(hex, fmt.Sprintf("%04x", word))
+		}
+
+		m := e.meta()
+		row := []string{addressRange(v), strings.Join(hex, " "), entryType(v), fmt.Sprint(m.scaled(v.Decode(words)))}
+		if withMeta {
+			row = append(row, m.Name, m.Unit, m.Description)
+		}
+		// Leave out the empty cells at the end, so the line do not end
+		// with padding.
+		for len(row) > 0 && row[len(row)-1] == "" {
+			row = row[:len(row)-1]
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
+
+// registerImage will return the words of the register image of the entry
+// given starting at the address given. For coil and discrete registers
+// the words are made from the coils of the entry, the same way as
+// setRegister puts them into the register.
+func registerImage(serv *mbserver.Server, rt registerType, v encoder, addr int) []uint16 {
+	var words []uint16
+	size := len(v.Encode())
+
+	switch rt {
+	case coilType, discreteType:
+		b := serv.Coils
+		if rt == discreteType {
+			b = serv.DiscreteInputs
+		}
+		words = coilsToWords(v, b[addr:])
+	case inputType:
+		words = append(words, serv.InputRegisters[addr:addr+size]...)
+	case holdingType:
+		words = append(words, serv.HoldingRegisters[addr:addr+size]...)
+	}
+
+	return words
+}
+
+// entryType will return the name of the concrete encoder type of the
+// entry.
+func entryType(e encoder) string {
+	t := fmt.Sprintf("%T", e)
+	return t[strings.LastIndex(t, ".")+1:]
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dumpHeader is the header of the CSV dump files.
+var dumpHeader = []string{"time", "device", "unit", "register", "address", "name", "value", "scaled", "engineeringUnit"}
+
+// dumpRecord is a single dump of the values written as a line of JSON.
+type dumpRecord struct {
+	Time    time.Time     `json:"time"`
+	Entries []entryStatus `json:"entries"`
+}
+
+// dumper appends the values of the entries of the devices to a CSV or
+// JSON file.
+type dumper struct {
+	devices    []*device
+	addrOffset int
+	// selected holds the entries to dump, by name or register:address
+	// like holding:201. All the entries are dumped when empty.
+	selected map[string]bool
+	filename string
+	format   string
+}
+
+// newDumper will return a dumper writing to the file given. The format is
+// csv or json, and is taken from the extension of the file when empty.
+// The entries to dump are given as a comma separated list of names or
+// register:address, where all the entries are dumped when empty.
+func newDumper(devices []*device, addrOffset int, filename string, format string, selection string) (*dumper, error) {
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(filename), ".")
+	}
+	if format != "csv" && format != "json" {
+		return nil, fmt.Errorf("unknown dump format %q, valid formats are csv|json", format)
+	}
+
+	selected := make(map[string]bool)
+	for _, v := range strings.Split(selection, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			selected[v] = true
+		}
+	}
+
+	return &dumper{
+		devices:    devices,
+		addrOffset: addrOffset,
+		selected:   selected,
+		filename:   filename,
+		format:     format,
+	}, nil
+}
+
+// isSelected will return true if the entry should be dumped.
+func (dp *dumper) isSelected(e entryStatus) bool {
+	if len(dp.selected) == 0 {
+		return true
+	}
+	return (e.Name != "" && dp.selected[e.Name]) || dp.selected[fmt.Sprintf("%v:%v", e.Register, e.Address)]
+}
+
+// entries will return the selected entries of the server and all the
+// units of each device, with their current values.
+func (dp *dumper) entries() []entryStatus {
+	var entries []entryStatus
+	for _, d := range dp.devices {
+		units := d.serv.Units()
+		var ids []int
+		for id := range units {
+			ids = append(ids, int(id))
+		}
+		sort.Ints(ids)
+
+		d.serv.Lock()
+		all := entryStatuses(d, d.serv, 0, dp.addrOffset)
+		for _, id := range ids {
+			all = append(all, entryStatuses(d, units[uint8(id)], id, dp.addrOffset)...)
+		}
+		d.serv.Unlock()
+
+		for _, e := range all {
+			if dp.isSelected(e) {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	return entries
+}
+
+// dump will append the values of the selected entries to the dump file,
+// with the time given.
+func (dp *dumper) dump(now time.Time) error {
+	fh, err := os.OpenFile(dp.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	fi, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+
+	if dp.format == "json" {
+		err = writeDumpJSON(fh, now, dp.entries())
+	} else {
+		err = writeDumpCSV(fh, now, dp.entries(), fi.Size() == 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	return fh.Close()
+}
+
+// writeDumpJSON will write the entries as a single line of JSON.
+func writeDumpJSON(w io.Writer, now time.Time, entries []entryStatus) error {
+	if entries == nil {
+		entries = []entryStatus{}
+	}
+	return json.NewEncoder(w).Encode(dumpRecord{Time: now, Entries: entries})
+}
+
+// writeDumpCSV will write a CSV row for each entry, and the header first
+// when header is true. The values that are not finite numbers are left
+// empty.
+func writeDumpCSV(w io.Writer, now time.Time, entries []entryStatus, header bool) error {
+	cw := csv.NewWriter(w)
+	if header {
+		cw.Write(dumpHeader)
+	}
+
+	for _, e := range entries {
+		var value, scaled string
+		if e.Value != nil {
+			value = strconv.FormatFloat(*e.Value, 'g', -1, 64)
+			scaled = strconv.FormatFloat(*e.Scaled, 'g', -1, 64)
+		}
+		cw.Write([]string{
+			now.Format(time.RFC3339Nano),
+			e.Device,
+			strconv.Itoa(e.Unit),
+			e.Register,
+			strconv.Itoa(e.Address),
+			e.Name,
+			value,
+			scaled,
+			e.EngineeringUnit,
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// startDump will start dumping the values of the entries every interval,
+// with the time of the simulation clock.
+func startDump(dp *dumper, interval time.Duration, clk *simClock) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			err := dp.dump(clk.Now())
+			if err != nil {
+				log.Printf("error: dump: %v\n", err)
+			}
+		}
+	}()
+}
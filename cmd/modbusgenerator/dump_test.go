@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestDump(t *testing.T) {
+	p := profile{
+		entries: map[registerType][]configEntry{
+			holdingType: testEntries([]map[string]interface{}{
+				{"type": "int16", "number": 215.0, "regAddr": 201.0, "name": "temp", "unit": "°C", "scale": 0.1},
+				{"type": "int16", "number": 1.0, "regAddr": 203.0},
+			}),
+		},
+	}
+	serv := mbserver.NewServer()
+	setRegister(serv, []encoder{p.entries[holdingType][0].enc, p.entries[holdingType][1].enc}, "holding", -1)
+	devices := []*device{{name: "boiler", serv: serv, profile: p}}
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	tests := []struct {
+		filename  string
+		selection string
+		expect    string
+	}{
+		{
+			filename:  "values.csv",
+			selection: "temp",
+			expect: "time,device,unit,register,address,name,value,scaled,engineeringUnit\n" +
+				"2024-01-02T10:00:00Z,boiler,0,holding,201,temp,215,21.5,°C\n" +
+				"2024-01-02T10:00:00Z,boiler,0,holding,201,temp,215,21.5,°C\n",
+		},
+		{
+			filename:  "values.json",
+			selection: "holding:203",
+			expect: `{"time":"2024-01-02T10:00:00Z","entries":[{"device":"boiler","register":"holding","address":203,"type":"int16BigEndian","scale":1,"value":1,"scaled":1}]}` + "\n" +
+				`{"time":"2024-01-02T10:00:00Z","entries":[{"device":"boiler","register":"holding","address":203,"type":"int16BigEndian","scale":1,"value":1,"scaled":1}]}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		filename := filepath.Join(dir, tt.filename)
+		dp, err := newDumper(devices, -1, filename, "", tt.selection)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		// The header is only written to a new file.
+		for i := 0; i < 2; i++ {
+			err = dp.dump(now)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+		}
+
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != tt.expect {
+			t.Errorf("expected %v, got %v", tt.expect, got)
+		}
+	}
+
+	_, err := newDumper(devices, -1, filepath.Join(dir, "values.txt"), "", "")
+	if err == nil || !strings.Contains(err.Error(), "unknown dump format") {
+		t.Errorf("expected unknown dump format error, got %v", err)
+	}
+}
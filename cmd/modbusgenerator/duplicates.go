@@ -0,0 +1,71 @@
+package main
+
+import "fmt"
+
+// addressKey is a register type of the server itself, or of a unit with
+// its own config files.
+type addressKey struct {
+	rt   registerType
+	unit uint8
+}
+
+// addressMap holds the entry set at each address of each register type
+// and unit, so the entries of different config files set at the same
+// address can be found.
+type addressMap map[addressKey]map[int]configEntry
+
+// claim will record the addresses of the entries given for the register
+// type and unit, and return an error by the index of each entry set at an
+// address already set by an entry of another config file, naming the files
+// and lines of both entries. Entries of the same file are checked when the
+// registers are set. The addresses are recorded also for the entries with
+// an error, so the later config files are checked against them too.
+func (am addressMap) claim(rt registerType, unit uint8, entries []configEntry) map[int]error {
+	k := addressKey{rt: rt, unit: unit}
+	if am[k] == nil {
+		am[k] = make(map[int]configEntry)
+	}
+	used := am[k]
+
+	errs := make(map[int]error)
+	for i, e := range entries {
+		size := entryWidth(rt, e.enc)
+
+		reported := false
+		for addr := e.enc.Address(); addr < e.enc.Address()+size; addr++ {
+			prev, ok := used[addr]
+			if ok && !reported && sourceFile(prev) != sourceFile(e) {
+				errs[i] = fmt.Errorf("%v register %v%v set by %v is already set by %v", rt, addr, unitSuffix(unit), sourceName(e), sourceName(prev))
+				reported = true
+			}
+			used[addr] = e
+		}
+	}
+
+	return errs
+}
+
+// sourceFile will return the config file the entry was loaded from, or
+// an empty string if not known.
+func sourceFile(e configEntry) string {
+	src, _ := e.source()
+	return src.file
+}
+
+// sourceName will return the position of the entry, or that it is
+// unknown.
+func sourceName(e configEntry) string {
+	if src, ok := e.source(); ok {
+		return src.String()
+	}
+	return "an unknown file"
+}
+
+// unitSuffix will return the unit ID to add to a message about a unit, or
+// nothing for the server itself.
+func unitSuffix(unit uint8) string {
+	if unit == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" of unit %v", unit)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestEntryLines(t *testing.T) {
+	js := []byte(`[
+	{"regAddr": 1},
+
+	{"regAddr": 2}, {"regAddr": 3},
+	{
+		"regAddr": 4
+	}
+]`)
+	expect := []int{2, 4, 4, 5}
+	got := entryLines(js)
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestDuplicateAddresses(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.json")
+	b := filepath.Join(dir, "b.json")
+	os.WriteFile(a, []byte(`[
+		{"type": "float32BigWordBigEndian", "number": 1, "regAddr": 7}]`), 0644)
+	os.WriteFile(b, []byte(`[
+		{"type": "int16BigEndian", "number": 2, "regAddr": 3},
+
+		{"type": "int16BigEndian", "number": 3, "regAddr": 8}]`), 0644)
+	files := []registerFile{
+		{filename: a, registerType: holdingType},
+		{filename: b, registerType: holdingType},
+	}
+
+	serv := mbserver.NewServer()
+	_, configErrors := loadProfile(serv, files, "", -1, false, false)
+	if configErrors != 1 {
+		t.Fatalf("expected 1 error for the duplicate address, got %v", configErrors)
+	}
+	// The duplicate entry is left out, and the other entries are set.
+	if serv.HoldingRegisters[2] != 2 {
+		t.Errorf("expected %v, got %v", 2, serv.HoldingRegisters[2])
+	}
+	if serv.HoldingRegisters[7] == 3 {
+		t.Errorf("expected the duplicate entry to be left out")
+	}
+
+	used := addressMap{}
+	entriesA, _, _, _ := loadRegisterFile(a)
+	entriesB, _, _, _ := loadRegisterFile(b)
+	used.claim(holdingType, 0, entriesA)
+	errs := used.claim(holdingType, 0, entriesB)
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	msg := errs[1].Error()
+	if !strings.Contains(msg, b+" entry 1 (line 4)") || !strings.Contains(msg, a+" entry 0 (line 2)") {
+		t.Errorf("expected both files and lines in the error, got %v", msg)
+	}
+
+	// The same address of another unit is not a duplicate.
+	errs = used.claim(holdingType, 2, entriesB)
+	if len(errs) != 0 {
+		t.Errorf("expected no errors for another unit, got %v", errs)
+	}
+
+	// With the warn policy the file loaded last wins.
+	serv = mbserver.NewServer()
+	_, configErrors = loadProfile(serv, files, "", -1, false, true)
+	if configErrors != 0 {
+		t.Fatalf("expected no errors, got %v", configErrors)
+	}
+	if serv.HoldingRegisters[7] != 3 {
+		t.Errorf("expected %v, got %v", 3, serv.HoldingRegisters[7])
+	}
+}
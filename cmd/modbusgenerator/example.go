@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// exampleEntry is a single entry of an example config. Since JSON
+// do not support comments, the explanation of each entry is put
+// in the description field which is ignored when loading the config.
+type exampleEntry struct {
+	Type        string  `json:"type"`
+	Number      float64 `json:"number"`
+	RegAddr     int     `json:"regAddr"`
+	Description string  `json:"description"`
+}
+
+// exampleConfigs holds a complete example config for each register type.
+var exampleConfigs = map[registerType][]exampleEntry{
+	coilType: {
+		{Type: "bit", Number: 1, RegAddr: 301, Description: "coil at address 301 set to on, the number can also be given as true or \"on\""},
+		{Type: "bit", Number: 0, RegAddr: 302, Description: "coil at address 302 set to off, the number can also be given as false or \"off\""},
+		{Type: "bit", Number: 1, RegAddr: 303, Description: "coil at address 303 set to on"},
+	},
+	discreteType: {
+		{Type: "bit", Number: 1, RegAddr: 401, Description: "discrete input at address 401 set to on"},
+		{Type: "bit", Number: 0, RegAddr: 402, Description: "discrete input at address 402 set to off"},
+		{Type: "bit", Number: 1, RegAddr: 403, Description: "discrete input at address 403 set to on"},
+	},
+	inputType: {
+		{Type: "float32BigWordBigEndian", Number: 3.1415, RegAddr: 101, Description: "float32 with byte order ABCD at address 101 and 102"},
+		{Type: "float32LittleWordBigEndian", Number: 3.1415, RegAddr: 103, Description: "float32 with byte order CDAB at address 103 and 104"},
+		{Type: "float32BigWordLittleEndian", Number: 3.1415, RegAddr: 105, Description: "float32 with byte order BADC at address 105 and 106"},
+		{Type: "float32LittleWordLittleEndian", Number: 3.1415, RegAddr: 107, Description: "float32 with byte order DCBA at address 107 and 108"},
+		{Type: "int16", Number: -5, RegAddr: 109, Description: "signed 16 bit temperature at address 109, taking a single register"},
+		{Type: "uint16", Number: 1200, RegAddr: 110, Description: "unsigned 16 bit speed at address 110, right after the single register before"},
+	},
+	holdingType: {
+		{Type: "float32_abcd", Number: 21.5, RegAddr: 201, Description: "float32 setpoint using the alias for float32BigWordBigEndian"},
+		{Type: "float32_cdab", Number: 55.25, RegAddr: 203, Description: "float32 setpoint using the alias for float32LittleWordBigEndian"},
+		{Type: "float32BigWordBigEndian", Number: -10, RegAddr: 205, Description: "float32 at address 205 and 206"},
+		{Type: "wordUint16", Number: 45, RegAddr: 207, Description: "unsigned 16 bit temperature setpoint at address 207, taking a single register"},
+		{Type: "wordInt16", Number: -3, RegAddr: 208, Description: "signed 16 bit offset at address 208"},
+	},
+}
+
+// printExampleConfig will write a complete example config for the
+// register type given.
+func printExampleConfig(w io.Writer, rt registerType) error {
+	entries, ok := exampleConfigs[rt]
+	if !ok {
+		return fmt.Errorf("no example config for register type %v, valid types are coil|discrete|input|holding", rt)
+	}
+
+	b, err := json.MarshalIndent(entries, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestExampleConfigs(t *testing.T) {
+	for _, rt := range []registerType{coilType, discreteType, inputType, holdingType} {
+		var buf bytes.Buffer
+		err := printExampleConfig(&buf, rt)
+		if err != nil {
+			t.Fatalf("%v: expected nil, got %v", rt, err)
+		}
+
+		registryRawData := []map[string]interface{}{}
+		err = json.Unmarshal(buf.Bytes(), &registryRawData)
+		if err != nil {
+			t.Fatalf("%v: expected nil, got %v", rt, err)
+		}
+
+		var registryData []encoder
+		for _, obj := range registryRawData {
+			enc := NewEncoder(obj)
+			if enc == nil {
+				t.Fatalf("%v: unknown type %v in example config", rt, obj["type"])
+			}
+			registryData = append(registryData, enc)
+		}
+
+		// The addresses of the entries must follow the width of each
+		// entry.
+		err = setRegister(mbserver.NewServer(), registryData, string(rt), -1)
+		if err != nil {
+			t.Errorf("%v: expected nil, got %v", rt, err)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// expectInterval is the interval between each check of the expectations
+// of the values of the registers.
+const expectInterval = time.Millisecond * 100
+
+// expectSpec is a single expectation of the expectations file given with
+// -expect, which is either an expected value of a register, or a write
+// the clients are expected to make to a register.
+type expectSpec struct {
+	// Name describes the expectation in the log, and is made from the
+	// other fields when not given.
+	Name     string       `json:"name"`
+	Device   string       `json:"device"`
+	Unit     int          `json:"unit"`
+	Register registerType `json:"register"`
+	Address  int          `json:"address"`
+	// Type is the type of the value, where the type of the config entry
+	// at the address is used when not given.
+	Type string `json:"type"`
+	// Value is the value expected like ">=20.5", which can be left out
+	// for a write where any value is expected.
+	Value     string  `json:"value"`
+	Tolerance float64 `json:"tolerance"`
+	// Written is true when the clients are expected to write the
+	// register, and false when the register is expected to have the
+	// value.
+	Written bool `json:"written"`
+	// Within is the duration of simulated time since start the
+	// expectation must be met within, like 30s. An expected value without
+	// a duration is checked once at start.
+	Within string `json:"within"`
+}
+
+// expectCheck is an expectation being checked for a server of a device.
+type expectCheck struct {
+	name    string
+	written bool
+	rt      registerType
+	// expect is nil when any value written is expected.
+	expect *expectation
+	within time.Duration
+	device *device
+	serv   *mbserver.Server
+	entry  configEntry
+
+	// done is true when the expectation is met or failed, and passed is
+	// true when it was met.
+	done   bool
+	passed bool
+	// last is the value read last, which is logged when failed.
+	last    float64
+	hasLast bool
+}
+
+// expectRunner checks the expectations while the simulator runs, and
+// reports if they were all met when all of them are done.
+type expectRunner struct {
+	mu         sync.Mutex
+	checks     []*expectCheck
+	clk        *simClock
+	start      time.Time
+	addrOffset int
+	// done is closed when all the expectations are done.
+	done chan struct{}
+}
+
+// loadExpectations will load the expectations file given, and return the
+// checks of the expectations for the devices given.
+func loadExpectations(filename string, devices []*device) ([]*expectCheck, error) {
+	js, err := readConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	js, err = expandEnv(stripJSONC(js))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+
+	var specs []expectSpec
+	err = json.Unmarshal(js, &specs)
+	if err != nil {
+		return nil, fmt.Errorf("%v: decoding json: %v", filename, err)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("%v: no expectations given", filename)
+	}
+
+	var checks []*expectCheck
+	for i, spec := range specs {
+		c, err := newExpectCheck(spec, devices)
+		if err != nil {
+			return nil, fmt.Errorf("%v: expectation %v: %v", filename, i, err)
+		}
+		checks = append(checks, c)
+	}
+
+	return checks, nil
+}
+
+// newExpectCheck will return the check of the expectation given for the
+// device it selects.
+func newExpectCheck(spec expectSpec, devices []*device) (*expectCheck, error) {
+	c := &expectCheck{name: spec.Name, written: spec.Written, rt: spec.Register}
+
+	switch spec.Register {
+	case coilType, holdingType:
+	case discreteType, inputType:
+		if spec.Written {
+			return nil, fmt.Errorf("the clients can only write the coil and holding registers, got %q", spec.Register)
+		}
+	default:
+		return nil, fmt.Errorf("register must be one of coil|discrete|input|holding, got %q", spec.Register)
+	}
+
+	if spec.Value != "" {
+		e, err := parseExpectation(spec.Value, spec.Tolerance)
+		if err != nil {
+			return nil, err
+		}
+		c.expect = &e
+	} else if !spec.Written {
+		return nil, fmt.Errorf("value must be given when not expecting a write")
+	}
+
+	if spec.Within != "" {
+		var err error
+		c.within, err = time.ParseDuration(spec.Within)
+		if err != nil || c.within < 0 {
+			return nil, fmt.Errorf("within must be a duration like 30s, got %q", spec.Within)
+		}
+	}
+	if spec.Written && c.within == 0 {
+		return nil, fmt.Errorf("within must be given when expecting a write")
+	}
+
+	switch {
+	case spec.Device == "" && len(devices) == 1:
+		c.device = devices[0]
+	case spec.Device == "":
+		return nil, fmt.Errorf("device must be given for a fleet")
+	default:
+		for _, d := range devices {
+			if d.name == spec.Device {
+				c.device = d
+			}
+		}
+		if c.device == nil {
+			return nil, fmt.Errorf("no device with the name %v", spec.Device)
+		}
+	}
+	c.serv = c.device.serv
+	if spec.Unit != 0 {
+		s, ok := c.device.serv.Units()[uint8(spec.Unit)]
+		if !ok || spec.Unit < 0 || spec.Unit > 255 {
+			return nil, fmt.Errorf("no unit %v", spec.Unit)
+		}
+		c.serv = s
+	}
+
+	var err error
+	c.entry, err = registerEntry(c.device.profile, spec.Register, spec.Address, spec.Type, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.name == "" {
+		c.name = fmt.Sprintf("%v %v", spec.Register, spec.Address)
+		if spec.Written {
+			c.name = "write of " + c.name
+		}
+		if c.expect != nil {
+			c.name += " " + c.expect.String()
+		}
+		if spec.Device != "" {
+			c.name = spec.Device + " " + c.name
+		}
+		if spec.Unit != 0 {
+			c.name += fmt.Sprintf(" on unit %v", spec.Unit)
+		}
+	}
+
+	return c, nil
+}
+
+// startExpectations will start checking the expectations given, where
+// the written expectations are checked for each write request from the
+// clients, and the expected values every expectInterval. The done
+// channel of the runner returned is closed when all the expectations are
+// done.
+func startExpectations(checks []*expectCheck, clk *simClock, addrOffset int) *expectRunner {
+	er := &expectRunner{
+		checks:     checks,
+		clk:        clk,
+		start:      clk.Now(),
+		addrOffset: addrOffset,
+		done:       make(chan struct{}),
+	}
+
+	// Watch the writes to each of the servers with written expectations.
+	watched := make(map[*mbserver.Server]bool)
+	for _, c := range checks {
+		if !c.written || watched[c.serv] {
+			continue
+		}
+		watched[c.serv] = true
+		serv := c.serv
+		c.device.serv.Lock()
+		watchWrites(serv, func(rt registerType, start int, count int) {
+			er.written(serv, rt, start, count)
+		})
+		c.device.serv.Unlock()
+	}
+
+	go func() {
+		ticker := time.NewTicker(expectInterval)
+		defer ticker.Stop()
+
+		for {
+			if er.check() {
+				close(er.done)
+				return
+			}
+			<-ticker.C
+		}
+	}()
+
+	return er
+}
+
+// written will check the written expectations of the server given against
+// the addresses written, which is called with the server locked.
+func (er *expectRunner) written(serv *mbserver.Server, rt registerType, start int, count int) {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+
+	now := er.clk.Now()
+	for _, c := range er.checks {
+		if c.done || !c.written || c.serv != serv || c.rt != rt {
+			continue
+		}
+		if !entryInRange(rt, c.entry, start, count, er.addrOffset) {
+			continue
+		}
+
+		v, ok := entryValue(serv, rt, c.entry, er.addrOffset)
+		if !ok {
+			continue
+		}
+		c.last, c.hasLast = v, true
+		if c.expect == nil || c.expect.match(v) {
+			er.settle(c, true, now)
+		}
+	}
+}
+
+// check will check the expected values, and fail the expectations not
+// met within their duration. It returns true when all the expectations
+// are done.
+func (er *expectRunner) check() bool {
+	now := er.clk.Now()
+	elapsed := now.Sub(er.start)
+
+	all := true
+	for _, c := range er.checks {
+		if !c.written {
+			c.device.serv.Lock()
+			v, ok := entryValue(c.serv, c.rt, c.entry, er.addrOffset)
+			c.device.serv.Unlock()
+
+			er.mu.Lock()
+			if ok && !c.done {
+				c.last, c.hasLast = v, true
+				if c.expect.match(v) {
+					er.settle(c, true, now)
+				}
+			}
+			er.mu.Unlock()
+		}
+
+		er.mu.Lock()
+		if !c.done && elapsed >= c.within {
+			er.settle(c, false, now)
+		}
+		all = all && c.done
+		er.mu.Unlock()
+	}
+
+	return all
+}
+
+// settle will mark the expectation given as done, and log if it was met.
+// It is called with the runner locked.
+func (er *expectRunner) settle(c *expectCheck, passed bool, now time.Time) {
+	c.done = true
+	c.passed = passed
+	elapsed := now.Sub(er.start).Round(time.Millisecond)
+
+	switch {
+	case passed:
+		log.Printf("info: expect: %v: passed after %v\n", c.name, elapsed)
+	case c.written && c.hasLast:
+		log.Printf("error: expect: %v: failed, not written as expected within %v, last written %v\n", c.name, c.within, c.last)
+	case c.written:
+		log.Printf("error: expect: %v: failed, not written within %v\n", c.name, c.within)
+	case c.hasLast:
+		log.Printf("error: expect: %v: failed after %v, the value was %v\n", c.name, elapsed, c.last)
+	default:
+		log.Printf("error: expect: %v: failed, the address is outside the %v registers\n", c.name, c.rt)
+	}
+}
+
+// result will return the number of expectations that passed, and the
+// names of the expectations that failed.
+func (er *expectRunner) result() (int, []string) {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+
+	passed := 0
+	var failed []string
+	for _, c := range er.checks {
+		if c.passed {
+			passed++
+		} else {
+			failed = append(failed, c.name)
+		}
+	}
+	return passed, failed
+}
+
+// exitCode will log the result of the expectations, and return the exit
+// code of the simulator, which is 1 if any of them failed.
+func (er *expectRunner) exitCode() int {
+	passed, failed := er.result()
+	if len(failed) > 0 {
+		log.Printf("error: expect: %v of %v expectations failed: %v\n", len(failed), len(er.checks), strings.Join(failed, ", "))
+		return 1
+	}
+	log.Printf("info: expect: all %v expectations passed\n", passed)
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestExpectations(t *testing.T) {
+	serv := mbserver.NewServer()
+	entries := map[registerType][]configEntry{
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "float32_abcd", "number": 0.0, "regAddr": 201.0},
+		}),
+	}
+	d := &device{serv: serv, profile: profile{entries: entries}}
+	serv.InputRegisters[0] = 7
+
+	var checks []*expectCheck
+	for _, spec := range []expectSpec{
+		{Register: coilType, Address: 10, Value: "1", Written: true, Within: "1h"},
+		{Name: "setpoint", Register: holdingType, Address: 201, Value: ">=20", Within: "1h"},
+		{Register: holdingType, Address: 5, Written: true, Within: "1h"},
+		{Register: inputType, Address: 1, Value: "5", Within: "10s"},
+	} {
+		c, err := newExpectCheck(spec, []*device{d})
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		checks = append(checks, c)
+	}
+	if checks[0].name != "write of coil 10 == 1" {
+		t.Errorf("expected %v, got %v", "write of coil 10 == 1", checks[0].name)
+	}
+
+	clk := newSimClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+	er := startExpectations(checks, clk, -1)
+
+	err := serv.ListenRTUTCP("127.0.0.1:3406")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v", err)
+	}
+	defer serv.Close()
+	c, err := newClient("127.0.0.1:3406", 1, time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v", err)
+	}
+	defer c.Close()
+
+	// Writing the coil 0 does not meet the expectation, but 1 does.
+	c.write(coilType, 9, []uint16{0})
+	c.write(coilType, 8, []uint16{0, 1, 0})
+	c.write(holdingType, 200, encodeNumber(entries[holdingType][0], 21.5))
+
+	clk.Step(time.Minute)
+	time.Sleep(expectInterval * 3)
+	passed, failed := er.result()
+	if passed != 2 || !isEqual([]string{"write of holding 5", "input 1 == 5"}, failed) {
+		t.Errorf("expected 2 passed, got %v and %v failed", passed, failed)
+	}
+
+	clk.Step(time.Hour)
+	select {
+	case <-er.done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the expectations done")
+	}
+	if code := er.exitCode(); code != 1 {
+		t.Errorf("expected %v, got %v", 1, code)
+	}
+}
+
+func TestExpectSpecInvalid(t *testing.T) {
+	d := &device{name: "boiler", serv: mbserver.NewServer()}
+	for _, spec := range []expectSpec{
+		{Register: "foo", Address: 1, Value: "1"},
+		{Register: inputType, Address: 1, Written: true, Within: "1s"},
+		{Register: holdingType, Address: 1},
+		{Register: holdingType, Address: 1, Value: "1", Within: "soon"},
+		{Register: holdingType, Address: 1, Written: true},
+		{Register: holdingType, Address: 1, Value: "1", Device: "pump"},
+		{Register: holdingType, Address: 1, Value: "1", Unit: 2},
+		{Register: holdingType, Address: 1, Value: "1", Type: "float64"},
+	} {
+		if _, err := newExpectCheck(spec, []*device{d}); err == nil {
+			t.Errorf("expected error for %+v, got nil", spec)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// runExport implements the export subcommand, which writes the register
+// image populated from the config files as a C header or as a raw binary
+// blob, so the same reference image can be used in device emulators.
+// It returns the exit code, which is 0 on success and 2 on errors.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Write the register image populated from the config files as a C header or a binary blob.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator export [flags] -jsonHolding holding.json\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator export -format bin -registerType holding -output holding.bin -jsonHolding holding.json\n\n")
+		fs.PrintDefaults()
+	}
+	jsonCoil := fs.String("jsonCoil", "", "The coil register config file")
+	jsonDiscrete := fs.String("jsonDiscrete", "", "The discrete input register config file")
+	jsonInput := fs.String("jsonInput", "", "The input register config file")
+	jsonHolding := fs.String("jsonHolding", "", "The holding register config file")
+	registerStartOffset := fs.Int("registerStartOffset", -1, "The register start offset, see the main flags")
+	format := fs.String("format", "c", "The format to export, c for a C header with an array for each register type, or bin for the raw register image of the register type given with -registerType")
+	rt := fs.String("registerType", "", "The register type to export with the bin format (coil|discrete|input|holding)")
+	output := fs.String("output", "-", "The file to write the export to, or - for stdout")
+	fs.Parse(args)
+
+	registerFiles := []registerFile{
+		{filename: *jsonCoil, registerType: coilType},
+		{filename: *jsonDiscrete, registerType: discreteType},
+		{filename: *jsonInput, registerType: inputType},
+		{filename: *jsonHolding, registerType: holdingType},
+	}
+	var exported []registerFile
+	for _, v := range registerFiles {
+		if v.filename != "" && (*format != "bin" || string(v.registerType) == *rt) {
+			exported = append(exported, v)
+		}
+	}
+
+	switch {
+	case fs.NArg() != 0:
+		fs.Usage()
+		return 2
+	case *format != "c" && *format != "bin":
+		log.Printf("error: -format must be c or bin, got %q\n", *format)
+		return 2
+	case *format == "bin" && len(exported) != 1:
+		log.Printf("error: the bin format needs -registerType and the config file of that register type\n")
+		return 2
+	case len(exported) == 0:
+		log.Printf("error: no config files given, use -jsonCoil, -jsonDiscrete, -jsonInput or -jsonHolding\n")
+		return 2
+	}
+
+	serv := mbserver.NewServer()
+	defer serv.Close()
+	_, configErrors := loadProfile(serv, exported, "", *registerStartOffset, false, false)
+	if configErrors > 0 {
+		log.Printf("error: %v errors in the config files\n", configErrors)
+		return 2
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		fh, err := os.Create(*output)
+		if err != nil {
+			log.Printf("error: %v\n", err)
+			return 2
+		}
+		defer fh.Close()
+		w = fh
+	}
+
+	// Cut the registers after the last address populated, since a
+	// register with only fill directives is not cut by setRegister.
+	var err error
+	for _, rf := range exported {
+		err = cutImage(serv, rf, *registerStartOffset)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil && *format == "bin" {
+		err = writeBinaryImage(w, serv, exported[0].registerType)
+	}
+	if err == nil && *format == "c" {
+		err = writeCHeader(w, serv, exported)
+	}
+	if err != nil {
+		log.Printf("error: %v\n", err)
+		return 2
+	}
+
+	return 0
+}
+
+// cutImage will cut the register of the register file given after the
+// last address populated by its entries and fill directives.
+func cutImage(serv *mbserver.Server, rf registerFile, addrOffset int) error {
+	entries, fills, _, errs, err := rf.load()
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	end := 0
+	for _, e := range entries {
+		size := len(e.enc.Encode())
+		if rf.registerType == coilType || rf.registerType == discreteType {
+			// Each word of a coil entry is put into two coils, and a
+			// bit into one.
+			size = coilCount(e.enc)
+		}
+		if n := e.enc.Address() + addrOffset + size; n > end {
+			end = n
+		}
+	}
+	for _, f := range fills {
+		if n := f.to + addrOffset + 1; n > end {
+			end = n
+		}
+	}
+
+	switch rf.registerType {
+	case coilType:
+		serv.Coils = serv.Coils[:end]
+	case discreteType:
+		serv.DiscreteInputs = serv.DiscreteInputs[:end]
+	case inputType:
+		serv.InputRegisters = serv.InputRegisters[:end]
+	case holdingType:
+		serv.HoldingRegisters = serv.HoldingRegisters[:end]
+	}
+
+	return nil
+}
+
+// exportedImage will return the populated part of the register of the
+// register type given, starting at address 0 of the protocol. The coil
+// and discrete registers hold one byte for each coil, which is 1 when
+// the coil is on.
+func exportedImage(serv *mbserver.Server, rt registerType) ([]byte, []uint16) {
+	switch rt {
+	case coilType:
+		return serv.Coils, nil
+	case discreteType:
+		return serv.DiscreteInputs, nil
+	case inputType:
+		return nil, serv.InputRegisters
+	case holdingType:
+		return nil, serv.HoldingRegisters
+	}
+	return nil, nil
+}
+
+// writeBinaryImage will write the register image of the register type
+// given as a raw binary blob. The input and holding registers are
+// written as 16-bit big endian words, the byte order used on the wire,
+// and the coil and discrete registers as one byte for each coil.
+func writeBinaryImage(w io.Writer, serv *mbserver.Server, rt registerType) error {
+	bits, words := exportedImage(serv, rt)
+	if words == nil {
+		_, err := w.Write(bits)
+		return err
+	}
+
+	return binary.Write(w, binary.BigEndian, words)
+}
+
+// writeCHeader will write the register images of the register files
+// given as a C header, with a const array for each register type and
+// a define with its length, e.g. HOLDING_REGISTERS_LEN. Index 0 of each
+// array is address 0 of the protocol.
+func writeCHeader(w io.Writer, serv *mbserver.Server, registerFiles []registerFile) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "/* Register image exported by modbusgenerator. */\n")
+	fmt.Fprintf(bw, "#ifndef MODBUSGENERATOR_REGISTERS_H\n")
+	fmt.Fprintf(bw, "#define MODBUSGENERATOR_REGISTERS_H\n\n")
+	fmt.Fprintf(bw, "#include <stdint.h>\n")
+
+	for _, rf := range registerFiles {
+		bits, words := exportedImage(serv, rf.registerType)
+		name := string(rf.registerType) + "_registers"
+		upper := strings.ToUpper(name)
+
+		var values []string
+		ctype := "uint16_t"
+		if words == nil {
+			ctype = "uint8_t"
+			for _, b := range bits {
+				values = append(values, fmt.Sprintf("0x%02x", b))
+			}
+		} else {
+			for _, word := range words {
+				values = append(values, fmt.Sprintf("0x%04x", word))
+			}
+		}
+
+		fmt.Fprintf(bw, "\n/* %v */\n", rf.filename)
+		fmt.Fprintf(bw, "#define %v_LEN %v\n", upper, len(values))
+		fmt.Fprintf(bw, "static const %v %v[%v_LEN] = {\n", ctype, name, upper)
+		// Write 8 values on each line.
+		for i := 0; i < len(values); i += 8 {
+			end := i + 8
+			if end > len(values) {
+				end = len(values)
+			}
+			fmt.Fprintf(bw, "    %v,\n", strings.Join(values[i:end], ", "))
+		}
+		fmt.Fprintf(bw, "};\n")
+	}
+
+	fmt.Fprintf(bw, "\n#endif /* MODBUSGENERATOR_REGISTERS_H */\n")
+	return bw.Flush()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	holdingFile := filepath.Join(dir, "holding.json")
+	coilFile := filepath.Join(dir, "coil.json")
+	os.WriteFile(holdingFile, []byte(`[
+		{"fill": [1, 2], "value": "0xBEEF"},
+		{"type": "float32BigWordBigEndian", "number": 1, "regAddr": 3}]`), 0644)
+	os.WriteFile(coilFile, []byte(`[{"fill": [1, 3], "pattern": [1, 0]}]`), 0644)
+
+	registerFiles := []registerFile{
+		{filename: coilFile, registerType: coilType},
+		{filename: holdingFile, registerType: holdingType},
+	}
+	serv := mbserver.NewServer()
+	_, configErrors := loadProfile(serv, registerFiles, "", -1, false, false)
+	if configErrors != 0 {
+		t.Fatalf("expected no errors, got %v", configErrors)
+	}
+	for _, rf := range registerFiles {
+		err := cutImage(serv, rf, -1)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := writeBinaryImage(&buf, serv, holdingType)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expectBin := []byte{0xbe, 0xef, 0xbe, 0xef, 0x3f, 0x80, 0x00, 0x00}
+	if !bytes.Equal(expectBin, buf.Bytes()) {
+		t.Errorf("expected %x, got %x", expectBin, buf.Bytes())
+	}
+
+	buf.Reset()
+	err = writeCHeader(&buf, serv, registerFiles)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := "/* Register image exported by modbusgenerator. */\n" +
+		"#ifndef MODBUSGENERATOR_REGISTERS_H\n" +
+		"#define MODBUSGENERATOR_REGISTERS_H\n\n" +
+		"#include <stdint.h>\n\n" +
+		"/* " + coilFile + " */\n" +
+		"#define COIL_REGISTERS_LEN 3\n" +
+		"static const uint8_t coil_registers[COIL_REGISTERS_LEN] = {\n" +
+		"    0x01, 0x00, 0x01,\n" +
+		"};\n\n" +
+		"/* " + holdingFile + " */\n" +
+		"#define HOLDING_REGISTERS_LEN 4\n" +
+		"static const uint16_t holding_registers[HOLDING_REGISTERS_LEN] = {\n" +
+		"    0xbeef, 0xbeef, 0x3f80, 0x0000,\n" +
+		"};\n\n" +
+		"#endif /* MODBUSGENERATOR_REGISTERS_H */\n"
+	if expect != buf.String() {
+		t.Errorf("expected %q, got %q", expect, buf.String())
+	}
+}
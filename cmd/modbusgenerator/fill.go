@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// fill is a fill directive of a config file, filling a range of
+// addresses with a pattern of words repeated over the range.
+type fill struct {
+	from int
+	to   int
+	// words is the pattern repeated over the range.
+	words []uint16
+	// increment is added to the words for each repetition of the
+	// pattern, so an incrementing pattern can be made.
+	increment int
+}
+
+// splitFills will return the fill directives of the raw config data,
+// which are the entries with a "fill" field, and the rest of the entries
+// of the raw config data.
+func splitFills(registryRawData []map[string]interface{}) ([]fill, []map[string]interface{}, error) {
+	var fills []fill
+	var rest []map[string]interface{}
+
+	for i, obj := range registryRawData {
+		if _, ok := obj["fill"]; !ok {
+			rest = append(rest, obj)
+			continue
+		}
+
+		f, err := parseFill(obj)
+		if err != nil {
+			return nil, nil, errorAt(obj, i, "%v", err)
+		}
+		fills = append(fills, f)
+	}
+
+	return fills, rest, nil
+}
+
+// parseFill will parse a fill directive like
+// {"fill": [1000, 1999], "value": "0xDEAD"}, where the pattern is either
+// the single word given with "value", or the words given with "pattern",
+// and the optional "increment" is added to the words for each repetition
+// of the pattern.
+func parseFill(obj map[string]interface{}) (fill, error) {
+	var f fill
+
+	r, _ := obj["fill"].([]interface{})
+	if len(r) != 2 {
+		return f, fmt.Errorf("fill must be the first and last address like [1000, 1999], got %v", obj["fill"])
+	}
+	from, ok1 := r[0].(float64)
+	to, ok2 := r[1].(float64)
+	if !ok1 || !ok2 || from < 0 || to < from || to > 65535 || from != float64(int(from)) || to != float64(int(to)) {
+		return f, fmt.Errorf("fill must be the first and last address like [1000, 1999], got %v", obj["fill"])
+	}
+	f.from = int(from)
+	f.to = int(to)
+
+	value, hasValue := obj["value"]
+	pattern, hasPattern := obj["pattern"]
+	switch {
+	case hasValue && !hasPattern:
+		w, err := parseWord(value)
+		if err != nil {
+			return f, fmt.Errorf("value: %v", err)
+		}
+		f.words = []uint16{w}
+	case hasPattern && !hasValue:
+		p, _ := pattern.([]interface{})
+		if len(p) == 0 {
+			return f, fmt.Errorf("pattern must be a list of words, got %v", pattern)
+		}
+		for _, v := range p {
+			w, err := parseWord(v)
+			if err != nil {
+				return f, fmt.Errorf("pattern: %v", err)
+			}
+			f.words = append(f.words, w)
+		}
+	default:
+		return f, fmt.Errorf("fill must have either a value or a pattern")
+	}
+
+	if inc, ok := obj["increment"]; ok {
+		n, ok := inc.(float64)
+		if !ok || n != float64(int(n)) {
+			return f, fmt.Errorf("increment must be an integer, got %v", inc)
+		}
+		f.increment = int(n)
+	}
+
+	return f, nil
+}
+
+// parseWord will parse a word given either as a number from 0 to 65535,
+// or as a string like "0xDEAD", "0b1010" or "42".
+func parseWord(v interface{}) (uint16, error) {
+	switch v := v.(type) {
+	case float64:
+		if v < 0 || v > 65535 || v != float64(int(v)) {
+			return 0, fmt.Errorf("word must be 0 to 65535, got %v", v)
+		}
+		return uint16(v), nil
+	case string:
+		n, err := strconv.ParseUint(v, 0, 16)
+		if err != nil {
+			return 0, fmt.Errorf("word must be 0 to 65535 like 0xDEAD, got %q", v)
+		}
+		return uint16(n), nil
+	}
+
+	return 0, fmt.Errorf("word must be a number or a string like 0xDEAD, got %v", v)
+}
+
+// word will return the word of the fill at the index given from the
+// start of the range.
+func (f fill) word(i int) uint16 {
+	n := i / len(f.words)
+	return f.words[i%len(f.words)] + uint16(n*f.increment)
+}
+
+// applyFill will write the words of the fill into the register of the
+// register type given. Each address of the coil and discrete registers
+// is a single coil, which is turned on when the word is not 0.
+func applyFill(serv *mbserver.Server, rt registerType, f fill, addrOffset int) error {
+	if f.from+addrOffset < 0 || f.to+addrOffset > 65535 {
+		return fmt.Errorf("fill %v-%v is outside the register", f.from, f.to)
+	}
+
+	for i := 0; i <= f.to-f.from; i++ {
+		addr := f.from + addrOffset + i
+		w := f.word(i)
+
+		switch rt {
+		case coilType, discreteType:
+			b := serv.Coils[:cap(serv.Coils)]
+			if rt == discreteType {
+				b = serv.DiscreteInputs[:cap(serv.DiscreteInputs)]
+			}
+			b[addr] = 0
+			if w != 0 {
+				b[addr] = 1
+			}
+		case inputType:
+			serv.InputRegisters[:cap(serv.InputRegisters)][addr] = w
+		case holdingType:
+			serv.HoldingRegisters[:cap(serv.HoldingRegisters)][addr] = w
+		}
+	}
+
+	return nil
+}
+
+// extendToFills will extend the length of the register of the register
+// type given to include the ranges of the fills, since setRegister cuts
+// the register after the last entry.
+func extendToFills(serv *mbserver.Server, rt registerType, fills []fill, addrOffset int) {
+	for _, f := range fills {
+		end := f.to + addrOffset + 1
+		switch rt {
+		case coilType:
+			if end > len(serv.Coils) && end <= cap(serv.Coils) {
+				serv.Coils = serv.Coils[:end]
+			}
+		case discreteType:
+			if end > len(serv.DiscreteInputs) && end <= cap(serv.DiscreteInputs) {
+				serv.DiscreteInputs = serv.DiscreteInputs[:end]
+			}
+		case inputType:
+			if end > len(serv.InputRegisters) && end <= cap(serv.InputRegisters) {
+				serv.InputRegisters = serv.InputRegisters[:end]
+			}
+		case holdingType:
+			if end > len(serv.HoldingRegisters) && end <= cap(serv.HoldingRegisters) {
+				serv.HoldingRegisters = serv.HoldingRegisters[:end]
+			}
+		}
+	}
+}
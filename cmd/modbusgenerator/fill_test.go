@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestApplyFill(t *testing.T) {
+	registryRawData := []map[string]interface{}{
+		{"fill": []interface{}{1000.0, 1003.0}, "value": "0xDEAD"},
+		{"type": "wordInt16LittleEndian", "number": 7.0, "regAddr": 1.0},
+		{"fill": []interface{}{2000.0, 2004.0}, "value": 10.0, "increment": 1.0},
+		{"fill": []interface{}{3000.0, 3005.0}, "pattern": []interface{}{"0x0001", 2.0}, "increment": 16.0},
+	}
+
+	fills, rest, err := splitFills(registryRawData)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(fills) != 3 || len(rest) != 1 {
+		t.Fatalf("expected 3 fills and 1 entry, got %v and %v", len(fills), len(rest))
+	}
+
+	serv := mbserver.NewServer()
+	for _, f := range fills {
+		err := applyFill(serv, holdingType, f, -1)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+	}
+
+	tests := []struct {
+		addr   int
+		expect []uint16
+	}{
+		{999, []uint16{0xDEAD, 0xDEAD, 0xDEAD, 0xDEAD, 0}},
+		{1999, []uint16{10, 11, 12, 13, 14, 0}},
+		{2999, []uint16{1, 2, 17, 18, 33, 34, 0}},
+	}
+	for _, tt := range tests {
+		got := serv.HoldingRegisters[tt.addr : tt.addr+len(tt.expect)]
+		if !isEqual(tt.expect, got) {
+			t.Errorf("address %v: expected %v, got %v", tt.addr, tt.expect, got)
+		}
+	}
+
+	// Each address of the coil register is a single coil.
+	err = applyFill(serv, coilType, fill{from: 11, to: 14, words: []uint16{1, 0}}, -1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if !isEqual([]byte{1, 0, 1, 0, 0}, serv.Coils[10:15]) {
+		t.Errorf("expected %v, got %v", []byte{1, 0, 1, 0, 0}, serv.Coils[10:15])
+	}
+}
+
+func TestParseFillInvalid(t *testing.T) {
+	for _, obj := range []map[string]interface{}{
+		{"fill": []interface{}{1000.0}, "value": 1.0},
+		{"fill": []interface{}{1000.0, 999.0}, "value": 1.0},
+		{"fill": []interface{}{1000.0, 1999.0}},
+		{"fill": []interface{}{1000.0, 1999.0}, "value": 70000.0},
+		{"fill": []interface{}{1000.0, 1999.0}, "value": "0xZZ"},
+		{"fill": []interface{}{1000.0, 1999.0}, "value": 1.0, "pattern": []interface{}{1.0}},
+	} {
+		_, err := parseFill(obj)
+		if err == nil {
+			t.Errorf("%v: expected error not nil, got %v", obj, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is the prefix of the environment variables that can be
+// used to set the flags.
+const envPrefix = "MODBUSGENERATOR_"
+
+// flagEnvName will return the name of the environment variable for
+// the flag name given, e.g. MODBUSGENERATOR_LISTENRTUTCPPORT for the
+// listenRTUTCPPort flag.
+func flagEnvName(name string) string {
+	return envPrefix + strings.ToUpper(name)
+}
+
+// applyFlagSources will set the value of all the flags of the flag set
+// that were not given on the command line from the environment
+// variables, or from the server config file if one is given. The
+// precedence from highest to lowest is:
+//   - command line flag.
+//   - environment variable.
+//   - server config file.
+//   - default value of the flag.
+//
+// The server config file is a JSON object where the keys are the flag
+// names, and the values are the flag values.
+func applyFlagSources(fs *flag.FlagSet, configFile string) error {
+	fileValues := map[string]interface{}{}
+	if configFile != "" {
+		b, err := os.ReadFile(configFile)
+		if err != nil {
+			return fmt.Errorf("failed to read server config file: %v", err)
+		}
+		loadedConfigs.add(configFile, b)
+		err = json.Unmarshal(stripJSONC(b), &fileValues)
+		if err != nil {
+			return fmt.Errorf("failed to decode server config file %v: %v", configFile, err)
+		}
+
+		for k := range fileValues {
+			if fs.Lookup(k) == nil {
+				return fmt.Errorf("server config file %v: unknown flag %v", configFile, k)
+			}
+		}
+	}
+
+	setOnCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || setOnCommandLine[f.Name] {
+			return
+		}
+
+		if v, ok := os.LookupEnv(flagEnvName(f.Name)); ok {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("environment variable %v: %v", flagEnvName(f.Name), e)
+			}
+			return
+		}
+
+		if v, ok := fileValues[f.Name]; ok {
+			if e := fs.Set(f.Name, fmt.Sprint(v)); e != nil {
+				err = fmt.Errorf("server config file %v: flag %v: %v", configFile, f.Name, e)
+			}
+		}
+	})
+
+	return err
+}
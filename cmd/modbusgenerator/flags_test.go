@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyFlagSources(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "server.json")
+	os.WriteFile(configFile, []byte(`{"a": "file", "b": "file", "c": "file"}`), 0644)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	a := fs.String("a", "default", "")
+	b := fs.String("b", "default", "")
+	c := fs.String("c", "default", "")
+	d := fs.String("d", "default", "")
+	fs.Parse([]string{"-a", "commandline"})
+
+	t.Setenv(flagEnvName("b"), "env")
+
+	err := applyFlagSources(fs, configFile)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	expect := []string{"commandline", "env", "file", "default"}
+	got := []string{*a, *b, *c, *d}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestApplyFlagSourcesUnknownFlag(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "server.json")
+	os.WriteFile(configFile, []byte(`{"unknown": "file"}`), 0644)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("a", "default", "")
+
+	err := applyFlagSources(fs, configFile)
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v", err)
+	}
+}
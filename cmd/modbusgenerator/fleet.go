@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// device is a simulated device listening on its own address, with the
+// server of the device and the units added to it.
+type device struct {
+	// name and labels identifies the device in the status reported,
+	// and are only set for the devices of a fleet config.
+	name    string
+	labels  map[string]string
+	address string
+	// index is the index of the device of a port range, where the device
+	// on the first port has index 0.
+	index int
+	// units is the comma separated list of unit IDs of the device, which
+	// overrides the units flag when set.
+	units string
+	// conn holds the settings of the client connections of the listener
+	// of the device.
+	conn mbserver.ConnConfig
+	// mbap overrides the gatewayMBAP flag when set.
+	mbap *bool
+	// unitMap overrides the gatewayUnitMap flag when set.
+	unitMap map[uint8]unitMapping
+	// limits and unitLimits override the function codes and the count
+	// limits of the flags for the device and for each of its units when
+	// set.
+	limits     requestLimits
+	unitLimits map[uint8]requestLimits
+	// profileName is the name of the profile the device simulates, which
+	// is advertised by mDNS, and only set for the devices of a fleet
+	// config.
+	profileName string
+	serv        *mbserver.Server
+	servers     []*mbserver.Server
+	// blocks holds the simulation blocks of each of the servers.
+	blocks [][]*simBlock
+	// configHash is the hash of the config the device was loaded with.
+	configHash string
+	// journals holds the change journal of each of the servers.
+	journals []*journal
+	// qualities holds the qualities of the measurements of each of the
+	// servers.
+	qualities [][]*qualityValue
+	profile   profile
+	// activity holds the uptime and the requests of the device reported
+	// by the status of the devices.
+	activity deviceActivity
+}
+
+// parsePortRange will parse a port range like "10502-10601", and return
+// the first and last port of the range.
+func parsePortRange(s string) (int, int, error) {
+	firstStr, lastStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid port range %q, must be on the form first-last", s)
+	}
+
+	first, err := strconv.Atoi(strings.TrimSpace(firstStr))
+	if err != nil || first < 1 || first > 65535 {
+		return 0, 0, fmt.Errorf("invalid first port %q in port range", firstStr)
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(lastStr))
+	if err != nil || last < first || last > 65535 {
+		return 0, 0, fmt.Errorf("invalid last port %q in port range", lastStr)
+	}
+
+	return first, last, nil
+}
+
+// newPortRangeDevices will create a device for each port in the range
+// given, listening on each of the hosts given. The first device uses the server
+// given, and the other devices get a copy of its registers, where the
+// entries with a "deviceIncrement" field get the number of the entry
+// plus the index of the device times the increment, e.g. for giving each
+// device its own serial number.
+func newPortRangeDevices(serv *mbserver.Server, p profile, hosts []string, first int, last int, addrOffset int) []*device {
+	var devices []*device
+	for port := first; port <= last; port++ {
+		var addrs []string
+		for _, host := range hosts {
+			addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(port)))
+		}
+		d := &device{
+			address: strings.Join(addrs, ","),
+			index:   port - first,
+			serv:    serv,
+			profile: p,
+		}
+		if port != first {
+			d.serv = mbserver.NewServer()
+			copyRegisters(d.serv, serv)
+			applyDeviceIncrement(d.serv, p, port-first, addrOffset)
+		}
+		devices = append(devices, d)
+	}
+
+	return devices
+}
+
+// applyDeviceIncrement will write the number of the entries with a
+// "deviceIncrement" field plus the index times the increment into the
+// registers of the server.
+func applyDeviceIncrement(serv *mbserver.Server, p profile, index int, addrOffset int) {
+	for rt, entries := range p.entries {
+		for _, e := range entries {
+			inc, ok := e.raw["deviceIncrement"].(float64)
+			if !ok {
+				continue
+			}
+
+			n := e.enc.Decode(e.enc.Encode()) + float64(index)*inc
+			writeEntry(serv, rt, e, n, addrOffset)
+		}
+	}
+}
+
+// writeEntry will encode the number given with the type of the entry,
+// and write it into the register of the register type given at the
+// address of the entry, the same way as setRegister puts it into the
+// register.
+func writeEntry(serv *mbserver.Server, rt registerType, e configEntry, n float64, addrOffset int) {
+	words := encodeNumber(e, n)
+	addr := e.enc.Address() + addrOffset
+
+	switch rt {
+	case coilType, discreteType:
+		b := serv.Coils[:cap(serv.Coils)]
+		if rt == discreteType {
+			b = serv.DiscreteInputs[:cap(serv.DiscreteInputs)]
+		}
+		if addr >= 0 && addr+coilCount(e.enc) <= len(b) {
+			copy(b[addr:], wordToCoils(e.enc, words[0]))
+		}
+	case inputType:
+		if addr >= 0 && addr < cap(serv.InputRegisters) {
+			copy(serv.InputRegisters[addr:cap(serv.InputRegisters)], words)
+		}
+	case holdingType:
+		if addr >= 0 && addr < cap(serv.HoldingRegisters) {
+			copy(serv.HoldingRegisters[addr:cap(serv.HoldingRegisters)], words)
+		}
+	}
+}
+
+// serverSeed will return the seed for the randomness of a server of a
+// device, derived from the seed of the simulation, the address of the
+// device, and the index of the server within the device, so each server
+// gets its own sequence of random values which is the same for each run
+// with the same seed.
+func serverSeed(seed int64, address string, index int) int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v/%v", address, index)
+	return seed ^ int64(h.Sum64())
+}
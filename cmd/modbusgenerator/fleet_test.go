@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestParsePortRange(t *testing.T) {
+	first, last, err := parsePortRange("10502-10601")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if first != 10502 || last != 10601 {
+		t.Errorf("expected %v-%v, got %v-%v", 10502, 10601, first, last)
+	}
+
+	for _, s := range []string{"10502", "10601-10502", "0-10", "10-70000", "a-b"} {
+		_, _, err := parsePortRange(s)
+		if err == nil {
+			t.Errorf("%q: expected error not nil, got %v", s, err)
+		}
+	}
+}
+
+func TestPortRangeDevices(t *testing.T) {
+	p := profile{
+		entries: map[registerType][]configEntry{
+			holdingType: testEntries([]map[string]interface{}{
+				{"type": "float32BigWordBigEndian", "number": 1000.0, "regAddr": 201.0, "deviceIncrement": 1.0},
+				{"type": "float32BigWordBigEndian", "number": 5.0, "regAddr": 203.0},
+			}),
+		},
+	}
+
+	serv := mbserver.NewServer()
+	setRegister(serv, []encoder{p.entries[holdingType][0].enc, p.entries[holdingType][1].enc}, "holding", -1)
+
+	devices := newPortRangeDevices(serv, p, []string{"127.0.0.1", "::1"}, 10502, 10504, -1)
+	if len(devices) != 3 {
+		t.Fatalf("expected %v devices, got %v", 3, len(devices))
+	}
+
+	for i, d := range devices {
+		serial := p.entries[holdingType][0].enc.Decode(d.serv.HoldingRegisters[200:202])
+		if serial != float64(1000+i) {
+			t.Errorf("device %v: expected serial %v, got %v", i, 1000+i, serial)
+		}
+		v := p.entries[holdingType][1].enc.Decode(d.serv.HoldingRegisters[202:204])
+		if v != 5 {
+			t.Errorf("device %v: expected %v, got %v", i, 5, v)
+		}
+	}
+	if devices[2].address != "127.0.0.1:10504,[::1]:10504" {
+		t.Errorf("expected %v, got %v", "127.0.0.1:10504,[::1]:10504", devices[2].address)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The float32 bit patterns of the NaN values that can be given by name.
+const (
+	float32QuietNaN     = 0x7fc00000
+	float32SignalingNaN = 0x7fa00000
+)
+
+// parseSpecialNumbers will replace the numbers of the raw config data
+// given as a string, like "NaN", "+Inf", "-Inf" or "sNaN", with the
+// float64 value they represent, so they can be used with the float
+// encoders.
+func parseSpecialNumbers(registryRawData []map[string]interface{}) error {
+	for i, obj := range registryRawData {
+		s, ok := obj["number"].(string)
+		if !ok {
+			continue
+		}
+
+		n, err := parseSpecialNumber(s)
+		if err != nil {
+			return errorAt(obj, i, "%v", err)
+		}
+		obj["number"] = n
+	}
+
+	return nil
+}
+
+// parseSpecialNumber will parse a float special value, which is one of
+// NaN, +Inf, Inf, -Inf or sNaN for the signaling NaN, or a NaN with the
+// payload given as the float32 bits like "NaN:0x7fa00001". The case of
+// the names is ignored.
+func parseSpecialNumber(s string) (float64, error) {
+	name, payload, hasPayload := strings.Cut(s, ":")
+
+	switch strings.ToLower(name) {
+	case "nan", "qnan":
+		if !hasPayload {
+			return nanFromFloat32Bits(float32QuietNaN), nil
+		}
+		b, err := strconv.ParseUint(payload, 0, 32)
+		if err != nil || !math.IsNaN(float64(math.Float32frombits(uint32(b)))) {
+			return 0, fmt.Errorf("number %q must have the float32 bits of a NaN like NaN:0x7fa00001", s)
+		}
+		return nanFromFloat32Bits(uint32(b)), nil
+	case "snan":
+		if !hasPayload {
+			return nanFromFloat32Bits(float32SignalingNaN), nil
+		}
+	case "inf", "+inf":
+		if !hasPayload {
+			return math.Inf(1), nil
+		}
+	case "-inf":
+		if !hasPayload {
+			return math.Inf(-1), nil
+		}
+	}
+
+	return 0, fmt.Errorf("number %q must be a number, or one of NaN, sNaN, +Inf or -Inf", s)
+}
+
+// nanFromFloat32Bits will return the float64 NaN holding the sign and
+// payload of the float32 NaN bits given. The conversion is done on the
+// bits since converting a signaling NaN with float64() can make it a
+// quiet NaN.
+func nanFromFloat32Bits(b uint32) float64 {
+	sign := uint64(b>>31) << 63
+	payload := uint64(b&0x7fffff) << 29
+	return math.Float64frombits(sign | 0x7ff<<52 | payload)
+}
+
+// float32Bits will return the float32 bits of the number given. The
+// payload of a NaN is kept, which a conversion with float32() does not
+// do for a signaling NaN.
+func float32Bits(n float64) uint32 {
+	if !math.IsNaN(n) {
+		return math.Float32bits(float32(n))
+	}
+
+	b := math.Float64bits(n)
+	sign := uint32(b>>63) << 31
+	payload := uint32(b>>29) & 0x7fffff
+	return sign | 0x7f800000 | payload
+}
+
+// The rounding modes that can be given with the "rounding" field of the
+// float entries.
+const (
+	roundNearest    = "nearest"
+	roundTowardZero = "towardZero"
+)
+
+// checkRounding will check that the rounding mode given is known. An
+// empty rounding mode is the default, nearest.
+func checkRounding(rounding string) error {
+	switch rounding {
+	case "", roundNearest, roundTowardZero:
+		return nil
+	}
+	return fmt.Errorf("rounding must be %v or %v, got %q", roundNearest, roundTowardZero, rounding)
+}
+
+// roundFloat32 will round the number to a value that can be held by a
+// float32 with the rounding mode given. With nearest, which is also the
+// default, the number is rounded to the nearest float32 value. With
+// towardZero the number is rounded to the nearest float32 value that is
+// not further from zero than the number. NaN is returned as it is, so
+// the payload is kept.
+func roundFloat32(n float64, rounding string) float64 {
+	if math.IsNaN(n) {
+		return n
+	}
+
+	f := float32(n)
+	if rounding == roundTowardZero && math.Abs(float64(f)) > math.Abs(n) {
+		f = math.Nextafter32(f, 0)
+	}
+	return float64(f)
+}
+
+// warnInexactFloats will write a warning for each float entry where the
+// value read back from the register differs from the number configured
+// by more than the tolerance, since a float32 can not hold every number
+// a config can be given. The name is the name of the device, which is
+// empty for the device given with the main flags.
+func warnInexactFloats(name string, entries map[registerType][]configEntry, tolerance float64) {
+	prefix := ""
+	if name != "" {
+		prefix = name + ": "
+	}
+
+	for _, rt := range historyRegisterTypes {
+		for _, e := range entries[rt] {
+			switch e.enc.(type) {
+			case *float32LittleWordBigEndian, *float32BigWordBigEndian, *float32LittleWordLittleEndian, *float32BigWordLittleEndian:
+			default:
+				continue
+			}
+			n, ok := e.raw["number"].(float64)
+			if !ok || math.IsNaN(n) || math.IsInf(n, 0) {
+				continue
+			}
+
+			v := e.enc.Decode(e.enc.Encode())
+			if math.Abs(v-n) > tolerance {
+				log.Printf("warning: %v%v register %v: number %v is %v when read back as float32\n", prefix, rt, e.enc.Address(), n, v)
+			}
+		}
+	}
+}
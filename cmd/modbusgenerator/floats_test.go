@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseSpecialNumber(t *testing.T) {
+	tests := []struct {
+		number string
+		expect []uint16
+	}{
+		{"NaN", []uint16{0x7fc0, 0x0000}},
+		{"nan", []uint16{0x7fc0, 0x0000}},
+		{"sNaN", []uint16{0x7fa0, 0x0000}},
+		{"NaN:0x7fa00001", []uint16{0x7fa0, 0x0001}},
+		{"NaN:0xffc00000", []uint16{0xffc0, 0x0000}},
+		{"+Inf", []uint16{0x7f80, 0x0000}},
+		{"Inf", []uint16{0x7f80, 0x0000}},
+		{"-Inf", []uint16{0xff80, 0x0000}},
+	}
+
+	for _, tt := range tests {
+		n, err := parseSpecialNumber(tt.number)
+		if err != nil {
+			t.Fatalf("%v: expected nil, got %v", tt.number, err)
+		}
+
+		enc := float32BigWordBigEndian{Number: n}
+		got := enc.Encode()
+		if !isEqual(tt.expect, got) {
+			t.Errorf("%v: expected %04x, got %04x", tt.number, tt.expect, got)
+		}
+
+		// The payload must be kept when decoded and encoded again.
+		enc.Number = enc.Decode(got)
+		if again := enc.Encode(); !isEqual(got, again) {
+			t.Errorf("%v: expected %04x, got %04x", tt.number, got, again)
+		}
+	}
+
+	for _, s := range []string{"foo", "NaN:0x3f800000", "NaN:bar", "-Inf:1", ""} {
+		_, err := parseSpecialNumber(s)
+		if err == nil {
+			t.Errorf("%q: expected error not nil, got %v", s, err)
+		}
+	}
+}
+
+func TestParseSpecialNumbers(t *testing.T) {
+	raw := []map[string]interface{}{
+		{"type": "float32_abcd", "number": "NaN", "regAddr": 1.0},
+		{"type": "float32_abcd", "number": 1.5, "regAddr": 3.0},
+	}
+	err := parseSpecialNumbers(raw)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if n := raw[0]["number"].(float64); !math.IsNaN(n) {
+		t.Errorf("expected NaN, got %v", n)
+	}
+	if n := raw[1]["number"].(float64); n != 1.5 {
+		t.Errorf("expected 1.5, got %v", n)
+	}
+}
+
+func TestRoundFloat32(t *testing.T) {
+	tests := []struct {
+		number   float64
+		rounding string
+		expect   float32
+	}{
+		{0.1, "", 0.1},
+		{0.1, "nearest", 0.1},
+		{0.1, "towardZero", math.Nextafter32(0.1, 0)},
+		{-0.1, "towardZero", math.Nextafter32(-0.1, 0)},
+		{0.5, "towardZero", 0.5},
+		{1e39, "towardZero", math.MaxFloat32},
+	}
+
+	for _, tt := range tests {
+		got := roundFloat32(tt.number, tt.rounding)
+		if float64(tt.expect) != got {
+			t.Errorf("%v %v: expected %v, got %v", tt.number, tt.rounding, tt.expect, got)
+		}
+	}
+
+	enc := NewEncoder(map[string]interface{}{"type": "float32_abcd", "number": 0.1, "regAddr": 1.0, "rounding": "up"})
+	if err := enc.(validator).validate(); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// parseFunctionCodes will parse a comma separated list of modbus
+// function codes, e.g. "3,16".
+func parseFunctionCodes(s string) ([]uint8, error) {
+	var fcs []uint8
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		fc, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || fc == 0 {
+			return nil, fmt.Errorf("invalid function code %q", v)
+		}
+		fcs = append(fcs, uint8(fc))
+	}
+
+	return fcs, nil
+}
+
+// requestLimits holds the function codes answered by a device or a
+// unit of a fleet config, and the number of coils or registers allowed
+// in a single request, overriding the flags with the same names when
+// set.
+type requestLimits struct {
+	FunctionCodes *string `json:"functionCodes,omitempty"`
+	MaxReadCount  *int    `json:"maxReadCount,omitempty"`
+	MaxWriteCount *int    `json:"maxWriteCount,omitempty"`
+}
+
+// check will return an error if the limits are not valid.
+func (l requestLimits) check() error {
+	if l.FunctionCodes != nil {
+		_, err := parseFunctionCodes(*l.FunctionCodes)
+		if err != nil {
+			return fmt.Errorf("functionCodes: %v", err)
+		}
+	}
+	if l.MaxReadCount != nil && *l.MaxReadCount < 0 {
+		return fmt.Errorf("maxReadCount must be 0 or more, got %v", *l.MaxReadCount)
+	}
+	if l.MaxWriteCount != nil && *l.MaxWriteCount < 0 {
+		return fmt.Errorf("maxWriteCount must be 0 or more, got %v", *l.MaxWriteCount)
+	}
+	return nil
+}
+
+// override will return the limits, with the limits set in o replacing
+// them.
+func (l requestLimits) override(o requestLimits) requestLimits {
+	if o.FunctionCodes != nil {
+		l.FunctionCodes = o.FunctionCodes
+	}
+	if o.MaxReadCount != nil {
+		l.MaxReadCount = o.MaxReadCount
+	}
+	if o.MaxWriteCount != nil {
+		l.MaxWriteCount = o.MaxWriteCount
+	}
+	return l
+}
+
+// requestLimitsOf will return the limits of the server of the device
+// given, which are the flags, overridden by the limits of the device,
+// and then by the limits of the unit when the server is a unit.
+func requestLimitsOf(d *device, f *flags, serv *mbserver.Server) requestLimits {
+	l := requestLimits{FunctionCodes: &f.functionCodes, MaxReadCount: &f.maxReadCount, MaxWriteCount: &f.maxWriteCount}
+	l = l.override(d.limits)
+	if id := unitIDOf(d, serv); id != 0 {
+		l = l.override(d.unitLimits[uint8(id)])
+	}
+	return l
+}
+
+// enableFunctionCodes will disable all the functions of the server
+// except the function codes given, so requests for the other
+// function codes are answered with Illegal Function.
+func enableFunctionCodes(serv *mbserver.Server, fcs []uint8) error {
+	enabled := make(map[uint8]bool)
+	for _, fc := range fcs {
+		if serv.FunctionHandler(fc) == nil {
+			return fmt.Errorf("function code %v is not supported by the server", fc)
+		}
+		enabled[fc] = true
+	}
+
+	for fc := 0; fc < 256; fc++ {
+		if !enabled[uint8(fc)] {
+			serv.RegisterFunctionHandler(uint8(fc), nil)
+		}
+	}
+
+	return nil
+}
+
+// limitCount will wrap the functions of the server given, so a request
+// for more than the maximum number of coils or registers is answered
+// with Illegal Data Value. The read functions are limited by maxRead,
+// and the write multiple functions by maxWrite. A limit of 0 means no
+// limit.
+func limitCount(serv *mbserver.Server, maxRead int, maxWrite int) {
+	limits := map[uint8]int{
+		1:  maxRead,
+		2:  maxRead,
+		3:  maxRead,
+		4:  maxRead,
+		15: maxWrite,
+		16: maxWrite,
+	}
+
+	for fc, max := range limits {
+		function := serv.FunctionHandler(fc)
+		if function == nil || max <= 0 {
+			continue
+		}
+
+		max := max
+		serv.RegisterFunctionHandler(fc, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+			data := frame.GetData()
+			if len(data) >= 4 && int(binary.BigEndian.Uint16(data[2:4])) > max {
+				return []byte{}, &mbserver.IllegalDataValue
+			}
+			return function(s, frame)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestEnableFunctionCodes(t *testing.T) {
+	fcs, err := parseFunctionCodes("3, 16")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	err = enableFunctionCodes(serv, fcs)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	for _, fc := range []uint8{1, 2, 3, 4, 5, 6, 15, 16} {
+		enabled := serv.FunctionHandler(fc) != nil
+		if enabled != (fc == 3 || fc == 16) {
+			t.Errorf("function code %v: expected enabled to be %v, got %v", fc, !enabled, enabled)
+		}
+	}
+}
+
+func TestParseFunctionCodesInvalid(t *testing.T) {
+	for _, s := range []string{"3,foo", "0", "256"} {
+		_, err := parseFunctionCodes(s)
+		if err == nil {
+			t.Errorf("%q: expected error not nil, got %v", s, err)
+		}
+	}
+}
+
+func TestLimitCount(t *testing.T) {
+	serv := mbserver.NewServer()
+	limitCount(serv, 20, 10)
+
+	tests := []struct {
+		function uint8
+		count    uint16
+		expect   mbserver.Exception
+	}{
+		{3, 20, mbserver.Success},
+		{3, 21, mbserver.IllegalDataValue},
+		{1, 21, mbserver.IllegalDataValue},
+		{16, 10, mbserver.Success},
+		{16, 11, mbserver.IllegalDataValue},
+	}
+
+	for _, tt := range tests {
+		var frame mbserver.TCPFrame
+		frame.Function = tt.function
+		if tt.function == 16 {
+			mbserver.SetDataWithRegisterAndNumberAndValues(&frame, 0, tt.count, make([]uint16, tt.count))
+		} else {
+			mbserver.SetDataWithRegisterAndNumber(&frame, 0, tt.count)
+		}
+
+		_, exception := serv.FunctionHandler(tt.function)(serv, &frame)
+		if *exception != tt.expect {
+			t.Errorf("function %v with count %v: expected %v, got %v", tt.function, tt.count, tt.expect.String(), exception.String())
+		}
+	}
+}
+
+func TestRequestLimitsOf(t *testing.T) {
+	serv := mbserver.NewServer()
+	units := addUnits(serv, []uint8{1, 2})
+	deviceFCs, unitFCs := "3", "4"
+	d := &device{
+		serv:       serv,
+		limits:     requestLimits{FunctionCodes: &deviceFCs},
+		unitLimits: map[uint8]requestLimits{2: {FunctionCodes: &unitFCs}},
+	}
+	f := &flags{functionCodes: "3,16"}
+
+	// The unit without its own limits gets the limits of the device,
+	// which override the flags.
+	tests := []struct {
+		serv   *mbserver.Server
+		expect string
+	}{
+		{serv, "3"},
+		{units[0], "3"},
+		{units[1], "4"},
+	}
+	for _, tt := range tests {
+		got := *requestLimitsOf(d, f, tt.serv).FunctionCodes
+		if got != tt.expect {
+			t.Errorf("expected %v, got %v", tt.expect, got)
+		}
+	}
+
+	// The count limits are overridden one by one.
+	maxRead := 10
+	d.unitLimits[1] = requestLimits{MaxReadCount: &maxRead}
+	l := requestLimitsOf(d, &flags{maxReadCount: 20, maxWriteCount: 5}, units[0])
+	if *l.MaxReadCount != 10 || *l.MaxWriteCount != 5 {
+		t.Errorf("expected %v and %v, got %v and %v", 10, 5, *l.MaxReadCount, *l.MaxWriteCount)
+	}
+
+	// Without the limits of the device the flags are used.
+	d.limits = requestLimits{}
+	got := *requestLimitsOf(d, f, units[0]).FunctionCodes
+	if got != "3,16" {
+		t.Errorf("expected %v, got %v", "3,16", got)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// unitMapping is where the requests for a unit ID given in gatewayUnitMap
+// are sent, which is a unit of the device, or a unit of an upstream modbus
+// RTU over TCP server when upstream is set.
+type unitMapping struct {
+	unit     uint8
+	upstream string
+}
+
+// parseUnitMap will parse a comma separated list of unit ID mappings,
+// e.g. "1=11,2=12@10.0.0.5:502", where the unit ID of the requests is
+// given before the equal sign, and the unit ID handling them after it,
+// optionally followed by @ and the address of the upstream server the
+// requests are forwarded to. The unit IDs of the requests are 0 to 255,
+// and the unit IDs handling them 1 to 247.
+func parseUnitMap(s string) (map[uint8]unitMapping, error) {
+	units := make(map[uint8]unitMapping)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		from, to, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid unit mapping %q, must be like 1=11 or 1=11@host:port", v)
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(from), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unit ID %q, valid unit IDs of the requests are 0 to 255", from)
+		}
+		to, upstream, forward := strings.Cut(to, "@")
+		if forward && upstream == "" {
+			return nil, fmt.Errorf("invalid unit mapping %q, the upstream address must be given after @", v)
+		}
+		ids, err := parseUnitIDs(to)
+		if err != nil || len(ids) != 1 {
+			return nil, fmt.Errorf("invalid unit ID %q, valid unit IDs are 1 to 247", to)
+		}
+		units[uint8(id)] = unitMapping{unit: ids[0], upstream: strings.TrimSpace(upstream)}
+	}
+
+	if len(units) == 0 {
+		return nil, nil
+	}
+	return units, nil
+}
+
+// localUnits will return the mappings given to the units of the device,
+// which are given to the listeners of the device.
+func localUnits(units map[uint8]unitMapping) map[uint8]uint8 {
+	var local map[uint8]uint8
+	for id, m := range units {
+		if m.upstream != "" {
+			continue
+		}
+		if local == nil {
+			local = make(map[uint8]uint8)
+		}
+		local[id] = m.unit
+	}
+	return local
+}
+
+// upstream forwards the requests of a unit to a unit of an upstream modbus
+// RTU over TCP server, like a gateway forwarding the requests with a
+// rewritten unit ID. It is only used by the goroutine of the unit
+// forwarding the requests, so the connection needs no locking.
+type upstream struct {
+	address string
+	unitID  uint8
+	timeout time.Duration
+	c       *client
+}
+
+// addUpstreamUnits will add a unit to the server for each of the mappings
+// given with an upstream, where all the function codes are forwarded to
+// the upstream server outside the lock of the server, so the other units
+// are answered while a request waits for the upstream server.
+func addUpstreamUnits(serv *mbserver.Server, units map[uint8]unitMapping, timeout time.Duration) error {
+	for id, m := range units {
+		if m.upstream == "" {
+			continue
+		}
+		if _, ok := serv.Units()[id]; ok {
+			return fmt.Errorf("unit %v is forwarded upstream, and can not also be a unit of the device", id)
+		}
+
+		up := &upstream{address: m.upstream, unitID: m.unit, timeout: timeout}
+		u := serv.AddUnit(id)
+		u.Forward(up.forward)
+	}
+	return nil
+}
+
+// forward will send the request given to the upstream server, and return
+// the data of the response. The request is answered with the Gateway Path
+// Unavailable exception when the upstream server can not be connected to,
+// and with the Gateway Target Device Failed to Respond exception when it
+// does not answer. The connection is opened again for the next request
+// after an error.
+func (u *upstream) forward(frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+	if u.c == nil {
+		c, err := newClient(u.address, u.unitID, u.timeout)
+		if err != nil {
+			log.Printf("warning: upstream %v: %v\n", u.address, err)
+			return []byte{}, &mbserver.GatewayPathUnavailable
+		}
+		u.c = c
+	}
+
+	data, err := u.c.request(frame.GetFunction(), frame.GetData())
+	var ee exceptionError
+	switch {
+	case errors.As(err, &ee):
+		return []byte{}, &ee.exception
+	case err != nil:
+		log.Printf("warning: upstream %v: %v\n", u.address, err)
+		u.c.Close()
+		u.c = nil
+		return []byte{}, &mbserver.GatewayTargetDeviceFailedtoRespond
+	}
+	return data, &mbserver.Success
+}
+
+// serialSide will return the state of the serial side of a gateway given
+// by its name, which is up, slow or down. The delay is the time each
+// request holds the serial side when it is slow, and the time before the
+// answer when it is down, and the response is how the requests are
+// answered when it is down, as for the units that are offline.
+func serialSide(state string, response string, delay time.Duration) (mbserver.SerialSide, error) {
+	exception, ok := offlineResponses[response]
+	if !ok {
+		return mbserver.SerialSide{}, fmt.Errorf("response must be none, timeout or path, got %q", response)
+	}
+
+	switch state {
+	case "up":
+		return mbserver.SerialSide{}, nil
+	case "slow":
+		return mbserver.SerialSide{Delay: delay}, nil
+	case "down":
+		return mbserver.SerialSide{Down: true, DownException: exception, Delay: delay}, nil
+	}
+	return mbserver.SerialSide{}, fmt.Errorf("state must be up, slow or down, got %q", state)
+}
+
+// serialState will return the name of the state of the serial side given.
+func serialState(side mbserver.SerialSide) string {
+	switch {
+	case side.Down:
+		return "down"
+	case side.Delay > 0:
+		return "slow"
+	}
+	return "up"
+}
+
+// gatewayControl marks the serial side of the gateways simulated by the
+// devices as up, slow or down at runtime.
+type gatewayControl struct {
+	devices []*device
+	// response and delay are used when they are not given in the
+	// request.
+	response string
+	delay    time.Duration
+}
+
+// gatewayStatus is the state of the serial side of the gateway simulated
+// by a device returned by the gateway endpoints.
+type gatewayStatus struct {
+	Device   string `json:"device,omitempty"`
+	Address  string `json:"address"`
+	Serial   string `json:"serial"`
+	Response string `json:"response,omitempty"`
+	Delay    string `json:"delay,omitempty"`
+}
+
+// statuses will return the state of the serial side of all the devices.
+func (gc *gatewayControl) statuses() []gatewayStatus {
+	var statuses []gatewayStatus
+	for _, d := range gc.devices {
+		d.serv.Lock()
+		side := d.serv.SerialSide()
+		d.serv.Unlock()
+
+		st := gatewayStatus{Device: d.name, Address: d.address, Serial: serialState(side)}
+		if side.Down {
+			st.Response = offlineResponseName(side.DownException)
+		}
+		if side.Delay > 0 {
+			st.Delay = side.Delay.String()
+		}
+		statuses = append(statuses, st)
+	}
+
+	return statuses
+}
+
+// handleGateway answers with the state of the serial side of the devices.
+func (gc *gatewayControl) handleGateway(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, gc.statuses())
+}
+
+// handleSerial will mark the serial side of the gateway as given with the
+// state query parameter, which is up, slow or down, e.g.
+// /gateway/serial?state=down&response=path. The delay query parameter is
+// a duration like 2s overriding the delay given with gatewaySerialDelay,
+// and the response query parameter overrides gatewayDownResponse. The
+// device query parameter selects the device of a fleet, and all the
+// devices are selected if not given.
+func (gc *gatewayControl) handleSerial(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	response := gc.response
+	if v := q.Get("response"); v != "" {
+		response = v
+	}
+	delay := gc.delay
+	if v := q.Get("delay"); v != "" {
+		var err error
+		delay, err = time.ParseDuration(v)
+		if err != nil || delay < 0 {
+			http.Error(w, fmt.Sprintf("delay must be a duration like 2s, got %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	side, err := serialSide(q.Get("state"), response, delay)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	devices, err := selectDevices(gc.devices, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	for _, d := range devices {
+		d.serv.Lock()
+		d.serv.SetSerialSide(side)
+		d.serv.Unlock()
+	}
+
+	writeJSON(w, http.StatusOK, gc.statuses())
+}
+
+// printGatewayStatuses will write the state of the serial side of the
+// devices given as a table, with the name of the devices of a fleet.
+func printGatewayStatuses(w io.Writer, statuses []gatewayStatus) {
+	withName := false
+	for _, st := range statuses {
+		if st.Device != "" {
+			withName = true
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"ADDRESS", "SERIAL", "RESPONSE", "DELAY"}
+	if withName {
+		header = append([]string{"DEVICE"}, header...)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, st := range statuses {
+		row := []string{st.Address, st.Serial, "-", "-"}
+		if st.Response != "" {
+			row[2] = st.Response
+		}
+		if st.Delay != "" {
+			row[3] = st.Delay
+		}
+		if withName {
+			row = append([]string{st.Device}, row...)
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
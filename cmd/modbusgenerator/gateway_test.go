@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestParseUnitMap(t *testing.T) {
+	got, err := parseUnitMap("1=11, 255=2, 5=3@10.0.0.5:502")
+	expect := map[uint8]unitMapping{1: {unit: 11}, 255: {unit: 2}, 5: {unit: 3, upstream: "10.0.0.5:502"}}
+	if err != nil || !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v and %v", expect, got, err)
+	}
+	expectLocal := map[uint8]uint8{1: 11, 255: 2}
+	if local := localUnits(got); !isEqual(expectLocal, local) {
+		t.Errorf("expected %v, got %v", expectLocal, local)
+	}
+
+	if got, err := parseUnitMap(""); err != nil || got != nil {
+		t.Errorf("expected nil, got %v and %v", got, err)
+	}
+
+	for _, v := range []string{"1", "1=0", "1=248", "256=1", "a=1", "1=2,3", "1=2@"} {
+		if _, err := parseUnitMap(v); err == nil {
+			t.Errorf("expected error for %q, got nil", v)
+		}
+	}
+}
+
+func TestGatewayControl(t *testing.T) {
+	d1 := &device{name: "d1", address: ":10502", serv: mbserver.NewServer()}
+	d2 := &device{name: "d2", address: ":10503", serv: mbserver.NewServer()}
+	gc := &gatewayControl{devices: []*device{d1, d2}, response: "timeout", delay: time.Second}
+
+	rec := httptest.NewRecorder()
+	gc.handleSerial(rec, httptest.NewRequest("POST", "/gateway/serial?state=down&response=path&device=d2", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected %v, got %v", http.StatusOK, rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	gc.handleSerial(rec, httptest.NewRequest("POST", "/gateway/serial?state=slow&delay=2s&device=d1", nil))
+
+	expect := []gatewayStatus{
+		{Device: "d1", Address: ":10502", Serial: "slow", Delay: "2s"},
+		{Device: "d2", Address: ":10503", Serial: "down", Response: "path", Delay: "1s"},
+	}
+	got := gc.statuses()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	var buf bytes.Buffer
+	printGatewayStatuses(&buf, got)
+	expectTable := "DEVICE  ADDRESS  SERIAL  RESPONSE  DELAY\n" +
+		"d1      :10502   slow    -         2s\n" +
+		"d2      :10503   down    path      1s\n"
+	if expectTable != buf.String() {
+		t.Errorf("expected %q, got %q", expectTable, buf.String())
+	}
+
+	rec = httptest.NewRecorder()
+	gc.handleSerial(rec, httptest.NewRequest("POST", "/gateway/serial?state=up", nil))
+	for _, st := range gc.statuses() {
+		if st.Serial != "up" {
+			t.Errorf("expected %v, got %v", "up", st.Serial)
+		}
+	}
+
+	tests := map[string]int{
+		"/gateway/serial?state=sideways":              http.StatusBadRequest,
+		"/gateway/serial?state=down&response=foo":     http.StatusBadRequest,
+		"/gateway/serial?state=slow&delay=fast":       http.StatusBadRequest,
+		"/gateway/serial?state=up&device=nonexisting": http.StatusNotFound,
+	}
+	for url, code := range tests {
+		rec = httptest.NewRecorder()
+		gc.handleSerial(rec, httptest.NewRequest("POST", url, nil))
+		if rec.Code != code {
+			t.Errorf("%v: expected %v, got %v", url, code, rec.Code)
+		}
+	}
+}
+
+func TestUpstreamUnits(t *testing.T) {
+	up := mbserver.NewServer()
+	up.AddUnit(2).HoldingRegisters[10] = 1234
+	err := up.ListenRTUTCP("127.0.0.1:3403")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v", err)
+	}
+	defer up.Close()
+
+	serv := mbserver.NewServer()
+	units := map[uint8]unitMapping{
+		5: {unit: 2, upstream: "127.0.0.1:3403"},
+		6: {unit: 2, upstream: "127.0.0.1:3405"},
+		7: {unit: 3},
+	}
+	err = addUpstreamUnits(serv, units, time.Second)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	err = serv.ListenRTUTCP("127.0.0.1:3404")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v", err)
+	}
+	defer serv.Close()
+
+	c, err := newClient("127.0.0.1:3404", 5, time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v", err)
+	}
+	defer c.Close()
+
+	values, err := c.read(holdingType, 10, 1)
+	if err != nil || !isEqual([]uint16{1234}, values) {
+		t.Errorf("expected %v, got %v and %v", []uint16{1234}, values, err)
+	}
+	err = c.write(holdingType, 11, []uint16{42})
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	up.Lock()
+	if got := up.Units()[2].HoldingRegisters[11]; got != 42 {
+		t.Errorf("expected %v, got %v", 42, got)
+	}
+	up.Unlock()
+
+	// The exceptions of the upstream server are passed on.
+	_, err = c.request(3, []byte{0, 0, 0, 200})
+	if err != (exceptionError{exception: mbserver.IllegalDataValue}) {
+		t.Errorf("expected %v, got %v", exceptionError{exception: mbserver.IllegalDataValue}, err)
+	}
+
+	// Nothing listens on the upstream address of unit 6.
+	c.unitID = 6
+	_, err = c.read(holdingType, 10, 1)
+	if err != (exceptionError{exception: mbserver.GatewayPathUnavailable}) {
+		t.Errorf("expected %v, got %v", exceptionError{exception: mbserver.GatewayPathUnavailable}, err)
+	}
+
+	err = addUpstreamUnits(serv, units, time.Second)
+	if err == nil {
+		t.Errorf("expected error for a unit forwarded twice, got nil")
+	}
+}
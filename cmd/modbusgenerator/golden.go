@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// runTest implements the test subcommand, which renders the register
+// image of a config file, and compares it against a golden binary image
+// written earlier by export or by test with -update, so CI can catch
+// unintended changes of the encoding of the values.
+// It returns the exit code, which is 0 if the images are equal, 1 if
+// they differ, and 2 on errors.
+func runTest(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Compare the register image of a config file against a golden binary image.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator test [flags] -registerType holding -golden holding.bin -jsonHolding holding.json\n\n")
+		fs.PrintDefaults()
+	}
+	jsonCoil := fs.String("jsonCoil", "", "The coil register config file")
+	jsonDiscrete := fs.String("jsonDiscrete", "", "The discrete input register config file")
+	jsonInput := fs.String("jsonInput", "", "The input register config file")
+	jsonHolding := fs.String("jsonHolding", "", "The holding register config file")
+	registerStartOffset := fs.Int("registerStartOffset", -1, "The register start offset, see the main flags")
+	rt := fs.String("registerType", "", "The register type to compare (coil|discrete|input|holding)")
+	golden := fs.String("golden", "", "The golden binary image, in the bin format of export")
+	update := fs.Bool("update", false, "Write the register image to the golden file instead of comparing against it")
+	fs.Parse(args)
+
+	var rf registerFile
+	for _, v := range []registerFile{
+		{filename: *jsonCoil, registerType: coilType},
+		{filename: *jsonDiscrete, registerType: discreteType},
+		{filename: *jsonInput, registerType: inputType},
+		{filename: *jsonHolding, registerType: holdingType},
+	} {
+		if v.filename != "" && string(v.registerType) == *rt {
+			rf = v
+		}
+	}
+
+	switch {
+	case fs.NArg() != 0:
+		fs.Usage()
+		return 2
+	case rf.filename == "":
+		log.Printf("error: -registerType and the config file of that register type must be given\n")
+		return 2
+	case *golden == "":
+		log.Printf("error: -golden must be given\n")
+		return 2
+	}
+
+	img, entries, err := renderImage(rf, *registerStartOffset)
+	if err != nil {
+		log.Printf("error: %v\n", err)
+		return 2
+	}
+
+	if *update {
+		err := os.WriteFile(*golden, img, 0644)
+		if err != nil {
+			log.Printf("error: %v\n", err)
+			return 2
+		}
+		log.Printf("info: wrote %v bytes to %v\n", len(img), *golden)
+		return 0
+	}
+
+	goldenImg, err := os.ReadFile(*golden)
+	if err != nil {
+		log.Printf("error: %v\n", err)
+		return 2
+	}
+	differ, err := diffImages(os.Stdout, goldenImg, img, rf.registerType, entries, *registerStartOffset)
+	if err != nil {
+		log.Printf("error: %v\n", err)
+		return 2
+	}
+	if differ {
+		return 1
+	}
+	return 0
+}
+
+// renderImage will load the config file given, and return its register
+// image in the bin format of export, and the entries of the config.
+func renderImage(rf registerFile, addrOffset int) ([]byte, []configEntry, error) {
+	serv := mbserver.NewServer()
+	defer serv.Close()
+	p, configErrors := loadProfile(serv, []registerFile{rf}, "", addrOffset, false, false)
+	if configErrors > 0 {
+		return nil, nil, fmt.Errorf("%v errors in the config files", configErrors)
+	}
+	err := cutImage(serv, rf, addrOffset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var buf bytes.Buffer
+	err = writeBinaryImage(&buf, serv, rf.registerType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return buf.Bytes(), p.entries[rf.registerType], nil
+}
+
+// imageValues will return the value of each address of an image in the
+// bin format of export, which is a word for the input and holding
+// registers, and 0 or 1 for the coil and discrete registers.
+func imageValues(img []byte, rt registerType) ([]uint16, error) {
+	var values []uint16
+	if rt == coilType || rt == discreteType {
+		for _, b := range img {
+			values = append(values, uint16(b))
+		}
+		return values, nil
+	}
+
+	if len(img)%2 != 0 {
+		return nil, fmt.Errorf("the image of the %v registers must have an even number of bytes, got %v", rt, len(img))
+	}
+	for i := 0; i < len(img); i += 2 {
+		values = append(values, binary.BigEndian.Uint16(img[i:]))
+	}
+	return values, nil
+}
+
+// diffImages will compare the golden image against the image rendered,
+// and write the differences. The addresses of the entries in the config
+// are written with the values decoded with the type of the entry, and
+// the other addresses with their raw values. The addresses are written
+// as in the config files. It returns true if there were any differences.
+func diffImages(w io.Writer, goldenImg []byte, img []byte, rt registerType, entries []configEntry, addrOffset int) (bool, error) {
+	oldValues, err := imageValues(goldenImg, rt)
+	if err != nil {
+		return false, fmt.Errorf("golden image: %v", err)
+	}
+	newValues, err := imageValues(img, rt)
+	if err != nil {
+		return false, err
+	}
+
+	bits := rt == coilType || rt == discreteType
+	sizeOf := func(e encoder) int {
+		if bits {
+			return coilCount(e)
+		}
+		return len(e.Encode())
+	}
+	starts := make(map[int]encoder)
+	for _, e := range entries {
+		starts[e.enc.Address()+addrOffset] = e.enc
+	}
+
+	differ := false
+	n := max(len(oldValues), len(newValues))
+	for i := 0; i < n; i++ {
+		if e, ok := starts[i]; ok {
+			end := i + sizeOf(e)
+			oldValue, oldOK := decodeImageEntry(e, oldValues, i, end, bits)
+			newValue, newOK := decodeImageEntry(e, newValues, i, end, bits)
+			if oldOK && newOK && isEqualWords(oldValues[i:end], newValues[i:end]) {
+				i = end - 1
+				continue
+			}
+
+			differ = true
+			switch {
+			case !oldOK:
+				fmt.Fprintf(w, "+ %v %v %v\n", addressRange(e), entryType(e), newValue)
+			case !newOK:
+				fmt.Fprintf(w, "- %v %v %v\n", addressRange(e), entryType(e), oldValue)
+			default:
+				fmt.Fprintf(w, "~ %v %v %v -> %v (%v -> %v)\n", addressRange(e), entryType(e), oldValue, newValue, formatImageValues(oldValues[i:end], bits), formatImageValues(newValues[i:end], bits))
+			}
+			i = end - 1
+			continue
+		}
+
+		addr := i - addrOffset
+		switch {
+		case i >= len(oldValues):
+			fmt.Fprintf(w, "+ %v %v\n", addr, formatImageValues(newValues[i:i+1], bits))
+		case i >= len(newValues):
+			fmt.Fprintf(w, "- %v %v\n", addr, formatImageValues(oldValues[i:i+1], bits))
+		case oldValues[i] != newValues[i]:
+			fmt.Fprintf(w, "~ %v %v -> %v\n", addr, formatImageValues(oldValues[i:i+1], bits), formatImageValues(newValues[i:i+1], bits))
+		default:
+			continue
+		}
+		differ = true
+	}
+
+	return differ, nil
+}
+
+// decodeImageEntry will decode the value of the entry given from the
+// values of the image from start to end, and return false if the image
+// ends before the entry.
+func decodeImageEntry(e encoder, values []uint16, start int, end int, bits bool) (float64, bool) {
+	if end > len(values) {
+		return 0, false
+	}
+	if !bits {
+		return e.Decode(values[start:end]), true
+	}
+
+	var coils []byte
+	for _, v := range values[start:end] {
+		coils = append(coils, byte(v))
+	}
+	return e.Decode(coilsToWords(e, coils)), true
+}
+
+// formatImageValues will format the raw values of an image, as hex words
+// for the input and holding registers, and as 0 or 1 for the coils.
+func formatImageValues(values []uint16, bits bool) string {
+	var b []byte
+	for i, v := range values {
+		if i > 0 {
+			b = append(b, ' ')
+		}
+		if bits {
+			b = fmt.Appendf(b, "%v", v)
+		} else {
+			b = fmt.Appendf(b, "0x%04x", v)
+		}
+	}
+	return string(b)
+}
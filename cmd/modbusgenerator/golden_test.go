@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffImages(t *testing.T) {
+	dir := t.TempDir()
+	holdingFile := filepath.Join(dir, "holding.json")
+	os.WriteFile(holdingFile, []byte(`[
+		{"fill": [1, 2], "value": "0xBEEF"},
+		{"type": "float32BigWordBigEndian", "number": 1, "regAddr": 3}]`), 0644)
+
+	rf := registerFile{filename: holdingFile, registerType: holdingType}
+	img, entries, err := renderImage(rf, -1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expectImg := []byte{0xbe, 0xef, 0xbe, 0xef, 0x3f, 0x80, 0x00, 0x00}
+	if !bytes.Equal(expectImg, img) {
+		t.Errorf("expected %x, got %x", expectImg, img)
+	}
+
+	var buf bytes.Buffer
+	differ, err := diffImages(&buf, img, img, holdingType, entries, -1)
+	if err != nil || differ || buf.Len() != 0 {
+		t.Errorf("expected equal images, got %v, %v and %q", differ, err, buf.String())
+	}
+
+	// The golden image has another float and fill value, and an extra
+	// register.
+	golden := []byte{0xbe, 0xef, 0xbe, 0xee, 0x40, 0x00, 0x00, 0x00, 0x00, 0x01}
+	buf.Reset()
+	differ, err = diffImages(&buf, golden, img, holdingType, entries, -1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if !differ {
+		t.Errorf("expected the images to differ")
+	}
+	expect := "~ 2 0xbeee -> 0xbeef\n" +
+		"~ 3-4 float32BigWordBigEndian 2 -> 1 (0x4000 0x0000 -> 0x3f80 0x0000)\n" +
+		"- 5 0x0001\n"
+	if expect != buf.String() {
+		t.Errorf("expected %q, got %q", expect, buf.String())
+	}
+
+	// The golden image ends before the float.
+	buf.Reset()
+	diffImages(&buf, img[:4], img, holdingType, entries, -1)
+	expect = "+ 3-4 float32BigWordBigEndian 1\n"
+	if expect != buf.String() {
+		t.Errorf("expected %q, got %q", expect, buf.String())
+	}
+
+	_, err = diffImages(&buf, golden[:3], img, holdingType, entries, -1)
+	if err == nil {
+		t.Errorf("expected error for an odd number of bytes, got %v", err)
+	}
+}
+
+func TestDiffCoilImages(t *testing.T) {
+	coilFile := filepath.Join(t.TempDir(), "coil.json")
+	os.WriteFile(coilFile, []byte(`[{"type": "bit", "number": 1, "regAddr": 2}]`), 0644)
+
+	img, entries, err := renderImage(registerFile{filename: coilFile, registerType: coilType}, -1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	differ, _ := diffImages(&buf, []byte{1, 0}, img, coilType, entries, -1)
+	expect := "~ 1 1 -> 0\n~ 2 bit 0 -> 1 (0 -> 1)\n"
+	if !differ || expect != buf.String() {
+		t.Errorf("expected %q, got %q", expect, buf.String())
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// group is a consistency group of a config file, which is a range of
+// registers that are always read from the same update, so a client
+// reading the range never sees the values of two different updates.
+type group struct {
+	name string
+	rt   registerType
+	from int
+	to   int
+}
+
+// splitGroups will return the consistency groups of the raw config data,
+// which are the entries with a "group" field like
+// {"group": [100, 109], "name": "meter1"}, and the rest of the entries
+// of the raw config data.
+func splitGroups(registryRawData []map[string]interface{}) ([]group, []map[string]interface{}, error) {
+	var groups []group
+	var rest []map[string]interface{}
+
+	for i, obj := range registryRawData {
+		if _, ok := obj["group"]; !ok {
+			rest = append(rest, obj)
+			continue
+		}
+
+		r, _ := obj["group"].([]interface{})
+		if len(r) != 2 {
+			return nil, nil, errorAt(obj, i, "group must be the first and last address like [100, 109], got %v", obj["group"])
+		}
+		from, ok1 := r[0].(float64)
+		to, ok2 := r[1].(float64)
+		if !ok1 || !ok2 || from < 0 || to < from || to > 65535 || from != float64(int(from)) || to != float64(int(to)) {
+			return nil, nil, errorAt(obj, i, "group must be the first and last address like [100, 109], got %v", obj["group"])
+		}
+		name, _ := obj["name"].(string)
+
+		groups = append(groups, group{name: name, from: int(from), to: int(to)})
+	}
+
+	return groups, rest, nil
+}
+
+// groupBuffer holds the values of a group published for the clients,
+// while the registers of the group are updated.
+type groupBuffer struct {
+	group
+	// start is the address of the first register of the group in the
+	// register of the server.
+	start int
+	words []uint16
+}
+
+// registers will return the register of the server the group is in.
+func (b *groupBuffer) registers(serv *mbserver.Server) []uint16 {
+	if b.rt == inputType {
+		return serv.InputRegisters[:cap(serv.InputRegisters)]
+	}
+	return serv.HoldingRegisters[:cap(serv.HoldingRegisters)]
+}
+
+// publish will copy the registers of the group into the buffer read by
+// the clients. The server must be locked.
+func (b *groupBuffer) publish(serv *mbserver.Server) {
+	copy(b.words, b.registers(serv)[b.start:])
+}
+
+// overlay will write the published values of the group into the data of
+// a read response, for the registers of the group within the range read
+// from the address given.
+func (b *groupBuffer) overlay(data []byte, addr int, count int) {
+	for i := 0; i < count; i++ {
+		j := addr + i - b.start
+		if j < 0 || j >= len(b.words) || 1+i*2+2 > len(data) {
+			continue
+		}
+		binary.BigEndian.PutUint16(data[1+i*2:], b.words[j])
+	}
+}
+
+// startGroups will make the reads of the registers of the groups given
+// answered from a buffer, which is published from the registers every
+// interval of the clock. The values updated in between are not seen by
+// the clients until published, so all the registers of a group are
+// always read from the same update. A write by a client to a group is
+// published at once, so the value written can be read back.
+func startGroups(serv *mbserver.Server, groups []group, addrOffset int, interval time.Duration, clk *simClock) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	var buffers []*groupBuffer
+	for _, g := range groups {
+		if g.rt != inputType && g.rt != holdingType {
+			return fmt.Errorf("group %v: groups are only supported for input and holding registers", g.name)
+		}
+		if g.from+addrOffset < 0 {
+			return fmt.Errorf("group %v: %v-%v is outside the register", g.name, g.from, g.to)
+		}
+		buffers = append(buffers, &groupBuffer{group: g, start: g.from + addrOffset, words: make([]uint16, g.to-g.from+1)})
+	}
+
+	publish := func() {
+		for _, b := range buffers {
+			b.publish(serv)
+		}
+	}
+	serv.Lock()
+	publish()
+	serv.Unlock()
+
+	for _, fc := range []uint8{3, 4, 6, 16} {
+		function := serv.FunctionHandler(fc)
+		if function == nil {
+			continue
+		}
+
+		rt := holdingType
+		if fc == 4 {
+			rt = inputType
+		}
+		fc := fc
+		serv.RegisterFunctionHandler(fc, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+			data, exception := function(s, frame)
+			if *exception != mbserver.Success {
+				return data, exception
+			}
+
+			req := frame.GetData()
+			addr := int(binary.BigEndian.Uint16(req[0:2]))
+			for _, b := range buffers {
+				if b.rt != rt {
+					continue
+				}
+				switch fc {
+				case 3, 4:
+					count := int(binary.BigEndian.Uint16(req[2:4]))
+					b.overlay(data, addr, count)
+				default:
+					b.publish(s)
+				}
+			}
+			return data, exception
+		})
+	}
+
+	go runSteps(serv, clk, interval, func(dt time.Duration) {
+		publish()
+	})
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestSplitGroups(t *testing.T) {
+	raw := []map[string]interface{}{
+		{"group": []interface{}{100.0, 103.0}, "name": "meter1"},
+		{"type": "int16", "number": 1.0, "regAddr": 100.0},
+	}
+
+	groups, rest, err := splitGroups(raw)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := []group{{name: "meter1", from: 100, to: 103}}
+	if !isEqual(expect, groups) {
+		t.Errorf("expected %v, got %v", expect, groups)
+	}
+	if len(rest) != 1 {
+		t.Errorf("expected 1, got %v", len(rest))
+	}
+
+	for _, v := range []interface{}{[]interface{}{5.0}, []interface{}{5.0, 4.0}, []interface{}{1.5, 4.0}, "1-4"} {
+		_, _, err := splitGroups([]map[string]interface{}{{"group": v}})
+		if err == nil {
+			t.Errorf("expected error for group %v, got nil", v)
+		}
+	}
+}
+
+func TestStartGroups(t *testing.T) {
+	serv := mbserver.NewServer()
+	clk := newSimClock(time.Now(), 0)
+	groups := []group{{name: "meter1", rt: holdingType, from: 11, to: 12}}
+
+	err := startGroups(serv, groups, -1, time.Millisecond*10, clk)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	read := func() []uint16 {
+		var frame mbserver.TCPFrame
+		frame.Function = 3
+		mbserver.SetDataWithRegisterAndNumber(&frame, 9, 3)
+		serv.Lock()
+		data, exception := serv.FunctionHandler(3)(serv, &frame)
+		serv.Unlock()
+		if *exception != mbserver.Success {
+			t.Fatalf("expected Success, got %v", exception.String())
+		}
+		var got []uint16
+		for i := 0; i < 3; i++ {
+			got = append(got, binary.BigEndian.Uint16(data[1+i*2:]))
+		}
+		return got
+	}
+
+	// The group of the addresses 11 and 12 in the config is the registers
+	// 10 and 11. An update of the registers is not read until published, except
+	// for the registers outside the group.
+	serv.Lock()
+	serv.HoldingRegisters[9] = 1
+	serv.HoldingRegisters[10] = 2
+	serv.HoldingRegisters[11] = 3
+	serv.Unlock()
+	expect := []uint16{1, 0, 0}
+	if got := read(); !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// Let the publishing start before the clock is stepped.
+	time.Sleep(time.Millisecond * 20)
+	clk.Step(time.Millisecond * 10)
+	time.Sleep(time.Millisecond * 30)
+	expect = []uint16{1, 2, 3}
+	if got := read(); !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// A write by a client is read back at once.
+	var frame mbserver.TCPFrame
+	frame.Function = 6
+	mbserver.SetDataWithRegisterAndNumber(&frame, 10, 7)
+	serv.Lock()
+	_, exception := serv.FunctionHandler(6)(serv, &frame)
+	serv.Unlock()
+	if *exception != mbserver.Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	expect = []uint16{1, 7, 3}
+	if got := read(); !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	err = startGroups(mbserver.NewServer(), []group{{name: "c", rt: coilType, from: 1, to: 2}}, -1, time.Second, clk)
+	if err == nil {
+		t.Errorf("expected error for a coil group, got nil")
+	}
+}
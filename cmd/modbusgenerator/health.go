@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// health holds the status of the generator reported by the health
+// endpoints.
+type health struct {
+	mu            sync.Mutex
+	devices       []*device
+	listening     bool
+	configsLoaded bool
+	configErrors  int
+	lastLoad      time.Time
+}
+
+// healthStatus is the JSON body returned by the health endpoints.
+type healthStatus struct {
+	Status         string               `json:"status"`
+	Version        string               `json:"version"`
+	ConfigDrift    bool                 `json:"configDrift"`
+	Listening      bool                 `json:"listening"`
+	ConfigsLoaded  bool                 `json:"configsLoaded"`
+	ConfigErrors   int                  `json:"configErrors"`
+	LastLoad       time.Time            `json:"lastLoad"`
+	LastLoadStatus string               `json:"lastLoadStatus"`
+	Listeners      []listenerStatStatus `json:"listeners"`
+}
+
+// listenerStatStatus is the status of a single listener, with the name
+// and labels of the device listening when given in a fleet config.
+type listenerStatStatus struct {
+	Device      string            `json:"device,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Address     string            `json:"address"`
+	Connections int               `json:"connections"`
+	Rejected    uint64            `json:"rejected,omitempty"`
+	ConfigHash  string            `json:"configHash,omitempty"`
+}
+
+// newHealth will return a health reporting the listeners of the devices
+// given.
+func newHealth(devices ...*device) *health {
+	return &health{devices: devices}
+}
+
+// setLoaded marks the config files as loaded, with the number of errors
+// found while loading them.
+func (h *health) setLoaded(configErrors int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.configsLoaded = true
+	h.configErrors = configErrors
+	h.lastLoad = time.Now()
+}
+
+// setListening marks the listener as started.
+func (h *health) setListening() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.listening = true
+}
+
+// status returns the current status, and true if the generator is ready
+// to answer requests.
+func (h *health) status() (healthStatus, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ready := h.listening && h.configsLoaded
+
+	st := healthStatus{
+		Status:         "ok",
+		Version:        readBuildInfo().String(),
+		ConfigDrift:    loadedConfigs.drifted(),
+		Listening:      h.listening,
+		ConfigsLoaded:  h.configsLoaded,
+		ConfigErrors:   h.configErrors,
+		LastLoad:       h.lastLoad,
+		LastLoadStatus: "ok",
+		Listeners:      []listenerStatStatus{},
+	}
+	if !ready {
+		st.Status = "not ready"
+	}
+	if h.configErrors > 0 {
+		st.LastLoadStatus = fmt.Sprintf("%v errors", h.configErrors)
+	}
+	if h.listening {
+		for _, d := range h.devices {
+			for _, v := range d.serv.ListenerStats() {
+				st.Listeners = append(st.Listeners, listenerStatStatus{Device: d.name, Labels: d.labels, Address: v.Address, Connections: v.Connections, Rejected: v.Rejected, ConfigHash: d.configHash})
+			}
+		}
+	}
+
+	return st, ready
+}
+
+// handleHealthz answers if the generator is alive, which it always is
+// when it is able to answer.
+func (h *health) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	st, _ := h.status()
+	writeJSON(w, http.StatusOK, st)
+}
+
+// handleReadyz answers if the generator is ready to answer requests,
+// which is when the config files are loaded and the listener is started.
+func (h *health) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	st, ready := h.status()
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, st)
+}
+
+// writeJSON will write v as the JSON body of the response with the
+// status code given.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("error: failed to write http response: %v\n", err)
+	}
+}
+
+// startHTTPServer will start a HTTP server listening on the address given,
+// serving the handler given. The server uses TLS when a TLS config is
+// given.
+func startHTTPServer(addr string, handler http.Handler, tlsConfig *tls.Config) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	return serveHTTP(l, handler), nil
+}
+
+// startHTTPSocket will start a HTTP server listening on the unix socket
+// given, serving the handler given. The permissions of the socket
+// are set to the mode given, so access can be limited to the owner and
+// group. A socket left behind by an earlier run is removed, but any
+// other file at the path is an error.
+func startHTTPSocket(path string, mode os.FileMode, handler http.Handler) (*http.Server, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%v exists and is not a socket", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, mode)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return serveHTTP(l, handler), nil
+}
+
+// serveHTTP will serve the handler on the listener given.
+func serveHTTP(l net.Listener, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	go func() {
+		err := srv.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("error: http server: %v\n", err)
+		}
+	}()
+
+	return srv
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestHealthReadyz(t *testing.T) {
+	h := newHealth(&device{serv: mbserver.NewServer()})
+
+	rec := httptest.NewRecorder()
+	h.handleReadyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected %v before configs are loaded, got %v", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	h.setLoaded(2)
+	h.setListening()
+
+	rec = httptest.NewRecorder()
+	h.handleReadyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected %v when ready, got %v", http.StatusOK, rec.Code)
+	}
+
+	var st healthStatus
+	err := json.Unmarshal(rec.Body.Bytes(), &st)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if st.LastLoadStatus != "2 errors" {
+		t.Errorf("expected last load status %q, got %q", "2 errors", st.LastLoadStatus)
+	}
+}
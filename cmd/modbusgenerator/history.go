@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// historyRegisterTypes is the order the register types are recorded in.
+var historyRegisterTypes = []registerType{coilType, discreteType, inputType, holdingType}
+
+// historySample is a change of the value of an entry recorded in the
+// history.
+type historySample struct {
+	time   time.Time
+	device string
+	// unit is the unit ID of the value, or -1 for the server of the
+	// device itself.
+	unit     int
+	register registerType
+	address  int
+	typ      string
+	value    float64
+}
+
+// line will return the sample as a line of InfluxDB line protocol, or
+// false if the value is not a number or infinite, which line protocol
+// does not support.
+func (s historySample) line() (string, bool) {
+	if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+		return "", false
+	}
+
+	tags := historyTags(s.device, s.unit, s.register, s.address, s.typ)
+	return fmt.Sprintf("modbus,%v value=%v %v", tags, s.value, s.time.UnixNano()), true
+}
+
+// historyLines will return the samples given as lines of InfluxDB line
+// protocol, leaving out the values line protocol does not support.
+func historyLines(samples []historySample) []string {
+	var lines []string
+	for _, s := range samples {
+		if l, ok := s.line(); ok {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// recorder records the changes of the values of the entries of a device.
+type recorder struct {
+	device     *device
+	addrOffset int
+	// last holds the last value recorded for each entry, by the tags
+	// of the entry.
+	last map[string]float64
+}
+
+func newRecorder(d *device, addrOffset int) *recorder {
+	return &recorder{
+		device:     d,
+		addrOffset: addrOffset,
+		last:       make(map[string]float64),
+	}
+}
+
+// record will return a sample for each entry of the device, and of each
+// unit of the device, where the value has changed since the last time
+// record was called. The first time all the values are returned.
+func (r *recorder) record(now time.Time) []historySample {
+	d := r.device
+
+	// Record the server of the device, and all the units sorted by id.
+	servers := []*mbserver.Server{d.serv}
+	unitIDs := []int{-1}
+	units := d.serv.Units()
+	var ids []int
+	for id := range units {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		servers = append(servers, units[uint8(id)])
+		unitIDs = append(unitIDs, id)
+	}
+
+	d.serv.Lock()
+	defer d.serv.Unlock()
+
+	var samples []historySample
+	for i, serv := range servers {
+		for _, rt := range historyRegisterTypes {
+			for _, e := range d.profile.entries[rt] {
+				v, ok := entryValue(serv, rt, e, r.addrOffset)
+				if !ok {
+					continue
+				}
+
+				tags := historyTags(d.name, unitIDs[i], rt, e.enc.Address(), entryType(e.enc))
+				last, seen := r.last[tags]
+				if seen && math.Float64bits(last) == math.Float64bits(v) {
+					continue
+				}
+				r.last[tags] = v
+
+				samples = append(samples, historySample{
+					time:     now,
+					device:   d.name,
+					unit:     unitIDs[i],
+					register: rt,
+					address:  e.enc.Address(),
+					typ:      entryType(e.enc),
+					value:    v,
+				})
+			}
+		}
+	}
+
+	return samples
+}
+
+// entryValue will return the value of the entry decoded from the
+// registers of the server. It returns false if the entry is outside the
+// registers.
+func entryValue(serv *mbserver.Server, rt registerType, e configEntry, addrOffset int) (float64, bool) {
+	words, ok := entryWords(serv, rt, e, addrOffset)
+	if !ok {
+		return 0, false
+	}
+	return e.enc.Decode(words), true
+}
+
+// entryWords will return the words of the entry in the registers of the
+// server, where the coils and discrete inputs of the entry are made into
+// words. It returns false if the entry is outside the registers.
+func entryWords(serv *mbserver.Server, rt registerType, e configEntry, addrOffset int) ([]uint16, bool) {
+	addr := e.enc.Address() + addrOffset
+	size := len(e.enc.Encode())
+	if addr < 0 {
+		return nil, false
+	}
+
+	var words []uint16
+	switch rt {
+	case coilType, discreteType:
+		b := serv.Coils[:cap(serv.Coils)]
+		if rt == discreteType {
+			b = serv.DiscreteInputs[:cap(serv.DiscreteInputs)]
+		}
+		if addr+coilCount(e.enc) > len(b) {
+			return nil, false
+		}
+		words = coilsToWords(e.enc, b[addr:])
+	case inputType:
+		if addr+size > cap(serv.InputRegisters) {
+			return nil, false
+		}
+		words = serv.InputRegisters[addr : addr+size]
+	case holdingType:
+		if addr+size > cap(serv.HoldingRegisters) {
+			return nil, false
+		}
+		words = serv.HoldingRegisters[addr : addr+size]
+	}
+
+	return words, true
+}
+
+// historyTags will return the tags of a line for the entry given.
+func historyTags(deviceName string, unitID int, rt registerType, address int, typ string) string {
+	var tags []string
+	if deviceName != "" {
+		tags = append(tags, "device="+escapeTag(deviceName))
+	}
+	if unitID >= 0 {
+		tags = append(tags, fmt.Sprintf("unit=%v", unitID))
+	}
+	tags = append(tags, "register="+string(rt), fmt.Sprintf("address=%v", address), "type="+typ)
+
+	return strings.Join(tags, ",")
+}
+
+// escapeTag will escape the characters that have a special meaning in
+// the tags of the line protocol.
+func escapeTag(s string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
+
+// influxWriter writes the lines to the InfluxDB write endpoint given
+// with the url, e.g. http://localhost:8086/api/v2/write?org=o&bucket=b.
+type influxWriter struct {
+	url    string
+	token  string
+	client http.Client
+}
+
+// Write will post the lines given to the InfluxDB write endpoint.
+func (w *influxWriter) Write(p []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(p))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return 0, fmt.Errorf("unexpected http status: %v", resp.Status)
+	}
+
+	return len(p), nil
+}
+
+// historyWriter writes the samples recorded in the history somewhere,
+// like a file or a database.
+type historyWriter interface {
+	writeSamples(samples []historySample) error
+}
+
+// lineWriter writes the samples as InfluxDB line protocol to the writer,
+// like a file or the InfluxDB write endpoint.
+type lineWriter struct {
+	w io.Writer
+}
+
+func (l lineWriter) writeSamples(samples []historySample) error {
+	lines := historyLines(samples)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	_, err := l.w.Write([]byte(strings.Join(lines, "\n") + "\n"))
+	return err
+}
+
+// startHistory will start recording the changes of the values of the
+// devices given at every interval, and write them to the writers given,
+// with the time of the simulation clock.
+func startHistory(devices []*device, addrOffset int, interval time.Duration, writers []historyWriter, clk *simClock) {
+	if len(writers) == 0 {
+		return
+	}
+
+	var recorders []*recorder
+	for _, d := range devices {
+		recorders = append(recorders, newRecorder(d, addrOffset))
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			now := clk.Now()
+			var samples []historySample
+			for _, r := range recorders {
+				samples = append(samples, r.record(now)...)
+			}
+			if len(samples) == 0 {
+				continue
+			}
+
+			for _, w := range writers {
+				err := w.writeSamples(samples)
+				if err != nil {
+					log.Printf("error: history: %v\n", err)
+				}
+			}
+		}
+	}()
+}
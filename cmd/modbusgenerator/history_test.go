@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestRecorder(t *testing.T) {
+	p := profile{
+		entries: map[registerType][]configEntry{
+			coilType: testEntries([]map[string]interface{}{
+				{"type": "wordInt16BigEndian", "number": 1.0, "regAddr": 301.0},
+			}),
+			holdingType: testEntries([]map[string]interface{}{
+				{"type": "float32BigWordBigEndian", "number": 21.5, "regAddr": 201.0},
+			}),
+		},
+	}
+
+	serv := mbserver.NewServer()
+	setRegister(serv, []encoder{p.entries[coilType][0].enc}, "coil", -1)
+	setRegister(serv, []encoder{p.entries[holdingType][0].enc}, "holding", -1)
+	d := &device{name: "boiler 1", serv: serv, profile: p}
+
+	r := newRecorder(d, -1)
+	now := time.Unix(0, 1000)
+
+	lines := historyLines(r.record(now))
+	expect := []string{
+		`modbus,device=boiler\ 1,register=coil,address=301,type=wordInt16BigEndian value=1 1000`,
+		`modbus,device=boiler\ 1,register=holding,address=201,type=float32BigWordBigEndian value=21.5 1000`,
+	}
+	if !isEqual(expect, lines) {
+		t.Errorf("expected %v, got %v", expect, lines)
+	}
+
+	// Only the changed values are recorded.
+	copy(serv.HoldingRegisters[200:], encodeNumber(p.entries[holdingType][0], 22))
+	lines = historyLines(r.record(now))
+	expect = []string{
+		`modbus,device=boiler\ 1,register=holding,address=201,type=float32BigWordBigEndian value=22 1000`,
+	}
+	if !isEqual(expect, lines) {
+		t.Errorf("expected %v, got %v", expect, lines)
+	}
+
+	if lines := historyLines(r.record(now)); len(lines) != 0 {
+		t.Errorf("expected no lines, got %v", lines)
+	}
+}
+
+func TestInfluxWriter(t *testing.T) {
+	var got string
+	var auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	w := &influxWriter{url: ts.URL, token: "secret"}
+	line := "modbus,register=holding,address=201 value=1 1000\n"
+	_, err := w.Write([]byte(line))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if got != line || !strings.HasSuffix(auth, "secret") {
+		t.Errorf("expected %q with token, got %q and %q", line, got, auth)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// journalRecordSize is the number of registers of each event in the
+// journal region of the input registers.
+const journalRecordSize = 6
+
+// journalTables is the Modbus table number of each register type, as
+// used in the event records of the journal region.
+var journalTables = map[registerType]uint16{
+	coilType:     0,
+	discreteType: 1,
+	inputType:    3,
+	holdingType:  4,
+}
+
+// journalEvent is a change of the value of a config entry. The old and
+// new values are in the engineering unit of the entry.
+type journalEvent struct {
+	Seq             uint64    `json:"seq"`
+	Time            time.Time `json:"time"`
+	Device          string    `json:"device,omitempty"`
+	Unit            int       `json:"unit,omitempty"`
+	Register        string    `json:"register"`
+	Address         int       `json:"address"`
+	Type            string    `json:"type"`
+	Name            string    `json:"name,omitempty"`
+	EngineeringUnit string    `json:"engineeringUnit,omitempty"`
+	Old             float64   `json:"old"`
+	New             float64   `json:"new"`
+	// raw is the new value as decoded from the registers, which is
+	// written into the journal region.
+	raw float64
+}
+
+// journal keeps the last changes of the values of the config entries of
+// a server, like the event log of a device. The events are kept in a
+// circular buffer, where the oldest event is dropped when full.
+type journal struct {
+	device string
+	unit   int
+	size   int
+	events []journalEvent
+	seq    uint64
+	// changes finds the changes of the values of the entries.
+	changes *changeTracker
+	// region is the address of the journal region in the input
+	// registers, or -1 when the journal is not readable over Modbus.
+	region int
+}
+
+func newJournal(device string, unit int, size int, region int) *journal {
+	return &journal{
+		device:  device,
+		unit:    unit,
+		size:    size,
+		changes: newChangeTracker(),
+		region:  region,
+	}
+}
+
+// changeTracker finds the changes of the values of the config entries of
+// a server.
+type changeTracker struct {
+	// last holds the last value of each entry, by register type and
+	// index of the entry.
+	last map[registerType][]float64
+}
+
+func newChangeTracker() *changeTracker {
+	return &changeTracker{last: make(map[registerType][]float64)}
+}
+
+// check will call fn with the old and new value of each entry where the
+// value has changed since the last check. The first check only takes the
+// values of the entries, and changes to or from NaN or infinity are
+// skipped, since JSON do not support them. The server must be locked.
+func (ct *changeTracker) check(serv *mbserver.Server, entries map[registerType][]configEntry, addrOffset int, fn func(rt registerType, e configEntry, old float64, v float64)) {
+	for _, rt := range historyRegisterTypes {
+		first := ct.last[rt] == nil
+		if first {
+			ct.last[rt] = make([]float64, len(entries[rt]))
+		}
+
+		for i, e := range entries[rt] {
+			v, ok := entryValue(serv, rt, e, addrOffset)
+			if !ok {
+				continue
+			}
+			old := ct.last[rt][i]
+			ct.last[rt][i] = v
+			if first || math.Float64bits(old) == math.Float64bits(v) {
+				continue
+			}
+			if math.IsNaN(v) || math.IsInf(v, 0) || math.IsNaN(old) || math.IsInf(old, 0) {
+				continue
+			}
+
+			fn(rt, e, old, v)
+		}
+	}
+}
+
+// check will add an event for each entry where the value has changed
+// since the last check. The first check only takes the values of the
+// entries. The server must be locked.
+func (j *journal) check(serv *mbserver.Server, entries map[registerType][]configEntry, addrOffset int, now time.Time) {
+	j.changes.check(serv, entries, addrOffset, func(rt registerType, e configEntry, old float64, v float64) {
+		j.add(serv, changeEvent(j.device, j.unit, rt, e, old, v, now))
+	})
+}
+
+// changeEvent will return the event of the change of the value of the
+// entry given, with the values in the engineering unit of the entry.
+func changeEvent(device string, unit int, rt registerType, e configEntry, old float64, v float64, now time.Time) journalEvent {
+	m := e.meta()
+	return journalEvent{
+		Time:            now,
+		Device:          device,
+		Unit:            unit,
+		Register:        string(rt),
+		Address:         e.enc.Address(),
+		Type:            entryType(e.enc),
+		Name:            m.Name,
+		EngineeringUnit: m.Unit,
+		Old:             m.scaled(old),
+		New:             m.scaled(v),
+		raw:             v,
+	}
+}
+
+// add will add the event to the journal, and write it into the journal
+// region when the journal is readable over Modbus.
+func (j *journal) add(serv *mbserver.Server, ev journalEvent) {
+	j.seq++
+	ev.Seq = j.seq
+	if len(j.events) == j.size {
+		j.events = j.events[1:]
+	}
+	j.events = append(j.events, ev)
+
+	if j.region < 0 {
+		return
+	}
+	slot := int((j.seq - 1) % uint64(j.size))
+	regs := serv.InputRegisters[:cap(serv.InputRegisters)]
+	t := uint32(ev.Time.Unix())
+	v := float32Bits(ev.raw)
+	copy(regs[j.region+2+slot*journalRecordSize:], []uint16{
+		uint16(t >> 16), uint16(t),
+		journalTables[registerType(ev.Register)],
+		uint16(ev.Address),
+		uint16(v >> 16), uint16(v),
+	})
+	j.writeHeader(serv)
+}
+
+// writeHeader will write the number of events added, and the slot the
+// next event is written to, into the first two registers of the journal
+// region.
+func (j *journal) writeHeader(serv *mbserver.Server) {
+	regs := serv.InputRegisters[:cap(serv.InputRegisters)]
+	regs[j.region] = uint16(j.seq)
+	regs[j.region+1] = uint16(j.seq % uint64(j.size))
+}
+
+// since will return the events of the journal with a sequence number
+// after the one given.
+func (j *journal) since(seq uint64) []journalEvent {
+	var events []journalEvent
+	for _, ev := range j.events {
+		if ev.Seq > seq {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// journalRegion will return the address in the input registers of the
+// journal region starting at the address given in the config files, and
+// check that the region is within the registers and do not overlap the
+// input register entries.
+func journalRegion(addr int, size int, addrOffset int, spans []addrSpan) (int, error) {
+	return inputRegion("journal", addr, 2+size*journalRecordSize, addrOffset, spans)
+}
+
+// inputRegion will return the address in the input registers of the
+// region with the name and number of registers given, starting at the
+// address given in the config files, and check that the region is within
+// the registers and do not overlap the spans given.
+func inputRegion(name string, addr int, length int, addrOffset int, spans []addrSpan) (int, error) {
+	from := addr + addrOffset
+	to := from + length
+	if from < 0 || to > 65536 {
+		return 0, fmt.Errorf("%v region %v-%v is outside the input registers", name, addr, to-addrOffset-1)
+	}
+	for _, s := range spans {
+		if s.from < to && from < s.to {
+			return 0, fmt.Errorf("%v region %v-%v overlaps the input registers at %v", name, addr, to-addrOffset-1, s.from-addrOffset)
+		}
+	}
+
+	return from, nil
+}
+
+// startJournal will start checking the entries of the server for changes
+// every interval of the clock, and add the changes to the journal.
+func startJournal(serv *mbserver.Server, j *journal, entries map[registerType][]configEntry, addrOffset int, interval time.Duration, clk *simClock) {
+	serv.Lock()
+	j.check(serv, entries, addrOffset, clk.Now())
+	if j.region >= 0 {
+		j.writeHeader(serv)
+	}
+	serv.Unlock()
+
+	go runSteps(serv, clk, interval, func(dt time.Duration) {
+		j.check(serv, entries, addrOffset, clk.Now())
+	})
+}
+
+// unitIDOf will return the unit ID the server given answers as on the
+// device, or 0 for the server of the device itself.
+func unitIDOf(d *device, serv *mbserver.Server) int {
+	for id, s := range d.serv.Units() {
+		if s == serv {
+			return int(id)
+		}
+	}
+	return 0
+}
+
+// journalControl answers with the events of the journals of the devices.
+type journalControl struct {
+	devices []*device
+}
+
+// handleJournal answers with the events of the journals, sorted by time.
+// The device query parameter selects the device of a fleet, and the
+// since query parameter only returns the events with a sequence number
+// after the one given, e.g. /journal?since=42. The sequence numbers are
+// counted for each unit.
+func (jc *journalControl) handleJournal(w http.ResponseWriter, r *http.Request) {
+	devices, err := selectDevices(jc.devices, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var since uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		since, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("since must be a sequence number, got %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events := []journalEvent{}
+	for _, d := range devices {
+		d.serv.Lock()
+		for _, j := range d.journals {
+			events = append(events, j.since(since)...)
+		}
+		d.serv.Unlock()
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+
+	writeJSON(w, http.StatusOK, events)
+}
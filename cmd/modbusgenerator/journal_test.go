@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestJournal(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 21.5, "regAddr": 201.0},
+		}),
+	}
+
+	serv := mbserver.NewServer()
+	setRegister(serv, []encoder{entries[holdingType][0].enc}, "holding", -1)
+
+	region, err := journalRegion(1001, 2, -1, nil)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	j := newJournal("boiler", 0, 2, region)
+	now := time.Unix(1000, 0)
+
+	// The first check only takes the values.
+	j.check(serv, entries, -1, now)
+	if len(j.events) != 0 {
+		t.Errorf("expected no events, got %v", j.events)
+	}
+
+	for _, n := range []float64{22, 23, 24} {
+		copy(serv.HoldingRegisters[200:], encodeNumber(entries[holdingType][0], n))
+		j.check(serv, entries, -1, now)
+	}
+
+	// Only the last two events are kept.
+	expect := []journalEvent{
+		{Seq: 2, Time: now, Device: "boiler", Register: "holding", Address: 201, Type: "float32BigWordBigEndian", Old: 22, New: 23, raw: 23},
+		{Seq: 3, Time: now, Device: "boiler", Register: "holding", Address: 201, Type: "float32BigWordBigEndian", Old: 23, New: 24, raw: 24},
+	}
+	if !isEqual(expect, j.events) {
+		t.Errorf("expected %v, got %v", expect, j.events)
+	}
+	if got := j.since(2); len(got) != 1 || got[0].Seq != 3 {
+		t.Errorf("expected event 3, got %v", got)
+	}
+
+	// The third event is written to the first slot of the region, and
+	// the next event goes to the second slot.
+	got := serv.InputRegisters[1000:1014]
+	expectRegs := []uint16{
+		3, 1,
+		0, 1000, 4, 201, 0x41c0, 0,
+		0, 1000, 4, 201, 0x41b8, 0,
+	}
+	if !isEqual(expectRegs, got) {
+		t.Errorf("expected %v, got %v", expectRegs, got)
+	}
+
+	_, err = journalRegion(199, 1, -1, []addrSpan{{from: 200, to: 202}})
+	if err == nil {
+		t.Errorf("expected error for a region overlapping an entry, got nil")
+	}
+	_, err = journalRegion(65530, 10, -1, nil)
+	if err == nil {
+		t.Errorf("expected error for a region outside the registers, got nil")
+	}
+}
+
+func TestHandleJournal(t *testing.T) {
+	j := newJournal("", 0, 10, -1)
+	j.events = []journalEvent{
+		{Seq: 1, Register: "holding", Address: 201, New: 1},
+		{Seq: 2, Register: "holding", Address: 201, Old: 1, New: 2},
+	}
+	jc := &journalControl{devices: []*device{{serv: mbserver.NewServer(), journals: []*journal{j}}}}
+
+	rec := httptest.NewRecorder()
+	jc.handleJournal(rec, httptest.NewRequest("GET", "/journal?since=1", nil))
+	var events []journalEvent
+	err := json.NewDecoder(rec.Body).Decode(&events)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(events) != 1 || events[0].New != 2 {
+		t.Errorf("expected the second event, got %v", events)
+	}
+
+	rec = httptest.NewRecorder()
+	jc.handleJournal(rec, httptest.NewRequest("GET", "/journal?since=foo", nil))
+	if rec.Code != 400 {
+		t.Errorf("expected 400, got %v", rec.Code)
+	}
+}
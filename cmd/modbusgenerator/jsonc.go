@@ -0,0 +1,75 @@
+package main
+
+import "bytes"
+
+// stripJSONC will return the JSON given with the // line comments and the
+// /* block */ comments removed, and the trailing commas before a closing
+// ] or } removed, so config files can be written as JSONC with commentary
+// inline. The removed characters are replaced with spaces, keeping the
+// newlines, so the lines and offsets of the decoding errors are the same
+// as in the file.
+func stripJSONC(js []byte) []byte {
+	out := make([]byte, len(js))
+	copy(out, js)
+
+	// blank will replace the characters from i up to j with spaces,
+	// keeping the newlines.
+	blank := func(i int, j int) {
+		for ; i < j; i++ {
+			if out[i] != '\n' {
+				out[i] = ' '
+			}
+		}
+	}
+
+	// comma holds the position of the last comma seen outside of the
+	// strings, or -1 if something other than white space and comments
+	// was seen after it.
+	comma := -1
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		switch {
+		case c == '"':
+			// Skip the string, with the escaped characters in it.
+			for i++; i < len(out) && out[i] != '"'; i++ {
+				if out[i] == '\\' {
+					i++
+				}
+			}
+			comma = -1
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			end := bytes.IndexByte(out[i:], '\n')
+			if end < 0 {
+				end = len(out) - i
+			}
+			blank(i, i+end)
+			i += end - 1
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := bytes.Index(out[i+2:], []byte("*/"))
+			if end < 0 {
+				// Leave an unterminated comment to the decoder to report.
+				return out
+			}
+			blank(i, i+2+end+2)
+			i += 2 + end + 1
+		case c == ',':
+			comma = i
+		case c == ']' || c == '}':
+			if comma >= 0 {
+				out[comma] = ' '
+			}
+			comma = -1
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		default:
+			comma = -1
+		}
+	}
+
+	return out
+}
+
+// isEmptyJSON will return true if the JSON given has nothing but white
+// space.
+func isEmptyJSON(js []byte) bool {
+	return len(bytes.TrimSpace(js)) == 0
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStripJSONC(t *testing.T) {
+	js := []byte(`[
+	// The temperature.
+	{"type": "int16BigEndian", "number": 1, "regAddr": 3, "description": "a // b /* c */ \"d\","},
+	/* The pressure,
+	   in bar. */
+	{"type": "int16BigEndian", "number": 2, "regAddr": 5,},
+]`)
+	got := stripJSONC(js)
+	if len(got) != len(js) || bytes.Count(got, []byte("\n")) != bytes.Count(js, []byte("\n")) {
+		t.Errorf("expected the length and lines to be kept, got %s", got)
+	}
+
+	var entries []map[string]interface{}
+	err := json.Unmarshal(got, &entries)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", len(entries))
+	}
+	expect := `a // b /* c */ "d",`
+	if entries[0]["description"] != expect {
+		t.Errorf("expected %v, got %v", expect, entries[0]["description"])
+	}
+}
+
+func TestLoadConfigFileJSONC(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "holding.json")
+
+	os.WriteFile(filename, []byte(`[
+	// The setpoint.
+	{"type": "int16BigEndian", "number": 1, "regAddr": 3},
+]`), 0644)
+	entries, err := loadConfigFile(filename)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected 1 entry, got %v, %v", entries, err)
+	}
+
+	// An empty file, or a file with only comments, is an empty register
+	// set.
+	for _, content := range []string{"", " \n", "// Nothing here yet.\n"} {
+		os.WriteFile(filename, []byte(content), 0644)
+		entries, err = loadConfigFile(filename)
+		if err != nil || len(entries) != 0 {
+			t.Errorf("expected no entries for %q, got %v, %v", content, entries, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaQueueSize is the number of change events waiting to be published,
+// where the events are dropped when the queue is full.
+const kafkaQueueSize = 1000
+
+// kafkaBatchSize is the max number of events written to Kafka at once.
+const kafkaBatchSize = 100
+
+// kafkaTimeout is the max time used for writing a batch of events.
+const kafkaTimeout = time.Second * 10
+
+// kafkaTopicName is the characters and length allowed in a Kafka topic.
+var kafkaTopicName = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+
+// kafkaChangeSchema is the Avro schema of the change events published to
+// Kafka, with the same fields as the JSON published to NATS.
+const kafkaChangeSchema = `{
+	"type": "record",
+	"name": "Change",
+	"namespace": "modbusgenerator",
+	"fields": [
+		{"name": "seq", "type": "long"},
+		{"name": "time", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+		{"name": "device", "type": "string"},
+		{"name": "unit", "type": "int"},
+		{"name": "register", "type": "string"},
+		{"name": "address", "type": "int"},
+		{"name": "type", "type": "string"},
+		{"name": "name", "type": "string"},
+		{"name": "engineeringUnit", "type": "string"},
+		{"name": "old", "type": "double"},
+		{"name": "new", "type": "double"}
+	]
+}`
+
+// kafkaWriter writes messages to Kafka, which is a *kafka.Writer unless
+// replaced in the tests.
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// kafkaPublisher publishes the changes of the values of the config entries
+// to a Kafka topic, serialized as Avro, so a data pipeline under test can
+// consume the same values as the Modbus clients see. The key of the
+// messages is the register changed, so the changes of a register are
+// kept in order on the same partition.
+type kafkaPublisher struct {
+	brokers string
+	codec   *goavro.Codec
+	w       kafkaWriter
+	events  chan journalEvent
+	failing bool
+}
+
+// newKafkaPublisher will start publishing the change events to the topic
+// given on the comma separated list of Kafka brokers given, like
+// localhost:9092,localhost:9093. The topic is created when it does not
+// exist and the brokers allow it.
+func newKafkaPublisher(brokers string, topic string) (*kafkaPublisher, error) {
+	var addrs []string
+	for _, b := range strings.Split(brokers, ",") {
+		b = strings.TrimSpace(b)
+		host, port, err := net.SplitHostPort(b)
+		if err != nil || host == "" || port == "" {
+			return nil, fmt.Errorf("kafkaBrokers must be a comma separated list like localhost:9092, got %q", brokers)
+		}
+		addrs = append(addrs, b)
+	}
+	if !kafkaTopicName.MatchString(topic) || topic == "." || topic == ".." {
+		return nil, fmt.Errorf("kafkaTopic must be a topic of letters, digits, dots, underscores and dashes, got %q", topic)
+	}
+
+	w := &kafka.Writer{
+		Addr:                   kafka.TCP(addrs...),
+		Topic:                  topic,
+		Balancer:               &kafka.Hash{},
+		BatchSize:              kafkaBatchSize,
+		BatchTimeout:           time.Millisecond * 10,
+		RequiredAcks:           kafka.RequireOne,
+		AllowAutoTopicCreation: true,
+	}
+
+	return startKafkaPublisher(strings.Join(addrs, ","), w)
+}
+
+// startKafkaPublisher will start publishing the change events with the
+// writer given, in batches of the events waiting in the queue.
+func startKafkaPublisher(brokers string, w kafkaWriter) (*kafkaPublisher, error) {
+	codec, err := goavro.NewCodec(kafkaChangeSchema)
+	if err != nil {
+		return nil, fmt.Errorf("kafka: %v", err)
+	}
+
+	kp := &kafkaPublisher{
+		brokers: brokers,
+		codec:   codec,
+		w:       w,
+		events:  make(chan journalEvent, kafkaQueueSize),
+	}
+
+	go func() {
+		for ev := range kp.events {
+			batch := []journalEvent{ev}
+		more:
+			for len(batch) < kafkaBatchSize {
+				select {
+				case ev := <-kp.events:
+					batch = append(batch, ev)
+				default:
+					break more
+				}
+			}
+			kp.publish(batch)
+		}
+	}()
+
+	return kp, nil
+}
+
+// enqueue will queue the event for publishing, and return false when the
+// queue is full.
+func (kp *kafkaPublisher) enqueue(ev journalEvent) bool {
+	select {
+	case kp.events <- ev:
+		return true
+	default:
+		return false
+	}
+}
+
+// String will return the name of the publisher used in the logs.
+func (kp *kafkaPublisher) String() string {
+	return "kafka"
+}
+
+// encode will return the event given serialized as Avro, with the single
+// object encoding, so the consumers can find the schema of the message by
+// its fingerprint.
+func (kp *kafkaPublisher) encode(ev journalEvent) ([]byte, error) {
+	return kp.codec.SingleFromNative(nil, map[string]interface{}{
+		"seq":             int64(ev.Seq),
+		"time":            ev.Time,
+		"device":          ev.Device,
+		"unit":            int32(ev.Unit),
+		"register":        ev.Register,
+		"address":         int32(ev.Address),
+		"type":            ev.Type,
+		"name":            ev.Name,
+		"engineeringUnit": ev.EngineeringUnit,
+		"old":             ev.Old,
+		"new":             ev.New,
+	})
+}
+
+// kafkaKey will return the key of the message of the event given, which
+// is <device>.<unit>.<register>.<address> like the subjects published to
+// NATS, with the device default for the server when not in a fleet.
+func kafkaKey(ev journalEvent) string {
+	device := "default"
+	if ev.Device != "" {
+		device = ev.Device
+	}
+	return fmt.Sprintf("%v.%v.%v.%v", device, ev.Unit, ev.Register, ev.Address)
+}
+
+// publish will write the events given to Kafka. The errors are logged
+// once until the brokers can be written to again.
+func (kp *kafkaPublisher) publish(events []journalEvent) {
+	msgs := make([]kafka.Message, 0, len(events))
+	for _, ev := range events {
+		b, err := kp.encode(ev)
+		if err != nil {
+			log.Printf("error: kafka: %v\n", err)
+			continue
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(kafkaKey(ev)), Value: b, Time: ev.Time})
+	}
+	if len(msgs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaTimeout)
+	defer cancel()
+	err := kp.w.WriteMessages(ctx, msgs...)
+	if err != nil {
+		if !kp.failing {
+			log.Printf("error: kafka: publishing to %v: %v\n", kp.brokers, err)
+		}
+		kp.failing = true
+		return
+	}
+	if kp.failing {
+		log.Printf("info: kafka: publishing to %v again\n", kp.brokers)
+	}
+	kp.failing = false
+}
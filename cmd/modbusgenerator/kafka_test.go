@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeKafkaWriter passes the messages written to the channel messages.
+type fakeKafkaWriter struct {
+	messages chan kafka.Message
+}
+
+func (w *fakeKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	for _, m := range msgs {
+		w.messages <- m
+	}
+	return nil
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	w := &fakeKafkaWriter{messages: make(chan kafka.Message, 10)}
+	kp, err := startKafkaPublisher("localhost:9092", w)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	entries := map[registerType][]configEntry{
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "int16", "number": 215.0, "regAddr": 201.0, "name": "supplyTemp", "scale": 0.1},
+		}),
+	}
+	serv := mbserver.NewServer()
+	setRegister(serv, []encoder{entries[holdingType][0].enc}, "holding", -1)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := newSimClock(now, 0)
+	startEvents(serv, kp, "boiler 1", 2, entries, -1, time.Millisecond*10, clk)
+
+	// The first check only takes the values, so the change is published
+	// by the next check, when the steps have started.
+	time.Sleep(time.Millisecond * 50)
+	serv.Lock()
+	serv.HoldingRegisters[200] = 220
+	serv.Unlock()
+	clk.Step(time.Millisecond * 10)
+
+	select {
+	case m := <-w.messages:
+		if string(m.Key) != "boiler 1.2.holding.201" {
+			t.Errorf("expected %v, got %v", "boiler 1.2.holding.201", string(m.Key))
+		}
+		native, _, err := kp.codec.NativeFromSingle(m.Value)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		ev := native.(map[string]interface{})
+		expect := map[string]interface{}{
+			"seq": 1, "time": now.Add(time.Millisecond * 10), "device": "boiler 1", "unit": 2,
+			"register": "holding", "address": 201, "type": "int16BigEndian",
+			"name": "supplyTemp", "engineeringUnit": "", "old": 21.5, "new": 22,
+		}
+		if !isEqual(expect, ev) {
+			t.Errorf("expected %v, got %v", expect, ev)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("expected the change published")
+	}
+}
+
+func TestNewKafkaPublisherInvalid(t *testing.T) {
+	for _, v := range []struct {
+		brokers string
+		topic   string
+	}{
+		{"localhost", "changes"},
+		{"localhost:9092,", "changes"},
+		{":9092", "changes"},
+		{"localhost:9092", ""},
+		{"localhost:9092", "modbus changes"},
+		{"localhost:9092", ".."},
+	} {
+		if _, err := newKafkaPublisher(v.brokers, v.topic); err == nil {
+			t.Errorf("expected error for %v %v, got nil", v.brokers, v.topic)
+		}
+	}
+}
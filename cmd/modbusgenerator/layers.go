@@ -0,0 +1,182 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// loadLayers will load the config files of each of the layers given,
+// where a layer is a directory or glob pattern like for configDir, and
+// return a register file for each register type and unit ID found in the
+// layers. The register file holds the files of the register type and unit
+// in the order of the layers, so the later layers override the earlier.
+func loadLayers(layers []string) ([]registerFile, error) {
+	type key struct {
+		rt   registerType
+		unit uint8
+	}
+	byKey := make(map[key]*registerFile)
+	// lastLayer holds the index of the last layer added to each of the
+	// register files, so the files of the same layer are kept together.
+	lastLayer := make(map[key]int)
+
+	for li, layer := range layers {
+		files, err := loadConfigDir(layer)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			k := key{rt: f.registerType, unit: f.unit}
+			rf, ok := byKey[k]
+			if !ok {
+				rf = &registerFile{registerType: f.registerType, unit: f.unit}
+				byKey[k] = rf
+			}
+			if !ok || lastLayer[k] != li {
+				rf.layers = append(rf.layers, []string{})
+				lastLayer[k] = li
+			}
+			n := len(rf.layers) - 1
+			rf.layers[n] = append(rf.layers[n], f.filename)
+		}
+	}
+
+	var files []registerFile
+	for _, rf := range byKey {
+		var names []string
+		for _, l := range rf.layers {
+			names = append(names, strings.Join(l, ", "))
+		}
+		rf.filename = strings.Join(names, " + ")
+		files = append(files, *rf)
+	}
+	order := map[registerType]int{coilType: 0, discreteType: 1, inputType: 2, holdingType: 3}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].unit != files[j].unit {
+			return files[i].unit < files[j].unit
+		}
+		return order[files[i].registerType] < order[files[j].registerType]
+	})
+
+	return files, nil
+}
+
+// loadLayeredFile will load the files of each layer of the register file,
+// and return the entries of the layers merged, where an entry of a later
+// layer overrides the entry of an earlier layer with the same name, or
+// else the same address, by replacing the fields given. An entry matching
+// no earlier entry is added. The fill directives and consistency groups of
+// all the layers are used. The entries that are not valid are left out,
+// with an error for each of them.
+func loadLayeredFile(rf registerFile) ([]configEntry, []fill, []group, []error, error) {
+	var merged []map[string]interface{}
+	var fills []fill
+	var groups []group
+	var errs []error
+
+	for _, layer := range rf.layers {
+		var added []map[string]interface{}
+		for _, filename := range layer {
+			raw, fl, gr, err := readRegisterFile(filename)
+			if err != nil {
+				return nil, nil, nil, nil, err
+			}
+			fills = append(fills, fl...)
+			groups = append(groups, gr...)
+
+			for i, obj := range raw {
+				base := findOverride(merged, obj)
+				if base == nil {
+					if _, ok := obj["type"].(string); !ok {
+						errs = append(errs, withFile(filename, errorAt(obj, i, "no earlier entry with the same name or address to override, and no type given")))
+						continue
+					}
+					added = append(added, obj)
+					continue
+				}
+				for k, v := range obj {
+					base[k] = v
+				}
+			}
+		}
+		merged = append(merged, added...)
+	}
+
+	// The registers are set in the order of the addresses.
+	sort.SliceStable(merged, func(i, j int) bool {
+		a, _ := merged[i]["regAddr"].(float64)
+		b, _ := merged[j]["regAddr"].(float64)
+		return a < b
+	})
+
+	entries, entryErrs := parseEntries(merged)
+	for _, err := range entryErrs {
+		errs = append(errs, withFile(rf.filename, err))
+	}
+
+	return entries, fills, groups, errs, nil
+}
+
+// findOverride will return the entry of the entries with the same name as
+// the entry given, or else with the same address, or nil if there is no
+// such entry.
+func findOverride(entries []map[string]interface{}, obj map[string]interface{}) map[string]interface{} {
+	if name, ok := obj["name"].(string); ok && name != "" {
+		for _, e := range entries {
+			if e["name"] == name {
+				return e
+			}
+		}
+	}
+	if addr, ok := obj["regAddr"].(float64); ok {
+		for _, e := range entries {
+			if e["regAddr"] == addr {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// load will load the entries, fill directives and consistency groups of
+// the register file, from its layers when given. The entries that are not
+// valid, or with a type that can not be used for the register type of the
+// file, are left out, with an error for each of them, while an error
+// returned alone means the file could not be loaded at all.
+func (rf registerFile) load() ([]configEntry, []fill, []group, []error, error) {
+	var entries []configEntry
+	var fills []fill
+	var groups []group
+	var errs []error
+
+	if len(rf.layers) > 0 {
+		var err error
+		entries, fills, groups, errs, err = loadLayeredFile(rf)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+	} else {
+		var raw []map[string]interface{}
+		var err error
+		raw, fills, groups, err = readRegisterFile(rf.filename)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		entries, errs = parseEntries(raw)
+		for i, err := range errs {
+			errs[i] = withFile(rf.filename, err)
+		}
+	}
+
+	var valid []configEntry
+	for _, e := range entries {
+		err := checkEntryType(rf.registerType, e)
+		if err != nil {
+			errs = append(errs, withFile(rf.filename, err))
+			continue
+		}
+		valid = append(valid, e)
+	}
+
+	return valid, fills, groups, errs, nil
+}
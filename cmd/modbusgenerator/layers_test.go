@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestLoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	for _, l := range []string{"base", "site", "test"} {
+		os.Mkdir(filepath.Join(dir, l), 0755)
+	}
+	os.WriteFile(filepath.Join(dir, "base", "holding.json"), []byte(`[
+		{"registerType": "holding"},
+		{"type": "int16BigEndian", "number": 20, "regAddr": 3, "name": "setpoint"},
+		{"type": "int16BigEndian", "number": 1, "regAddr": 5}]`), 0644)
+	os.WriteFile(filepath.Join(dir, "base", "coil.json"), []byte(`[
+		{"registerType": "coil"},
+		{"type": "wordInt16BigEndian", "number": 1, "regAddr": 3}]`), 0644)
+	os.WriteFile(filepath.Join(dir, "site", "holding.json"), []byte(`[
+		{"registerType": "holding"},
+		{"name": "setpoint", "number": 25}]`), 0644)
+	os.WriteFile(filepath.Join(dir, "test", "holding.json"), []byte(`[
+		{"registerType": "holding"},
+		{"type": "int16BigEndian", "number": 3, "regAddr": 7},
+		{"regAddr": 5, "number": 9}]`), 0644)
+
+	files, err := loadLayers([]string{
+		filepath.Join(dir, "base"),
+		filepath.Join(dir, "site"),
+		filepath.Join(dir, "test"),
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(files) != 2 || files[0].registerType != coilType || files[1].registerType != holdingType {
+		t.Fatalf("expected a coil and a holding register file, got %v", files)
+	}
+	if len(files[1].layers) != 3 {
+		t.Fatalf("expected 3 layers, got %v", files[1].layers)
+	}
+
+	serv := mbserver.NewServer()
+	p, configErrors := loadProfile(serv, files, "", -1, false, false)
+	if configErrors != 0 {
+		t.Fatalf("expected no errors, got %v", configErrors)
+	}
+
+	expect := []uint16{25, 0, 9, 0, 3}
+	got := serv.HoldingRegisters[2:7]
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	if len(p.entries[holdingType]) != 3 {
+		t.Errorf("expected 3 entries, got %v", len(p.entries[holdingType]))
+	}
+}
+
+func TestLoadLayersUnknownOverride(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "base"), 0755)
+	os.Mkdir(filepath.Join(dir, "site"), 0755)
+	os.WriteFile(filepath.Join(dir, "base", "holding.json"), []byte(`[
+		{"registerType": "holding"},
+		{"type": "int16BigEndian", "number": 20, "regAddr": 3, "name": "setpoint"}]`), 0644)
+	os.WriteFile(filepath.Join(dir, "site", "holding.json"), []byte(`[
+		{"registerType": "holding"},
+		{"name": "setpont", "number": 25}]`), 0644)
+
+	files, err := loadLayers([]string{filepath.Join(dir, "base"), filepath.Join(dir, "site")})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	_, _, _, errs, err := files[0].load()
+	if err != nil || len(errs) != 1 {
+		t.Errorf("expected an error for an override matching no entry")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// link is an input register entry holding a process value that follows
+// the setpoint written by clients to a holding register entry, like the
+// feedback of a control loop.
+type link struct {
+	setpoint configEntry
+	feedback configEntry
+	// rampRate is the max change of the process value per second.
+	// 0 means no ramp.
+	rampRate float64
+	// lag is the time constant of a first order lag the process value
+	// follows the setpoint with. 0 means no lag.
+	lag time.Duration
+}
+
+// parseLinks will return a link for each of the input register entries
+// that have a "setpoint" field, which is the address of the holding
+// register entry holding the setpoint. The process value follows the
+// setpoint with the "rampRate" given in units per second, or with the
+// first order "lag" given as a duration like "5s". Without any of them
+// the process value follows the setpoint immediately.
+func parseLinks(input []configEntry, holding []configEntry) ([]link, error) {
+	setpoints := make(map[int]configEntry)
+	for _, v := range holding {
+		setpoints[v.enc.Address()] = v
+	}
+
+	var links []link
+	for _, v := range input {
+		sp, ok := v.raw["setpoint"]
+		if !ok {
+			continue
+		}
+
+		addr, ok := sp.(float64)
+		if !ok {
+			return nil, v.errorf("setpoint must be the address of a holding register entry, got %v", sp)
+		}
+		setpoint, ok := setpoints[int(addr)]
+		if !ok {
+			return nil, v.errorf("no holding register entry found at setpoint address %v", addr)
+		}
+
+		l := link{setpoint: setpoint, feedback: v}
+
+		if r, ok := v.raw["rampRate"]; ok {
+			l.rampRate, ok = r.(float64)
+			if !ok || l.rampRate < 0 {
+				return nil, v.errorf("rampRate must be a positive number, got %v", r)
+			}
+		}
+		if lag, ok := v.raw["lag"]; ok {
+			s, ok := lag.(string)
+			if !ok {
+				return nil, v.errorf("lag must be a duration like 5s, got %v", lag)
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil || d < 0 {
+				return nil, v.errorf("lag must be a duration like 5s, got %v", lag)
+			}
+			l.lag = d
+		}
+
+		links = append(links, l)
+	}
+
+	return links, nil
+}
+
+// startLinks will start updating the process values of the links given
+// toward their setpoints at every interval of the simulation clock.
+func startLinks(serv *mbserver.Server, links []link, addrOffset int, interval time.Duration, clk *simClock) {
+	if len(links) == 0 {
+		return
+	}
+
+	// values holds the current process value of each link, so it is
+	// not rounded to the precision of the register between each step.
+	values := make([]float64, len(links))
+	for i, l := range links {
+		values[i] = l.feedback.enc.Decode(l.feedback.enc.Encode())
+	}
+
+	go runSteps(serv, clk, interval, func(dt time.Duration) {
+		for i, l := range links {
+			values[i] = updateLink(serv, l, values[i], addrOffset, dt)
+		}
+	})
+}
+
+// updateLink will move the process value of the link one step of dt
+// toward the setpoint, write it to the input register, and return the
+// new process value. The server must be locked by the caller.
+func updateLink(serv *mbserver.Server, l link, pv float64, addrOffset int, dt time.Duration) float64 {
+	spAddr := l.setpoint.enc.Address() + addrOffset
+	spSize := len(l.setpoint.enc.Encode())
+	pvAddr := l.feedback.enc.Address() + addrOffset
+	if spAddr+spSize > len(serv.HoldingRegisters) || pvAddr >= len(serv.InputRegisters) {
+		return pv
+	}
+
+	sp := l.setpoint.enc.Decode(serv.HoldingRegisters[spAddr : spAddr+spSize])
+	if math.IsNaN(sp) || math.IsInf(sp, 0) {
+		return pv
+	}
+
+	next := stepToward(pv, sp, l.rampRate, l.lag, dt)
+	writeValue(serv.InputRegisters, pvAddr, l.feedback, next)
+
+	return next
+}
+
+// stepToward will return the value after moving pv toward sp for the
+// duration dt, first with the first order lag, and then limited by the
+// ramp rate.
+func stepToward(pv float64, sp float64, rampRate float64, lag time.Duration, dt time.Duration) float64 {
+	next := sp
+	if lag > 0 {
+		next = pv + (sp-pv)*(1-math.Exp(-dt.Seconds()/lag.Seconds()))
+	}
+
+	if rampRate > 0 {
+		max := rampRate * dt.Seconds()
+		next = pv + math.Max(-max, math.Min(next-pv, max))
+	}
+
+	return next
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestStepToward(t *testing.T) {
+	tests := []struct {
+		rampRate float64
+		lag      time.Duration
+		expect   float64
+	}{
+		{0, 0, 10},
+		{2, 0, 2},
+		{100, 0, 10},
+	}
+
+	for _, tt := range tests {
+		v := stepToward(0, 10, tt.rampRate, tt.lag, time.Second)
+		if !isEqual(v, tt.expect) {
+			t.Errorf("rampRate %v lag %v: expected %v, got %v", tt.rampRate, tt.lag, tt.expect, v)
+		}
+	}
+
+	// With a lag the value should get closer, but not reach the setpoint.
+	v := stepToward(0, 10, 0, time.Second*5, time.Second)
+	if v <= 0 || v >= 10 {
+		t.Errorf("expected value between 0 and 10, got %v", v)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	holding := []map[string]interface{}{
+		{"type": "float32BigWordBigEndian", "number": 30.0, "regAddr": 201.0},
+	}
+	input := []map[string]interface{}{
+		{"type": "float32BigWordBigEndian", "number": 20.0, "regAddr": 101.0, "setpoint": 201.0, "rampRate": 5.0},
+	}
+	var holdingEntries, inputEntries []configEntry
+	for _, v := range holding {
+		holdingEntries = append(holdingEntries, configEntry{enc: NewEncoder(v), raw: v})
+	}
+	for _, v := range input {
+		inputEntries = append(inputEntries, configEntry{enc: NewEncoder(v), raw: v})
+	}
+
+	links, err := parseLinks(inputEntries, holdingEntries)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(links) != 1 {
+		t.Fatalf("expected %v links, got %v", 1, len(links))
+	}
+
+	serv := mbserver.NewServer()
+	setRegister(serv, []encoder{holdingEntries[0].enc}, "holding", -1)
+	setRegister(serv, []encoder{inputEntries[0].enc}, "input", -1)
+
+	pv := updateLink(serv, links[0], 20, -1, time.Second)
+	got := inputEntries[0].enc.Decode(serv.InputRegisters[100:102])
+	if pv != 25 || got != 25 {
+		t.Errorf("expected %v, got %v and %v", 25, pv, got)
+	}
+
+	_, err = parseLinks(inputEntries, nil)
+	if err == nil {
+		t.Errorf("expected error for missing setpoint entry, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// listenAddresses will return the addresses to listen on for the comma
+// separated list of addresses given, like "192.0.2.2:5502,[fd00::2]:5502".
+// The host of an address can be the name of a network interface, like
+// "eth0:5502", which listens on all the IPv4 and IPv6 addresses of the
+// interface.
+func listenAddresses(s string) ([]string, error) {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		addr = strings.TrimSpace(addr)
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		iface, err := net.InterfaceByName(host)
+		if host == "" || net.ParseIP(host) != nil || err != nil {
+			addrs = append(addrs, addr)
+			continue
+		}
+		ips, err := interfaceIPs(iface)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", addr, err)
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+	}
+
+	return addrs, nil
+}
+
+// listenHosts will return the host of each of the addresses to listen on
+// given with listenAddresses, keeping the interface names.
+func listenHosts(s string) ([]string, error) {
+	var hosts []string
+	for _, addr := range strings.Split(s, ",") {
+		host, _, err := net.SplitHostPort(strings.TrimSpace(addr))
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+
+	return hosts, nil
+}
+
+// interfaceIPs will return the IP addresses of the network interface
+// given, where the IPv6 link-local addresses are given with the zone of
+// the interface so they can be listened on.
+func interfaceIPs(iface *net.Interface) ([]string, error) {
+	ifaceAddrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []string
+	for _, a := range ifaceAddrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP.String()
+		if ipNet.IP.To4() == nil && ipNet.IP.IsLinkLocalUnicast() {
+			ip += "%" + iface.Name
+		}
+		ips = append(ips, ip)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("interface %v has no addresses", iface.Name)
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenAddresses(t *testing.T) {
+	addrs, err := listenAddresses("127.0.0.1:5502, [::1]:5502,:5503")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := []string{"127.0.0.1:5502", "[::1]:5502", ":5503"}
+	if !isEqual(expect, addrs) {
+		t.Errorf("expected %v, got %v", expect, addrs)
+	}
+
+	_, err = listenAddresses("127.0.0.1:5502,5503")
+	if err == nil {
+		t.Errorf("expected error for an address without a host, got %v", err)
+	}
+}
+
+func TestListenAddressesInterface(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	var lo *net.Interface
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagLoopback != 0 {
+			lo = &ifaces[i]
+		}
+	}
+	if lo == nil {
+		t.Skip("no loopback interface")
+	}
+
+	addrs, err := listenAddresses(lo.Name + ":5502")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	found := false
+	for _, addr := range addrs {
+		if addr == "127.0.0.1:5502" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected 127.0.0.1:5502 for interface %v, got %v", lo.Name, addrs)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// readFunctions is the function codes of the requests reading the
+// registers.
+var readFunctions = []uint8{1, 2, 3, 4}
+
+// readRange will return the register type and the range of the addresses
+// read by the read request given, and false if the request is not a read
+// request.
+func readRange(fc uint8, data []byte) (registerType, int, int, bool) {
+	if fc < 1 || fc > 4 || len(data) < 4 {
+		return "", 0, 0, false
+	}
+
+	rt := []registerType{coilType, discreteType, holdingType, inputType}[fc-1]
+	return rt, int(binary.BigEndian.Uint16(data[0:2])), int(binary.BigEndian.Uint16(data[2:4])), true
+}
+
+// watchReads will wrap the read functions of the server, so fn is called
+// with the register type and the range of the addresses read after every
+// successful read request, with the server locked.
+func watchReads(serv *mbserver.Server, fn func(rt registerType, start int, count int)) {
+	for _, fc := range readFunctions {
+		function := serv.FunctionHandler(fc)
+		if function == nil {
+			continue
+		}
+
+		fc := fc
+		serv.RegisterFunctionHandler(fc, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+			res, exception := function(s, frame)
+			if exception == &mbserver.Success {
+				if rt, start, count, ok := readRange(fc, frame.GetData()); ok {
+					fn(rt, start, count)
+				}
+			}
+			return res, exception
+		})
+	}
+}
+
+// accessLock holds the entries of a profile the clients can only access
+// after writing the key sequence to the key entry, like the write
+// protected parameter areas of a PLC, or the blocks of a device only read
+// after a password is written.
+type accessLock struct {
+	// locked holds the entries locked by register type.
+	locked map[registerType][]configEntry
+	// key is the holding register entry the values of sequence must be
+	// written to in order to unlock.
+	key      configEntry
+	sequence []float64
+}
+
+// parseWriteLock will return the lock of the coil and holding register
+// entries with the "locked" field set to true, which the clients can only
+// write after writing the values of the "unlock" field of a holding
+// register entry to it in order. It returns nil when no entries are
+// locked.
+func parseWriteLock(entries map[registerType][]configEntry) (*accessLock, error) {
+	return parseAccessLock(entries, "locked", "unlock", coilType, holdingType)
+}
+
+// parseReadLock will return the lock of the entries with the "protected"
+// field set to true, which the clients can only read after writing the
+// values of the "password" field of a holding register entry to it in
+// order. It returns nil when no entries are protected.
+func parseReadLock(entries map[registerType][]configEntry) (*accessLock, error) {
+	return parseAccessLock(entries, "protected", "password", historyRegisterTypes...)
+}
+
+// parseAccessLock will return the lock of the entries of the register
+// types given with the locked field given set to true, where the key
+// field given of a holding register entry holds the key sequence. It
+// returns nil when no entries are locked.
+func parseAccessLock(entries map[registerType][]configEntry, lockedField string, keyField string, lockable ...registerType) (*accessLock, error) {
+	al := &accessLock{locked: make(map[registerType][]configEntry)}
+	hasKey := false
+
+	for _, rt := range historyRegisterTypes {
+		for _, v := range entries[rt] {
+			if l, ok := v.raw[lockedField]; ok {
+				locked, ok := l.(bool)
+				if !ok {
+					return nil, v.errorf("%v must be true or false, got %v", lockedField, l)
+				}
+				if locked && !isRegisterType(rt, lockable) {
+					return nil, v.errorf("%v is not supported for the %v registers", lockedField, rt)
+				}
+				if locked {
+					al.locked[rt] = append(al.locked[rt], v)
+				}
+			}
+
+			k, ok := v.raw[keyField]
+			if !ok {
+				continue
+			}
+			if rt != holdingType {
+				return nil, v.errorf("%v is only supported for holding registers, got %v", keyField, rt)
+			}
+			if hasKey {
+				return nil, v.errorf("%v is already given for the holding register entry at %v", keyField, al.key.enc.Address())
+			}
+			seq, ok := k.([]interface{})
+			if !ok || len(seq) == 0 {
+				return nil, v.errorf("%v must be a list of the values to write, got %v", keyField, k)
+			}
+			for _, s := range seq {
+				n, ok := s.(float64)
+				if !ok {
+					return nil, v.errorf("%v must be a list of the values to write, got %v", keyField, k)
+				}
+				al.sequence = append(al.sequence, n)
+			}
+			if l, _ := v.raw[lockedField].(bool); l {
+				return nil, v.errorf("the %v entry can not be %v", keyField, lockedField)
+			}
+			al.key = v
+			hasKey = true
+		}
+	}
+
+	if len(al.locked) == 0 {
+		if hasKey {
+			return nil, al.key.errorf("%v is given, but no entries are %v", keyField, lockedField)
+		}
+		return nil, nil
+	}
+	if !hasKey {
+		return nil, fmt.Errorf("entries are %v, but no holding register entry has the %v", lockedField, keyField)
+	}
+
+	return al, nil
+}
+
+// isRegisterType will return true if the register type is one of the
+// register types given.
+func isRegisterType(rt registerType, types []registerType) bool {
+	for _, t := range types {
+		if t == rt {
+			return true
+		}
+	}
+	return false
+}
+
+// lockState is the state of an access lock of a server.
+type lockState struct {
+	*accessLock
+	// next is the index of the value of the key sequence expected next.
+	next     int
+	unlocked bool
+	// unlockedAt is the simulated time the entries were unlocked.
+	unlockedAt time.Time
+	timeout    time.Duration
+	clk        *simClock
+	// name identifies the lock of the server in the log.
+	name string
+}
+
+// isLocked will return true if the request for the range of the addresses
+// given touches any of the locked entries while locked. The entries are
+// locked again when the timeout has passed since unlocked.
+func (st *lockState) isLocked(rt registerType, start int, count int, addrOffset int) bool {
+	if st.unlocked && st.timeout > 0 && st.clk.Now().Sub(st.unlockedAt) >= st.timeout {
+		st.unlocked = false
+		log.Printf("info: %v: locked again after %v\n", st.name, st.timeout)
+	}
+	if st.unlocked {
+		return false
+	}
+
+	for _, e := range st.locked[rt] {
+		if entryInRange(rt, e, start, count, addrOffset) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchKey will wrap the write functions of the server, so the values
+// written to the key entry move the key sequence on.
+func (st *lockState) watchKey(serv *mbserver.Server, addrOffset int) {
+	watchWrites(serv, func(rt registerType, start int, count int) {
+		if rt == holdingType && entryInRange(rt, st.key, start, count, addrOffset) {
+			v, _ := entryValue(serv, rt, st.key, addrOffset)
+			st.advance(v)
+		}
+	})
+}
+
+// advance will move the key sequence on with the value written to the key
+// entry, unlocking the entries when the sequence is complete, and locking
+// them again when the value does not continue the sequence.
+func (st *lockState) advance(v float64) {
+	if v != st.sequence[st.next] {
+		if st.unlocked {
+			log.Printf("info: %v: locked again\n", st.name)
+		}
+		st.unlocked = false
+		st.next = 0
+		if v != st.sequence[0] {
+			return
+		}
+	}
+
+	st.next++
+	if st.next == len(st.sequence) {
+		st.next = 0
+		st.unlocked = true
+		st.unlockedAt = st.clk.Now()
+		log.Printf("info: %v: unlocked\n", st.name)
+	}
+}
+
+// startWriteLock will wrap the write functions of the server, so the
+// writes to the locked entries are answered with Illegal Data Address and
+// nothing is written, until the unlock sequence is written to the unlock
+// entry. A write to the unlock entry that does not continue the sequence
+// locks the entries again, and with a timeout the entries are locked again
+// the timeout of simulated time after being unlocked. The name given
+// identifies the server in the log.
+func startWriteLock(serv *mbserver.Server, al *accessLock, addrOffset int, timeout time.Duration, clk *simClock, name string) {
+	if al == nil {
+		return
+	}
+	st := &lockState{accessLock: al, timeout: timeout, clk: clk, name: "write lock: " + name}
+	st.watchKey(serv, addrOffset)
+
+	for _, fc := range writeFunctions {
+		function := serv.FunctionHandler(fc)
+		if function == nil {
+			continue
+		}
+
+		fc := fc
+		serv.RegisterFunctionHandler(fc, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+			rt, start, count, ok := writtenRange(fc, frame.GetData())
+			if ok && st.isLocked(rt, start, count, addrOffset) {
+				return []byte{}, &mbserver.IllegalDataAddress
+			}
+			return function(s, frame)
+		})
+	}
+}
+
+// startReadLock will wrap the read functions of the server, so the reads
+// of the protected entries are answered with Illegal Data Address, until
+// the password is written to the password entry. A wrong password locks
+// the entries again, and with a timeout the entries are locked again the
+// timeout of simulated time after being unlocked. The name given
+// identifies the server in the log.
+func startReadLock(serv *mbserver.Server, al *accessLock, addrOffset int, timeout time.Duration, clk *simClock, name string) {
+	if al == nil {
+		return
+	}
+	st := &lockState{accessLock: al, timeout: timeout, clk: clk, name: "read lock: " + name}
+	st.watchKey(serv, addrOffset)
+
+	for _, fc := range readFunctions {
+		function := serv.FunctionHandler(fc)
+		if function == nil {
+			continue
+		}
+
+		fc := fc
+		serv.RegisterFunctionHandler(fc, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+			rt, start, count, ok := readRange(fc, frame.GetData())
+			if ok && st.isLocked(rt, start, count, addrOffset) {
+				return []byte{}, &mbserver.IllegalDataAddress
+			}
+			return function(s, frame)
+		})
+	}
+}
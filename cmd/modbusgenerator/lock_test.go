@@ -0,0 +1,172 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestWriteLock(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		coilType: testEntries([]map[string]interface{}{
+			{"type": "bit", "number": 0.0, "regAddr": 1.0, "locked": true},
+		}),
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "uint16", "number": 0.0, "regAddr": 1.0, "unlock": []interface{}{21845.0, 43690.0}},
+			{"type": "uint16", "number": 0.0, "regAddr": 2.0},
+			{"type": "float32_abcd", "number": 0.0, "regAddr": 3.0, "locked": true},
+		}),
+	}
+	wl, err := parseWriteLock(entries)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	serv.HoldingRegisters = make([]uint16, 10)
+	clk := newSimClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+	startWriteLock(serv, wl, -1, time.Minute, clk, "test")
+
+	err = serv.ListenRTUTCP("127.0.0.1:3408")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v", err)
+	}
+	defer serv.Close()
+	c, err := newClient("127.0.0.1:3408", 1, time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v", err)
+	}
+	defer c.Close()
+
+	// locked will return true if the write was answered with Illegal
+	// Data Address.
+	locked := func(rt registerType, addr int, values []uint16) bool {
+		err := c.write(rt, addr, values)
+		e, ok := err.(exceptionError)
+		return ok && e.exception == mbserver.IllegalDataAddress
+	}
+
+	if !locked(holdingType, 3, []uint16{1}) || !locked(coilType, 0, []uint16{1}) {
+		t.Errorf("expected the writes of the locked entries rejected")
+	}
+	if locked(holdingType, 1, []uint16{7}) {
+		t.Errorf("expected the write of an entry not locked to succeed")
+	}
+
+	// A wrong value restarts the sequence.
+	c.write(holdingType, 0, []uint16{21845})
+	c.write(holdingType, 0, []uint16{1})
+	c.write(holdingType, 0, []uint16{43690})
+	if !locked(holdingType, 2, []uint16{1, 2}) {
+		t.Errorf("expected the write rejected after a wrong sequence")
+	}
+
+	c.write(holdingType, 0, []uint16{21845})
+	c.write(holdingType, 0, []uint16{43690})
+	if locked(holdingType, 2, []uint16{1, 2}) || locked(coilType, 0, []uint16{1}) {
+		t.Errorf("expected the writes to succeed after the unlock sequence")
+	}
+	if !isEqual([]uint16{1, 2}, serv.HoldingRegisters[2:4]) {
+		t.Errorf("expected %v, got %v", []uint16{1, 2}, serv.HoldingRegisters[2:4])
+	}
+
+	// The entries are locked again after the timeout.
+	clk.Step(time.Minute)
+	if !locked(holdingType, 2, []uint16{1, 2}) {
+		t.Errorf("expected the write rejected after the timeout")
+	}
+
+	// Writing another value to the unlock entry locks again.
+	c.write(holdingType, 0, []uint16{21845})
+	c.write(holdingType, 0, []uint16{43690})
+	c.write(holdingType, 0, []uint16{0})
+	if !locked(holdingType, 2, []uint16{1, 2}) {
+		t.Errorf("expected the write rejected after locking")
+	}
+}
+
+func TestParseWriteLockInvalid(t *testing.T) {
+	unlock := map[string]interface{}{"type": "uint16", "number": 0.0, "regAddr": 1.0, "unlock": []interface{}{1.0}}
+	locked := map[string]interface{}{"type": "uint16", "number": 0.0, "regAddr": 2.0, "locked": true}
+
+	for _, entries := range []map[registerType][]configEntry{
+		{holdingType: testEntries([]map[string]interface{}{locked})},
+		{holdingType: testEntries([]map[string]interface{}{unlock})},
+		{holdingType: testEntries([]map[string]interface{}{unlock, {"type": "uint16", "number": 0.0, "regAddr": 2.0, "locked": "yes"}})},
+		{holdingType: testEntries([]map[string]interface{}{unlock, locked}), inputType: testEntries([]map[string]interface{}{locked})},
+		{holdingType: testEntries([]map[string]interface{}{locked, {"type": "uint16", "number": 0.0, "regAddr": 1.0, "unlock": 1.0}})},
+		{holdingType: testEntries([]map[string]interface{}{locked, {"type": "uint16", "number": 0.0, "regAddr": 1.0, "unlock": []interface{}{1.0}, "locked": true}})},
+		{holdingType: testEntries([]map[string]interface{}{unlock, locked, unlock})},
+	} {
+		if _, err := parseWriteLock(entries); err == nil {
+			t.Errorf("expected error for %v, got nil", entries)
+		}
+	}
+
+	wl, err := parseWriteLock(map[registerType][]configEntry{holdingType: testEntries([]map[string]interface{}{{"type": "uint16", "number": 0.0, "regAddr": 1.0}})})
+	if wl != nil || err != nil {
+		t.Errorf("expected no lock, got %v and %v", wl, err)
+	}
+}
+
+func TestReadLock(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "uint16", "number": 0.0, "regAddr": 1.0, "password": []interface{}{1234.0}},
+		}),
+		inputType: testEntries([]map[string]interface{}{
+			{"type": "uint16", "number": 0.0, "regAddr": 1.0},
+			{"type": "uint16", "number": 0.0, "regAddr": 2.0, "protected": true},
+		}),
+	}
+	al, err := parseReadLock(entries)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	serv.InputRegisters = make([]uint16, 10)
+	serv.HoldingRegisters = make([]uint16, 10)
+	clk := newSimClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+	startReadLock(serv, al, -1, time.Minute, clk, "test")
+
+	err = serv.ListenRTUTCP("127.0.0.1:3409")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v", err)
+	}
+	defer serv.Close()
+	c, err := newClient("127.0.0.1:3409", 1, time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v", err)
+	}
+	defer c.Close()
+
+	// locked will return true if the read was answered with Illegal Data
+	// Address.
+	locked := func(addr int, count int) bool {
+		_, err := c.read(inputType, addr, count)
+		e, ok := err.(exceptionError)
+		return ok && e.exception == mbserver.IllegalDataAddress
+	}
+
+	if !locked(0, 2) || locked(0, 1) {
+		t.Errorf("expected only the reads of the protected entry rejected")
+	}
+
+	// A wrong password keeps the entries protected.
+	c.write(holdingType, 0, []uint16{4321})
+	if !locked(1, 1) {
+		t.Errorf("expected the read rejected after a wrong password")
+	}
+
+	c.write(holdingType, 0, []uint16{1234})
+	if locked(1, 1) {
+		t.Errorf("expected the read to succeed after the password")
+	}
+
+	clk.Step(time.Minute)
+	if !locked(1, 1) {
+		t.Errorf("expected the read rejected after the timeout")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func isEqual(a interface{}, b interface{}) bool {
+	expect, _ := json.Marshal(a)
+	got, _ := json.Marshal(b)
+	if string(expect) != string(got) {
+		return false
+	}
+	return true
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		enc    encoder
+		expect []uint16
+		number float64
+	}{
+		{float32LittleWordBigEndian{Number: 3.1415}, []uint16{0x0e56, 0x4049}, float64(float32(3.1415))},
+		{float32BigWordBigEndian{Number: 3.1415}, []uint16{0x4049, 0x0e56}, float64(float32(3.1415))},
+		{float32LittleWordLittleEndian{Number: 3.1415}, []uint16{0x560e, 0x4940}, float64(float32(3.1415))},
+		{float32BigWordLittleEndian{Number: 3.1415}, []uint16{0x4940, 0x560e}, float64(float32(3.1415))},
+		{wordInt16BigEndian{Number: 1}, []uint16{0x0101}, 1},
+		{wordInt16LittleEndian{Number: 1}, []uint16{0x0100}, 1},
+		{int16BigEndian{Number: -2}, []uint16{0xfffe}, -2},
+		{int16LittleEndian{Number: -2}, []uint16{0xfeff}, -2},
+		{uint16BigEndian{Number: 65534}, []uint16{0xfffe}, 65534},
+	}
+
+	for _, tt := range tests {
+		got := tt.enc.Encode()
+		if !isEqual(tt.expect, got) {
+			t.Errorf("%T: expected %v, got %v", tt.enc, tt.expect, got)
+		}
+
+		gotNumber := tt.enc.Decode(got)
+		if tt.number != gotNumber {
+			t.Errorf("%T: expected %v, got %v", tt.enc, tt.number, gotNumber)
+		}
+	}
+}
+
+func TestTypeAliases(t *testing.T) {
+	for _, v := range typeAliases {
+		m := map[string]interface{}{"type": v.alias, "number": 3.1415, "regAddr": 1.0}
+		alias := NewEncoder(m)
+
+		m["type"] = v.typeName
+		enc := NewEncoder(m)
+
+		if alias == nil || !isEqual(enc.Encode(), alias.Encode()) {
+			t.Errorf("%v: expected encoding equal to %v", v.alias, v.typeName)
+		}
+	}
+}
+
+func TestValidateSingleWord(t *testing.T) {
+	tests := []struct {
+		typ    string
+		number float64
+		valid  bool
+	}{
+		{"int16", -32768, true},
+		{"int16", 32767, true},
+		{"int16", 32768, false},
+		{"int16", 70000, false},
+		{"int16", 1.5, false},
+		{"uint16", 0, true},
+		{"uint16", 65535, true},
+		{"uint16", -1, false},
+		{"uint16", 70000, false},
+		{"int16_le", 70000, false},
+		{"int16_le", -5, true},
+		{"int16_le", 32768, false},
+		{"uint16_le", -5, false},
+		{"uint16_le", 65535, true},
+		{"wordInt16BigEndian", 256, false},
+		{"float32_abcd", 70000, true},
+	}
+
+	for _, tt := range tests {
+		enc := NewEncoder(map[string]interface{}{"type": tt.typ, "number": tt.number, "regAddr": 1.0})
+		var err error
+		if v, ok := enc.(validator); ok {
+			err = v.validate()
+		}
+		if tt.valid != (err == nil) {
+			t.Errorf("%v %v: expected valid %v, got %v", tt.typ, tt.number, tt.valid, err)
+		}
+	}
+}
+
+func TestSetRegisterEntryWidth(t *testing.T) {
+	newEntry := func(typ string, number float64, addr float64) encoder {
+		return NewEncoder(map[string]interface{}{"type": typ, "number": number, "regAddr": addr})
+	}
+
+	// The single word entries follow each other at the next address,
+	// while a float32 takes two addresses.
+	serv := mbserver.NewServer()
+	data := []encoder{
+		newEntry("int16", 1, 1),
+		newEntry("uint16", 2, 2),
+		newEntry("float32_abcd", 1.5, 3),
+		newEntry("int16", 7, 5),
+	}
+	err := setRegister(serv, data, "input", -1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := []uint16{1, 2, 0x3fc0, 0, 7}
+	if !isEqual(expect, serv.InputRegisters) {
+		t.Errorf("expected %v, got %v", expect, serv.InputRegisters)
+	}
+
+	// An entry within the float32 before it is rejected.
+	data[3] = newEntry("int16", 7, 4)
+	err = setRegister(mbserver.NewServer(), data, "holding", -1)
+	var oe *orderError
+	if !errors.As(err, &oe) || oe.index != 3 {
+		t.Errorf("expected an order error for entry 3, got %v", err)
+	}
+
+	// An entry going past the last address is rejected, also when it
+	// starts at the last address.
+	for _, e := range []encoder{newEntry("float32_abcd", 1.5, 65535), newEntry("uint16", 1, 65536)} {
+		err = setRegister(mbserver.NewServer(), []encoder{newEntry("int16", 1, 1), e}, "holding", 0)
+		var ae *addressError
+		if !errors.As(err, &ae) || ae.index != 1 {
+			t.Errorf("expected an address error for entry 1, got %v", err)
+		}
+	}
+	err = setRegister(mbserver.NewServer(), []encoder{newEntry("uint16", 1, 65535)}, "holding", 0)
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestCheckEntryType(t *testing.T) {
+	tests := []struct {
+		rt     registerType
+		typ    string
+		number float64
+		valid  bool
+	}{
+		{coilType, "wordInt16BigEndian", 1, true},
+		{coilType, "wordInt16BigEndian", 2, false},
+		{coilType, "float32_abcd", 1, false},
+		{coilType, "int16", 1, false},
+		{coilType, "bit", 1, true},
+		{holdingType, "bit", 1, false},
+		{discreteType, "wordInt16LittleEndian", 0, true},
+		{discreteType, "wordInt16LittleEndian", 5, false},
+		{inputType, "float32_abcd", 0.5, true},
+		{inputType, "wordInt16BigEndian", 1, false},
+		{holdingType, "uint16", 1200, true},
+		{holdingType, "int16_le", 7, true},
+	}
+
+	for _, tt := range tests {
+		raw := map[string]interface{}{"type": tt.typ, "number": tt.number, "regAddr": 3.0}
+		err := checkEntryType(tt.rt, configEntry{enc: NewEncoder(raw), raw: raw})
+		if tt.valid != (err == nil) {
+			t.Errorf("%v %v %v: expected valid %v, got %v", tt.rt, tt.typ, tt.number, tt.valid, err)
+		}
+	}
+}
+
+func TestCheckIntervals(t *testing.T) {
+	valid := func() flags {
+		return flags{
+			linkInterval:      time.Millisecond * 100,
+			blockStepInterval: time.Millisecond * 100,
+			groupInterval:     time.Second,
+			journalInterval:   time.Millisecond * 100,
+			historyInterval:   time.Second,
+			dumpInterval:      time.Second * 10,
+			sqliteInterval:    time.Millisecond * 100,
+		}
+	}
+
+	f := valid()
+	err := f.checkIntervals()
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	for _, set := range []func(f *flags){
+		func(f *flags) { f.linkInterval = 0 },
+		func(f *flags) { f.blockStepInterval = -time.Second },
+		func(f *flags) { f.groupInterval = 0 },
+		func(f *flags) { f.journalInterval = 0 },
+		func(f *flags) { f.historyInterval = 0 },
+		func(f *flags) { f.dumpInterval = 0 },
+		func(f *flags) { f.sqliteInterval = 0 },
+	} {
+		f := valid()
+		set(&f)
+		err := f.checkIntervals()
+		if err == nil {
+			t.Errorf("expected an error for %+v, got nil", f)
+		}
+	}
+}
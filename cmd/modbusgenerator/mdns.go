@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/mdns"
+	"github.com/miekg/dns"
+)
+
+// mdnsServiceType is the DNS-SD service type the listeners are advertised
+// with.
+const mdnsServiceType = "_modbus._tcp"
+
+// mdnsService is a listener of a device advertised with mDNS.
+type mdnsService struct {
+	instance string
+	port     int
+	txt      []string
+}
+
+// mdnsServices will return the services to advertise for the listeners
+// of the devices given. A device is advertised with its name from the
+// fleet config, or else with the name given, and a listener on another
+// port than the first one of the device gets the port added to the name,
+// so each instance has its own name.
+func mdnsServices(devices []*device, name string, profileName string, units string) ([]mdnsService, error) {
+	bi := readBuildInfo()
+	used := make(map[string]bool)
+
+	var services []mdnsService
+	for _, d := range devices {
+		addrs, err := listenAddresses(d.address)
+		if err != nil {
+			return nil, err
+		}
+
+		deviceName := name
+		if d.name != "" {
+			deviceName = d.name
+		}
+		txt := []string{"name=" + deviceName, "protocol=rtu-over-tcp", "version=" + bi.version}
+		deviceProfile := profileName
+		if d.profileName != "" {
+			deviceProfile = d.profileName
+		}
+		if deviceProfile != "" {
+			txt = append(txt, "profile="+deviceProfile)
+		}
+		deviceUnits := units
+		if d.units != "" {
+			deviceUnits = d.units
+		}
+		if deviceUnits != "" {
+			txt = append(txt, "units="+deviceUnits)
+		}
+		if d.configHash != "" {
+			txt = append(txt, "config="+d.configHash)
+		}
+		var keys []string
+		for k := range d.labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			txt = append(txt, k+"="+d.labels[k])
+		}
+
+		ports := make(map[int]bool)
+		for _, addr := range addrs {
+			_, portStr, _ := net.SplitHostPort(addr)
+			port, err := strconv.Atoi(portStr)
+			if err != nil || port == 0 || ports[port] {
+				continue
+			}
+			ports[port] = true
+
+			instance := deviceName
+			if used[strings.ToLower(instance)] {
+				instance = fmt.Sprintf("%v (%v)", deviceName, port)
+			}
+			used[strings.ToLower(instance)] = true
+			services = append(services, mdnsService{instance: instance, port: port, txt: txt})
+		}
+	}
+
+	return services, nil
+}
+
+// mdnsZones is the zones of the services advertised, where each zone
+// answers for one service.
+type mdnsZones []mdns.Zone
+
+// Records will return the records of all the zones answering the question
+// given, without the records several zones answer with, like the PTR
+// record of the service type.
+func (z mdnsZones) Records(q dns.Question) []dns.RR {
+	seen := make(map[string]bool)
+	var records []dns.RR
+	for _, zone := range z {
+		for _, rr := range zone.Records(q) {
+			if seen[rr.String()] {
+				continue
+			}
+			seen[rr.String()] = true
+			records = append(records, rr)
+		}
+	}
+	return records
+}
+
+// newMDNSZones will return the zones of the services given, with the host
+// name and the addresses of the host the services are on.
+func newMDNSZones(services []mdnsService, hostname string, ips []net.IP) (mdnsZones, error) {
+	hostname, _, _ = strings.Cut(hostname, ".")
+
+	var zones mdnsZones
+	for _, s := range services {
+		zone, err := mdns.NewMDNSService(escapeLabel(s.instance), mdnsServiceType, "local.", hostname+".local.", s.port, ips, s.txt)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", s.instance, err)
+		}
+		zones = append(zones, zone)
+	}
+	return zones, nil
+}
+
+// escapeLabel will escape the characters of the label given that are
+// escaped in the names of the DNS messages, like the dots and spaces of an
+// instance name, so the instance is one label.
+func escapeLabel(label string) string {
+	var b strings.Builder
+	for _, c := range label {
+		if strings.ContainsRune(`. '@;()"\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// startMDNS will start answering the mDNS queries for the services given
+// on the IPv4 and IPv6 mDNS multicast groups of the host.
+func startMDNS(services []mdnsService) (*mdns.Server, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	ips, err := hostIPs()
+	if err != nil {
+		return nil, err
+	}
+	zones, err := newMDNSZones(services, hostname, ips)
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := mdns.NewServer(&mdns.Config{Zone: zones})
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _, _ = strings.Cut(hostname, ".")
+	for _, s := range services {
+		log.Printf("info: mdns: advertising %q on port %v as %v.local\n", s.instance, s.port, hostname)
+	}
+
+	return server, nil
+}
+
+// hostIPs will return the addresses of the network interfaces that are
+// up and support multicast, or the loopback addresses if there are none.
+func hostIPs() ([]net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips, loopback []net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			switch {
+			case iface.Flags&net.FlagLoopback != 0:
+				loopback = append(loopback, ipNet.IP)
+			case iface.Flags&net.FlagMulticast != 0:
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return loopback, nil
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// testQuestion will return the question for the name and type given, as
+// read from a query received, so the names are escaped as on the wire.
+func testQuestion(t *testing.T, name string, qtype uint16) dns.Question {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := m.Unpack(b); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	return m.Question[0]
+}
+
+func TestMDNSZones(t *testing.T) {
+	services := []mdnsService{
+		{instance: "boiler.1", port: 10502, txt: []string{"name=boiler.1", "profile=boiler"}},
+		{instance: "modbusgenerator on lab-pc", port: 10503},
+	}
+	zones, err := newMDNSZones(services, "lab-pc.example.com", []net.IP{net.ParseIP("192.0.2.2"), net.ParseIP("fd00::2")})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	// The service type is answered with a PTR record for each instance.
+	records := zones.Records(testQuestion(t, "_modbus._tcp.local.", dns.TypePTR))
+	var instances []string
+	for _, rr := range records {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			instances = append(instances, ptr.Ptr)
+		}
+	}
+	expect := []string{`boiler\.1._modbus._tcp.local.`, `modbusgenerator\ on\ lab-pc._modbus._tcp.local.`}
+	if !isEqual(expect, instances) {
+		t.Fatalf("expected %v, got %v", expect, instances)
+	}
+
+	// The instance names are one label on the wire.
+	for i, name := range instances {
+		q := testQuestion(t, name, dns.TypeSRV)
+		labels := dns.SplitDomainName(q.Name)
+		if len(labels) != 4 {
+			t.Errorf("expected 4 labels, got %v", labels)
+		}
+		records := zones.Records(q)
+		if len(records) == 0 {
+			t.Fatalf("expected an answer for %v", name)
+		}
+		srv, ok := records[0].(*dns.SRV)
+		if !ok || srv.Port != uint16(services[i].port) || srv.Target != "lab-pc.local." {
+			t.Errorf("unexpected SRV record %v", records[0])
+		}
+	}
+
+	// The host is answered with its IPv4 and IPv6 addresses.
+	records = zones.Records(testQuestion(t, "lab-pc.local.", dns.TypeAAAA))
+	if len(records) != 1 || !records[0].(*dns.AAAA).AAAA.Equal(net.ParseIP("fd00::2")) {
+		t.Errorf("unexpected AAAA records %v", records)
+	}
+	records = zones.Records(testQuestion(t, "lab-pc.local.", dns.TypeA))
+	if len(records) != 1 || !records[0].(*dns.A).A.Equal(net.ParseIP("192.0.2.2")) {
+		t.Errorf("unexpected A records %v", records)
+	}
+
+	// The service types are listed once.
+	records = zones.Records(testQuestion(t, "_services._dns-sd._udp.local.", dns.TypePTR))
+	if len(records) != 1 {
+		t.Errorf("expected 1 record, got %v", records)
+	}
+
+	// Queries for other services are not answered.
+	records = zones.Records(testQuestion(t, "_http._tcp.local.", dns.TypePTR))
+	if len(records) != 0 {
+		t.Errorf("expected no answer for another service type, got %v", records)
+	}
+}
+
+func TestMDNSServices(t *testing.T) {
+	devices := []*device{
+		{address: "127.0.0.1:10502,[::1]:10502"},
+		{address: "127.0.0.1:10503"},
+		{name: "meter-1", address: ":10504", units: "1,2", profileName: "meter", labels: map[string]string{"site": "north"}},
+	}
+
+	services, err := mdnsServices(devices, "sim", "boiler", "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(services) != 3 {
+		t.Fatalf("expected 3 services, got %v", services)
+	}
+	if services[0].instance != "sim" || services[1].instance != "sim (10503)" || services[2].instance != "meter-1" {
+		t.Errorf("unexpected instance names %v, %v, %v", services[0].instance, services[1].instance, services[2].instance)
+	}
+
+	txt := strings.Join(services[2].txt, " ")
+	for _, expect := range []string{"name=meter-1", "profile=meter", "units=1,2", "site=north"} {
+		if !strings.Contains(txt, expect) {
+			t.Errorf("expected %v in %v", expect, txt)
+		}
+	}
+	if !strings.Contains(strings.Join(services[0].txt, " "), "profile=boiler") {
+		t.Errorf("expected the profile given, got %v", services[0].txt)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// entryMeta is the optional fields of a config entry describing the
+// value of the entry, so the config can be used as a register dictionary.
+type entryMeta struct {
+	Name string
+	// Unit is the engineering unit of the value, e.g. °C.
+	Unit string
+	// Scale is multiplied with the value of the entry to get the value
+	// in the engineering unit, e.g. 0.1 for an int16 holding tenths of
+	// a degree.
+	Scale float64
+	// Offset is added to the scaled value to get the value in the
+	// engineering unit, e.g. -40 for a sensor reporting 0 for -40 °C.
+	Offset      float64
+	Description string
+}
+
+// parseEntryMeta will return the metadata of the raw config entry given.
+// The scale is 1 when not given.
+func parseEntryMeta(raw map[string]interface{}) (entryMeta, error) {
+	m := entryMeta{Scale: 1}
+
+	for _, v := range []struct {
+		field string
+		value *string
+	}{
+		{"name", &m.Name},
+		{"unit", &m.Unit},
+		{"description", &m.Description},
+	} {
+		f, ok := raw[v.field]
+		if !ok {
+			continue
+		}
+		s, ok := f.(string)
+		if !ok {
+			return m, fmt.Errorf("%v must be a string, got %v", v.field, f)
+		}
+		*v.value = s
+	}
+
+	if f, ok := raw["scale"]; ok {
+		scale, ok := f.(float64)
+		if !ok || scale == 0 {
+			return m, fmt.Errorf("scale must be a number other than 0, got %v", f)
+		}
+		m.Scale = scale
+	}
+	if f, ok := raw["offset"]; ok {
+		offset, ok := f.(float64)
+		if !ok {
+			return m, fmt.Errorf("offset must be a number, got %v", f)
+		}
+		m.Offset = offset
+	}
+
+	return m, nil
+}
+
+// scaled will return the value decoded from the registers given in the
+// engineering unit.
+func (m entryMeta) scaled(v float64) float64 {
+	return roundScaled(v*m.Scale + m.Offset)
+}
+
+// unscaled will return the value in the engineering unit given as the
+// value to encode into the registers.
+func (m entryMeta) unscaled(v float64) float64 {
+	return roundScaled((v - m.Offset) / m.Scale)
+}
+
+// roundScaled will round a value converted with the scale to 12
+// significant digits, so 21.7 °C with a scale of 0.1 is 217 and not
+// 216.99999999999997, and 217 is 21.7 °C and not 21.700000000000003.
+func roundScaled(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	r, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'g', 12, 64), 64)
+	return r
+}
+
+// meta will return the metadata of the entry. The metadata is checked
+// when the config is loaded, so errors are ignored.
+func (e configEntry) meta() entryMeta {
+	m, _ := parseEntryMeta(e.raw)
+	return m
+}
+
+// hasMeta will return true if any of the entries given have a name, unit
+// or description.
+func hasMeta(entries []configEntry) bool {
+	for _, e := range entries {
+		m := e.meta()
+		if m.Name != "" || m.Unit != "" || m.Description != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// entryStatus is a config entry with its metadata and current value
+// returned by the entries endpoint.
+type entryStatus struct {
+	Device          string  `json:"device,omitempty"`
+	Unit            int     `json:"unit,omitempty"`
+	Register        string  `json:"register"`
+	Address         int     `json:"address"`
+	Type            string  `json:"type"`
+	Name            string  `json:"name,omitempty"`
+	EngineeringUnit string  `json:"engineeringUnit,omitempty"`
+	Scale           float64 `json:"scale"`
+	Offset          float64 `json:"offset,omitempty"`
+	Description     string  `json:"description,omitempty"`
+	// Value is the value decoded from the registers, and Scaled is the
+	// value times the scale plus the offset. They are left out when not a finite number,
+	// since JSON do not support NaN or infinity.
+	Value  *float64 `json:"value,omitempty"`
+	Scaled *float64 `json:"scaled,omitempty"`
+}
+
+// entryControl answers with the config entries of the devices.
+type entryControl struct {
+	devices    []*device
+	addrOffset int
+}
+
+// handleEntries answers with the config entries of the devices, with
+// their metadata and current value, as a dictionary of the registers.
+// The device query parameter selects the device of a fleet, and the unit
+// query parameter the unit the values are read from, where the values of
+// the server of the device are used if not given.
+func (ec *entryControl) handleEntries(w http.ResponseWriter, r *http.Request) {
+	devices, err := selectDevices(ec.devices, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	unit := 0
+	if s := r.URL.Query().Get("unit"); s != "" {
+		unit, err = strconv.Atoi(s)
+		if err != nil || unit < 0 || unit > 255 {
+			http.Error(w, fmt.Sprintf("unit must be a unit ID, got %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries := []entryStatus{}
+	for _, d := range devices {
+		serv := d.serv
+		if s, ok := d.serv.Units()[uint8(unit)]; ok {
+			serv = s
+		}
+
+		d.serv.Lock()
+		entries = append(entries, entryStatuses(d, serv, unit, ec.addrOffset)...)
+		d.serv.Unlock()
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// entryStatuses will return the entries of the device with their metadata
+// and the current value in the registers of the server given, which is
+// the server of the device or one of its units with the unit ID given.
+// The server must be locked.
+func entryStatuses(d *device, serv *mbserver.Server, unit int, addrOffset int) []entryStatus {
+	var entries []entryStatus
+	for _, rt := range historyRegisterTypes {
+		for _, e := range d.profile.entries[rt] {
+			m := e.meta()
+			es := entryStatus{
+				Device:          d.name,
+				Unit:            unit,
+				Register:        string(rt),
+				Address:         e.enc.Address(),
+				Type:            entryType(e.enc),
+				Name:            m.Name,
+				EngineeringUnit: m.Unit,
+				Scale:           m.Scale,
+				Offset:          m.Offset,
+				Description:     m.Description,
+			}
+			v, ok := entryValue(serv, rt, e, addrOffset)
+			if ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+				scaled := m.scaled(v)
+				es.Value = &v
+				es.Scaled = &scaled
+			}
+			entries = append(entries, es)
+		}
+	}
+
+	return entries
+}
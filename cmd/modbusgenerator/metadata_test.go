@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestParseEntryMeta(t *testing.T) {
+	m, err := parseEntryMeta(map[string]interface{}{"name": "temp", "unit": "°C", "scale": 0.1, "description": "Supply temperature"})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := entryMeta{Name: "temp", Unit: "°C", Scale: 0.1, Description: "Supply temperature"}
+	if !isEqual(expect, m) {
+		t.Errorf("expected %v, got %v", expect, m)
+	}
+
+	m, _ = parseEntryMeta(map[string]interface{}{})
+	if m.Scale != 1 {
+		t.Errorf("expected 1, got %v", m.Scale)
+	}
+
+	for _, raw := range []map[string]interface{}{
+		{"name": 1.0},
+		{"unit": true},
+		{"scale": 0.0},
+		{"scale": "0.1"},
+	} {
+		_, err := parseEntryMeta(raw)
+		if err == nil {
+			t.Errorf("expected error for %v, got nil", raw)
+		}
+	}
+}
+
+func TestHandleEntries(t *testing.T) {
+	p := profile{
+		entries: map[registerType][]configEntry{
+			holdingType: testEntries([]map[string]interface{}{
+				{"type": "int16", "number": 215.0, "regAddr": 201.0, "name": "temp", "unit": "°C", "scale": 0.1},
+			}),
+		},
+	}
+	serv := mbserver.NewServer()
+	setRegister(serv, []encoder{p.entries[holdingType][0].enc}, "holding", -1)
+	ec := &entryControl{devices: []*device{{serv: serv, profile: p}}, addrOffset: -1}
+
+	rec := httptest.NewRecorder()
+	ec.handleEntries(rec, httptest.NewRequest("GET", "/entries", nil))
+	var entries []entryStatus
+	err := json.NewDecoder(rec.Body).Decode(&entries)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v", entries)
+	}
+	e := entries[0]
+	if e.Name != "temp" || e.EngineeringUnit != "°C" || *e.Value != 215 || *e.Scaled != 21.5 {
+		t.Errorf("expected temp 215 scaled to 21.5 °C, got %+v", e)
+	}
+
+	rec = httptest.NewRecorder()
+	ec.handleEntries(rec, httptest.NewRequest("GET", "/entries?unit=foo", nil))
+	if rec.Code != 400 {
+		t.Errorf("expected 400, got %v", rec.Code)
+	}
+}
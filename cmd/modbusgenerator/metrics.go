@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// latencyBuckets is the upper bounds in seconds of the buckets of the
+// latency histograms.
+var latencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// latencyHistogram counts the latencies observed in the latency buckets.
+type latencyHistogram struct {
+	// counts holds the number of latencies in each bucket, and the
+	// latencies larger than the last bucket in the last element.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make([]uint64, len(latencyBuckets)+1)}
+}
+
+// observe will count the latency given.
+func (h *latencyHistogram) observe(d time.Duration) {
+	s := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, s)
+	h.counts[i]++
+	h.sum += s
+	h.count++
+}
+
+// write will write the histogram in the Prometheus text format, with the
+// buckets cumulative as Prometheus expects.
+func (h *latencyHistogram) write(w io.Writer, name string, device string) {
+	var cumulative uint64
+	for i, le := range latencyBuckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%v_bucket{device=%q,le=%q} %v\n", name, device, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%v_bucket{device=%q,le=\"+Inf\"} %v\n", name, device, h.count)
+	fmt.Fprintf(w, "%v_sum{device=%q} %v\n", name, device, h.sum)
+	fmt.Fprintf(w, "%v_count{device=%q} %v\n", name, device, h.count)
+}
+
+// requestKey is the labels the requests are counted by.
+type requestKey struct {
+	device    string
+	function  uint8
+	exception mbserver.Exception
+}
+
+// deviceLatencies is the latency histograms of a device.
+type deviceLatencies struct {
+	queue      *latencyHistogram
+	processing *latencyHistogram
+	total      *latencyHistogram
+}
+
+// transactionMetrics logs the requests handled by the devices with their
+// timing, and keeps the metrics of the requests for the metrics endpoint.
+type transactionMetrics struct {
+	mu sync.Mutex
+	// logTransactions is true if each request should be logged.
+	logTransactions bool
+	requests        map[requestKey]uint64
+	latencies       map[string]*deviceLatencies
+	// devices holds the devices watched, for the counters kept by the
+	// servers of the devices.
+	devices []*device
+}
+
+func newTransactionMetrics(logTransactions bool) *transactionMetrics {
+	return &transactionMetrics{
+		logTransactions: logTransactions,
+		requests:        make(map[requestKey]uint64),
+		latencies:       make(map[string]*deviceLatencies),
+	}
+}
+
+// watch will time the requests handled by the device given, and log
+// them if logTransactions is set.
+func (m *transactionMetrics) watch(d *device) {
+	m.mu.Lock()
+	m.devices = append(m.devices, d)
+	m.mu.Unlock()
+
+	d.serv.TransactionHook = func(tr mbserver.Transaction) {
+		if m.logTransactions {
+			logTransaction(d.name, tr)
+		}
+		m.observe(d.name, tr)
+	}
+}
+
+// logTransaction will log the request given with its timing in
+// milliseconds.
+func logTransaction(deviceName string, tr mbserver.Transaction) {
+	client := tr.Remote
+	if client == "" {
+		client = "serial"
+	}
+	if deviceName != "" {
+		client = deviceName + ": " + client
+	}
+
+	result := "not answered"
+	if tr.Answered {
+		result = tr.Exception.String()
+	}
+	log.Printf("info: transaction: %v unit %v fc%v %v, queue %.3fms, processing %.3fms, total %.3fms\n",
+		client, tr.UnitID, tr.Function, result, milliseconds(tr.Queue), milliseconds(tr.Processing), milliseconds(tr.Total))
+}
+
+// milliseconds will return the duration given in milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// observe will count the request given for the device given.
+func (m *transactionMetrics) observe(deviceName string, tr mbserver.Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{device: deviceName, function: tr.Function, exception: tr.Exception}]++
+
+	l, ok := m.latencies[deviceName]
+	if !ok {
+		l = &deviceLatencies{
+			queue:      newLatencyHistogram(),
+			processing: newLatencyHistogram(),
+			total:      newLatencyHistogram(),
+		}
+		m.latencies[deviceName] = l
+	}
+	l.queue.observe(tr.Queue)
+	l.processing.observe(tr.Processing)
+	l.total.observe(tr.Total)
+}
+
+// handleMetrics answers with the metrics of the requests in the
+// Prometheus text format.
+func (m *transactionMetrics) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	var keys []requestKey
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.device != b.device {
+			return a.device < b.device
+		}
+		if a.function != b.function {
+			return a.function < b.function
+		}
+		return a.exception < b.exception
+	})
+
+	fmt.Fprintf(w, "# HELP modbus_requests_total The number of requests handled, by function code and exception code, where 0 is no exception.\n")
+	fmt.Fprintf(w, "# TYPE modbus_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(w, "modbus_requests_total{device=%q,function=\"%v\",exception=\"%v\"} %v\n", k.device, k.function, uint8(k.exception), m.requests[k])
+	}
+
+	var devices []string
+	for name := range m.latencies {
+		devices = append(devices, name)
+	}
+	sort.Strings(devices)
+
+	histograms := []struct {
+		name string
+		help string
+		get  func(*deviceLatencies) *latencyHistogram
+	}{
+		{"modbus_request_queue_seconds", "The time from a request is received until it is handled, including the rate limits and the wait for other requests.", func(l *deviceLatencies) *latencyHistogram { return l.queue }},
+		{"modbus_request_processing_seconds", "The time handling a request.", func(l *deviceLatencies) *latencyHistogram { return l.processing }},
+		{"modbus_request_duration_seconds", "The time from a request is received until the response is written, including the simulated serial timing.", func(l *deviceLatencies) *latencyHistogram { return l.total }},
+	}
+	for _, h := range histograms {
+		fmt.Fprintf(w, "# HELP %v %v\n", h.name, h.help)
+		fmt.Fprintf(w, "# TYPE %v histogram\n", h.name)
+		for _, name := range devices {
+			h.get(m.latencies[name]).write(w, h.name, name)
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP modbus_malformed_frames_total The number of frames that could not be parsed, by how they were handled.\n")
+	fmt.Fprintf(w, "# TYPE modbus_malformed_frames_total counter\n")
+	for _, d := range m.devices {
+		st := d.serv.MalformedStats()
+		fmt.Fprintf(w, "modbus_malformed_frames_total{device=%q,action=\"dropped\"} %v\n", d.name, st.Dropped)
+		fmt.Fprintf(w, "modbus_malformed_frames_total{device=%q,action=\"closed\"} %v\n", d.name, st.Closed)
+		fmt.Fprintf(w, "modbus_malformed_frames_total{device=%q,action=\"answered\"} %v\n", d.name, st.Answered)
+	}
+}
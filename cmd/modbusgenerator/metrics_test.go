@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestTransactionMetrics(t *testing.T) {
+	m := newTransactionMetrics(false)
+	m.observe("plc1", mbserver.Transaction{Function: 3, Answered: true, Queue: time.Microsecond * 50, Processing: time.Microsecond * 20, Total: time.Millisecond * 2})
+	m.observe("plc1", mbserver.Transaction{Function: 3, Answered: true, Exception: mbserver.IllegalDataAddress, Queue: time.Millisecond * 20, Total: time.Millisecond * 30})
+
+	w := httptest.NewRecorder()
+	m.handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	for _, expect := range []string{
+		`modbus_requests_total{device="plc1",function="3",exception="0"} 1`,
+		`modbus_requests_total{device="plc1",function="3",exception="2"} 1`,
+		`modbus_request_queue_seconds_bucket{device="plc1",le="0.0001"} 1`,
+		`modbus_request_queue_seconds_bucket{device="plc1",le="0.01"} 1`,
+		`modbus_request_queue_seconds_bucket{device="plc1",le="0.05"} 2`,
+		`modbus_request_queue_seconds_bucket{device="plc1",le="+Inf"} 2`,
+		`modbus_request_queue_seconds_count{device="plc1"} 2`,
+		`modbus_request_duration_seconds_bucket{device="plc1",le="0.005"} 1`,
+	} {
+		if !strings.Contains(body, expect+"\n") {
+			t.Errorf("expected %q in the metrics, got %v", expect, body)
+		}
+	}
+}
+
+func TestMalformedMetrics(t *testing.T) {
+	m := newTransactionMetrics(false)
+	d := &device{name: "plc1", serv: mbserver.NewServer()}
+	m.watch(d)
+
+	w := httptest.NewRecorder()
+	m.handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	expect := `modbus_malformed_frames_total{device="plc1",action="closed"} 0`
+	if !strings.Contains(w.Body.String(), expect+"\n") {
+		t.Errorf("expected %q in the metrics, got %v", expect, w.Body.String())
+	}
+}
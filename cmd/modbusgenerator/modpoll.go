@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pollArgFlags is the flags of modpoll and mbpoll that take an argument,
+// by the name of the tool.
+var pollArgFlags = map[string]string{
+	"modpoll": "marctlopbds4",
+	"mbpoll":  "marctlopbdsP",
+}
+
+// pollCommand is a single modpoll or mbpoll command line.
+type pollCommand struct {
+	tool string
+	rt   registerType
+	// ref is the first reference polled, which is 1 based unless zero
+	// is set.
+	ref   int
+	count int
+	// dataType is the data type given after the colon of the -t flag,
+	// e.g. float or int.
+	dataType string
+	zero     bool
+	// bigEndian is true if the 32-bit values are polled with the big
+	// endian word order.
+	bigEndian bool
+	units     []int
+}
+
+// runImport implements the import subcommand, which makes a skeleton of
+// the config files from a list of modpoll or mbpoll command lines.
+// It returns the exit code, which is 0 on success and 2 on errors.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Make a skeleton of the config files covering the addresses and types polled by modpoll or mbpoll commands.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator import [flags] commands.txt\n\n")
+		fmt.Fprintf(os.Stderr, "The file has one command on each line. Use - to read the commands from stdin.\n\n")
+		fs.PrintDefaults()
+	}
+	outDir := fs.String("outDir", ".", "The directory to write the config files to")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		fh, err := os.Open(fs.Arg(0))
+		if err != nil {
+			log.Printf("error: %v\n", err)
+			return 2
+		}
+		defer fh.Close()
+		r = fh
+	}
+
+	commands, err := readPollCommands(r)
+	if err != nil {
+		log.Printf("error: %v\n", err)
+		return 2
+	}
+
+	configs, units := pollConfigs(commands)
+	args = nil
+	for _, rt := range historyRegisterTypes {
+		if len(configs[rt]) == 0 {
+			continue
+		}
+
+		filename := filepath.Join(*outDir, string(rt)+".json")
+		js, err := marshalEntries(configs[rt])
+		if err != nil {
+			log.Printf("error: %v\n", err)
+			return 2
+		}
+		err = os.WriteFile(filename, js, 0644)
+		if err != nil {
+			log.Printf("error: %v\n", err)
+			return 2
+		}
+		args = append(args, fmt.Sprintf("-json%v%v %v", strings.ToUpper(string(rt[:1])), rt[1:], filename))
+	}
+	if len(units) > 1 {
+		var ids []string
+		for _, id := range units {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		args = append(args, "-units "+strings.Join(ids, ","))
+	}
+
+	fmt.Printf("modbusgenerator %v\n", strings.Join(args, " "))
+	return 0
+}
+
+// readPollCommands will read the modpoll or mbpoll commands, one on
+// each line. Empty lines and lines starting with # are skipped.
+func readPollCommands(r io.Reader) ([]pollCommand, error) {
+	var commands []pollCommand
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		c, err := parsePollCommand(splitCommandLine(text))
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		commands = append(commands, c)
+	}
+
+	return commands, scanner.Err()
+}
+
+// splitCommandLine will split a command line into its arguments, where
+// arguments can be quoted with single or double quotes.
+func splitCommandLine(s string) []string {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args
+}
+
+// parsePollCommand will parse the arguments of a modpoll or mbpoll
+// command line.
+func parsePollCommand(args []string) (pollCommand, error) {
+	c := pollCommand{rt: holdingType, ref: 1, count: 1, units: []int{1}}
+	if len(args) == 0 {
+		return c, fmt.Errorf("empty command")
+	}
+
+	c.tool = strings.TrimSuffix(filepath.Base(args[0]), ".exe")
+	argFlags, ok := pollArgFlags[c.tool]
+	if !ok {
+		return c, fmt.Errorf("unknown command %v, must be modpoll or mbpoll", args[0])
+	}
+
+	for i := 1; i < len(args); i++ {
+		a := args[i]
+		if len(a) < 2 || a[0] != '-' {
+			// The device or host, or the values to write.
+			continue
+		}
+
+		name := a[1]
+		if !strings.ContainsRune(argFlags, rune(name)) {
+			switch {
+			case name == '0':
+				c.zero = true
+			case c.tool == "modpoll" && (name == 'f' || name == 'i'):
+				c.bigEndian = true
+			case c.tool == "mbpoll" && name == 'B':
+				c.bigEndian = true
+			}
+			continue
+		}
+
+		// The value of the flag is either the rest of the argument, or
+		// the next argument.
+		value := a[2:]
+		if value == "" {
+			if i+1 >= len(args) {
+				return c, fmt.Errorf("missing value of flag %v", a)
+			}
+			i++
+			value = args[i]
+		}
+
+		var err error
+		switch name {
+		case 'a':
+			c.units, err = parsePollUnits(value)
+		case 'r':
+			c.ref, err = strconv.Atoi(value)
+			if err == nil && (c.ref < 0 || c.ref > 65536) {
+				err = fmt.Errorf("reference must be 0 to 65536")
+			}
+		case 'c':
+			c.count, err = strconv.Atoi(value)
+			if err == nil && c.count < 1 {
+				err = fmt.Errorf("count must be 1 or more")
+			}
+		case 't':
+			table, dataType, _ := strings.Cut(value, ":")
+			c.dataType = dataType
+			switch table {
+			case "0":
+				c.rt = coilType
+			case "1":
+				c.rt = discreteType
+			case "3":
+				c.rt = inputType
+			case "4":
+				c.rt = holdingType
+			default:
+				err = fmt.Errorf("unknown type")
+			}
+		}
+		if err != nil {
+			return c, fmt.Errorf("invalid value %q of flag %v: %v", value, a[:2], err)
+		}
+	}
+
+	if c.ref == 0 && !c.zero {
+		return c, fmt.Errorf("reference 0 is only valid with -0")
+	}
+
+	return c, nil
+}
+
+// parsePollUnits will parse the unit IDs given with the -a flag, which
+// for mbpoll can be a list like 1,3 or a range like 1:5.
+func parsePollUnits(s string) ([]int, error) {
+	var units []int
+	for _, v := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(v, ":")
+		f, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, err
+		}
+		l := f
+		if isRange {
+			l, err = strconv.Atoi(last)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if f < 1 || l > 247 || l < f {
+			return nil, fmt.Errorf("unit IDs must be 1 to 247")
+		}
+		for id := f; id <= l; id++ {
+			units = append(units, id)
+		}
+	}
+
+	return units, nil
+}
+
+// regAddr will return the address of the first register polled, as
+// given in the config files with the default register start offset.
+func (c pollCommand) regAddr() int {
+	if c.zero {
+		return c.ref + 1
+	}
+	return c.ref
+}
+
+// pollConfigs will return the raw config entries covering the registers
+// polled by the commands, by register type, and the unit IDs polled. The
+// 32-bit floats are made entries with the word order polled, and all the
+// other registers are filled with 0, since modpoll and mbpoll has no
+// other types the config files have entries for.
+func pollConfigs(commands []pollCommand) (map[registerType][]map[string]interface{}, []int) {
+	configs := make(map[registerType][]map[string]interface{})
+	seen := make(map[string]bool)
+	unitSet := make(map[int]bool)
+
+	for _, c := range commands {
+		for _, id := range c.units {
+			unitSet[id] = true
+		}
+
+		addr := c.regAddr()
+		var obj map[string]interface{}
+		switch {
+		case (c.rt == inputType || c.rt == holdingType) && c.dataType == "float":
+			typ := "float32_cdab"
+			if c.bigEndian {
+				typ = "float32_abcd"
+			}
+			obj = map[string]interface{}{"type": typ, "number": 0, "regAddr": addr, "count": c.count}
+		case (c.rt == inputType || c.rt == holdingType) && (c.dataType == "int" || c.dataType == "mod"):
+			obj = map[string]interface{}{"fill": []int{addr, addr + c.count*2 - 1}, "value": 0}
+		default:
+			obj = map[string]interface{}{"fill": []int{addr, addr + c.count - 1}, "value": 0}
+		}
+
+		key := fmt.Sprint(c.rt, obj)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		configs[c.rt] = append(configs[c.rt], obj)
+	}
+
+	// The entries must be in the order of their addresses.
+	for _, entries := range configs {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entryStart(entries[i]) < entryStart(entries[j])
+		})
+	}
+
+	var units []int
+	for id := range unitSet {
+		units = append(units, id)
+	}
+	sort.Ints(units)
+
+	return configs, units
+}
+
+// entryStart will return the first address of a raw config entry made by
+// pollConfigs.
+func entryStart(obj map[string]interface{}) int {
+	if r, ok := obj["fill"].([]int); ok {
+		return r[0]
+	}
+	return obj["regAddr"].(int)
+}
+
+// marshalEntries will encode the raw config entries given as a JSON list
+// with each entry on its own line.
+func marshalEntries(entries []map[string]interface{}) ([]byte, error) {
+	var lines []string
+	for _, obj := range entries {
+		js, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, "    "+string(js))
+	}
+
+	return []byte("[\n" + strings.Join(lines, ",\n") + "\n]\n"), nil
+}
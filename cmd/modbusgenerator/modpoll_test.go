@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPollConfigs(t *testing.T) {
+	commands, err := readPollCommands(strings.NewReader(`
+# Comments and empty lines are skipped.
+modpoll -m tcp -a 1 -r 100 -c 10 -t 4 192.168.1.10
+modpoll -m tcp -a 2 -r 201 -c 4 -t 4:float -f 192.168.1.10
+/usr/bin/mbpoll -a 3:4 -r 1 -c 16 -t 0 -1 192.168.1.10
+mbpoll -0 -r 300 -c 2 -t 3:float 192.168.1.10
+mbpoll -r100 -c 10 -t 4 "192.168.1.10"
+mbpoll -r 500 -c 2 -t 4:int -B 192.168.1.10 1 2
+`))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	configs, units := pollConfigs(commands)
+
+	expect := map[registerType][]map[string]interface{}{
+		coilType: {
+			{"fill": []int{1, 16}, "value": 0},
+		},
+		inputType: {
+			{"type": "float32_cdab", "number": 0, "regAddr": 301, "count": 2},
+		},
+		holdingType: {
+			{"fill": []int{100, 109}, "value": 0},
+			{"type": "float32_abcd", "number": 0, "regAddr": 201, "count": 4},
+			{"fill": []int{500, 503}, "value": 0},
+		},
+	}
+	if !isEqual(expect, configs) {
+		t.Errorf("expected %v, got %v", expect, configs)
+	}
+	if !isEqual([]int{1, 2, 3, 4}, units) {
+		t.Errorf("expected %v, got %v", []int{1, 2, 3, 4}, units)
+	}
+}
+
+func TestParsePollCommandInvalid(t *testing.T) {
+	for _, line := range []string{
+		"modscan -r 1",
+		"modpoll -r",
+		"modpoll -r 0 192.168.1.10",
+		"modpoll -t 5 192.168.1.10",
+		"mbpoll -a 0:3 192.168.1.10",
+		"mbpoll -c foo 192.168.1.10",
+	} {
+		_, err := parsePollCommand(splitCommandLine(line))
+		if err == nil {
+			t.Errorf("%v: expected error not nil, got %v", line, err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// natsMessage is a message published to the fake NATS server.
+type natsMessage struct {
+	subject string
+	payload string
+}
+
+// fakeNATS will start a NATS server on a free port, and return its
+// address, the CONNECT options sent by the clients, the messages published
+// and the connections accepted, so the test can close them.
+func fakeNATS(t *testing.T) (string, chan string, chan natsMessage, chan net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	connects := make(chan string, 10)
+	messages := make(chan natsMessage, 10)
+	conns := make(chan net.Conn, 10)
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0:
+			case fields[0] == "CONNECT":
+				connects <- strings.TrimSpace(strings.TrimPrefix(line, "CONNECT "))
+			case fields[0] == "PING":
+				fmt.Fprintf(conn, "PONG\r\n")
+			case fields[0] == "PUB" && len(fields) == 3:
+				payload, _ := r.ReadString('\n')
+				messages <- natsMessage{fields[1], strings.TrimSpace(payload)}
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go serve(conn)
+		}
+	}()
+
+	return l.Addr().String(), connects, messages, conns
+}
+
+func TestNATSPublisher(t *testing.T) {
+	addr, connects, messages, _ := fakeNATS(t)
+	np, err := newNATSPublisher("nats://user:secret@"+addr, "rig", "", "", "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	entries := map[registerType][]configEntry{
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "int16", "number": 215.0, "regAddr": 201.0, "name": "supplyTemp", "scale": 0.1},
+		}),
+	}
+	serv := mbserver.NewServer()
+	setRegister(serv, []encoder{entries[holdingType][0].enc}, "holding", -1)
+	clk := newSimClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+	startEvents(serv, np, "boiler 1", 2, entries, -1, time.Millisecond*10, clk)
+
+	// The first check only takes the values, so the change is published
+	// by the next check, when the steps have started.
+	time.Sleep(time.Millisecond * 50)
+	serv.Lock()
+	serv.HoldingRegisters[200] = 220
+	serv.Unlock()
+	clk.Step(time.Millisecond * 10)
+
+	select {
+	case opts := <-connects:
+		var o map[string]interface{}
+		json.Unmarshal([]byte(opts), &o)
+		if o["user"] != "user" || o["pass"] != "secret" || o["verbose"] != false {
+			t.Errorf("expected the credentials given, got %v", opts)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("expected the client to connect")
+	}
+
+	select {
+	case m := <-messages:
+		if m.subject != "rig.boiler_1.2.holding.201" {
+			t.Errorf("expected %v, got %v", "rig.boiler_1.2.holding.201", m.subject)
+		}
+		var ev journalEvent
+		json.Unmarshal([]byte(m.payload), &ev)
+		if ev.Seq != 1 || ev.Name != "supplyTemp" || ev.Old != 21.5 || ev.New != 22 {
+			t.Errorf("expected the change of supplyTemp from 21.5 to 22, got %v", m.payload)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("expected the change published")
+	}
+}
+
+func TestNATSPublisherReconnect(t *testing.T) {
+	addr, _, messages, conns := fakeNATS(t)
+	np, err := newNATSPublisher("nats://"+addr, "rig", "", "", "")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	// The events published after the connection is lost are published
+	// when the client has connected again.
+	conn := <-conns
+	conn.Close()
+	for start := time.Now(); np.nc.IsConnected(); time.Sleep(time.Millisecond * 10) {
+		if time.Since(start) > time.Second*3 {
+			t.Fatalf("expected the client to notice the lost connection")
+		}
+	}
+	for i := 1; i <= 2; i++ {
+		np.enqueue(journalEvent{Seq: uint64(i), Register: "holding", Address: 201})
+	}
+
+	select {
+	case <-conns:
+	case <-time.After(natsReconnectWait * 3):
+		t.Fatalf("expected the client to connect again")
+	}
+	for i := 1; i <= 2; i++ {
+		select {
+		case m := <-messages:
+			var ev journalEvent
+			json.Unmarshal([]byte(m.payload), &ev)
+			if ev.Seq != uint64(i) {
+				t.Errorf("expected %v, got %v", i, m.payload)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("expected event %v published", i)
+		}
+	}
+}
+
+func TestNewNATSPublisherInvalid(t *testing.T) {
+	for _, v := range []struct {
+		url     string
+		subject string
+	}{
+		{"http://localhost:4222", "rig"},
+		{"nats://", "rig"},
+		{"nats://localhost", ""},
+		{"nats://localhost", "rig.>"},
+		{"ftp://localhost", "rig"},
+	} {
+		if _, err := newNATSPublisher(v.url, v.subject, "", "", ""); err == nil {
+			t.Errorf("expected error for %v %v, got nil", v.url, v.subject)
+		}
+	}
+}
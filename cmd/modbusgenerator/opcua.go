@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopcua/opcua/id"
+	"github.com/gopcua/opcua/server"
+	"github.com/gopcua/opcua/server/attrs"
+	"github.com/gopcua/opcua/ua"
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// opcuaNotifyInterval is the interval between each check of the values
+// of the entries, where the OPC UA clients monitoring a changed value are
+// notified.
+const opcuaNotifyInterval = time.Second
+
+// opcuaNamespace is the name of the OPC UA namespace holding the nodes of
+// the entries.
+const opcuaNamespace = "modbusgenerator"
+
+// opcuaLogger logs the errors of the OPC UA server with the log of the
+// generator while the server is running, since the server logs errors
+// when it builds its standard nodes and when it is stopped. The other
+// messages, like the secure channels closed by the clients, are routine
+// and dropped.
+type opcuaLogger struct {
+	running atomic.Bool
+}
+
+func (l *opcuaLogger) Debug(msg string, args ...any) {}
+func (l *opcuaLogger) Info(msg string, args ...any)  {}
+func (l *opcuaLogger) Warn(msg string, args ...any)  {}
+
+func (l *opcuaLogger) Error(msg string, args ...any) {
+	if l.running.Load() {
+		log.Printf("error: opcua: %v\n", strings.TrimSpace(fmt.Sprintf(msg, args...)))
+	}
+}
+
+// opcuaFacade is an OPC UA server with a read-only variable node for each
+// named entry of the devices, so the OPC UA clients read the same values
+// as the Modbus clients.
+type opcuaFacade struct {
+	srv    *server.Server
+	ns     *server.NodeNameSpace
+	logger *opcuaLogger
+	// done is closed when the server is stopped, which stops the
+	// notifications.
+	done chan struct{}
+}
+
+// opcuaNodeName will return the string node ID of the entry with the
+// name given, of the device and unit given. The name of the device and
+// the unit are left out when empty or 0.
+func opcuaNodeName(device string, unit int, name string) string {
+	var parts []string
+	if device != "" {
+		parts = append(parts, device)
+	}
+	if unit != 0 {
+		parts = append(parts, "unit"+strconv.Itoa(unit))
+	}
+	return strings.Join(append(parts, name), "/")
+}
+
+// startOPCUA will start an OPC UA server on the address given, with a
+// read-only variable node for each named entry of the servers of the
+// devices. The values are in the engineering unit of the entries.
+func startOPCUA(addr string, devices []*device, addrOffset int, clk *simClock) (*opcuaFacade, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 {
+		return nil, fmt.Errorf("invalid port in address %v", addr)
+	}
+
+	// The clients connect to the endpoint with the URL they were given,
+	// so a server listening on all addresses also gives the endpoints of
+	// localhost and the host name.
+	opts := []server.Option{
+		server.EnableSecurity("None", ua.MessageSecurityModeNone),
+		server.EnableAuthMode(ua.UserTokenTypeAnonymous),
+		server.ServerName("modbusgenerator"),
+		server.ProductName("modbusgenerator"),
+		server.SoftwareVersion(readBuildInfo().version),
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		hostname, _ := os.Hostname()
+		opts = append(opts, server.EndPoint("0.0.0.0", port), server.EndPoint("localhost", port))
+		if hostname != "" {
+			opts = append(opts, server.EndPoint(hostname, port))
+		}
+	} else {
+		opts = append(opts, server.EndPoint(host, port))
+	}
+
+	o := &opcuaFacade{logger: &opcuaLogger{}, done: make(chan struct{})}
+	opts = append(opts, server.SetLogger(o.logger))
+	o.srv = server.New(opts...)
+	o.ns = server.NewNodeNameSpace(o.srv, opcuaNamespace)
+	root, err := o.srv.Namespace(0)
+	if err != nil {
+		return nil, err
+	}
+	root.Objects().AddRef(o.ns.Objects(), id.HasComponent, true)
+
+	var trackers []func() []*ua.NodeID
+	for _, d := range devices {
+		for _, s := range d.servers {
+			unit := unitIDOf(d, s)
+			for _, rt := range historyRegisterTypes {
+				for _, e := range d.profile.entries[rt] {
+					m := e.meta()
+					if m.Name == "" {
+						continue
+					}
+					n := o.variable(opcuaNodeName(d.name, unit, m.Name), m, d.serv, s, rt, e, addrOffset, clk)
+					o.ns.AddNode(n)
+					o.ns.Objects().AddRef(n, id.HasComponent, true)
+				}
+			}
+			trackers = append(trackers, o.tracker(d, s, unit, addrOffset))
+		}
+	}
+
+	err = o.srv.Start(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	o.logger.running.Store(true)
+	go o.notify(trackers)
+
+	return o, nil
+}
+
+// variable will return the read-only variable node of the entry given,
+// whose value is read from the registers of the server when requested.
+func (o *opcuaFacade) variable(name string, m entryMeta, lock *mbserver.Server, serv *mbserver.Server, rt registerType, e configEntry, addrOffset int, clk *simClock) *server.Node {
+	description := m.Description
+	if m.Unit != "" {
+		description = strings.TrimSpace(description + " (" + m.Unit + ")")
+	}
+	access := byte(ua.AccessLevelTypeCurrentRead)
+
+	return server.NewNode(
+		ua.NewStringNodeID(o.ns.ID(), name),
+		map[ua.AttributeID]*ua.DataValue{
+			ua.AttributeIDNodeClass:       server.DataValueFromValue(uint32(ua.NodeClassVariable)),
+			ua.AttributeIDBrowseName:      server.DataValueFromValue(attrs.BrowseName(name)),
+			ua.AttributeIDDisplayName:     server.DataValueFromValue(attrs.DisplayName(m.Name, "")),
+			ua.AttributeIDDescription:     server.DataValueFromValue(attrs.DisplayName(description, "")),
+			ua.AttributeIDDataType:        server.DataValueFromValue(attrs.DataType(ua.NewNumericNodeID(0, id.Double))),
+			ua.AttributeIDAccessLevel:     server.DataValueFromValue(access),
+			ua.AttributeIDUserAccessLevel: server.DataValueFromValue(access),
+		},
+		nil,
+		func() *ua.DataValue {
+			lock.Lock()
+			v, ok := entryValue(serv, rt, e, addrOffset)
+			lock.Unlock()
+			if !ok {
+				return &ua.DataValue{EncodingMask: ua.DataValueStatusCode, Status: ua.StatusBadOutOfRange}
+			}
+			return &ua.DataValue{
+				EncodingMask:    ua.DataValueValue | ua.DataValueSourceTimestamp,
+				Value:           ua.MustVariant(m.scaled(v)),
+				SourceTimestamp: clk.Now(),
+			}
+		},
+	)
+}
+
+// tracker will return a function returning the nodes of the named
+// entries of the server given whose values have changed since it was last
+// called.
+func (o *opcuaFacade) tracker(d *device, serv *mbserver.Server, unit int, addrOffset int) func() []*ua.NodeID {
+	ct := newChangeTracker()
+	return func() []*ua.NodeID {
+		d.serv.Lock()
+		defer d.serv.Unlock()
+
+		var changed []*ua.NodeID
+		ct.check(serv, d.profile.entries, addrOffset, func(rt registerType, e configEntry, old float64, v float64) {
+			if name := e.meta().Name; name != "" {
+				changed = append(changed, ua.NewStringNodeID(o.ns.ID(), opcuaNodeName(d.name, unit, name)))
+			}
+		})
+		return changed
+	}
+}
+
+// notify will notify the clients monitoring the nodes of the entries
+// whose values have changed, every opcuaNotifyInterval. The clients are
+// notified with the servers unlocked, since the values are read again to
+// notify them.
+func (o *opcuaFacade) notify(trackers []func() []*ua.NodeID) {
+	ticker := time.NewTicker(opcuaNotifyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-o.done:
+			return
+		}
+		for _, changed := range trackers {
+			for _, n := range changed() {
+				o.srv.ChangeNotification(n)
+			}
+		}
+	}
+}
+
+// close will stop the OPC UA server.
+func (o *opcuaFacade) close() {
+	o.logger.running.Store(false)
+	close(o.done)
+	err := o.srv.Close()
+	if err != nil {
+		log.Printf("error: opcua: %v\n", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/ua"
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestOPCUANodeName(t *testing.T) {
+	tests := []struct {
+		device string
+		unit   int
+		expect string
+	}{
+		{"", 0, "supplyTemp"},
+		{"boiler-1", 0, "boiler-1/supplyTemp"},
+		{"boiler-1", 2, "boiler-1/unit2/supplyTemp"},
+	}
+	for _, tt := range tests {
+		got := opcuaNodeName(tt.device, tt.unit, "supplyTemp")
+		if tt.expect != got {
+			t.Errorf("expected %v, got %v", tt.expect, got)
+		}
+	}
+}
+
+func TestOPCUA(t *testing.T) {
+	serv := mbserver.NewServer()
+	entries := map[registerType][]configEntry{
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "int16", "number": 215.0, "regAddr": 201.0, "name": "supplyTemp", "scale": 0.1, "unit": "°C"},
+			{"type": "int16", "number": 7.0, "regAddr": 202.0},
+		}),
+	}
+	setRegister(serv, []encoder{entries[holdingType][0].enc}, "holding", -1)
+	d := &device{name: "boiler-1", serv: serv, servers: []*mbserver.Server{serv}, profile: profile{entries: entries}}
+	clk := newSimClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+
+	o, err := startOPCUA("127.0.0.1:3411", []*device{d}, -1, clk)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer o.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	c, err := opcua.NewClient("opc.tcp://127.0.0.1:3411", opcua.SecurityMode(ua.MessageSecurityModeNone), opcua.AutoReconnect(false))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	err = c.Connect(ctx)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer c.Close(context.Background())
+
+	read := func(name string) *ua.DataValue {
+		resp, err := c.Read(ctx, &ua.ReadRequest{
+			NodesToRead: []*ua.ReadValueID{{NodeID: ua.NewStringNodeID(o.ns.ID(), name), AttributeID: ua.AttributeIDValue}},
+		})
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		return resp.Results[0]
+	}
+
+	// The value of the named entry is read in its engineering unit.
+	dv := read("boiler-1/supplyTemp")
+	if dv.Status != ua.StatusOK || dv.Value.Value() != 21.5 {
+		t.Errorf("expected 21.5, got %v and %v", dv.Value, dv.Status)
+	}
+
+	serv.Lock()
+	serv.HoldingRegisters[200] = 220
+	serv.Unlock()
+	dv = read("boiler-1/supplyTemp")
+	if dv.Value.Value() != 22.0 {
+		t.Errorf("expected 22, got %v", dv.Value)
+	}
+
+	// The entries without a name have no node.
+	dv = read("boiler-1/202")
+	if dv.Status != ua.StatusBadNodeIDUnknown {
+		t.Errorf("expected %v, got %v", ua.StatusBadNodeIDUnknown, dv.Status)
+	}
+
+	// The nodes are read-only.
+	resp, err := c.Write(ctx, &ua.WriteRequest{
+		NodesToWrite: []*ua.WriteValue{{
+			NodeID:      ua.NewStringNodeID(o.ns.ID(), "boiler-1/supplyTemp"),
+			AttributeID: ua.AttributeIDValue,
+			Value:       &ua.DataValue{EncodingMask: ua.DataValueValue, Value: ua.MustVariant(30.0)},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if resp.Results[0] == ua.StatusOK {
+		t.Errorf("expected the write rejected, got %v", resp.Results[0])
+	}
+	if serv.HoldingRegisters[200] != 220 {
+		t.Errorf("expected 220, got %v", serv.HoldingRegisters[200])
+	}
+}
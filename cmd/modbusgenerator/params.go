@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// paramFormats is the formats of the instance parameters of the entries,
+// which is a number replacing the number of the entry, an offset added to
+// the number of the entry, text written two characters per register, or
+// bytes given in hex like a MAC address written two bytes per register.
+var paramFormats = map[string]bool{"number": true, "offset": true, "ascii": true, "hex": true}
+
+// paramFuncs is the functions of the parameter templates, in addition to
+// the builtin functions of text/template like printf.
+var paramFuncs = template.FuncMap{
+	"add": func(a int, b int) int { return a + b },
+	"mul": func(a int, b int) int { return a * b },
+}
+
+// entryParam is the instance parameter of a config entry, given with the
+// "param", "paramFormat" and "paramTemplate" fields, which fills in the
+// value of the entry for each unit and device the profile is instantiated
+// for, like a serial number.
+type entryParam struct {
+	name   string
+	format string
+	// template gives the value when the instances file has no value for
+	// the instance, and is nil when not given.
+	template *template.Template
+}
+
+// parseEntryParam will return the instance parameter of the raw config
+// entry given, and false if the entry has none.
+func parseEntryParam(raw map[string]interface{}) (entryParam, bool, error) {
+	f, ok := raw["param"]
+	if !ok {
+		return entryParam{}, false, nil
+	}
+	name, ok := f.(string)
+	if !ok || name == "" {
+		return entryParam{}, false, fmt.Errorf("param must be a name, got %v", f)
+	}
+
+	p := entryParam{name: name, format: "number"}
+	if f, ok := raw["paramFormat"]; ok {
+		format, _ := f.(string)
+		if !paramFormats[format] {
+			return p, false, fmt.Errorf("paramFormat must be number, offset, ascii or hex, got %v", f)
+		}
+		p.format = format
+	}
+	if f, ok := raw["paramTemplate"]; ok {
+		text, ok := f.(string)
+		if !ok {
+			return p, false, fmt.Errorf("paramTemplate must be a string, got %v", f)
+		}
+		t, err := template.New(name).Funcs(paramFuncs).Parse(text)
+		if err != nil {
+			return p, false, fmt.Errorf("paramTemplate: %v", err)
+		}
+		p.template = t
+	}
+
+	return p, true, nil
+}
+
+// instance is a server of a device the instance parameters are filled in
+// for, which is given to the parameter templates.
+type instance struct {
+	// Device is the name of the device of a fleet config.
+	Device string
+	// Index is the index of the device of a port range, where the device
+	// on the first port has index 0.
+	Index int
+	// Unit is the unit ID, which is 0 for the server of the device itself.
+	Unit int
+}
+
+// instanceRow is a row of the instances file, where keys holds the
+// columns selecting the instances the row is for, and params the values
+// of the parameters.
+type instanceRow struct {
+	keys   map[string]string
+	params map[string]string
+}
+
+// instanceKeys is the columns of the instances file selecting the
+// instances a row is for. The other columns are parameters.
+var instanceKeys = map[string]bool{"device": true, "index": true, "unit": true}
+
+// loadInstances will load the CSV instances file given, where the first
+// row is the names of the columns.
+func loadInstances(filename string) ([]instanceRow, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	return readInstances(fh)
+}
+
+// readInstances will read the rows of a CSV instances file.
+func readInstances(r io.Reader) ([]instanceRow, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	var rows []instanceRow
+	for _, record := range records[1:] {
+		row := instanceRow{keys: make(map[string]string), params: make(map[string]string)}
+		for i, v := range record {
+			if v == "" {
+				continue
+			}
+			if instanceKeys[header[i]] {
+				row.keys[header[i]] = v
+			} else {
+				row.params[header[i]] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// matches will return true if the row is for the instance given, which is
+// when all the key columns given in the row are equal to the instance.
+func (r instanceRow) matches(inst instance) bool {
+	for k, v := range r.keys {
+		var value string
+		switch k {
+		case "device":
+			value = inst.Device
+		case "index":
+			value = strconv.Itoa(inst.Index)
+		case "unit":
+			value = strconv.Itoa(inst.Unit)
+		}
+		if v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// paramValue will return the value of the parameter given for the
+// instance, from the last row of the instances file for the instance with
+// a value for it, or from the template of the parameter. It returns false
+// if the parameter has no value for the instance.
+func paramValue(p entryParam, inst instance, rows []instanceRow) (string, bool, error) {
+	for i := len(rows) - 1; i >= 0; i-- {
+		if v, ok := rows[i].params[p.name]; ok && rows[i].matches(inst) {
+			return v, true, nil
+		}
+	}
+	if p.template == nil {
+		return "", false, nil
+	}
+
+	var b strings.Builder
+	err := p.template.Execute(&b, inst)
+	if err != nil {
+		return "", false, err
+	}
+	return b.String(), true, nil
+}
+
+// applyParams will fill in the entries with an instance parameter in the
+// registers of the server of the instance given. The entries with the
+// same parameter in the same register type are filled in together in
+// address order, so text is written across all of them.
+func applyParams(serv *mbserver.Server, entries map[registerType][]configEntry, inst instance, rows []instanceRow, addrOffset int) error {
+	for _, rt := range []registerType{coilType, discreteType, inputType, holdingType} {
+		groups := make(map[string][]configEntry)
+		params := make(map[string]entryParam)
+		var names []string
+		for _, e := range entries[rt] {
+			p, ok, _ := parseEntryParam(e.raw)
+			if !ok {
+				continue
+			}
+			if _, ok := params[p.name]; !ok {
+				names = append(names, p.name)
+				params[p.name] = p
+			}
+			groups[p.name] = append(groups[p.name], e)
+		}
+
+		for _, name := range names {
+			p := params[name]
+			v, ok, err := paramValue(p, inst, rows)
+			if err != nil {
+				return fmt.Errorf("param %v: %v", name, err)
+			}
+			if !ok {
+				continue
+			}
+			err = writeParam(serv, rt, p, groups[name], v, addrOffset)
+			if err != nil {
+				return fmt.Errorf("param %v: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeParam will write the value given of the parameter into the entries
+// given of the register type.
+func writeParam(serv *mbserver.Server, rt registerType, p entryParam, entries []configEntry, v string, addrOffset int) error {
+	switch p.format {
+	case "number", "offset":
+		n, err := parseParamNumber(v)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if p.format == "offset" {
+				writeEntry(serv, rt, e, e.enc.Decode(e.enc.Encode())+n, addrOffset)
+			} else {
+				writeEntry(serv, rt, e, n, addrOffset)
+			}
+		}
+		return nil
+	}
+
+	if rt != inputType && rt != holdingType {
+		return fmt.Errorf("paramFormat %v is only supported for the input and holding registers", p.format)
+	}
+	b := []byte(v)
+	if p.format == "hex" {
+		var err error
+		b, err = hex.DecodeString(strings.NewReplacer(":", "", "-", "", ".", "").Replace(v))
+		if err != nil {
+			return fmt.Errorf("invalid hex value %q", v)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].enc.Address() < entries[j].enc.Address() })
+	size := 0
+	for _, e := range entries {
+		size += len(e.enc.Encode()) * 2
+	}
+	if len(b) > size {
+		return fmt.Errorf("%q is longer than the %v bytes of the entries", v, size)
+	}
+
+	regs := serv.InputRegisters[:cap(serv.InputRegisters)]
+	if rt == holdingType {
+		regs = serv.HoldingRegisters[:cap(serv.HoldingRegisters)]
+	}
+	for _, e := range entries {
+		addr := e.enc.Address() + addrOffset
+		for i := range e.enc.Encode() {
+			var w uint16
+			if len(b) > 0 {
+				w = uint16(b[0]) << 8
+				b = b[1:]
+			}
+			if len(b) > 0 {
+				w |= uint16(b[0])
+				b = b[1:]
+			}
+			if addr+i >= 0 && addr+i < len(regs) {
+				regs[addr+i] = w
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseParamNumber will parse the number of a parameter, which is a
+// decimal number, or an integer with a prefix like 0x.
+func parseParamNumber(v string) (float64, error) {
+	if n, err := strconv.ParseInt(v, 0, 64); err == nil {
+		return float64(n), nil
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", v)
+	}
+	return n, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestApplyParams(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		inputType: testEntries([]map[string]interface{}{
+			{"type": "uint16", "number": 0.0, "regAddr": 1.0, "param": "serial", "paramFormat": "ascii", "paramTemplate": `SN-{{printf "%03d" .Unit}}`},
+			{"type": "uint16", "number": 0.0, "regAddr": 2.0, "param": "serial", "paramFormat": "ascii"},
+			{"type": "uint16", "number": 0.0, "regAddr": 3.0, "param": "serial", "paramFormat": "ascii"},
+			{"type": "uint16", "number": 0.0, "regAddr": 4.0, "param": "mac", "paramFormat": "hex"},
+			{"type": "uint16", "number": 0.0, "regAddr": 5.0, "param": "mac", "paramFormat": "hex"},
+			{"type": "uint16", "number": 0.0, "regAddr": 6.0, "param": "mac", "paramFormat": "hex"},
+		}),
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 20.0, "regAddr": 11.0, "param": "offset", "paramFormat": "offset"},
+			{"type": "uint16", "number": 7.0, "regAddr": 13.0, "param": "address", "paramTemplate": "{{add 100 .Unit}}"},
+		}),
+	}
+
+	rows, err := readInstances(strings.NewReader("unit,mac,offset\n2,02:00:5e:00:00:02,0.5\n3,,-1\n"))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	err = applyParams(serv, entries, instance{Unit: 2}, rows, -1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := []uint16{'S'<<8 | 'N', '-'<<8 | '0', '0'<<8 | '2', 0x0200, 0x5e00, 0x0002}
+	if !isEqual(expect, serv.InputRegisters[0:6]) {
+		t.Errorf("expected %x, got %x", expect, serv.InputRegisters[0:6])
+	}
+	offset := entries[holdingType][0].enc.Decode(serv.HoldingRegisters[10:12])
+	if offset != 20.5 {
+		t.Errorf("expected %v, got %v", 20.5, offset)
+	}
+	if serv.HoldingRegisters[12] != 102 {
+		t.Errorf("expected %v, got %v", 102, serv.HoldingRegisters[12])
+	}
+
+	// The instance without a MAC in the instances file keeps the MAC of
+	// the config.
+	serv = mbserver.NewServer()
+	applyParams(serv, entries, instance{Unit: 3}, rows, -1)
+	if !isEqual([]uint16{0, 0, 0}, serv.InputRegisters[3:6]) {
+		t.Errorf("expected %v, got %v", []uint16{0, 0, 0}, serv.InputRegisters[3:6])
+	}
+	offset = entries[holdingType][0].enc.Decode(serv.HoldingRegisters[10:12])
+	if offset != 19 {
+		t.Errorf("expected %v, got %v", 19, offset)
+	}
+
+	// The serial number does not fit in the entries.
+	rows, _ = readInstances(strings.NewReader("serial\nSN-1234567\n"))
+	err = applyParams(mbserver.NewServer(), entries, instance{}, rows, -1)
+	if err == nil {
+		t.Errorf("expected error for a serial number that is too long, got nil")
+	}
+}
+
+func TestParseEntryParam(t *testing.T) {
+	p, ok, err := parseEntryParam(map[string]interface{}{"param": "serial", "paramFormat": "ascii", "paramTemplate": "{{.Index}}"})
+	if !ok || err != nil || p.name != "serial" || p.format != "ascii" || p.template == nil {
+		t.Errorf("unexpected param %+v, %v and %v", p, ok, err)
+	}
+	if _, ok, err := parseEntryParam(map[string]interface{}{}); ok || err != nil {
+		t.Errorf("expected no param, got %v and %v", ok, err)
+	}
+
+	for _, raw := range []map[string]interface{}{
+		{"param": 1.0},
+		{"param": "serial", "paramFormat": "base64"},
+		{"param": "serial", "paramTemplate": "{{.Unit"},
+	} {
+		if _, _, err := parseEntryParam(raw); err == nil {
+			t.Errorf("expected error for %v, got nil", raw)
+		}
+	}
+}
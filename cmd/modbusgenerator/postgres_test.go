@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePostgresTable(t *testing.T) {
+	tests := []struct {
+		table  string
+		expect string
+		valid  bool
+	}{
+		{"modbus_history", `"modbus_history"`, true},
+		{"history.modbus", `"history"."modbus"`, true},
+		{`my "table"`, `"my ""table"""`, true},
+		{"", "", false},
+		{"a.", "", false},
+		{"a.b.c", "", false},
+	}
+
+	for _, tt := range tests {
+		ident, err := parsePostgresTable(tt.table)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: expected valid %v, got %v", tt.table, tt.valid, err)
+			continue
+		}
+		if tt.valid && ident.Sanitize() != tt.expect {
+			t.Errorf("%q: expected %v, got %v", tt.table, tt.expect, ident.Sanitize())
+		}
+	}
+
+	ident, _ := parsePostgresTable("history.modbus")
+	if stmt := postgresCreateTable(ident); !strings.HasPrefix(stmt, `CREATE TABLE IF NOT EXISTS "history"."modbus" (`) {
+		t.Errorf("expected the table created, got %v", stmt)
+	}
+}
+
+func TestPostgresRows(t *testing.T) {
+	now := time.Unix(0, 1000)
+	rows := postgresRows([]historySample{
+		{time: now, device: "boiler", unit: -1, register: holdingType, address: 201, typ: "float32BigWordBigEndian", value: 21.5},
+		{time: now, unit: 2, register: coilType, address: 301, typ: "bit", value: math.NaN()},
+	})
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %v", len(rows))
+	}
+
+	// The server of the device itself has no unit.
+	expect := []interface{}{now, "boiler", nil, "holding", int32(201), "float32BigWordBigEndian", 21.5}
+	if !isEqual(expect, rows[0]) {
+		t.Errorf("expected %v, got %v", expect, rows[0])
+	}
+	// NaN is written, since PostgreSQL supports it.
+	if rows[1][2] != int32(2) || !math.IsNaN(rows[1][6].(float64)) {
+		t.Errorf("expected unit 2 and NaN, got %v", rows[1])
+	}
+}
+
+func TestPostgresWriterConnectError(t *testing.T) {
+	_, err := newPostgresWriter("postgres://user@127.0.0.1:1/modbus?connect_timeout=1", "modbus_history", false)
+	if err == nil {
+		t.Errorf("expected an error, got nil")
+	}
+}
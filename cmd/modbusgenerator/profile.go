@@ -0,0 +1,526 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// profile holds everything loaded from the config files that is used
+// to set up the servers simulating a device.
+type profile struct {
+	entries       map[registerType][]configEntry
+	bounds        []bound
+	links         []link
+	blocksRawData []map[string]interface{}
+	groups        []group
+	// writeLock and readLock holds the write and read protected
+	// entries, and are nil when no entries are protected.
+	writeLock *accessLock
+	readLock  *accessLock
+	qualities []quality
+	// units holds the registers of the units with their own config
+	// files, by unit ID.
+	units map[uint8]*mbserver.Server
+}
+
+// loadProfile will load the register files given into the registers of
+// the server, and return the profile with the behaviour given in the
+// register files and the blocks file. The errors found in the config
+// files are logged, and the number of errors is returned, so loading
+// can continue with the other files. The entries that are not valid are
+// left out, and a file that can not be loaded at all is skipped, so the
+// server can be started with the rest of the config, unless the caller
+// refuses to when there are errors. With a dry run the register image of
+// each register file is written to stdout. Entries of different files
+// set at the same address is an error, or only logged as a warning with
+// warnDuplicates, where the last file loaded wins.
+func loadProfile(serv *mbserver.Server, registerFiles []registerFile, jsonBlocks string, addrOffset int, dryRun bool, warnDuplicates bool) (profile, int) {
+	configErrors := 0
+
+	// entries holds the entries of the config files by register type,
+	// so entries can be linked across the register types.
+	p := profile{entries: make(map[registerType][]configEntry)}
+
+	// loadedLen holds the number of registers set by the files already
+	// loaded for each register type, so several files can set the
+	// registers of the same type.
+	loadedLen := make(map[registerType]int)
+
+	// used holds the entries set at each address, so entries of different
+	// files set at the same address can be reported.
+	used := addressMap{}
+
+	// Iterate over all the filenames specified, and create a holding
+	// structure to keep all the file handles in, with info about each
+	// register.
+	for _, v := range registerFiles {
+		if v.filename == "" || v.unit != 0 {
+			continue
+		}
+
+		// registryData will hold all the data to put into a complete
+		// register.
+		// each element of the slice will represent a register entry.
+		fileEntries, fills, groups, n, err := loadValidEntries(v, used, warnDuplicates)
+		configErrors += n
+		if err != nil {
+			log.Printf("error: %v\n", err)
+			configErrors++
+			continue
+		}
+		if len(groups) > 0 && v.registerType != inputType && v.registerType != holdingType {
+			log.Printf("error: %v: groups are only supported for input and holding registers\n", v.filename)
+			configErrors++
+			groups = nil
+		}
+		for _, g := range groups {
+			g.rt = v.registerType
+			p.groups = append(p.groups, g)
+		}
+
+		// bounds holds the range of values clients are allowed to
+		// write to the holding registers.
+		if v.registerType == holdingType {
+			var valid []configEntry
+			for _, e := range fileEntries {
+				bounds, err := parseBounds([]configEntry{e})
+				if err != nil {
+					log.Printf("error: %v\n", withFile(v.filename, err))
+					configErrors++
+					continue
+				}
+				p.bounds = append(p.bounds, bounds...)
+				valid = append(valid, e)
+			}
+			fileEntries = valid
+		}
+
+		// Fill the address ranges of the fill directives before the
+		// entries are set, so the entries take precedence.
+		for _, fl := range fills {
+			err = applyFill(serv, v.registerType, fl, addrOffset)
+			if err != nil {
+				log.Printf("error: %v: %v\n", v.filename, err)
+				configErrors++
+			}
+		}
+
+		// setRegister will set and populate the values into the register
+		fileEntries, n, err = setEntries(serv, v, fileEntries, addrOffset)
+		configErrors += n
+		if err != nil {
+			log.Printf("error: setRegister: %v: %v\n", v.filename, err)
+			configErrors++
+			continue
+		}
+		p.entries[v.registerType] = append(p.entries[v.registerType], fileEntries...)
+		extendToFills(serv, v.registerType, fills, addrOffset)
+		extendRegister(serv, v.registerType, loadedLen[v.registerType])
+		loadedLen[v.registerType] = registerLen(serv, v.registerType)
+
+		if dryRun {
+			printRegisterImage(os.Stdout, serv, v, fileEntries, addrOffset)
+		}
+	}
+
+	// Load the files for a single unit into the registers of the unit,
+	// which start as a copy of the registers loaded above. Only the
+	// values of the registers are taken from these files, while the
+	// behaviour is given by the files for the server itself.
+	for _, v := range registerFiles {
+		if v.filename == "" || v.unit == 0 {
+			continue
+		}
+
+		fileEntries, fills, _, n, err := loadValidEntries(v, used, warnDuplicates)
+		configErrors += n
+		if err != nil {
+			log.Printf("error: %v\n", err)
+			configErrors++
+			continue
+		}
+
+		img := p.unitImage(serv, v.unit)
+		for _, fl := range fills {
+			err = applyFill(img, v.registerType, fl, addrOffset)
+			if err != nil {
+				log.Printf("error: %v: %v\n", v.filename, err)
+				configErrors++
+			}
+		}
+		fileEntries, n, err = setEntries(img, v, fileEntries, addrOffset)
+		configErrors += n
+		if err != nil {
+			log.Printf("error: setRegister: %v: %v\n", v.filename, err)
+			configErrors++
+			continue
+		}
+
+		if dryRun {
+			printRegisterImage(os.Stdout, img, v, fileEntries, addrOffset)
+		}
+	}
+
+	// Find the input registers that should follow a setpoint in the
+	// holding registers.
+	var err error
+	p.links, err = parseLinks(p.entries[inputType], p.entries[holdingType])
+	if err != nil {
+		log.Printf("error: links: %v\n", err)
+		configErrors++
+	}
+
+	// Find the measurements with a companion entry holding their
+	// quality.
+	p.qualities, err = parseQualities(p.entries)
+	if err != nil {
+		log.Printf("error: quality: %v\n", err)
+		configErrors++
+	}
+
+	// Check the deadbands of the values generated by the links and
+	// blocks.
+	err = checkDeadbands(p.entries)
+	if err != nil {
+		log.Printf("error: deadband: %v\n", err)
+		configErrors++
+	}
+
+	// Find the entries the clients can only write after the unlock
+	// sequence is written.
+	p.writeLock, err = parseWriteLock(p.entries)
+	if err != nil {
+		log.Printf("error: write lock: %v\n", err)
+		configErrors++
+	}
+
+	// Find the entries the clients can only read after the password is
+	// written.
+	p.readLock, err = parseReadLock(p.entries)
+	if err != nil {
+		log.Printf("error: read lock: %v\n", err)
+		configErrors++
+	}
+
+	// Load the simulation blocks bound to the registers. The blocks are
+	// created for each server when set up, so each unit gets its own
+	// state.
+	if jsonBlocks != "" {
+		p.blocksRawData, err = loadConfigFileSources(jsonBlocks)
+		if err == nil {
+			_, err = parseBlocks(p.blocksRawData, p.entries, addrOffset, nil, 0)
+		}
+		if err != nil {
+			log.Printf("error: blocks: %v\n", err)
+			configErrors++
+			p.blocksRawData = nil
+		}
+	}
+
+	return p, configErrors
+}
+
+// loadValidEntries will load the entries, fill directives and consistency
+// groups of the register file, leaving out the entries that are not valid,
+// and the entries set at an address already set by another file unless
+// warnDuplicates is set. An error is logged for each entry left out, and
+// the number of them is returned. An error is returned if the file could
+// not be loaded at all.
+func loadValidEntries(v registerFile, used addressMap, warnDuplicates bool) ([]configEntry, []fill, []group, int, error) {
+	entries, fills, groups, errs, err := v.load()
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	for _, err := range errs {
+		log.Printf("error: %v\n", err)
+	}
+	n := len(errs)
+
+	dups := used.claim(v.registerType, v.unit, entries)
+	var valid []configEntry
+	for i, e := range entries {
+		err, ok := dups[i]
+		switch {
+		case !ok:
+			valid = append(valid, e)
+		case warnDuplicates:
+			log.Printf("warning: %v\n", err)
+			valid = append(valid, e)
+		default:
+			log.Printf("error: %v\n", err)
+			n++
+		}
+	}
+
+	return valid, fills, groups, n, nil
+}
+
+// entryErrorIndex will return the index of the entry the error of
+// setRegister was returned for, if the error is for a single entry.
+func entryErrorIndex(err error) (int, bool) {
+	var oe *orderError
+	if errors.As(err, &oe) {
+		return oe.index, true
+	}
+	var ae *addressError
+	if errors.As(err, &ae) {
+		return ae.index, true
+	}
+	return 0, false
+}
+
+// setEntries will set the values of the entries into the registers of the
+// server, leaving out the entries with an address that is not after the
+// entry before them. An error is logged for each entry left out, and the
+// entries set and the number of entries left out is returned.
+func setEntries(serv *mbserver.Server, v registerFile, entries []configEntry, addrOffset int) ([]configEntry, int, error) {
+	n := 0
+	for {
+		var registryData []encoder
+		for _, e := range entries {
+			registryData = append(registryData, e.enc)
+		}
+
+		err := setRegister(serv, registryData, string(v.registerType), addrOffset)
+		index, ok := entryErrorIndex(err)
+		if err == nil || !ok || index >= len(entries) {
+			return entries, n, err
+		}
+
+		log.Printf("error: setRegister: %v\n", withFile(v.filename, setRegisterError(entries, err)))
+		n++
+		entries = append(entries[:index:index], entries[index+1:]...)
+	}
+}
+
+// setRegisterError will return the error of setRegister with the position
+// of the entry it was returned for when known.
+func setRegisterError(entries []configEntry, err error) error {
+	if index, ok := entryErrorIndex(err); ok && index < len(entries) {
+		if _, ok := entries[index].source(); ok {
+			return entries[index].errorf("%v", err)
+		}
+	}
+	return err
+}
+
+// fleetDevice is a single device of a fleet config.
+type fleetDevice struct {
+	Name         string            `json:"name"`
+	Listen       string            `json:"listen"`
+	Units        string            `json:"units,omitempty"`
+	JSONCoil     string            `json:"jsonCoil,omitempty"`
+	JSONDiscrete string            `json:"jsonDiscrete,omitempty"`
+	JSONInput    string            `json:"jsonInput,omitempty"`
+	JSONHolding  string            `json:"jsonHolding,omitempty"`
+	JSONBlocks   string            `json:"jsonBlocks,omitempty"`
+	ConfigDir    string            `json:"configDir,omitempty"`
+	Layers       []string          `json:"layers,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Profile      string            `json:"profile,omitempty"`
+	// KeepAlive, IdleTimeout and MaxSessionDuration are durations like
+	// 5m overriding the flags with the same names for the device.
+	KeepAlive          string `json:"keepAlive,omitempty"`
+	IdleTimeout        string `json:"idleTimeout,omitempty"`
+	MaxSessionDuration string `json:"maxSessionDuration,omitempty"`
+	// MaxConnections overrides the flag with the same name for the
+	// device, like 1 for a device emulating a serial device server.
+	MaxConnections *int `json:"maxConnections,omitempty"`
+	// GatewayUnitMap and GatewayMBAP override the flags with the same
+	// names for the device.
+	GatewayUnitMap string `json:"gatewayUnitMap,omitempty"`
+	GatewayMBAP    *bool  `json:"gatewayMBAP,omitempty"`
+	// requestLimits overrides the function codes and the count limits
+	// of the flags for the device, and UnitLimits overrides them for the
+	// units given by their unit ID, e.g. {"2": {"maxReadCount": 10}}.
+	requestLimits
+	UnitLimits map[string]requestLimits `json:"unitLimits,omitempty"`
+}
+
+// unitLimits will check the request limits of the device and its units,
+// and return the limits of the units by their unit ID.
+func (v fleetDevice) unitLimits() (map[uint8]requestLimits, error) {
+	err := v.requestLimits.check()
+	if err != nil {
+		return nil, err
+	}
+
+	units := make(map[uint8]requestLimits)
+	for k, l := range v.UnitLimits {
+		ids, err := parseUnitIDs(k)
+		if err != nil || len(ids) != 1 {
+			return nil, fmt.Errorf("unitLimits: invalid unit ID %q, valid unit IDs are 1 to 247", k)
+		}
+		err = l.check()
+		if err != nil {
+			return nil, fmt.Errorf("unitLimits: unit %v: %v", ids[0], err)
+		}
+		units[ids[0]] = l
+	}
+	return units, nil
+}
+
+// connConfig will return the settings of the client connections of the
+// device, which are the settings given, overridden by the settings of the
+// device.
+func (v fleetDevice) connConfig(conn mbserver.ConnConfig) (mbserver.ConnConfig, error) {
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"keepAlive", v.KeepAlive, &conn.KeepAlive},
+		{"idleTimeout", v.IdleTimeout, &conn.IdleTimeout},
+		{"maxSessionDuration", v.MaxSessionDuration, &conn.MaxSessionDuration},
+	} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return conn, fmt.Errorf("%v must be a duration like 5m, got %q", d.name, d.value)
+		}
+		*d.dst = duration
+	}
+	if v.MaxConnections != nil {
+		conn.MaxConnections = *v.MaxConnections
+	}
+	if v.GatewayUnitMap != "" {
+		units, err := parseUnitMap(v.GatewayUnitMap)
+		if err != nil {
+			return conn, fmt.Errorf("gatewayUnitMap: %v", err)
+		}
+		conn.UnitMap = localUnits(units)
+	}
+
+	return conn, nil
+}
+
+// loadFleet will load the fleet config given, and return a device for
+// each of the devices in the config, loaded from their own config files.
+// The config files of the devices are relative to the fleet config, and
+// the connection settings given are used for the devices not setting
+// their own. The
+// number of errors found in the config files of the devices is returned,
+// and an error if the fleet config itself is not valid, or the registers
+// of a device could not be populated.
+func loadFleet(filename string, conn mbserver.ConnConfig, addrOffset int, dryRun bool, warnDuplicates bool) ([]*device, int, error) {
+	js, err := readConfig(filename)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read fleet config %v: %v", filename, err)
+	}
+	js, err = expandEnv(stripJSONC(js))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%v: %v", filename, err)
+	}
+
+	var fleet []fleetDevice
+	err = json.Unmarshal(js, &fleet)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%v: decoding json: %v", filename, err)
+	}
+
+	configErrors := 0
+	names := make(map[string]bool)
+	var devices []*device
+	for i, v := range fleet {
+		if v.Name == "" || v.Listen == "" {
+			return nil, configErrors, fmt.Errorf("%v: device %v: name and listen must be set", filename, i)
+		}
+		if names[v.Name] {
+			return nil, configErrors, fmt.Errorf("%v: device %v: duplicate name %v", filename, i, v.Name)
+		}
+		names[v.Name] = true
+		deviceConn, err := v.connConfig(conn)
+		if err != nil {
+			return nil, configErrors, fmt.Errorf("%v: device %v: %v", filename, v.Name, err)
+		}
+		// The unit map was checked by connConfig.
+		unitMap, _ := parseUnitMap(v.GatewayUnitMap)
+		unitLimits, err := v.unitLimits()
+		if err != nil {
+			return nil, configErrors, fmt.Errorf("%v: device %v: %v", filename, v.Name, err)
+		}
+
+		var registerFiles []registerFile
+		for _, rf := range []registerFile{
+			{filename: v.JSONCoil, registerType: coilType},
+			{filename: v.JSONDiscrete, registerType: discreteType},
+			{filename: v.JSONInput, registerType: inputType},
+			{filename: v.JSONHolding, registerType: holdingType},
+		} {
+			if rf.filename == "" {
+				continue
+			}
+			rf.filename, err = resolveInclude(filename, rf.filename)
+			if err != nil {
+				return nil, configErrors, fmt.Errorf("%v: device %v: %v", filename, v.Name, err)
+			}
+			registerFiles = append(registerFiles, rf)
+		}
+		if v.ConfigDir != "" {
+			dir, err := resolveInclude(filename, v.ConfigDir)
+			if err != nil {
+				return nil, configErrors, fmt.Errorf("%v: device %v: %v", filename, v.Name, err)
+			}
+			dirFiles, err := loadConfigDir(dir)
+			if err != nil {
+				return nil, configErrors, fmt.Errorf("%v: device %v: %v", filename, v.Name, err)
+			}
+			registerFiles = append(registerFiles, dirFiles...)
+		}
+		if len(v.Layers) > 0 {
+			var layers []string
+			for _, l := range v.Layers {
+				l, err = resolveInclude(filename, l)
+				if err != nil {
+					return nil, configErrors, fmt.Errorf("%v: device %v: %v", filename, v.Name, err)
+				}
+				layers = append(layers, l)
+			}
+			layerFiles, err := loadLayers(layers)
+			if err != nil {
+				return nil, configErrors, fmt.Errorf("%v: device %v: %v", filename, v.Name, err)
+			}
+			registerFiles = append(registerFiles, layerFiles...)
+		}
+		if len(registerFiles) == 0 {
+			return nil, configErrors, fmt.Errorf("%v: device %v: no config files given", filename, v.Name)
+		}
+
+		jsonBlocks := v.JSONBlocks
+		if jsonBlocks != "" {
+			jsonBlocks, err = resolveInclude(filename, jsonBlocks)
+			if err != nil {
+				return nil, configErrors, fmt.Errorf("%v: device %v: %v", filename, v.Name, err)
+			}
+		}
+
+		serv := mbserver.NewServer()
+		p, n := loadProfile(serv, registerFiles, jsonBlocks, addrOffset, dryRun, warnDuplicates)
+		configErrors += n
+
+		devices = append(devices, &device{
+			name:        v.Name,
+			labels:      v.Labels,
+			address:     v.Listen,
+			units:       v.Units,
+			conn:        deviceConn,
+			mbap:        v.GatewayMBAP,
+			unitMap:     unitMap,
+			limits:      v.requestLimits,
+			unitLimits:  unitLimits,
+			profileName: v.Profile,
+			serv:        serv,
+			profile:     p,
+		})
+	}
+
+	return devices, configErrors, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestLoadFleet(t *testing.T) {
+	dir := t.TempDir()
+
+	holding := `[{"type": "float32BigWordBigEndian", "number": 21.5, "regAddr": 201}]`
+	err := os.WriteFile(filepath.Join(dir, "holding.json"), []byte(holding), 0600)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	fleet := `[
+		{"name": "boiler-1", "listen": "127.0.0.1:10502", "units": "1,2", "jsonHolding": "holding.json", "labels": {"site": "north"}, "idleTimeout": "5m", "maxConnections": 1, "gatewayUnitMap": "1=11", "functionCodes": "3,4", "unitLimits": {"2": {"functionCodes": "3", "maxReadCount": 10}}},
+		{"name": "boiler-2", "listen": "127.0.0.1:10503", "jsonHolding": "holding.json"}
+	]`
+	fleetFile := filepath.Join(dir, "fleet.json")
+	err = os.WriteFile(fleetFile, []byte(fleet), 0600)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	conn := mbserver.ConnConfig{IdleTimeout: time.Minute, MaxSessionDuration: time.Hour}
+	devices, configErrors, err := loadFleet(fleetFile, conn, -1, false, false)
+	if err != nil || configErrors != 0 {
+		t.Fatalf("expected nil and 0 errors, got %v and %v", err, configErrors)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("expected %v devices, got %v", 2, len(devices))
+	}
+
+	d := devices[0]
+	if d.name != "boiler-1" || d.address != "127.0.0.1:10502" || d.units != "1,2" || d.labels["site"] != "north" {
+		t.Errorf("unexpected device %+v", d)
+	}
+	// The connection settings of the device override the flags.
+	expect := mbserver.ConnConfig{IdleTimeout: time.Minute * 5, MaxSessionDuration: time.Hour, MaxConnections: 1, UnitMap: map[uint8]uint8{1: 11}}
+	if !isEqual(expect, d.conn) {
+		t.Errorf("expected %+v, got %+v", expect, d.conn)
+	}
+	if *d.limits.FunctionCodes != "3,4" || *d.unitLimits[2].FunctionCodes != "3" || *d.unitLimits[2].MaxReadCount != 10 {
+		t.Errorf("expected function codes 3,4, and 3 and a max read count of 10 for unit 2, got %+v and %+v", d.limits, d.unitLimits[2])
+	}
+	if !isEqual(conn, devices[1].conn) {
+		t.Errorf("expected %+v, got %+v", conn, devices[1].conn)
+	}
+	v := d.profile.entries[holdingType][0].enc.Decode(d.serv.HoldingRegisters[200:202])
+	if v != 21.5 {
+		t.Errorf("expected %v, got %v", 21.5, v)
+	}
+
+	// Duplicate names are not allowed.
+	err = os.WriteFile(fleetFile, []byte(`[
+		{"name": "boiler-1", "listen": ":10502", "jsonHolding": "holding.json"},
+		{"name": "boiler-1", "listen": ":10503", "jsonHolding": "holding.json"}
+	]`), 0600)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	_, _, err = loadFleet(fleetFile, mbserver.ConnConfig{}, -1, false, false)
+	if err == nil {
+		t.Errorf("expected error for duplicate names, got %v", err)
+	}
+
+	// The limits of the device and its units are checked.
+	for _, fleet := range []string{
+		`[{"name": "boiler-1", "listen": ":10502", "jsonHolding": "holding.json", "functionCodes": "3,foo"}]`,
+		`[{"name": "boiler-1", "listen": ":10502", "jsonHolding": "holding.json", "unitLimits": {"2": {"functionCodes": "0"}}}]`,
+		`[{"name": "boiler-1", "listen": ":10502", "jsonHolding": "holding.json", "unitLimits": {"300": {"functionCodes": "3"}}}]`,
+		`[{"name": "boiler-1", "listen": ":10502", "jsonHolding": "holding.json", "maxReadCount": -1}]`,
+		`[{"name": "boiler-1", "listen": ":10502", "jsonHolding": "holding.json", "unitLimits": {"2": {"maxWriteCount": -1}}}]`,
+	} {
+		err = os.WriteFile(fleetFile, []byte(fleet), 0600)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		_, _, err = loadFleet(fleetFile, mbserver.ConnConfig{}, -1, false, false)
+		if err == nil {
+			t.Errorf("%v: expected error not nil, got %v", fleet, err)
+		}
+	}
+
+	// The connection settings must be durations.
+	err = os.WriteFile(fleetFile, []byte(`[
+		{"name": "boiler-1", "listen": ":10502", "jsonHolding": "holding.json", "idleTimeout": "5"}
+	]`), 0600)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	_, _, err = loadFleet(fleetFile, mbserver.ConnConfig{}, -1, false, false)
+	if err == nil {
+		t.Errorf("expected error for an idle timeout that is not a duration, got %v", err)
+	}
+}
+
+func TestLoadProfileLeavesOutErrors(t *testing.T) {
+	dir := t.TempDir()
+	holdingFile := filepath.Join(dir, "holding.json")
+	coilFile := filepath.Join(dir, "coil.json")
+	os.WriteFile(holdingFile, []byte(`[
+		{"type": "int16BigEndian", "number": 1, "regAddr": 3},
+		{"type": "noSuchType", "number": 2, "regAddr": 5},
+		{"type": "int16BigEndian", "number": 3, "regAddr": 9},
+		{"type": "int16BigEndian", "number": 4, "regAddr": 7}]`), 0644)
+	os.WriteFile(coilFile, []byte(`[{"type": "bit"`), 0644)
+	files := []registerFile{
+		{filename: coilFile, registerType: coilType},
+		{filename: holdingFile, registerType: holdingType},
+	}
+
+	serv := mbserver.NewServer()
+	p, configErrors := loadProfile(serv, files, "", -1, false, false)
+	if configErrors != 3 {
+		t.Fatalf("expected %v, got %v", 3, configErrors)
+	}
+	if len(p.entries[holdingType]) != 2 {
+		t.Fatalf("expected %v, got %v", 2, len(p.entries[holdingType]))
+	}
+	expect := []uint16{1, 3}
+	got := []uint16{serv.HoldingRegisters[2], serv.HoldingRegisters[8]}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
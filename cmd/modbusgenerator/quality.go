@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// qualityStates is the states of the quality of a measurement, in the
+// order of their codes in quality.codes, and qualityFields the fields of
+// the entries giving the codes.
+var (
+	qualityStates = []string{"good", "uncertain", "bad"}
+	qualityFields = []string{"qualityGood", "qualityUncertain", "qualityBad"}
+)
+
+// quality is an input or holding register entry holding a measurement,
+// with a companion entry holding the quality of the measurement, like
+// many RTUs report the quality of their data.
+type quality struct {
+	rt          registerType
+	measurement configEntry
+	companion   configEntry
+	// codes is the number written to the companion entry for each of
+	// the qualityStates.
+	codes [3]float64
+	// The measurement is uncertain outside min and max.
+	min float64
+	max float64
+}
+
+// parseQualities will return a quality for each of the input and holding
+// register entries with a "quality" field, which is the address of the
+// companion entry of the same register type. The codes written for the
+// good, uncertain and bad states are given with the "qualityGood",
+// "qualityUncertain" and "qualityBad" fields, which are 0, 1 and 2 by
+// default, and the measurement is uncertain outside the range given with
+// the "qualityRange" field.
+func parseQualities(entries map[registerType][]configEntry) ([]quality, error) {
+	var qualities []quality
+	for _, rt := range historyRegisterTypes {
+		for _, v := range entries[rt] {
+			c, ok := v.raw["quality"]
+			if !ok {
+				continue
+			}
+			if rt != inputType && rt != holdingType {
+				return nil, v.errorf("quality is only supported for input and holding registers, got %v", rt)
+			}
+
+			addr, ok := c.(float64)
+			if !ok {
+				return nil, v.errorf("quality must be the address of a %v register entry, got %v", rt, c)
+			}
+			q := quality{rt: rt, measurement: v, min: math.Inf(-1), max: math.Inf(1)}
+			found := false
+			for _, e := range entries[rt] {
+				if e.enc.Address() == int(addr) {
+					q.companion = e
+					found = true
+				}
+			}
+			if !found {
+				return nil, v.errorf("no %v register entry found at quality address %v", rt, addr)
+			}
+			if q.companion.enc.Address() == v.enc.Address() {
+				return nil, v.errorf("quality must be the address of another entry, got %v", addr)
+			}
+
+			for i, name := range qualityFields {
+				q.codes[i] = float64(i)
+				if n, ok := v.raw[name]; ok {
+					q.codes[i], ok = n.(float64)
+					if !ok {
+						return nil, v.errorf("%v must be a number, got %v", name, n)
+					}
+				}
+			}
+
+			if r, ok := v.raw["qualityRange"]; ok {
+				l, ok := r.([]interface{})
+				if !ok || len(l) != 2 {
+					return nil, v.errorf("qualityRange must be a list with the lowest and highest good value, got %v", r)
+				}
+				min, ok1 := l[0].(float64)
+				max, ok2 := l[1].(float64)
+				if !ok1 || !ok2 || min > max {
+					return nil, v.errorf("qualityRange must be a list with the lowest and highest good value, got %v", r)
+				}
+				q.min, q.max = min, max
+			}
+
+			qualities = append(qualities, q)
+		}
+	}
+
+	return qualities, nil
+}
+
+// qualityValue is the quality of a measurement of a server.
+type qualityValue struct {
+	quality
+	// state is the state of the quality written last, and override the
+	// state set at runtime, which is empty when the state is found from
+	// the value of the measurement.
+	state    string
+	override string
+}
+
+// update will write the code of the state of the quality to the companion
+// entry. Without an override the measurement is bad when not a number,
+// uncertain outside the quality range, and good otherwise. The server
+// must be locked by the caller.
+func (q *qualityValue) update(serv *mbserver.Server, addrOffset int) {
+	state := q.override
+	if state == "" {
+		v, _ := entryValue(serv, q.rt, q.measurement, addrOffset)
+		switch {
+		case math.IsNaN(v) || math.IsInf(v, 0):
+			state = "bad"
+		case v < q.min || v > q.max:
+			state = "uncertain"
+		default:
+			state = "good"
+		}
+	}
+
+	q.state = state
+	for i, s := range qualityStates {
+		if s == state {
+			writeEntry(serv, q.rt, q.companion, q.codes[i], addrOffset)
+		}
+	}
+}
+
+// startQualities will write the quality of the measurements given to
+// their companion entries at start, and then at every interval of the
+// simulation clock. It returns the qualities of the server, so they can
+// be overridden at runtime.
+func startQualities(serv *mbserver.Server, qualities []quality, addrOffset int, interval time.Duration, clk *simClock) []*qualityValue {
+	if len(qualities) == 0 {
+		return nil
+	}
+
+	var values []*qualityValue
+	serv.Lock()
+	for _, q := range qualities {
+		qv := &qualityValue{quality: q}
+		qv.update(serv, addrOffset)
+		values = append(values, qv)
+	}
+	serv.Unlock()
+
+	go runSteps(serv, clk, interval, func(dt time.Duration) {
+		for _, qv := range values {
+			qv.update(serv, addrOffset)
+		}
+	})
+
+	return values
+}
+
+// qualityControl sets the quality of the measurements of the devices at
+// runtime.
+type qualityControl struct {
+	devices    []*device
+	addrOffset int
+}
+
+// qualityStatus is the quality of a measurement returned by the quality
+// endpoints.
+type qualityStatus struct {
+	Device   string `json:"device,omitempty"`
+	Register string `json:"register"`
+	Address  int    `json:"address"`
+	Quality  int    `json:"quality"`
+	State    string `json:"state"`
+	// Override is true when the state is set at runtime.
+	Override bool `json:"override"`
+}
+
+// qualityStatuses will return the quality of the measurements of all the
+// devices. The measurements are the same for each unit of a device, so
+// the quality of the server of the device is returned.
+func (qc *qualityControl) qualityStatuses() []qualityStatus {
+	statuses := []qualityStatus{}
+	for _, d := range qc.devices {
+		if len(d.qualities) == 0 {
+			continue
+		}
+
+		d.serv.Lock()
+		for _, q := range d.qualities[0] {
+			statuses = append(statuses, qualityStatus{
+				Device:   d.name,
+				Register: string(q.rt),
+				Address:  q.measurement.enc.Address(),
+				Quality:  q.companion.enc.Address(),
+				State:    q.state,
+				Override: q.override != "",
+			})
+		}
+		d.serv.Unlock()
+	}
+
+	return statuses
+}
+
+// handleQuality answers with the quality of the measurements.
+func (qc *qualityControl) handleQuality(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, qc.qualityStatuses())
+}
+
+// handleQualitySet will set the quality of the measurement at the address
+// of the register given to the state given with the state query
+// parameter, which is good, uncertain or bad, in all the units of the
+// devices, e.g. /quality/set?register=input&address=101&state=bad. The
+// state auto finds the quality from the value of the measurement again.
+// The device query parameter selects the device of a fleet, and all the
+// devices are selected if not given.
+func (qc *qualityControl) handleQualitySet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "good", "uncertain", "bad":
+	case "auto":
+		state = ""
+	default:
+		http.Error(w, fmt.Sprintf("state must be one of good|uncertain|bad|auto, got %q", state), http.StatusBadRequest)
+		return
+	}
+
+	devices, err := selectDevices(qc.devices, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	rt, addr, err := parseRegisterQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	found := false
+	for _, d := range devices {
+		d.serv.Lock()
+		for i, qualities := range d.qualities {
+			for _, q := range qualities {
+				if q.rt == rt && q.measurement.enc.Address() == addr {
+					q.override = state
+					q.update(d.servers[i], qc.addrOffset)
+					found = true
+				}
+			}
+		}
+		d.serv.Unlock()
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("no measurement with a quality at %v %v", rt, addr), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, qc.qualityStatuses())
+}
+
+// printQualityStatuses will write the quality of the measurements as a
+// table, with the name of the device when any of them has one.
+func printQualityStatuses(w io.Writer, statuses []qualityStatus) {
+	withName := false
+	for _, st := range statuses {
+		if st.Device != "" {
+			withName = true
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"REGISTER", "ADDRESS", "QUALITY", "STATE"}
+	if withName {
+		header = append([]string{"DEVICE"}, header...)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, st := range statuses {
+		state := st.State
+		if !st.Override {
+			state += " (auto)"
+		}
+		row := []string{st.Register, strconv.Itoa(st.Address), strconv.Itoa(st.Quality), state}
+		if withName {
+			row = append([]string{st.Device}, row...)
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
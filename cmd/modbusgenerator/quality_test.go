@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestQualities(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		inputType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 20.0, "regAddr": 1.0, "quality": 3.0, "qualityRange": []interface{}{0.0, 100.0}},
+			{"type": "uint16", "number": 0.0, "regAddr": 3.0},
+			{"type": "float32BigWordBigEndian", "number": 5.0, "regAddr": 4.0, "quality": 6.0, "qualityGood": 192.0, "qualityBad": 24.0},
+			{"type": "uint16", "number": 0.0, "regAddr": 6.0},
+		}),
+	}
+	qualities, err := parseQualities(entries)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(qualities) != 2 || qualities[1].codes != [3]float64{192, 1, 24} {
+		t.Fatalf("expected 2 qualities with the codes given, got %+v", qualities)
+	}
+
+	serv := mbserver.NewServer()
+	serv.InputRegisters = make([]uint16, 10)
+	copy(serv.InputRegisters, encodeNumber(entries[inputType][0], 20))
+	copy(serv.InputRegisters[3:], encodeNumber(entries[inputType][2], 5))
+	clk := newSimClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+	values := startQualities(serv, qualities, -1, time.Hour, clk)
+	if !isEqual([]uint16{0, 192}, []uint16{serv.InputRegisters[2], serv.InputRegisters[5]}) {
+		t.Errorf("expected good, got %v and %v", serv.InputRegisters[2], serv.InputRegisters[5])
+	}
+
+	// Outside the quality range is uncertain, and not a number is bad.
+	copy(serv.InputRegisters, encodeNumber(entries[inputType][0], 120))
+	copy(serv.InputRegisters[3:], encodeNumber(entries[inputType][2], math.NaN()))
+	for _, qv := range values {
+		qv.update(serv, -1)
+	}
+	if !isEqual([]uint16{1, 24}, []uint16{serv.InputRegisters[2], serv.InputRegisters[5]}) {
+		t.Errorf("expected uncertain and bad, got %v and %v", serv.InputRegisters[2], serv.InputRegisters[5])
+	}
+
+	// The quality set at runtime is kept until set to auto.
+	d := &device{serv: serv, servers: []*mbserver.Server{serv}, qualities: [][]*qualityValue{values}}
+	qc := &qualityControl{devices: []*device{d}, addrOffset: -1}
+	for _, v := range []struct {
+		query    string
+		code     int
+		expected uint16
+	}{
+		{"register=input&address=1&state=bad", http.StatusOK, 2},
+		{"register=input&address=1&state=auto", http.StatusOK, 1},
+		{"register=input&address=1&state=broken", http.StatusBadRequest, 1},
+		{"register=input&address=3&state=bad", http.StatusNotFound, 1},
+	} {
+		rec := httptest.NewRecorder()
+		qc.handleQualitySet(rec, httptest.NewRequest(http.MethodPost, "/quality/set?"+v.query, nil))
+		if rec.Code != v.code {
+			t.Errorf("%v: expected %v, got %v", v.query, v.code, rec.Code)
+		}
+		if serv.InputRegisters[2] != v.expected {
+			t.Errorf("%v: expected %v, got %v", v.query, v.expected, serv.InputRegisters[2])
+		}
+	}
+}
+
+func TestParseQualitiesInvalid(t *testing.T) {
+	for _, raw := range []map[string]interface{}{
+		{"type": "uint16", "number": 0.0, "regAddr": 1.0, "quality": 9.0},
+		{"type": "uint16", "number": 0.0, "regAddr": 1.0, "quality": 1.0},
+		{"type": "uint16", "number": 0.0, "regAddr": 1.0, "quality": "2"},
+		{"type": "uint16", "number": 0.0, "regAddr": 1.0, "quality": 2.0, "qualityBad": "bad"},
+		{"type": "uint16", "number": 0.0, "regAddr": 1.0, "quality": 2.0, "qualityRange": []interface{}{10.0, 0.0}},
+	} {
+		entries := map[registerType][]configEntry{
+			inputType: testEntries([]map[string]interface{}{raw, {"type": "uint16", "number": 0.0, "regAddr": 2.0}}),
+		}
+		if _, err := parseQualities(entries); err == nil {
+			t.Errorf("expected error for %v, got nil", raw)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// parseEntryRandomize will return the range of the "randomize" field of
+// the raw config entry given, which is a list with the lowest and highest
+// initial value of the entry, and false if the entry has none.
+func parseEntryRandomize(raw map[string]interface{}) (float64, float64, bool, error) {
+	f, ok := raw["randomize"]
+	if !ok {
+		return 0, 0, false, nil
+	}
+
+	r, ok := f.([]interface{})
+	if !ok || len(r) != 2 {
+		return 0, 0, false, fmt.Errorf("randomize must be a list with the lowest and highest value, got %v", f)
+	}
+	min, ok1 := r[0].(float64)
+	max, ok2 := r[1].(float64)
+	if !ok1 || !ok2 || min > max {
+		return 0, 0, false, fmt.Errorf("randomize must be a list with the lowest and highest value, got %v", f)
+	}
+	if !isFloatEntry(raw) && math.Ceil(min) > math.Floor(max) {
+		return 0, 0, false, fmt.Errorf("randomize must include a whole number for the type %v, got %v", raw["type"], f)
+	}
+
+	return min, max, true, nil
+}
+
+// isFloatEntry will return true if the type of the raw config entry given
+// is one of the float types, and false for the integer and bit types.
+func isFloatEntry(raw map[string]interface{}) bool {
+	typ, _ := raw["type"].(string)
+	return strings.HasPrefix(resolveTypeAlias(typ), "float32")
+}
+
+// applyRandomize will write a random initial value within the range of
+// the "randomize" field of the entries into the registers of the server,
+// where the entries of the integer types get a whole number. The values
+// are only set when the server is set up, so they are static while the
+// simulator runs.
+func applyRandomize(serv *mbserver.Server, entries map[registerType][]configEntry, rng *rand.Rand, addrOffset int) {
+	for _, rt := range historyRegisterTypes {
+		for _, e := range entries[rt] {
+			min, max, ok, _ := parseEntryRandomize(e.raw)
+			if !ok {
+				continue
+			}
+
+			var n float64
+			if isFloatEntry(e.raw) {
+				n = min + rng.Float64()*(max-min)
+			} else {
+				lo, hi := math.Ceil(min), math.Floor(max)
+				n = lo + float64(rng.Int63n(int64(hi-lo)+1))
+			}
+			writeEntry(serv, rt, e, n, addrOffset)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestApplyRandomize(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		coilType: testEntries([]map[string]interface{}{
+			{"type": "bit", "number": 0.0, "regAddr": 1.0, "randomize": []interface{}{0.0, 1.0}},
+		}),
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 20.0, "regAddr": 1.0, "randomize": []interface{}{19.5, 20.5}},
+			{"type": "uint16", "number": 7.0, "regAddr": 3.0, "randomize": []interface{}{0.5, 3.5}},
+			{"type": "uint16", "number": 9.0, "regAddr": 4.0},
+		}),
+	}
+
+	floats := make(map[float64]bool)
+	ints := make(map[uint16]bool)
+	for seed := int64(0); seed < 50; seed++ {
+		serv := mbserver.NewServer()
+		applyRandomize(serv, entries, rand.New(rand.NewSource(seed)), -1)
+
+		f := entries[holdingType][0].enc.Decode(serv.HoldingRegisters[0:2])
+		if f < 19.5 || f > 20.5 {
+			t.Errorf("expected a value from 19.5 to 20.5, got %v", f)
+		}
+		floats[f] = true
+		n := serv.HoldingRegisters[2]
+		if n < 1 || n > 3 {
+			t.Errorf("expected a value from 1 to 3, got %v", n)
+		}
+		ints[n] = true
+		if serv.HoldingRegisters[3] != 0 {
+			t.Errorf("expected %v, got %v", 0, serv.HoldingRegisters[3])
+		}
+		if serv.Coils[0] > 1 {
+			t.Errorf("expected 0 or 1, got %v", serv.Coils[0])
+		}
+	}
+	if len(floats) < 40 || len(ints) != 3 {
+		t.Errorf("expected varied values, got %v and %v", floats, ints)
+	}
+
+	// The same seed gives the same values.
+	a, b := mbserver.NewServer(), mbserver.NewServer()
+	applyRandomize(a, entries, rand.New(rand.NewSource(1)), -1)
+	applyRandomize(b, entries, rand.New(rand.NewSource(1)), -1)
+	if !isEqual(a.HoldingRegisters[0:3], b.HoldingRegisters[0:3]) {
+		t.Errorf("expected %v, got %v", a.HoldingRegisters[0:3], b.HoldingRegisters[0:3])
+	}
+}
+
+func TestParseEntryRandomizeInvalid(t *testing.T) {
+	for _, raw := range []map[string]interface{}{
+		{"type": "uint16", "randomize": 1.0},
+		{"type": "uint16", "randomize": []interface{}{1.0}},
+		{"type": "uint16", "randomize": []interface{}{2.0, 1.0}},
+		{"type": "uint16", "randomize": []interface{}{"1", 2.0}},
+		{"type": "uint16", "randomize": []interface{}{1.2, 1.8}},
+	} {
+		if _, _, _, err := parseEntryRandomize(raw); err == nil {
+			t.Errorf("expected error for %v, got nil", raw)
+		}
+	}
+
+	if _, _, _, err := parseEntryRandomize(map[string]interface{}{"type": "float32_abcd", "randomize": []interface{}{1.2, 1.8}}); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
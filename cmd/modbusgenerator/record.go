@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// writeFunctions is the function codes of the write requests that are
+// recorded.
+var writeFunctions = []uint8{5, 6, 15, 16}
+
+// writeRecord is a single recorded write request, written as a line of
+// JSON to the record file.
+type writeRecord struct {
+	Time     time.Time `json:"time"`
+	Device   string    `json:"device,omitempty"`
+	Unit     uint8     `json:"unit"`
+	Function uint8     `json:"function"`
+	// Data is the hex encoded data of the request.
+	Data string `json:"data"`
+}
+
+// recordWriter writes the write records to a file, and can be shared
+// by the servers of all the devices.
+type recordWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+func newRecordWriter(w io.Writer) *recordWriter {
+	return &recordWriter{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// write will write a record of the request given.
+func (rw *recordWriter) write(deviceName string, fc uint8, frame mbserver.Framer) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	err := rw.enc.Encode(writeRecord{
+		Time:     rw.now(),
+		Device:   deviceName,
+		Unit:     frame.GetDevice(),
+		Function: fc,
+		Data:     hex.EncodeToString(frame.GetData()),
+	})
+	if err != nil {
+		log.Printf("error: recording write: %v\n", err)
+	}
+}
+
+// recordWrites will wrap the write functions of the server, so every
+// successful write request is recorded with the record writer.
+func recordWrites(serv *mbserver.Server, deviceName string, rw *recordWriter) {
+	for _, fc := range writeFunctions {
+		function := serv.FunctionHandler(fc)
+		if function == nil {
+			continue
+		}
+
+		fc := fc
+		serv.RegisterFunctionHandler(fc, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+			res, exception := function(s, frame)
+			if exception == &mbserver.Success {
+				rw.write(deviceName, fc, frame)
+			}
+			return res, exception
+		})
+	}
+}
+
+// readRecords will read the write records from the file given.
+func readRecords(filename string) ([]writeRecord, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var records []writeRecord
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var r writeRecord
+		err := json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			return nil, fmt.Errorf("%v: line %v: %v", filename, line, err)
+		}
+		if _, err := hex.DecodeString(r.Data); err != nil {
+			return nil, fmt.Errorf("%v: line %v: data: %v", filename, line, err)
+		}
+		records = append(records, r)
+	}
+
+	return records, scanner.Err()
+}
+
+// replayWrites will apply the write records given to the devices, to the
+// device with the same name as the record, and the unit of the device
+// with the unit ID of the record. Records for units that are not added
+// to the device are applied to the server of the device. The records are
+// applied with the same time between them as when they were recorded,
+// unless immediate is true.
+func replayWrites(devices []*device, records []writeRecord, immediate bool) error {
+	byName := make(map[string]*device)
+	for _, d := range devices {
+		byName[d.name] = d
+	}
+
+	for i, r := range records {
+		d, ok := byName[r.Device]
+		if !ok {
+			return fmt.Errorf("record %v: no device named %q", i, r.Device)
+		}
+
+		if i > 0 && !immediate {
+			time.Sleep(r.Time.Sub(records[i-1].Time))
+		}
+
+		s := d.serv
+		if u, ok := d.serv.Units()[r.Unit]; ok {
+			s = u
+		}
+
+		data, _ := hex.DecodeString(r.Data)
+		frame := &mbserver.TCPFrame{Device: r.Unit, Function: r.Function, Data: data}
+
+		d.serv.Lock()
+		function := s.FunctionHandler(r.Function)
+		exception := &mbserver.IllegalFunction
+		if function != nil {
+			_, exception = function(s, frame)
+		}
+		d.serv.Unlock()
+
+		if exception != &mbserver.Success {
+			log.Printf("error: replay: record %v: function %v answered with %v\n", i, r.Function, exception.String())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "session.jsonl")
+	fh, err := os.Create(recordFile)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	rw := newRecordWriter(fh)
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	rw.now = func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}
+	recordWrites(serv, "", rw)
+
+	// A successful write and a failing write, where only the
+	// successful write should be recorded.
+	var frame mbserver.TCPFrame
+	frame.Function = 16
+	mbserver.SetDataWithRegisterAndNumberAndValues(&frame, 200, 2, []uint16{0x41ac, 0x0000})
+	serv.FunctionHandler(16)(serv, &frame)
+
+	var bad mbserver.TCPFrame
+	bad.Function = 16
+	mbserver.SetDataWithRegisterAndNumberAndValues(&bad, 65535, 2, []uint16{1, 2})
+	serv.FunctionHandler(16)(serv, &bad)
+
+	frame.Function = 6
+	mbserver.SetDataWithRegisterAndNumber(&frame, 210, 7)
+	serv.FunctionHandler(6)(serv, &frame)
+	fh.Close()
+
+	records, err := readRecords(recordFile)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected %v records, got %v", 2, len(records))
+	}
+
+	// Replay against a fresh register image.
+	fresh := &device{serv: mbserver.NewServer()}
+	err = replayWrites([]*device{fresh}, records, false)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	expect := []uint16{0x41ac, 0x0000}
+	if !isEqual(expect, fresh.serv.HoldingRegisters[200:202]) || fresh.serv.HoldingRegisters[210] != 7 {
+		t.Errorf("expected %v and %v, got %v and %v", expect, 7, fresh.serv.HoldingRegisters[200:202], fresh.serv.HoldingRegisters[210])
+	}
+
+	// Records for an unknown device is an error.
+	records[0].Device = "unknown"
+	err = replayWrites([]*device{fresh}, records, true)
+	if err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
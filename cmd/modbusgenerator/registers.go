@@ -0,0 +1,526 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// registerValue is the value of a register returned by the registers
+// endpoint.
+type registerValue struct {
+	Device   string `json:"device,omitempty"`
+	Unit     int    `json:"unit,omitempty"`
+	Register string `json:"register"`
+	Address  int    `json:"address"`
+	Type     string `json:"type"`
+	// Value is left out when not a finite number, since JSON do not
+	// support NaN or infinity. Text is the value formatted as text, which
+	// is also given for NaN and infinity.
+	Value *float64 `json:"value,omitempty"`
+	Text  string   `json:"text"`
+	// EngineeringUnit is the unit of the entry when the value is scaled
+	// to the engineering unit.
+	EngineeringUnit string `json:"engineeringUnit,omitempty"`
+}
+
+// registerControl reads and writes the values of the registers of the
+// devices at runtime.
+type registerControl struct {
+	devices    []*device
+	addrOffset int
+}
+
+// registerEntry will return the config entry for reading or writing the
+// value at the address of the register given, with the type given. When
+// no type is given the type of the config entry at the address is used,
+// or wordInt16BigEndian for the coil and discrete registers and
+// uint16BigEndian for the input and holding registers when there is no
+// entry at the address.
+func registerEntry(p profile, rt registerType, addr int, typ string, n float64) (configEntry, error) {
+	if typ == "" {
+		for _, e := range p.entries[rt] {
+			if e.enc.Address() == addr {
+				typ = entryType(e.enc)
+			}
+		}
+	}
+	if typ == "" {
+		typ = "uint16BigEndian"
+		if rt == coilType || rt == discreteType {
+			typ = "wordInt16BigEndian"
+		}
+	}
+
+	raw := map[string]interface{}{"type": typ, "number": n, "regAddr": float64(addr)}
+	enc := NewEncoder(raw)
+	if enc == nil {
+		return configEntry{}, fmt.Errorf("unknown type %v", typ)
+	}
+	if v, ok := enc.(validator); ok {
+		if err := v.validate(); err != nil {
+			return configEntry{}, err
+		}
+	}
+	e := configEntry{enc: enc, raw: raw}
+	if err := checkEntryType(rt, e); err != nil {
+		return configEntry{}, err
+	}
+
+	return e, nil
+}
+
+// entryMetaAt will return the metadata of the config entry of the profile
+// at the address of the register type given, which has a scale of 1 when
+// there is no entry at the address.
+func entryMetaAt(p profile, rt registerType, addr int) entryMeta {
+	for _, e := range p.entries[rt] {
+		if e.enc.Address() == addr {
+			return e.meta()
+		}
+	}
+	return entryMeta{Scale: 1}
+}
+
+// parseRegisterQuery will return the register type and address given
+// with the register and address query parameters.
+func parseRegisterQuery(r *http.Request) (registerType, int, error) {
+	rt := registerType(r.URL.Query().Get("register"))
+	switch rt {
+	case coilType, discreteType, inputType, holdingType:
+	default:
+		return rt, 0, fmt.Errorf("register must be one of coil|discrete|input|holding, got %q", rt)
+	}
+
+	addr, err := strconv.Atoi(r.URL.Query().Get("address"))
+	if err != nil || addr < 0 || addr > 65535 {
+		return rt, 0, fmt.Errorf("address must be 0 to 65535, got %q", r.URL.Query().Get("address"))
+	}
+
+	return rt, addr, nil
+}
+
+// parseUnitQuery will return the unit ID given with the unit query
+// parameter, or 0 for the server of the device when not given.
+func parseUnitQuery(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("unit")
+	if s == "" {
+		return 0, nil
+	}
+	unit, err := strconv.Atoi(s)
+	if err != nil || unit < 0 || unit > 255 {
+		return 0, fmt.Errorf("unit must be a unit ID, got %q", s)
+	}
+	return unit, nil
+}
+
+// unitServer will return the server of the unit of the device given,
+// which is the server of the device itself for unit 0, or an error if the
+// device has no such unit.
+func unitServer(d *device, unit int) (*mbserver.Server, error) {
+	if unit == 0 {
+		return d.serv, nil
+	}
+	s, ok := d.serv.Units()[uint8(unit)]
+	if !ok {
+		return nil, fmt.Errorf("device %v has no unit %v", d.name, unit)
+	}
+	return s, nil
+}
+
+// parseScaledQuery will return true if the values are given and answered
+// in the engineering unit of the entries, as given with the scaled query
+// parameter.
+func parseScaledQuery(r *http.Request) (bool, error) {
+	s := r.URL.Query().Get("scaled")
+	if s == "" {
+		return false, nil
+	}
+	scaled, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("scaled must be true or false, got %q", s)
+	}
+	return scaled, nil
+}
+
+// parseNumber will parse a number given as text, which can also be one
+// of the float special values like NaN.
+func parseNumber(s string) (float64, error) {
+	n, err := strconv.ParseFloat(s, 64)
+	if err == nil {
+		return n, nil
+	}
+	return parseSpecialNumber(s)
+}
+
+// handleRegisters answers with the value at the address of the register
+// given, e.g. /registers?register=holding&address=201&type=float32_abcd.
+// With POST the value given with the value query parameter is written to
+// the registers before answering. The address is given as in the config
+// files, and the type is the type of the config entry at the address if
+// not given. The device query parameter selects the device of a fleet,
+// and the unit query parameter the unit, where the server of the device
+// is used if not given, and 404 is answered if a device has no such
+// unit. With scaled=true the values are given and
+// answered in the engineering unit of the entry, as given by its scale
+// and offset, and converted to and from the value of the registers.
+func (rc *registerControl) handleRegisters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed, use GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	devices, err := selectDevices(rc.devices, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	rt, addr, err := parseRegisterQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	unit, err := parseUnitQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scaled, err := parseScaledQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var n float64
+	if r.Method == http.MethodPost {
+		n, err = parseNumber(r.URL.Query().Get("value"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("value: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Find the servers of the unit before anything is written, so an
+	// unknown unit writes nothing.
+	servers := make([]*mbserver.Server, len(devices))
+	for i, d := range devices {
+		servers[i], err = unitServer(d, unit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	values := []registerValue{}
+	for i, d := range devices {
+		m := entryMetaAt(d.profile, rt, addr)
+		raw := n
+		if scaled {
+			raw = m.unscaled(n)
+		}
+
+		e, err := registerEntry(d.profile, rt, addr, r.URL.Query().Get("type"), raw)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		serv := servers[i]
+		d.serv.Lock()
+		old, _ := entryValue(serv, rt, e, rc.addrOffset)
+		if r.Method == http.MethodPost {
+			writeEntry(serv, rt, e, raw, rc.addrOffset)
+		}
+		v, ok := entryValue(serv, rt, e, rc.addrOffset)
+		d.serv.Unlock()
+		if !ok {
+			http.Error(w, fmt.Sprintf("address %v is outside the %v registers", addr, rt), http.StatusBadRequest)
+			return
+		}
+
+		rawText := strconv.FormatFloat(v, 'g', -1, 64)
+		if scaled {
+			v = m.scaled(v)
+		}
+		rv := registerValue{
+			Device:   d.name,
+			Unit:     unit,
+			Register: string(rt),
+			Address:  addr,
+			Type:     entryType(e.enc),
+			Text:     strconv.FormatFloat(v, 'g', -1, 64),
+		}
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			rv.Value = &v
+		}
+		if scaled {
+			rv.EngineeringUnit = m.Unit
+		}
+		values = append(values, rv)
+
+		// The audit log has the values of the registers.
+		if rec := auditFromContext(r.Context()); rec != nil && r.Method == http.MethodPost {
+			rec.Changes = append(rec.Changes, auditChange{
+				Device:   rv.Device,
+				Unit:     rv.Unit,
+				Register: rv.Register,
+				Address:  rv.Address,
+				Type:     rv.Type,
+				Old:      strconv.FormatFloat(old, 'g', -1, 64),
+				New:      rawText,
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, values)
+}
+
+// batchChange is a value written to the registers with a batch of changes
+// to the registers/batch endpoint.
+type batchChange struct {
+	Register string `json:"register"`
+	Address  int    `json:"address"`
+	Type     string `json:"type,omitempty"`
+	// Value is a number, a float special value like "NaN", or a boolean
+	// literal like true or "on" for the coils and discrete inputs.
+	Value interface{} `json:"value"`
+}
+
+// number will return the value of the change as a number.
+func (c batchChange) number() (float64, error) {
+	if n, ok := parseBoolNumber(c.Value); ok {
+		return n, nil
+	}
+	switch v := c.Value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		return parseNumber(v)
+	}
+	return 0, fmt.Errorf("value must be a number, got %v", c.Value)
+}
+
+// tables holds the table of the Modbus memory of each register type.
+var tables = map[registerType]mbserver.Table{
+	coilType:     mbserver.CoilsTable,
+	discreteType: mbserver.DiscreteInputsTable,
+	inputType:    mbserver.InputRegistersTable,
+	holdingType:  mbserver.HoldingRegistersTable,
+}
+
+// entryUpdate will return the update writing the words given into the
+// registers at the address of the entry, the same way as writeEntry.
+func entryUpdate(rt registerType, e configEntry, words []uint16, addrOffset int) mbserver.RegisterUpdate {
+	u := mbserver.RegisterUpdate{Table: tables[rt], Address: e.enc.Address() + addrOffset}
+	if rt == coilType || rt == discreteType {
+		u.Bits = wordToCoils(e.enc, words[0])
+	} else {
+		u.Words = words
+	}
+	return u
+}
+
+// deviceBatch is the changes of a batch written to the server of a
+// device.
+type deviceBatch struct {
+	device  *device
+	serv    *mbserver.Server
+	entries []configEntry
+	metas   []entryMeta
+	updates []mbserver.RegisterUpdate
+	// written is the values written, and old the values overwritten,
+	// which are only read for the audit log.
+	written []float64
+	old     []float64
+}
+
+// applyBatches will lock the servers of all the devices of the batches,
+// check that all the updates are within the registers, and then write
+// all of them, so nothing is written to any device if any of the changes
+// is outside the registers. With readOld the values overwritten are read
+// into the batches first. The update hooks of the servers are called
+// after the locks are released.
+func applyBatches(batches []deviceBatch, changes []batchChange, readOld bool, addrOffset int) error {
+	// The devices are always locked in the order given, so two batches
+	// can not wait for the locks of each other.
+	for _, b := range batches {
+		b.device.serv.Lock()
+	}
+	unlock := func() {
+		for _, b := range batches {
+			b.device.serv.Unlock()
+		}
+	}
+
+	for _, b := range batches {
+		if err := b.serv.CheckUpdates(b.updates); err != nil {
+			unlock()
+			return err
+		}
+	}
+
+	for i := range batches {
+		b := &batches[i]
+		if readOld {
+			for j, e := range b.entries {
+				v, _ := entryValue(b.serv, registerType(changes[j].Register), e, addrOffset)
+				b.old = append(b.old, v)
+			}
+		}
+		// The updates are checked above, so they are all written.
+		b.serv.ApplyUpdatesLocked(b.updates)
+	}
+	unlock()
+
+	for _, b := range batches {
+		if b.serv.UpdateHook != nil && len(b.updates) > 0 {
+			b.serv.UpdateHook(b.updates)
+		}
+	}
+
+	return nil
+}
+
+// handleBatch will write all the changes given as a JSON list in the body
+// of a POST, like [{"register": "holding", "address": 201, "value": 21.5}],
+// to the registers of each device in one go, so the clients see either
+// none or all of the changes, and a simulation updating many registers at
+// a high rate only takes the lock of the server once for each batch. The
+// device and unit query parameters select the device and unit like for
+// the registers endpoint. The changes are written to all the devices
+// while holding the locks of all of them, and nothing is written to any
+// device if any of the changes is outside the registers of a device. It
+// answers with the values written.
+// With scaled=true the values are given and answered in the engineering
+// unit of the entries, like for the registers endpoint.
+func (rc *registerControl) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	devices, err := selectDevices(rc.devices, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	unit, err := parseUnitQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scaled, err := parseScaledQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var changes []batchChange
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		http.Error(w, fmt.Sprintf("decoding the changes: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Find the updates of all the devices before anything is written.
+	batches := make([]deviceBatch, len(devices))
+	for j, d := range devices {
+		serv, err := unitServer(d, unit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		b := deviceBatch{device: d, serv: serv}
+
+		for i, c := range changes {
+			rt := registerType(c.Register)
+			if _, ok := tables[rt]; !ok {
+				http.Error(w, fmt.Sprintf("change %v: register must be one of coil|discrete|input|holding, got %q", i, c.Register), http.StatusBadRequest)
+				return
+			}
+			if c.Address < 0 || c.Address > 65535 {
+				http.Error(w, fmt.Sprintf("change %v: address must be 0 to 65535, got %v", i, c.Address), http.StatusBadRequest)
+				return
+			}
+			n, err := c.number()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("change %v: %v", i, err), http.StatusBadRequest)
+				return
+			}
+			m := entryMetaAt(d.profile, rt, c.Address)
+			if scaled {
+				n = m.unscaled(n)
+			}
+			e, err := registerEntry(d.profile, rt, c.Address, c.Type, n)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("change %v: %v", i, err), http.StatusBadRequest)
+				return
+			}
+
+			words := encodeNumber(e, n)
+			b.entries = append(b.entries, e)
+			b.metas = append(b.metas, m)
+			b.updates = append(b.updates, entryUpdate(rt, e, words, rc.addrOffset))
+			b.written = append(b.written, e.enc.Decode(words))
+		}
+		batches[j] = b
+	}
+
+	// Write the changes to all the devices while holding the locks of
+	// all of them, so the clients of any device see either none or all
+	// of the changes, and the old values in the audit log are the values
+	// overwritten.
+	rec := auditFromContext(r.Context())
+	err = applyBatches(batches, changes, rec != nil, rc.addrOffset)
+	if err != nil {
+		http.Error(w, "a change is outside the registers, nothing is written", http.StatusBadRequest)
+		return
+	}
+
+	values := []registerValue{}
+	for _, b := range batches {
+		for i, e := range b.entries {
+			v := b.written[i]
+			if scaled {
+				v = b.metas[i].scaled(v)
+			}
+			rv := registerValue{
+				Device:   b.device.name,
+				Unit:     unit,
+				Register: changes[i].Register,
+				Address:  changes[i].Address,
+				Type:     entryType(e.enc),
+				Text:     strconv.FormatFloat(v, 'g', -1, 64),
+			}
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				rv.Value = &v
+			}
+			if scaled {
+				rv.EngineeringUnit = b.metas[i].Unit
+			}
+			values = append(values, rv)
+
+			if rec != nil {
+				rec.Changes = append(rec.Changes, auditChange{
+					Device:   rv.Device,
+					Unit:     rv.Unit,
+					Register: rv.Register,
+					Address:  rv.Address,
+					Type:     rv.Type,
+					Old:      strconv.FormatFloat(b.old[i], 'g', -1, 64),
+					New:      strconv.FormatFloat(b.written[i], 'g', -1, 64),
+				})
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, values)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestHandleRegisters(t *testing.T) {
+	p := profile{
+		entries: map[registerType][]configEntry{
+			holdingType: testEntries([]map[string]interface{}{
+				{"type": "int16", "number": -5.0, "regAddr": 201.0},
+			}),
+		},
+	}
+	serv := mbserver.NewServer()
+	setRegister(serv, []encoder{p.entries[holdingType][0].enc}, "holding", -1)
+	unit := serv.AddUnit(2)
+	rc := &registerControl{devices: []*device{{serv: serv, profile: p}}, addrOffset: -1}
+
+	tests := []struct {
+		method string
+		query  string
+		code   int
+		expect string
+	}{
+		// The type of the entry at the address is used by default.
+		{"GET", "register=holding&address=201", 200, "-5"},
+		{"POST", "register=holding&address=100&value=12.5&type=float32_be", 200, "12.5"},
+		{"GET", "register=holding&address=100&type=float32_be", 200, "12.5"},
+		{"POST", "register=holding&address=102&value=NaN&type=float32_be", 200, "NaN"},
+		{"POST", "register=coil&address=10&value=1", 200, "1"},
+		{"POST", "register=holding&address=201&value=40000", 400, ""},
+		{"GET", "register=foo&address=201", 400, ""},
+		{"GET", "register=holding&address=70000", 400, ""},
+		{"GET", "register=holding&address=201&type=foo", 400, ""},
+		// The unit is written, and a unit the device does not have is
+		// not found.
+		{"POST", "register=holding&address=150&value=7&unit=2", 200, "7"},
+		{"GET", "register=holding&address=150&unit=2", 200, "7"},
+		{"POST", "register=holding&address=150&value=8&unit=3", 404, ""},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		rc.handleRegisters(rec, httptest.NewRequest(tt.method, "/registers?"+tt.query, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%v %v: expected %v, got %v", tt.method, tt.query, tt.code, rec.Code)
+			continue
+		}
+		if tt.code != 200 {
+			continue
+		}
+
+		var values []registerValue
+		err := json.NewDecoder(rec.Body).Decode(&values)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if len(values) != 1 || values[0].Text != tt.expect {
+			t.Errorf("%v %v: expected %v, got %v", tt.method, tt.query, tt.expect, values)
+		}
+	}
+
+	// The float was written in the big endian word order.
+	expect := []uint16{0x4148, 0}
+	if got := serv.HoldingRegisters[99:101]; !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	if serv.HoldingRegisters[149] != 0 || unit.HoldingRegisters[149] != 7 {
+		t.Errorf("expected %v and %v, got %v and %v", 0, 7, serv.HoldingRegisters[149], unit.HoldingRegisters[149])
+	}
+}
+
+func TestHandleBatch(t *testing.T) {
+	serv := mbserver.NewServer()
+	rc := &registerControl{devices: []*device{{serv: serv}}, addrOffset: -1}
+
+	batches := 0
+	serv.UpdateHook = func([]mbserver.RegisterUpdate) { batches++ }
+
+	body := `[
+		{"register": "holding", "address": 100, "type": "float32_be", "value": 12.5},
+		{"register": "holding", "address": 102, "value": 7},
+		{"register": "coil", "address": 10, "type": "bit", "value": "on"}]`
+	rec := httptest.NewRecorder()
+	rc.handleBatch(rec, httptest.NewRequest("POST", "/registers/batch", strings.NewReader(body)))
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %v: %v", rec.Code, rec.Body)
+	}
+
+	var values []registerValue
+	err := json.NewDecoder(rec.Body).Decode(&values)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	var got []string
+	for _, v := range values {
+		got = append(got, v.Text)
+	}
+	if !isEqual([]string{"12.5", "7", "1"}, got) {
+		t.Errorf("expected %v, got %v", []string{"12.5", "7", "1"}, got)
+	}
+	expect := []uint16{0x4148, 0, 7}
+	if got := serv.HoldingRegisters[99:102]; !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	if serv.Coils[9] != 1 || batches != 1 {
+		t.Errorf("expected the coil set in a single batch, got %v %v", serv.Coils[9], batches)
+	}
+
+	// Nothing is written when any of the changes is not valid.
+	for _, body := range []string{
+		`[{"register": "holding", "address": 102, "value": 8}, {"register": "foo", "address": 1, "value": 1}]`,
+		`[{"register": "holding", "address": 102, "value": 8}, {"register": "holding", "address": 0, "value": 1}]`,
+		`[{"register": "holding", "address": 102, "value": 8}, {"register": "holding", "address": 70000, "value": 1}]`,
+		`[{"register": "holding", "address": 102, "value": true}, {"register": "holding", "address": 1, "value": [1]}]`,
+	} {
+		rec = httptest.NewRecorder()
+		rc.handleBatch(rec, httptest.NewRequest("POST", "/registers/batch", strings.NewReader(body)))
+		if rec.Code != 400 {
+			t.Errorf("%v: expected 400, got %v", body, rec.Code)
+		}
+	}
+	if serv.HoldingRegisters[101] != 7 || batches != 1 {
+		t.Errorf("expected nothing written, got %v %v", serv.HoldingRegisters[101], batches)
+	}
+}
+
+func TestHandleBatchDevices(t *testing.T) {
+	a := mbserver.NewServer()
+	a.AddUnit(2)
+	b := mbserver.NewServer()
+	b.HoldingRegisters = make([]uint16, 50)
+	rc := &registerControl{devices: []*device{{name: "a", serv: a}, {name: "b", serv: b}}, addrOffset: -1}
+
+	// Nothing is written to any of the devices when a change is outside
+	// the registers of one of them, or one of them does not have the
+	// unit.
+	body := `[{"register": "holding", "address": 10, "value": 8}, {"register": "holding", "address": 100, "value": 9}]`
+	rec := httptest.NewRecorder()
+	rc.handleBatch(rec, httptest.NewRequest("POST", "/registers/batch", strings.NewReader(body)))
+	if rec.Code != 400 {
+		t.Errorf("expected 400, got %v", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	rc.handleBatch(rec, httptest.NewRequest("POST", "/registers/batch?unit=2", strings.NewReader(body)))
+	if rec.Code != 404 {
+		t.Errorf("expected 404, got %v", rec.Code)
+	}
+	if a.HoldingRegisters[9] != 0 || a.Units()[2].HoldingRegisters[9] != 0 || b.HoldingRegisters[9] != 0 {
+		t.Errorf("expected nothing written, got %v %v %v", a.HoldingRegisters[9], a.Units()[2].HoldingRegisters[9], b.HoldingRegisters[9])
+	}
+
+	// The changes are written to all the devices.
+	body = `[{"register": "holding", "address": 10, "value": 8}]`
+	rec = httptest.NewRecorder()
+	rc.handleBatch(rec, httptest.NewRequest("POST", "/registers/batch", strings.NewReader(body)))
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %v: %v", rec.Code, rec.Body)
+	}
+	if a.HoldingRegisters[9] != 8 || b.HoldingRegisters[9] != 8 {
+		t.Errorf("expected 8 written to both, got %v %v", a.HoldingRegisters[9], b.HoldingRegisters[9])
+	}
+}
+
+func TestHandleRegistersScaled(t *testing.T) {
+	p := profile{
+		entries: map[registerType][]configEntry{
+			holdingType: testEntries([]map[string]interface{}{
+				{"type": "int16", "number": 215.0, "regAddr": 201.0, "scale": 0.1, "unit": "°C"},
+				{"type": "uint16", "number": 0.0, "regAddr": 202.0, "scale": 0.5, "offset": -40.0, "unit": "°C"},
+			}),
+		},
+	}
+	serv := mbserver.NewServer()
+	for _, e := range p.entries[holdingType] {
+		setRegister(serv, []encoder{e.enc}, "holding", -1)
+	}
+	rc := &registerControl{devices: []*device{{serv: serv, profile: p}}, addrOffset: -1}
+
+	tests := []struct {
+		method string
+		query  string
+		code   int
+		expect string
+	}{
+		{"GET", "register=holding&address=201&scaled=true", 200, "21.5"},
+		{"GET", "register=holding&address=201", 200, "215"},
+		{"POST", "register=holding&address=201&value=-3.2&scaled=true", 200, "-3.2"},
+		{"POST", "register=holding&address=202&value=20&scaled=true", 200, "20"},
+		// The value must fit the registers after the conversion.
+		{"POST", "register=holding&address=202&value=-50&scaled=true", 400, ""},
+		{"GET", "register=holding&address=201&scaled=maybe", 400, ""},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		rc.handleRegisters(rec, httptest.NewRequest(tt.method, "/registers?"+tt.query, nil))
+		if rec.Code != tt.code {
+			t.Errorf("%v %v: expected %v, got %v", tt.method, tt.query, tt.code, rec.Code)
+			continue
+		}
+		if tt.code != 200 {
+			continue
+		}
+
+		var values []registerValue
+		err := json.NewDecoder(rec.Body).Decode(&values)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if len(values) != 1 || values[0].Text != tt.expect {
+			t.Errorf("%v %v: expected %v, got %v", tt.method, tt.query, tt.expect, values)
+		}
+	}
+
+	expect := []uint16{0xffe0, 120}
+	if got := serv.HoldingRegisters[200:202]; !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// The batch converts each of the changes with the entry at its
+	// address.
+	body := `[{"register": "holding", "address": 201, "value": 1.5}, {"register": "holding", "address": 202, "value": -40}]`
+	rec := httptest.NewRecorder()
+	rc.handleBatch(rec, httptest.NewRequest("POST", "/registers/batch?scaled=true", strings.NewReader(body)))
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %v: %v", rec.Code, rec.Body)
+	}
+	var values []registerValue
+	json.NewDecoder(rec.Body).Decode(&values)
+	if len(values) != 2 || values[0].Text != "1.5" || values[0].EngineeringUnit != "°C" {
+		t.Errorf("expected 1.5 °C, got %+v", values)
+	}
+	expect = []uint16{15, 0}
+	if got := serv.HoldingRegisters[200:202]; !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
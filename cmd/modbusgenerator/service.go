@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// defaultServiceName is the name the generator is installed with as a
+// Windows service when no name is given.
+const defaultServiceName = "modbusgenerator"
+
+// serviceReady is closed when the generator is ready to answer requests,
+// and serviceStop is closed when the service manager asks the generator
+// to stop, when it runs as a Windows service.
+var (
+	serviceReady = make(chan struct{})
+	serviceStop  = make(chan struct{})
+)
+
+// serviceConfig is how the generator is installed as a Windows service.
+type serviceConfig struct {
+	name        string
+	displayName string
+	description string
+	// args is the flags the generator is started with by the service
+	// manager.
+	args []string
+}
+
+// parseServiceArgs will parse the arguments of the service subcommand,
+// which are the action, the flags of the service, and after -- the flags
+// the generator is started with.
+func parseServiceArgs(args []string) (string, serviceConfig, error) {
+	fs := flag.NewFlagSet("service", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Install or uninstall the generator as a Windows service.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator service install [flags] -- [generator flags]\n")
+		fmt.Fprintf(os.Stderr, "  modbusgenerator service uninstall [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	name := fs.String("name", defaultServiceName, "The name of the service")
+	displayName := fs.String("displayName", "Modbus generator", "The name of the service shown in the service manager")
+	description := fs.String("description", "Simulates Modbus devices from config files", "The description of the service shown in the service manager")
+
+	if len(args) == 0 {
+		fs.Usage()
+		return "", serviceConfig{}, fmt.Errorf("missing action, install or uninstall")
+	}
+	action := args[0]
+	if action != "install" && action != "uninstall" {
+		fs.Usage()
+		return "", serviceConfig{}, fmt.Errorf("unknown action %q, must be install or uninstall", action)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return "", serviceConfig{}, err
+	}
+	if *name == "" {
+		return "", serviceConfig{}, fmt.Errorf("name can not be empty")
+	}
+	if action == "uninstall" && fs.NArg() > 0 {
+		return "", serviceConfig{}, fmt.Errorf("uninstall takes no generator flags, got %v", fs.Args())
+	}
+
+	return action, serviceConfig{name: *name, displayName: *displayName, description: *description, args: fs.Args()}, nil
+}
+
+// runService will run the service subcommand, which installs or
+// uninstalls the generator as a Windows service, and return the exit
+// code.
+func runService(args []string) int {
+	action, config, err := parseServiceArgs(args)
+	if err != nil {
+		log.Printf("error: service: %v\n", err)
+		return 2
+	}
+
+	switch action {
+	case "install":
+		err = installService(config)
+	case "uninstall":
+		err = uninstallService(config.name)
+	}
+	if err != nil {
+		log.Printf("error: service: %v\n", err)
+		return 1
+	}
+
+	log.Printf("info: service: %ved %v\n", action, config.name)
+	return 0
+}
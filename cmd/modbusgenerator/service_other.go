@@ -0,0 +1,29 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// errNoWindowsService is returned by the service subcommand on other
+// platforms than windows.
+var errNoWindowsService = errors.New("running as a Windows service is only supported on windows, use systemd or -pidFile instead")
+
+// isWindowsService always returns false on other platforms than windows.
+func isWindowsService() bool {
+	return false
+}
+
+// runWindowsService is not supported on other platforms than windows.
+func runWindowsService(name string) int {
+	return 1
+}
+
+// installService is not supported on other platforms than windows.
+func installService(config serviceConfig) error {
+	return errNoWindowsService
+}
+
+// uninstallService is not supported on other platforms than windows.
+func uninstallService(name string) error {
+	return errNoWindowsService
+}
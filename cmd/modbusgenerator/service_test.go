@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseServiceArgs(t *testing.T) {
+	action, config, err := parseServiceArgs([]string{"install", "-name", "boiler", "--", "-jsonHolding", `C:\modbus\holding.json`, "-listenRTUTCPPort", ":502"})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if action != "install" {
+		t.Errorf("expected install, got %v", action)
+	}
+	if config.name != "boiler" {
+		t.Errorf("expected boiler, got %v", config.name)
+	}
+	if !isEqual([]string{"-jsonHolding", `C:\modbus\holding.json`, "-listenRTUTCPPort", ":502"}, config.args) {
+		t.Errorf("expected the generator flags, got %v", config.args)
+	}
+
+	action, config, err = parseServiceArgs([]string{"uninstall"})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if action != "uninstall" || config.name != defaultServiceName {
+		t.Errorf("expected uninstall of %v, got %v of %v", defaultServiceName, action, config.name)
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"start"},
+		{"install", "-name", ""},
+		{"uninstall", "--", "-listenRTUTCPPort", ":502"},
+	} {
+		_, _, err := parseServiceArgs(args)
+		if err == nil {
+			t.Errorf("%v: expected an error, got nil", args)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// isWindowsService will return true if the generator was started by the
+// Windows service manager.
+func isWindowsService() bool {
+	ok, err := svc.IsWindowsService()
+	if err != nil {
+		log.Printf("error: service: failed to check if running as a service: %v\n", err)
+		return false
+	}
+	return ok
+}
+
+// serviceHandler runs the generator as a Windows service.
+type serviceHandler struct{}
+
+// Execute will start the generator, report it as running to the service
+// manager when it is ready, and stop it when the service manager asks.
+func (serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	done := make(chan struct{})
+	go func() {
+		runGenerator()
+		close(done)
+	}()
+
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	ready := serviceReady
+	for {
+		select {
+		case <-ready:
+			status <- svc.Status{State: svc.Running, Accepts: accepts}
+			// Only report running once.
+			ready = nil
+		case <-done:
+			// The generator stopped by itself, e.g. on an error in the
+			// config files.
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				close(serviceStop)
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// runWindowsService will run the generator as the Windows service with
+// the name given, and return the exit code.
+func runWindowsService(name string) int {
+	err := svc.Run(name, serviceHandler{})
+	if err != nil {
+		log.Printf("error: service: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// installService will install the generator as a Windows service started
+// automatically with the flags of the config given.
+func installService(config serviceConfig) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the path of the generator: %v", err)
+	}
+	exe, err = filepath.Abs(exe)
+	if err != nil {
+		return fmt.Errorf("failed to find the path of the generator: %v", err)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(config.name)
+	if err == nil {
+		s.Close()
+		return fmt.Errorf("service %v already exists", config.name)
+	}
+
+	c := mgr.Config{
+		StartType:   mgr.StartAutomatic,
+		DisplayName: config.displayName,
+		Description: config.description,
+	}
+	s, err = m.CreateService(config.name, exe, c, config.args...)
+	if err != nil {
+		return fmt.Errorf("failed to create service %v: %v", config.name, err)
+	}
+	defer s.Close()
+
+	return nil
+}
+
+// uninstallService will stop the Windows service with the name given if
+// it is running, and remove it.
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %v is not installed: %v", name, err)
+	}
+	defer s.Close()
+
+	st, err := s.Control(svc.Stop)
+	if err == nil {
+		// Wait for the generator to stop, so the binary can be removed
+		// after uninstalling.
+		timeout := time.Now().Add(time.Second * 10)
+		for st.State != svc.Stopped && time.Now().Before(timeout) {
+			time.Sleep(time.Millisecond * 300)
+			st, err = s.Query()
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	err = s.Delete()
+	if err != nil {
+		return fmt.Errorf("failed to remove service %v: %v", name, err)
+	}
+	return nil
+}
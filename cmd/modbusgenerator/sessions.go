@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// maxClosedSessions is the number of closed sessions kept for the
+// sessions endpoint.
+const maxClosedSessions = 100
+
+// sessionLabel is a label given to the clients connecting from the
+// network of the label.
+type sessionLabel struct {
+	network *net.IPNet
+	label   string
+}
+
+// parseSessionLabels will parse a comma separated list of networks and
+// labels like "10.0.1.0/24=agents,10.0.2.15=scada". An address without a
+// prefix length is a network with only that address.
+func parseSessionLabels(s string) ([]sessionLabel, error) {
+	var labels []sessionLabel
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		cidr, label, ok := strings.Cut(v, "=")
+		if !ok || strings.TrimSpace(label) == "" {
+			return nil, fmt.Errorf("invalid session label %q, must be on the form network=label", v)
+		}
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q in session label", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%v/%v", cidr, bits)
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q in session label: %v", cidr, err)
+		}
+		labels = append(labels, sessionLabel{network: network, label: strings.TrimSpace(label)})
+	}
+
+	return labels, nil
+}
+
+// sessionLabelFor will return the label of the most specific network
+// containing the address given, or an empty string if no network
+// contains it.
+func sessionLabelFor(labels []sessionLabel, addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+
+	label := ""
+	longest := -1
+	for _, l := range labels {
+		ones, _ := l.network.Mask.Size()
+		if l.network.Contains(ip) && ones > longest {
+			label = l.label
+			longest = ones
+		}
+	}
+
+	return label
+}
+
+// sessionStatus is the status of a single TCP connection returned by the
+// sessions endpoint.
+type sessionStatus struct {
+	Device       string        `json:"device,omitempty"`
+	Label        string        `json:"label,omitempty"`
+	Remote       string        `json:"remote"`
+	Local        string        `json:"local"`
+	Connected    time.Time     `json:"connected"`
+	Disconnected *time.Time    `json:"disconnected,omitempty"`
+	Requests     map[uint8]int `json:"requests"`
+	BytesRead    int           `json:"bytesRead"`
+	BytesWritten int           `json:"bytesWritten"`
+	Reason       string        `json:"reason,omitempty"`
+}
+
+// sessionLog logs the TCP connections to the devices, and keeps the
+// connections recently closed for the sessions endpoint.
+type sessionLog struct {
+	mu      sync.Mutex
+	labels  []sessionLabel
+	devices []*device
+	// closed holds the last closed sessions, oldest first.
+	closed []sessionStatus
+}
+
+func newSessionLog(labels []sessionLabel) *sessionLog {
+	return &sessionLog{labels: labels}
+}
+
+// status will return the status of the session given for the device
+// given.
+func (l *sessionLog) status(d *device, st mbserver.SessionStats) sessionStatus {
+	ss := sessionStatus{
+		Device:       d.name,
+		Label:        sessionLabelFor(l.labels, st.Remote),
+		Remote:       st.Remote,
+		Local:        st.Local,
+		Connected:    st.Connected,
+		Requests:     st.Requests,
+		BytesRead:    st.BytesRead,
+		BytesWritten: st.BytesWritten,
+		Reason:       st.Reason,
+	}
+	if !st.Disconnected.IsZero() {
+		ss.Disconnected = &st.Disconnected
+	}
+
+	return ss
+}
+
+// watch will log the sessions of the device given when they are opened
+// and closed.
+func (l *sessionLog) watch(d *device) {
+	l.mu.Lock()
+	l.devices = append(l.devices, d)
+	l.mu.Unlock()
+
+	d.serv.SessionHook = func(st mbserver.SessionStats) {
+		ss := l.status(d, st)
+
+		client := ss.Remote
+		if ss.Label != "" {
+			client = fmt.Sprintf("%v (%v)", ss.Label, ss.Remote)
+		}
+		if ss.Disconnected == nil {
+			log.Printf("info: session: %v connected to %v\n", client, ss.Local)
+			return
+		}
+		reason := ""
+		if ss.Reason != "" {
+			reason = fmt.Sprintf(", closed by %v", ss.Reason)
+		}
+		log.Printf("info: session: %v disconnected from %v after %v, requests: %v, bytes read: %v, bytes written: %v%v\n",
+			client, ss.Local, ss.Disconnected.Sub(ss.Connected).Round(time.Millisecond), formatRequests(ss.Requests), ss.BytesRead, ss.BytesWritten, reason)
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.closed = append(l.closed, ss)
+		if len(l.closed) > maxClosedSessions {
+			l.closed = l.closed[len(l.closed)-maxClosedSessions:]
+		}
+	}
+}
+
+// formatRequests will format the number of requests by function code
+// like "fc3=10 fc16=2", sorted by function code.
+func formatRequests(requests map[uint8]int) string {
+	var fcs []int
+	for fc := range requests {
+		fcs = append(fcs, int(fc))
+	}
+	sort.Ints(fcs)
+
+	var s []string
+	for _, fc := range fcs {
+		s = append(s, fmt.Sprintf("fc%v=%v", fc, requests[uint8(fc)]))
+	}
+	if len(s) == 0 {
+		return "none"
+	}
+
+	return strings.Join(s, " ")
+}
+
+// sessionsResponse is the JSON body returned by the sessions endpoint.
+type sessionsResponse struct {
+	Open   []sessionStatus `json:"open"`
+	Closed []sessionStatus `json:"closed"`
+}
+
+// handleSessions answers with the TCP connections open to the devices,
+// and the connections recently closed.
+func (l *sessionLog) handleSessions(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	resp := sessionsResponse{Open: []sessionStatus{}, Closed: []sessionStatus{}}
+	for _, d := range l.devices {
+		for _, st := range d.serv.Sessions() {
+			resp.Open = append(resp.Open, l.status(d, st))
+		}
+	}
+	resp.Closed = append(resp.Closed, l.closed...)
+
+	writeJSON(w, http.StatusOK, resp)
+}
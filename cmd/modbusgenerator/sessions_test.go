@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestSessionLabelFor(t *testing.T) {
+	labels, err := parseSessionLabels("10.0.0.0/8=lab, 10.0.1.0/24=agents,10.0.1.15=scada,::1=local")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	tests := map[string]string{
+		"10.2.3.4:5000":   "lab",
+		"10.0.1.20:5000":  "agents",
+		"10.0.1.15:5000":  "scada",
+		"[::1]:5000":      "local",
+		"192.168.1.1:502": "",
+	}
+	for addr, expect := range tests {
+		got := sessionLabelFor(labels, addr)
+		if expect != got {
+			t.Errorf("%v: expected %q, got %q", addr, expect, got)
+		}
+	}
+}
+
+func TestParseSessionLabelsInvalid(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/8", "10.0.0.0/8=", "10.0.0.300=x", "10.0.0.0/40=x"} {
+		_, err := parseSessionLabels(s)
+		if err == nil {
+			t.Errorf("%v: expected error, got nil", s)
+		}
+	}
+}
+
+func TestFormatRequests(t *testing.T) {
+	expect := "fc3=10 fc16=2"
+	got := formatRequests(map[uint8]int{16: 2, 3: 10})
+	if expect != got {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// maxSnapshots is the max number of snapshots kept, where the oldest
+// snapshot is dropped when a new one is taken, since each snapshot holds a
+// copy of all the registers of all the servers.
+const maxSnapshots = 32
+
+// snapshotControl takes snapshots of the simulation, and rewinds the
+// simulation to them, so a scenario can be stepped through and replayed
+// from a known state while it is developed.
+type snapshotControl struct {
+	devices []*device
+	clk     *simClock
+	// mu guards snapshots, which are ordered from the oldest.
+	mu        sync.Mutex
+	snapshots []*snapshot
+}
+
+// snapshot is the state of the simulation at the simulated time it was
+// taken, with the registers and the state of the blocks of each server of
+// each device.
+type snapshot struct {
+	name    string
+	time    time.Time
+	devices [][]serverSnapshot
+}
+
+// serverSnapshot is the registers and the state of the blocks of a
+// server.
+type serverSnapshot struct {
+	coils            []byte
+	discreteInputs   []byte
+	holdingRegisters []uint16
+	inputRegisters   []uint16
+	blocks           []any
+}
+
+// snapshotStatus is a snapshot returned by the snapshot endpoints.
+type snapshotStatus struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+// take will take a snapshot with the name given, replacing the snapshot
+// with the same name.
+func (sc *snapshotControl) take(name string) snapshotStatus {
+	s := &snapshot{name: name, time: sc.clk.Now()}
+	for _, d := range sc.devices {
+		d.serv.Lock()
+		servers := make([]serverSnapshot, len(d.servers))
+		for i, serv := range d.servers {
+			servers[i] = serverSnapshot{
+				coils:            append([]byte(nil), serv.Coils[:cap(serv.Coils)]...),
+				discreteInputs:   append([]byte(nil), serv.DiscreteInputs[:cap(serv.DiscreteInputs)]...),
+				holdingRegisters: append([]uint16(nil), serv.HoldingRegisters[:cap(serv.HoldingRegisters)]...),
+				inputRegisters:   append([]uint16(nil), serv.InputRegisters[:cap(serv.InputRegisters)]...),
+			}
+			if i < len(d.blocks) {
+				for _, b := range d.blocks[i] {
+					servers[i].blocks = append(servers[i].blocks, b.state())
+				}
+			}
+		}
+		d.serv.Unlock()
+		s.devices = append(s.devices, servers)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for i, old := range sc.snapshots {
+		if old.name == name {
+			sc.snapshots = append(sc.snapshots[:i], sc.snapshots[i+1:]...)
+			break
+		}
+	}
+	if len(sc.snapshots) >= maxSnapshots {
+		log.Printf("info: snapshot %q dropped, since at most %v snapshots are kept\n", sc.snapshots[0].name, maxSnapshots)
+		sc.snapshots = sc.snapshots[1:]
+	}
+	sc.snapshots = append(sc.snapshots, s)
+
+	return snapshotStatus{Name: s.name, Time: s.time}
+}
+
+// rewind will set the clock back to the time of the snapshot with the
+// name given, and the registers and the blocks back to their state in the
+// snapshot. The clock is set back first, so the blocks are not stepped
+// between restoring them and setting back the clock.
+func (sc *snapshotControl) rewind(name string) (snapshotStatus, error) {
+	sc.mu.Lock()
+	var s *snapshot
+	for _, v := range sc.snapshots {
+		if v.name == name {
+			s = v
+		}
+	}
+	sc.mu.Unlock()
+	if s == nil {
+		return snapshotStatus{}, fmt.Errorf("no snapshot named %q", name)
+	}
+
+	sc.clk.Rewind(s.time)
+	for i, d := range sc.devices {
+		d.serv.Lock()
+		for j, serv := range d.servers {
+			s.devices[i][j].restore(serv)
+			if j < len(d.blocks) {
+				for k, b := range d.blocks[j] {
+					b.setState(s.devices[i][j].blocks[k])
+				}
+			}
+		}
+		d.serv.Unlock()
+	}
+
+	return snapshotStatus{Name: s.name, Time: s.time}, nil
+}
+
+// restore will copy the registers of the snapshot to the server given.
+// The server must be locked by the caller.
+func (s serverSnapshot) restore(serv *mbserver.Server) {
+	copy(serv.Coils[:cap(serv.Coils)], s.coils)
+	copy(serv.DiscreteInputs[:cap(serv.DiscreteInputs)], s.discreteInputs)
+	copy(serv.HoldingRegisters[:cap(serv.HoldingRegisters)], s.holdingRegisters)
+	copy(serv.InputRegisters[:cap(serv.InputRegisters)], s.inputRegisters)
+}
+
+// list will return the snapshots, ordered from the oldest.
+func (sc *snapshotControl) list() []snapshotStatus {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	statuses := []snapshotStatus{}
+	for _, s := range sc.snapshots {
+		statuses = append(statuses, snapshotStatus{Name: s.name, Time: s.time})
+	}
+	return statuses
+}
+
+// handleSnapshots answers with the snapshots on GET, and takes a snapshot
+// with the name given with the name query parameter on POST, e.g.
+// /snapshots?name=filled, answering with the snapshot taken.
+func (sc *snapshotControl) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, sc.list())
+	case http.MethodPost:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "name must be given", http.StatusBadRequest)
+			return
+		}
+		st := sc.take(name)
+		log.Printf("info: snapshot %q taken at %v\n", st.Name, st.Time.Format(time.RFC3339))
+		writeJSON(w, http.StatusOK, st)
+	default:
+		http.Error(w, "method not allowed, use GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRewind will rewind the simulation to the snapshot given with the
+// name query parameter, e.g. /snapshots/rewind?name=filled, and answer
+// with the status of the clock.
+func (sc *snapshotControl) handleRewind(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st, err := sc.rewind(r.URL.Query().Get("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("info: simulation rewound to snapshot %q at %v\n", st.Name, st.Time.Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, sc.clk.status())
+}
+
+// printSnapshots will write the snapshots as a table.
+func printSnapshots(w io.Writer, snapshots []snapshotStatus) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTIME")
+	for _, s := range snapshots {
+		fmt.Fprintf(tw, "%v\t%v\n", s.Name, s.Time.Format(time.RFC3339))
+	}
+	tw.Flush()
+}
+
+// The state of a block is a copy of the block, which holds the
+// configuration of the block along with its state. The random source of a
+// random block is shared by the copies, so its values after a rewind
+// continue the random sequence instead of repeating it.
+
+func (b *tankBlock) state() any      { return *b }
+func (b *tankBlock) setState(s any)  { *b = s.(tankBlock) }
+func (b *motorBlock) state() any     { return *b }
+func (b *motorBlock) setState(s any) { *b = s.(motorBlock) }
+func (b *pidBlock) state() any       { return *b }
+func (b *pidBlock) setState(s any)   { *b = s.(pidBlock) }
+func (b *alarmBlock) state() any     { return *b }
+func (b *alarmBlock) setState(s any) { *b = s.(alarmBlock) }
+
+func (b *scheduleBlock) state() any     { return *b }
+func (b *scheduleBlock) setState(s any) { *b = s.(scheduleBlock) }
+func (b *randomBlock) state() any       { return *b }
+func (b *randomBlock) setState(s any)   { *b = s.(randomBlock) }
+func (b *counterBlock) state() any      { return *b }
+func (b *counterBlock) setState(s any)  { *b = s.(counterBlock) }
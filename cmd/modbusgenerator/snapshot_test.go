@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestSnapshotRewind(t *testing.T) {
+	entries := map[registerType][]configEntry{
+		inputType: testEntries([]map[string]interface{}{
+			{"type": "float32BigWordBigEndian", "number": 0.0, "regAddr": 101.0},
+		}),
+	}
+	blocksRawData := []map[string]interface{}{
+		{"block": "tank", "inflowCoil": 301.0, "outflowCoil": 302.0, "level": 101.0, "inflowRate": 2.0},
+	}
+	blocks, err := parseBlocks(blocksRawData, entries, -1, nil, 0)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	serv.Coils[300] = 1
+	d := &device{serv: serv, servers: []*mbserver.Server{serv}, blocks: [][]*simBlock{blocks}}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := newSimClock(start, 0)
+	sc := &snapshotControl{devices: []*device{d}, clk: clk}
+
+	step := func(n int) {
+		for i := 0; i < n; i++ {
+			blocks[0].step(serv, time.Second)
+		}
+		clk.Step(time.Second * time.Duration(n))
+	}
+	level := func() float64 {
+		return entries[inputType][0].enc.Decode(serv.InputRegisters[100:102])
+	}
+
+	step(2)
+	rec := httptest.NewRecorder()
+	sc.handleSnapshots(rec, httptest.NewRequest("POST", "/snapshots?name=filling", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected %v, got %v", http.StatusOK, rec.Code)
+	}
+
+	// The tank keeps filling after the snapshot, and a client stops the
+	// inflow.
+	step(3)
+	serv.Coils[300] = 0
+	if level() != 10 {
+		t.Errorf("expected level %v, got %v", 10, level())
+	}
+
+	// The rewind sets back the clock, the registers and the state of the
+	// tank, so the tank fills from where it was.
+	rec = httptest.NewRecorder()
+	sc.handleRewind(rec, httptest.NewRequest("POST", "/snapshots/rewind?name=filling", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected %v, got %v", http.StatusOK, rec.Code)
+	}
+	if now := clk.Now(); !now.Equal(start.Add(time.Second * 2)) {
+		t.Errorf("expected %v, got %v", start.Add(time.Second*2), now)
+	}
+	if level() != 4 || serv.Coils[300] != 1 {
+		t.Errorf("expected level 4 and the inflow on, got level %v and inflow %v", level(), serv.Coils[300])
+	}
+	step(1)
+	if level() != 6 {
+		t.Errorf("expected level %v, got %v", 6, level())
+	}
+
+	expect := []snapshotStatus{{Name: "filling", Time: start.Add(time.Second * 2)}}
+	if got := sc.list(); !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	tests := map[string]int{
+		"/snapshots/rewind?name=unknown": http.StatusNotFound,
+		"/snapshots/rewind":              http.StatusNotFound,
+	}
+	for url, code := range tests {
+		rec := httptest.NewRecorder()
+		sc.handleRewind(rec, httptest.NewRequest("POST", url, nil))
+		if rec.Code != code {
+			t.Errorf("%v: expected %v, got %v", url, code, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	sc.handleSnapshots(rec, httptest.NewRequest("POST", "/snapshots", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected %v, got %v", http.StatusBadRequest, rec.Code)
+	}
+
+	var buf bytes.Buffer
+	printSnapshots(&buf, expect)
+	if buf.String() != "NAME     TIME\nfilling  2024-01-01T00:00:02Z\n" {
+		t.Errorf("expected the snapshots as a table, got %q", buf.String())
+	}
+}
+
+func TestSnapshotLimit(t *testing.T) {
+	sc := &snapshotControl{clk: newSimClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 0)}
+	for i := 0; i <= maxSnapshots; i++ {
+		sc.take(string(rune('a'+i%26)) + string(rune('0'+i/26)))
+	}
+	sc.take("b0")
+
+	// The oldest snapshot is dropped, and a snapshot taken again with the
+	// same name is moved last.
+	got := sc.list()
+	if len(got) != maxSnapshots || got[0].Name != "c0" || got[len(got)-1].Name != "b0" {
+		t.Errorf("expected %v snapshots from c0 to b0, got %v", maxSnapshots, got)
+	}
+}
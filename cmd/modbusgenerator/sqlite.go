@@ -0,0 +1,548 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the tables of the database if they do not exist.
+// The registers table holds the last value of every register, where seq
+// is the seq of the row in the history table written with the value, so
+// the changes since a seq can be found.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS registers (
+	device TEXT NOT NULL,
+	unit INTEGER NOT NULL,
+	register TEXT NOT NULL,
+	address INTEGER NOT NULL,
+	value INTEGER NOT NULL,
+	seq INTEGER NOT NULL,
+	PRIMARY KEY (device, unit, register, address)
+);
+CREATE INDEX IF NOT EXISTS registers_seq ON registers (seq);
+CREATE TABLE IF NOT EXISTS history (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	time TEXT NOT NULL,
+	device TEXT NOT NULL,
+	unit INTEGER NOT NULL,
+	register TEXT NOT NULL,
+	address INTEGER NOT NULL,
+	value INTEGER NOT NULL
+);
+`
+
+// sqliteStore keeps the registers of the devices in a SQLite database, so
+// the values are kept between restarts of the generator, the changes can
+// be queried with SQL, and several generators using the same database
+// share the same registers. Each server gets a sqliteImage as its
+// RegisterStore, so the clients read and write the registers in the
+// database.
+type sqliteStore struct {
+	db     *sql.DB
+	clk    *simClock
+	images []*sqliteImage
+	// seq is the seq of the last change read from the database.
+	seq int64
+	// pending holds the changes that failed to be written, which are
+	// written again with the next changes.
+	pending []sqliteChange
+}
+
+// sqliteImage is the registers of a server of a device kept in the
+// database. It is the RegisterStore of the server, where the writes of
+// the clients are also written to the memory of the server, so the
+// simulation sees them.
+type sqliteImage struct {
+	st     *sqliteStore
+	device string
+	// unit is the unit ID of the server, which is 0 for the server of
+	// the device itself.
+	unit int
+	// lock is the server of the device, whose lock is shared by the
+	// units.
+	lock *mbserver.Server
+	serv *mbserver.Server
+	// addrs holds the sorted addresses of the registers of the config
+	// entries, which are the registers written by the simulation, and
+	// last the values of those registers as last written to or read from
+	// the database, so the changes of the simulation can be found.
+	addrs map[registerType][]int
+	last  map[registerType][]uint16
+}
+
+// sqliteChange is a change of the value of a register of a server.
+type sqliteChange struct {
+	device  string
+	unit    int
+	rt      registerType
+	address int
+	value   uint16
+}
+
+// openSQLiteStore will open the SQLite database in the file given, which
+// is created if it does not exist. The changes are written to the history
+// with the time of the clock given.
+func openSQLiteStore(filename string, clk *simClock) (*sqliteStore, error) {
+	// Wait for the other generators using the same database instead of
+	// failing when the database is locked.
+	db, err := sql.Open("sqlite", "file:"+filename+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite: failed to create the tables in %v: %v", filename, err)
+	}
+
+	return &sqliteStore{db: db, clk: clk}, nil
+}
+
+// Close will close the database.
+func (st *sqliteStore) Close() error {
+	return st.db.Close()
+}
+
+// add will keep the registers of the server of the device given, and of
+// all its units, in the database, by setting the store of each server.
+// The registers of the entries of the device are synced with the memory
+// of the servers, with the address offset given.
+func (st *sqliteStore) add(d *device, addrOffset int) {
+	addrs := make(map[registerType][]int)
+	for rt, spans := range entrySpans(d.profile.entries, addrOffset) {
+		seen := make(map[int]bool)
+		for _, span := range spans {
+			for a := max(span.from, 0); a < span.to; a++ {
+				if !seen[a] {
+					seen[a] = true
+					addrs[rt] = append(addrs[rt], a)
+				}
+			}
+		}
+		sort.Ints(addrs[rt])
+	}
+
+	for _, s := range d.servers {
+		img := &sqliteImage{
+			st:     st,
+			device: d.name,
+			unit:   unitIDOf(d, s),
+			lock:   d.serv,
+			serv:   s,
+			addrs:  addrs,
+			last:   make(map[registerType][]uint16),
+		}
+		for rt, a := range addrs {
+			img.last[rt] = make([]uint16, len(a))
+		}
+		st.images = append(st.images, img)
+		s.Store = img
+	}
+}
+
+// key will return the key of the server of the change in the maps of the
+// changes read from the database.
+func (c sqliteChange) key() string {
+	return fmt.Sprintf("%v/%v", c.device, c.unit)
+}
+
+// readChanges will return the changes written to the database after the
+// seq given, by the key of their server, and the seq of the last change.
+func (st *sqliteStore) readChanges(seq int64) (map[string][]sqliteChange, int64, error) {
+	rows, err := st.db.Query("SELECT device, unit, register, address, value, seq FROM registers WHERE seq > ? ORDER BY seq", seq)
+	if err != nil {
+		return nil, seq, fmt.Errorf("sqlite: failed to read the changes: %v", err)
+	}
+	defer rows.Close()
+
+	changes := make(map[string][]sqliteChange)
+	for rows.Next() {
+		var c sqliteChange
+		var rt string
+		err := rows.Scan(&c.device, &c.unit, &rt, &c.address, &c.value, &seq)
+		if err != nil {
+			return nil, seq, fmt.Errorf("sqlite: failed to read the changes: %v", err)
+		}
+		c.rt = registerType(rt)
+		changes[c.key()] = append(changes[c.key()], c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, seq, fmt.Errorf("sqlite: failed to read the changes: %v", err)
+	}
+
+	return changes, seq, nil
+}
+
+// writeChanges will write the changes given to the registers table, and
+// add them to the history table with the time given.
+func (st *sqliteStore) writeChanges(changes []sqliteChange, now time.Time) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	tx, err := st.db.Begin()
+	if err != nil {
+		return fmt.Errorf("sqlite: %v", err)
+	}
+	defer tx.Rollback()
+
+	t := now.UTC().Format(time.RFC3339Nano)
+	for _, c := range changes {
+		res, err := tx.Exec("INSERT INTO history (time, device, unit, register, address, value) VALUES (?, ?, ?, ?, ?, ?)",
+			t, c.device, c.unit, string(c.rt), c.address, c.value)
+		if err != nil {
+			return fmt.Errorf("sqlite: failed to write the history: %v", err)
+		}
+		seq, err := res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("sqlite: failed to write the history: %v", err)
+		}
+		_, err = tx.Exec("INSERT INTO registers (device, unit, register, address, value, seq) VALUES (?, ?, ?, ?, ?, ?) "+
+			"ON CONFLICT (device, unit, register, address) DO UPDATE SET value = excluded.value, seq = excluded.seq",
+			c.device, c.unit, string(c.rt), c.address, c.value, seq)
+		if err != nil {
+			return fmt.Errorf("sqlite: failed to write the registers: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("sqlite: %v", err)
+	}
+	return nil
+}
+
+// load will set the registers of the servers to the values kept in the
+// database, which take precedence over the values of the config files,
+// and write the registers that are not in the database yet, except those
+// that are 0. It is called once before the servers are started.
+func (st *sqliteStore) load() error {
+	stored, seq, err := st.readChanges(0)
+	if err != nil {
+		return err
+	}
+
+	var changes []sqliteChange
+	for _, img := range st.images {
+		img.lock.Lock()
+		known := make(map[registerType]map[int]bool)
+		for _, c := range stored[img.key()] {
+			if known[c.rt] == nil {
+				known[c.rt] = make(map[int]bool)
+			}
+			known[c.rt][c.address] = true
+			img.setMemory(c.rt, c.address, c.value)
+		}
+		for _, rt := range historyRegisterTypes {
+			memory := img.memory(rt)
+			for addr, v := range memory {
+				if v != 0 && !known[rt][addr] {
+					changes = append(changes, img.change(rt, addr, v))
+				}
+			}
+			for i, addr := range img.addrs[rt] {
+				if addr < len(memory) {
+					img.last[rt][i] = memory[addr]
+				}
+			}
+		}
+		img.lock.Unlock()
+	}
+	st.seq = seq
+
+	return st.writeChanges(changes, st.clk.Now())
+}
+
+// sync will apply the changes made to the database by the other
+// generators to the memory of the servers, and write the changes made
+// by the simulation to the registers of the entries to the database. A
+// register changed both in the memory and in the database keeps the value
+// of the memory, which is written to the database. The database is only
+// read and written with the servers unlocked.
+func (st *sqliteStore) sync(now time.Time) error {
+	remote, seq, err := st.readChanges(st.seq)
+	if err != nil {
+		return err
+	}
+	st.seq = seq
+
+	changes := st.pending
+	for _, img := range st.images {
+		img.lock.Lock()
+		for _, c := range remote[img.key()] {
+			img.apply(c)
+		}
+		changes = img.changes(changes)
+		img.lock.Unlock()
+	}
+
+	err = st.writeChanges(changes, now)
+	if err != nil {
+		st.pending = changes
+		return err
+	}
+	st.pending = nil
+	return nil
+}
+
+// start will sync the registers with the database every interval, with
+// the time of the simulation clock in the history.
+func (st *sqliteStore) start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			err := st.sync(st.clk.Now())
+			if err != nil {
+				log.Printf("error: %v\n", err)
+			}
+		}
+	}()
+}
+
+// key will return the key of the server of the image in the maps of the
+// changes read from the database.
+func (img *sqliteImage) key() string {
+	return fmt.Sprintf("%v/%v", img.device, img.unit)
+}
+
+// change will return the change of the register of the image given.
+func (img *sqliteImage) change(rt registerType, address int, value uint16) sqliteChange {
+	return sqliteChange{device: img.device, unit: img.unit, rt: rt, address: address, value: value}
+}
+
+// memory will return the registers of the type given in the memory of
+// the server as words, where the coils and discrete inputs are 0 or 1.
+// The server must be locked.
+func (img *sqliteImage) memory(rt registerType) []uint16 {
+	s := img.serv
+	switch rt {
+	case coilType:
+		return bytesToWords(s.Coils[:cap(s.Coils)])
+	case discreteType:
+		return bytesToWords(s.DiscreteInputs[:cap(s.DiscreteInputs)])
+	case inputType:
+		return s.InputRegisters[:cap(s.InputRegisters)]
+	default:
+		return s.HoldingRegisters[:cap(s.HoldingRegisters)]
+	}
+}
+
+// size will return the number of registers of the type given in the
+// memory of the server.
+func (img *sqliteImage) size(rt registerType) int {
+	s := img.serv
+	switch rt {
+	case coilType:
+		return cap(s.Coils)
+	case discreteType:
+		return cap(s.DiscreteInputs)
+	case inputType:
+		return cap(s.InputRegisters)
+	default:
+		return cap(s.HoldingRegisters)
+	}
+}
+
+// bytesToWords will return the coils given as words.
+func bytesToWords(b []byte) []uint16 {
+	words := make([]uint16, len(b))
+	for i, v := range b {
+		words[i] = uint16(v)
+	}
+	return words
+}
+
+// setMemory will write the value to the register of the memory of the
+// server, and mark it as synced if it is a register of an entry. The
+// server must be locked.
+func (img *sqliteImage) setMemory(rt registerType, address int, value uint16) {
+	s := img.serv
+	if address < 0 || address >= img.size(rt) {
+		return
+	}
+	switch rt {
+	case coilType:
+		s.Coils[:cap(s.Coils)][address] = byte(value)
+	case discreteType:
+		s.DiscreteInputs[:cap(s.DiscreteInputs)][address] = byte(value)
+	case inputType:
+		s.InputRegisters[:cap(s.InputRegisters)][address] = value
+	case holdingType:
+		s.HoldingRegisters[:cap(s.HoldingRegisters)][address] = value
+	}
+	if i, ok := img.entryIndex(rt, address); ok {
+		img.last[rt][i] = value
+	}
+}
+
+// entryIndex will return the index of the address given in the addresses
+// of the entries, and false if it is not the address of an entry.
+func (img *sqliteImage) entryIndex(rt registerType, address int) (int, bool) {
+	addrs := img.addrs[rt]
+	i := sort.SearchInts(addrs, address)
+	return i, i < len(addrs) && addrs[i] == address
+}
+
+// apply will write the value of the change read from the database to
+// the memory, unless it is the register of an entry changed by the
+// simulation since last synced, so a change not yet written to the
+// database is kept. The server must be locked.
+func (img *sqliteImage) apply(c sqliteChange) {
+	if c.address < 0 || c.address >= img.size(c.rt) {
+		return
+	}
+	if i, ok := img.entryIndex(c.rt, c.address); ok && img.word(c.rt, c.address) != img.last[c.rt][i] {
+		return
+	}
+	img.setMemory(c.rt, c.address, c.value)
+}
+
+// word will return the value of the register in the memory of the
+// server. The server must be locked.
+func (img *sqliteImage) word(rt registerType, address int) uint16 {
+	s := img.serv
+	switch rt {
+	case coilType:
+		return uint16(s.Coils[:cap(s.Coils)][address])
+	case discreteType:
+		return uint16(s.DiscreteInputs[:cap(s.DiscreteInputs)][address])
+	case inputType:
+		return s.InputRegisters[:cap(s.InputRegisters)][address]
+	default:
+		return s.HoldingRegisters[:cap(s.HoldingRegisters)][address]
+	}
+}
+
+// changes will append the registers of the entries whose values in the
+// memory differ from the values last synced to the changes given, and
+// mark them as synced. Only the registers of the entries are compared,
+// since the other registers are only written by the clients, which write
+// them through the store. The server must be locked.
+func (img *sqliteImage) changes(changes []sqliteChange) []sqliteChange {
+	for _, rt := range historyRegisterTypes {
+		for i, addr := range img.addrs[rt] {
+			if addr >= img.size(rt) {
+				continue
+			}
+			v := img.word(rt, addr)
+			if v == img.last[rt][i] {
+				continue
+			}
+			img.last[rt][i] = v
+			changes = append(changes, img.change(rt, addr, v))
+		}
+	}
+	return changes
+}
+
+// read will return the values of the registers of the type given from
+// the database, where the registers not in the database are 0, or
+// IllegalDataAddress if the range is outside the memory of the server.
+func (img *sqliteImage) read(rt registerType, address int, quantity int) ([]uint16, error) {
+	if address < 0 || quantity < 0 || address+quantity > img.size(rt) {
+		return nil, mbserver.IllegalDataAddress
+	}
+
+	rows, err := img.st.db.Query("SELECT address, value FROM registers WHERE device = ? AND unit = ? AND register = ? AND address >= ? AND address < ?",
+		img.device, img.unit, string(rt), address, address+quantity)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: failed to read the registers: %v", err)
+	}
+	defer rows.Close()
+
+	values := make([]uint16, quantity)
+	for rows.Next() {
+		var addr int
+		var v uint16
+		err := rows.Scan(&addr, &v)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite: failed to read the registers: %v", err)
+		}
+		values[addr-address] = v
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: failed to read the registers: %v", err)
+	}
+
+	return values, nil
+}
+
+// write will write the values to the registers of the type given in the
+// database, and then to the memory of the server, or return
+// IllegalDataAddress without writing if the range is outside the memory.
+// The server must be locked.
+func (img *sqliteImage) write(rt registerType, address int, values []uint16) error {
+	if address < 0 || address+len(values) > img.size(rt) {
+		return mbserver.IllegalDataAddress
+	}
+
+	changes := make([]sqliteChange, len(values))
+	for i, v := range values {
+		changes[i] = img.change(rt, address+i, v)
+	}
+	err := img.st.writeChanges(changes, img.st.clk.Now())
+	if err != nil {
+		return err
+	}
+
+	for i, v := range values {
+		img.setMemory(rt, address+i, v)
+	}
+	return nil
+}
+
+// readCoils will return the coils or discrete inputs read from the
+// database as bytes.
+func (img *sqliteImage) readCoils(rt registerType, address int, quantity int) ([]byte, error) {
+	words, err := img.read(rt, address, quantity)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, len(words))
+	for i, v := range words {
+		b[i] = byte(v)
+	}
+	return b, nil
+}
+
+func (img *sqliteImage) ReadCoils(address int, quantity int) ([]byte, error) {
+	return img.readCoils(coilType, address, quantity)
+}
+
+func (img *sqliteImage) ReadDiscreteInputs(address int, quantity int) ([]byte, error) {
+	return img.readCoils(discreteType, address, quantity)
+}
+
+func (img *sqliteImage) ReadHoldingRegisters(address int, quantity int) ([]uint16, error) {
+	return img.read(holdingType, address, quantity)
+}
+
+func (img *sqliteImage) ReadInputRegisters(address int, quantity int) ([]uint16, error) {
+	return img.read(inputType, address, quantity)
+}
+
+func (img *sqliteImage) WriteCoils(address int, values []byte) error {
+	return img.write(coilType, address, bytesToWords(values))
+}
+
+func (img *sqliteImage) WriteHoldingRegisters(address int, values []uint16) error {
+	return img.write(holdingType, address, values)
+}
+
+func (img *sqliteImage) WriteDiscreteInputs(address int, values []byte) error {
+	return img.write(discreteType, address, bytesToWords(values))
+}
+
+func (img *sqliteImage) WriteInputRegisters(address int, values []uint16) error {
+	return img.write(inputType, address, values)
+}
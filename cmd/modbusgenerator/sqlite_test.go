@@ -0,0 +1,113 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "registers.db")
+	clk := newSimClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+	entries := map[registerType][]configEntry{
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "uint16", "number": 100.0, "regAddr": 11.0},
+		}),
+	}
+
+	newStore := func(serv *mbserver.Server) *sqliteStore {
+		st, err := openSQLiteStore(filename, clk)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		t.Cleanup(func() { st.Close() })
+		st.add(&device{name: "boiler", serv: serv, servers: []*mbserver.Server{serv}, profile: profile{entries: entries}}, -1)
+		err = st.load()
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		return st
+	}
+
+	// The values of the config files are written to the database by
+	// the first generator.
+	a := mbserver.NewServer()
+	a.HoldingRegisters[10] = 100
+	a.Coils[3] = 1
+	stA := newStore(a)
+
+	// The values of the database take precedence over the values of the
+	// config files of the second generator.
+	b := mbserver.NewServer()
+	b.HoldingRegisters[10] = 5
+	stB := newStore(b)
+	if b.HoldingRegisters[10] != 100 || b.Coils[3] != 1 {
+		t.Errorf("expected holding 100 and coil 1, got %v and %v", b.HoldingRegisters[10], b.Coils[3])
+	}
+
+	// A write of a client is written to the database and the memory, and
+	// read by the clients of the other generator.
+	err := a.Store.WriteHoldingRegisters(20, []uint16{7, 8})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if a.HoldingRegisters[20] != 7 || a.HoldingRegisters[21] != 8 {
+		t.Errorf("expected the write in memory, got %v", a.HoldingRegisters[20:22])
+	}
+	got, err := b.Store.ReadHoldingRegisters(19, 3)
+	expect := []uint16{0, 7, 8}
+	if err != nil || !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v and %v", expect, got, err)
+	}
+	coils, err := b.Store.ReadCoils(3, 1)
+	if err != nil || coils[0] != 1 {
+		t.Errorf("expected coil 1, got %v and %v", coils, err)
+	}
+
+	// A change of an entry by the simulation is written by the sync, and
+	// set in the memory of the other generator by its sync.
+	a.Lock()
+	a.HoldingRegisters[10] = 42
+	a.Unlock()
+	err = stA.sync(clk.Now())
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	got, _ = b.Store.ReadHoldingRegisters(10, 1)
+	if got[0] != 42 {
+		t.Errorf("expected 42, got %v", got[0])
+	}
+	err = stB.sync(clk.Now())
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if b.HoldingRegisters[10] != 42 || b.HoldingRegisters[21] != 8 {
+		t.Errorf("expected 42 and 8, got %v and %v", b.HoldingRegisters[10], b.HoldingRegisters[21])
+	}
+
+	// The values are kept when the generator is started again.
+	c := mbserver.NewServer()
+	newStore(c)
+	expect = []uint16{42, 0}
+	if !isEqual(expect, c.HoldingRegisters[10:12]) {
+		t.Errorf("expected %v, got %v", expect, c.HoldingRegisters[10:12])
+	}
+
+	// The reads outside the memory are answered with IllegalDataAddress.
+	_, err = a.Store.ReadHoldingRegisters(65535, 2)
+	if err != mbserver.IllegalDataAddress {
+		t.Errorf("expected %v, got %v", mbserver.IllegalDataAddress, err)
+	}
+
+	// Every change is kept in the history.
+	var count int
+	err = stA.db.QueryRow("SELECT COUNT(*) FROM history WHERE device = 'boiler' AND register = 'holding'").Scan(&count)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if count != 4 {
+		t.Errorf("expected 4, got %v", count)
+	}
+}
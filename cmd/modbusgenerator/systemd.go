@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify will send the state given to systemd using the socket given
+// in the NOTIFY_SOCKET environment variable, e.g. "READY=1". If the
+// generator is not started by systemd with Type=notify nothing is sent.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// An abstract socket is given with a leading @.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// startWatchdog will start sending keep-alive pings to the systemd
+// watchdog at half the interval given in the WATCHDOG_USEC environment
+// variable. If the watchdog is not enabled for the service nothing is
+// sent.
+func startWatchdog() {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return
+	}
+
+	// Only ping if the watchdog is meant for this process.
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	interval := time.Duration(usec) * time.Microsecond / 2
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			err := sdNotify("WATCHDOG=1")
+			if err != nil {
+				log.Printf("error: systemd watchdog: %v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestSdNotify(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	err = sdNotify("READY=1")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := "READY=1"
+	got := string(buf[:n])
+	if expect != got {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestSdNotifyWithoutSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	err := sdNotify("READY=1")
+	if err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// encoderType describes one of the encoder types that can be used
+// in the "type" field of a config entry.
+type encoderType struct {
+	typeName string
+	words    int
+	// registerTypes holds the register types the encoder type can be
+	// used for, where the bit style types are used for the coils and
+	// discrete inputs, and the register style types for the input and
+	// holding registers.
+	registerTypes []registerType
+	description   string
+}
+
+// encoderTypes holds all the encoder types that NewEncoder knows
+// about.
+var encoderTypes = []encoderType{
+	{
+		typeName:      "float32LittleWordBigEndian",
+		words:         2,
+		registerTypes: []registerType{inputType, holdingType},
+		description:   "float32 where the two words have swapped order, and the byte order within each word is normal",
+	},
+	{
+		typeName:      "float32BigWordBigEndian",
+		words:         2,
+		registerTypes: []registerType{inputType, holdingType},
+		description:   "float32 where the two words are in normal order, and the byte order within each word is normal",
+	},
+	{
+		typeName:      "float32LittleWordLittleEndian",
+		words:         2,
+		registerTypes: []registerType{inputType, holdingType},
+		description:   "float32 where the two words have swapped order, and the byte order within each word is swapped",
+	},
+	{
+		typeName:      "float32BigWordLittleEndian",
+		words:         2,
+		registerTypes: []registerType{inputType, holdingType},
+		description:   "float32 where the two words are in normal order, and the byte order within each word is swapped",
+	},
+	{
+		typeName:      "wordInt16BigEndian",
+		words:         1,
+		registerTypes: []registerType{coilType, discreteType},
+		description:   "single word where the value is held in the 8MSB, generally used for coil and discrete registers",
+	},
+	{
+		typeName:      "wordInt16LittleEndian",
+		words:         1,
+		registerTypes: []registerType{coilType, discreteType, inputType, holdingType},
+		description:   "single word where the byte order is swapped",
+	},
+	{
+		typeName:      "int16BigEndian",
+		words:         1,
+		registerTypes: []registerType{inputType, holdingType},
+		description:   "signed single word from -32768 to 32767 in two's complement, where the byte order is normal",
+	},
+	{
+		typeName:      "int16LittleEndian",
+		words:         1,
+		registerTypes: []registerType{inputType, holdingType},
+		description:   "signed single word from -32768 to 32767 in two's complement, where the byte order is swapped",
+	},
+	{
+		typeName:      "uint16BigEndian",
+		words:         1,
+		registerTypes: []registerType{inputType, holdingType},
+		description:   "unsigned single word from 0 to 65535, where the byte order is normal",
+	},
+	{
+		typeName:      "bit",
+		words:         1,
+		registerTypes: []registerType{coilType, discreteType},
+		description:   "a single coil or discrete input set to exactly 0 or 1, which can also be given as true/false or on/off",
+	},
+}
+
+// typeAlias is an alternative name for one of the encoder types,
+// following the naming conventions used by other modbus tools.
+// The letters in the alias describe the byte order as it is sent
+// on the wire, where A is the most significant byte of the value.
+type typeAlias struct {
+	alias    string
+	typeName string
+}
+
+// typeAliases holds all the aliases that can be used in the "type"
+// field of a config entry instead of the encoder type name.
+var typeAliases = []typeAlias{
+	{alias: "float32_abcd", typeName: "float32BigWordBigEndian"},
+	{alias: "float32_be", typeName: "float32BigWordBigEndian"},
+	{alias: "float32be", typeName: "float32BigWordBigEndian"},
+	{alias: "float32_badc", typeName: "float32BigWordLittleEndian"},
+	{alias: "float32_be_byteswap", typeName: "float32BigWordLittleEndian"},
+	{alias: "float32_cdab", typeName: "float32LittleWordBigEndian"},
+	{alias: "float32_le_byteswap", typeName: "float32LittleWordBigEndian"},
+	{alias: "float32_dcba", typeName: "float32LittleWordLittleEndian"},
+	{alias: "float32_le", typeName: "float32LittleWordLittleEndian"},
+	{alias: "float32le", typeName: "float32LittleWordLittleEndian"},
+	{alias: "int16", typeName: "int16BigEndian"},
+	{alias: "int16_ab", typeName: "int16BigEndian"},
+	{alias: "int16_be", typeName: "int16BigEndian"},
+	{alias: "int16_ba", typeName: "int16LittleEndian"},
+	{alias: "int16_le", typeName: "int16LittleEndian"},
+	{alias: "uint16", typeName: "uint16BigEndian"},
+	{alias: "uint16_ab", typeName: "uint16BigEndian"},
+	{alias: "uint16_be", typeName: "uint16BigEndian"},
+	{alias: "uint16_ba", typeName: "wordInt16LittleEndian"},
+	{alias: "uint16_le", typeName: "wordInt16LittleEndian"},
+	{alias: "wordInt16", typeName: "int16BigEndian"},
+	{alias: "wordUint16", typeName: "uint16BigEndian"},
+}
+
+// resolveTypeAlias will return the encoder type name for the alias
+// given. If the name given is not an alias it is returned as it is.
+func resolveTypeAlias(name string) string {
+	for _, v := range typeAliases {
+		if v.alias == name {
+			return v.typeName
+		}
+	}
+
+	return name
+}
+
+// checkEntryType will return an error if the type of the entry given can
+// not be used for the register type given, like a float32 in a coil file,
+// which would give a meaningless bit image. The number of a coil or
+// discrete input must be 0 or 1.
+func checkEntryType(rt registerType, e configEntry) error {
+	name := entryType(e.enc)
+	for _, v := range encoderTypes {
+		if v.typeName != name {
+			continue
+		}
+		if !containsRegisterType(v.registerTypes, rt) {
+			return e.errorf("type %v can not be used for %v registers, use one of %v", e.raw["type"], rt, strings.Join(typesFor(rt), ", "))
+		}
+	}
+
+	if rt == coilType || rt == discreteType {
+		n := e.enc.Decode(e.enc.Encode())
+		if n != 0 && n != 1 {
+			return e.errorf("number of a %v must be 0 or 1, got %v", rt, e.raw["number"])
+		}
+	}
+
+	return nil
+}
+
+// containsRegisterType will return true if the register type is found in
+// the register types given.
+func containsRegisterType(rts []registerType, rt registerType) bool {
+	for _, v := range rts {
+		if v == rt {
+			return true
+		}
+	}
+	return false
+}
+
+// typesFor will return the names of the encoder types that can be used for
+// the register type given.
+func typesFor(rt registerType) []string {
+	var names []string
+	for _, v := range encoderTypes {
+		if containsRegisterType(v.registerTypes, rt) {
+			names = append(names, v.typeName)
+		}
+	}
+	return names
+}
+
+// printTypes will write a table of all the encoder types, and a
+// table of all the type aliases and the encoder types they map onto.
+func printTypes(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "TYPE\tWORDS\tREGISTERS\tDESCRIPTION\n")
+	for _, v := range encoderTypes {
+		var rts []string
+		for _, rt := range v.registerTypes {
+			rts = append(rts, string(rt))
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", v.typeName, v.words, strings.Join(rts, "|"), v.description)
+	}
+	fmt.Fprintf(tw, "\n")
+	fmt.Fprintf(tw, "ALIAS\tTYPE\n")
+	for _, v := range typeAliases {
+		fmt.Fprintf(tw, "%v\t%v\n", v.alias, v.typeName)
+	}
+	tw.Flush()
+}
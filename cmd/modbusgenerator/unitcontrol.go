@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// offlineResponses is the ways a unit that is offline can answer, by the
+// name used in the unit endpoints.
+var offlineResponses = map[string]*mbserver.Exception{
+	"none":    nil,
+	"timeout": &mbserver.GatewayTargetDeviceFailedtoRespond,
+	"path":    &mbserver.GatewayPathUnavailable,
+}
+
+// offlineResponseName will return the name of the offline response given.
+func offlineResponseName(exception *mbserver.Exception) string {
+	for name, e := range offlineResponses {
+		if e == exception {
+			return name
+		}
+	}
+	return exception.String()
+}
+
+// unitControl takes the units of the devices offline and back online at
+// runtime.
+type unitControl struct {
+	devices []*device
+}
+
+// unitStatus is the status of a unit that is offline returned by the unit
+// endpoints.
+type unitStatus struct {
+	Device   string `json:"device,omitempty"`
+	Address  string `json:"address"`
+	Unit     uint8  `json:"unit"`
+	Response string `json:"response"`
+}
+
+// selectDevices will return the devices with the name given with the
+// device query parameter, or all the devices if not given.
+func selectDevices(devices []*device, r *http.Request) ([]*device, error) {
+	name := r.URL.Query().Get("device")
+	if name == "" {
+		return devices, nil
+	}
+
+	for _, d := range devices {
+		if d.name == name {
+			return []*device{d}, nil
+		}
+	}
+	return nil, fmt.Errorf("no device named %q", name)
+}
+
+// offlineUnits will return the status of all the units that are offline.
+func (uc *unitControl) offlineUnits() []unitStatus {
+	units := []unitStatus{}
+	for _, d := range uc.devices {
+		d.serv.Lock()
+		for id := 0; id < 256; id++ {
+			exception, ok := d.serv.UnitOffline(uint8(id))
+			if !ok {
+				continue
+			}
+			units = append(units, unitStatus{
+				Device:   d.name,
+				Address:  d.address,
+				Unit:     uint8(id),
+				Response: offlineResponseName(exception),
+			})
+		}
+		d.serv.Unlock()
+	}
+
+	return units
+}
+
+// handleUnits answers with the units that are offline.
+func (uc *unitControl) handleUnits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, uc.offlineUnits())
+}
+
+// handleOffline will take the unit given with the unit query parameter
+// offline, answering with the response given with the response query
+// parameter, which is none for not answering at all, timeout for the
+// Gateway Target Device Failed to Respond exception, or path for the
+// Gateway Path Unavailable exception, e.g.
+// /units/offline?unit=3&response=timeout. The device query parameter
+// selects the device of a fleet, and all the devices are selected if not
+// given.
+func (uc *unitControl) handleOffline(w http.ResponseWriter, r *http.Request) {
+	uc.handleChange(w, r, true)
+}
+
+// handleOnline will bring the unit given with the unit query parameter
+// back online, e.g. /units/online?unit=3.
+func (uc *unitControl) handleOnline(w http.ResponseWriter, r *http.Request) {
+	uc.handleChange(w, r, false)
+}
+
+// handleChange will take the unit given offline or back online, and
+// answer with the units that are offline.
+func (uc *unitControl) handleChange(w http.ResponseWriter, r *http.Request, offline bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseUint(r.URL.Query().Get("unit"), 10, 8)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unit must be a unit ID from 0 to 255, got %q", r.URL.Query().Get("unit")), http.StatusBadRequest)
+		return
+	}
+
+	response := "timeout"
+	if v := r.URL.Query().Get("response"); v != "" {
+		response = v
+	}
+	exception, ok := offlineResponses[response]
+	if offline && !ok {
+		http.Error(w, fmt.Sprintf("response must be none, timeout or path, got %q", response), http.StatusBadRequest)
+		return
+	}
+
+	devices, err := selectDevices(uc.devices, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	for _, d := range devices {
+		d.serv.Lock()
+		if offline {
+			d.serv.SetUnitOffline(uint8(id), exception)
+		} else {
+			d.serv.SetUnitOnline(uint8(id))
+		}
+		d.serv.Unlock()
+	}
+
+	writeJSON(w, http.StatusOK, uc.offlineUnits())
+}
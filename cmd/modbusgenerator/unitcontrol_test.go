@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestUnitControl(t *testing.T) {
+	d1 := &device{name: "d1", address: ":10502", serv: mbserver.NewServer()}
+	d2 := &device{name: "d2", address: ":10503", serv: mbserver.NewServer()}
+	uc := &unitControl{devices: []*device{d1, d2}}
+
+	rec := httptest.NewRecorder()
+	uc.handleOffline(rec, httptest.NewRequest("POST", "/units/offline?unit=3&response=path&device=d2", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected %v, got %v", http.StatusOK, rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	uc.handleOffline(rec, httptest.NewRequest("POST", "/units/offline?unit=4&response=none", nil))
+
+	expect := []unitStatus{
+		{Device: "d1", Address: ":10502", Unit: 4, Response: "none"},
+		{Device: "d2", Address: ":10503", Unit: 3, Response: "path"},
+		{Device: "d2", Address: ":10503", Unit: 4, Response: "none"},
+	}
+	got := uc.offlineUnits()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	rec = httptest.NewRecorder()
+	uc.handleOnline(rec, httptest.NewRequest("POST", "/units/online?unit=4", nil))
+	rec = httptest.NewRecorder()
+	uc.handleOnline(rec, httptest.NewRequest("POST", "/units/online?unit=3&device=d2", nil))
+	if got := uc.offlineUnits(); len(got) != 0 {
+		t.Errorf("expected no units offline, got %v", got)
+	}
+
+	tests := map[string]int{
+		"/units/offline?unit=300":                  http.StatusBadRequest,
+		"/units/offline?unit=3&response=foo":       http.StatusBadRequest,
+		"/units/offline?unit=3&device=nonexisting": http.StatusNotFound,
+	}
+	for url, code := range tests {
+		rec = httptest.NewRecorder()
+		uc.handleOffline(rec, httptest.NewRequest("POST", url, nil))
+		if rec.Code != code {
+			t.Errorf("%v: expected %v, got %v", url, code, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// parseUnitIDs will parse a comma separated list of modbus unit IDs,
+// e.g. "1,2,3". Valid unit IDs are 1 to 247.
+func parseUnitIDs(s string) ([]uint8, error) {
+	var ids []uint8
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		id, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || id < 1 || id > 247 {
+			return nil, fmt.Errorf("invalid unit ID %q, valid unit IDs are 1 to 247", v)
+		}
+		ids = append(ids, uint8(id))
+	}
+
+	return ids, nil
+}
+
+// containsUnitID will return true if the unit ID given is in ids.
+func containsUnitID(ids []uint8, id uint8) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// addUnits will add a unit to the server for each of the unit IDs given,
+// where each unit gets its own copy of the registers populated in the
+// server from the config files.
+func addUnits(serv *mbserver.Server, ids []uint8) []*mbserver.Server {
+	var units []*mbserver.Server
+	for _, id := range ids {
+		u := serv.AddUnit(id)
+		copyRegisters(u, serv)
+		units = append(units, u)
+	}
+
+	return units
+}
+
+// copyRegisters will copy all the registers of the src server into the
+// dst server.
+func copyRegisters(dst *mbserver.Server, src *mbserver.Server) {
+	copy(dst.Coils[:cap(dst.Coils)], src.Coils[:cap(src.Coils)])
+	copy(dst.DiscreteInputs[:cap(dst.DiscreteInputs)], src.DiscreteInputs[:cap(src.DiscreteInputs)])
+	copy(dst.HoldingRegisters[:cap(dst.HoldingRegisters)], src.HoldingRegisters[:cap(src.HoldingRegisters)])
+	copy(dst.InputRegisters[:cap(dst.InputRegisters)], src.InputRegisters[:cap(src.InputRegisters)])
+}
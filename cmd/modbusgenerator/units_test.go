@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestAddUnits(t *testing.T) {
+	ids, err := parseUnitIDs("1,2")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	serv := mbserver.NewServer()
+	serv.HoldingRegisters[100] = 7
+
+	units := addUnits(serv, ids)
+	if len(units) != 2 {
+		t.Fatalf("expected 2 units, got %v", len(units))
+	}
+
+	// Each unit should have its own copy of the registers.
+	units[0].HoldingRegisters[100] = 8
+	got := []uint16{serv.HoldingRegisters[100], units[0].HoldingRegisters[100], units[1].HoldingRegisters[100]}
+	expect := []uint16{7, 8, 7}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestParseUnitIDsInvalid(t *testing.T) {
+	for _, s := range []string{"0", "248", "foo"} {
+		_, err := parseUnitIDs(s)
+		if err == nil {
+			t.Errorf("%q: expected error not nil, got %v", s, err)
+		}
+	}
+}
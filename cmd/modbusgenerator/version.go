@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// version and buildTime are set when building a release, with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.buildTime=2024-01-02T10:00:00Z"
+//
+// When not set they are taken from the version control info the go
+// command embeds in the binary.
+var (
+	version   = ""
+	buildTime = ""
+)
+
+// buildInfo is the version and build time of the binary.
+type buildInfo struct {
+	version string
+	// revision is the version control revision the binary was built
+	// from, when known.
+	revision  string
+	buildTime time.Time
+}
+
+// readBuildInfo will return the version and build time of the binary.
+func readBuildInfo() buildInfo {
+	bi := buildInfo{version: version}
+	bi.buildTime, _ = time.Parse(time.RFC3339, buildTime)
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if bi.version == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			bi.version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				bi.revision = s.Value
+			case "vcs.time":
+				if bi.buildTime.IsZero() {
+					bi.buildTime, _ = time.Parse(time.RFC3339, s.Value)
+				}
+			}
+		}
+	}
+	if bi.version == "" {
+		bi.version = "devel"
+	}
+
+	return bi
+}
+
+// String will return the version with the revision and build time when
+// known.
+func (bi buildInfo) String() string {
+	s := bi.version
+	if bi.revision != "" {
+		rev := bi.revision
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		s += " " + rev
+	}
+	if !bi.buildTime.IsZero() {
+		s += " " + bi.buildTime.UTC().Format(time.RFC3339)
+	}
+	return s
+}
+
+// printVersion will print the version of the binary.
+func printVersion() {
+	fmt.Printf("modbusgenerator %v\n", readBuildInfo())
+}
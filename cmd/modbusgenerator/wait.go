@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expectation is the value a register is expected to have by the assert
+// and wait-for commands, like ">=20.5".
+type expectation struct {
+	// op is one of ==, !=, <, <=, > and >=.
+	op    string
+	value float64
+	// tolerance is the largest difference between the value read and the
+	// value expected that is still equal.
+	tolerance float64
+}
+
+// expectationOps holds the operators of an expectation, where the two
+// character operators are first so they are found before < and >.
+var expectationOps = []string{"==", "!=", "<=", ">=", "<", ">", "="}
+
+// parseExpectation will parse an expectation like "7", "==7", "!=0" or
+// ">=20.5", where a value without an operator must be equal to the
+// value read.
+func parseExpectation(s string, tolerance float64) (expectation, error) {
+	e := expectation{op: "==", tolerance: tolerance}
+	for _, op := range expectationOps {
+		if strings.HasPrefix(s, op) {
+			e.op = op
+			s = s[len(op):]
+			break
+		}
+	}
+	if e.op == "=" {
+		e.op = "=="
+	}
+
+	var err error
+	e.value, err = strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return expectation{}, fmt.Errorf("invalid expected value %q, must be a number with an optional ==, !=, <, <=, > or >= before it", s)
+	}
+
+	return e, nil
+}
+
+// match will return true if the value given is as expected. NaN is only
+// matched by !=.
+func (e expectation) match(v float64) bool {
+	equal := math.Abs(v-e.value) <= e.tolerance
+	switch e.op {
+	case "!=":
+		return !equal
+	case "<":
+		return v < e.value
+	case "<=":
+		return v <= e.value
+	case ">":
+		return v > e.value
+	case ">=":
+		return v >= e.value
+	default:
+		return equal
+	}
+}
+
+func (e expectation) String() string {
+	if e.tolerance > 0 && (e.op == "==" || e.op == "!=") {
+		return fmt.Sprintf("%v %v ±%v", e.op, e.value, e.tolerance)
+	}
+	return fmt.Sprintf("%v %v", e.op, e.value)
+}
+
+// waitFor will read the values with the function given every interval
+// until all of them are as expected, or the timeout is reached, and
+// return the values read last. The errors of the reads are retried until
+// the timeout, so the values can be waited for while the server is
+// starting. With a timeout of 0 the values are only read once.
+func waitFor(read func() ([]float64, error), e expectation, timeout time.Duration, interval time.Duration) ([]float64, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		values, err := read()
+		if err == nil {
+			matched := len(values) > 0
+			for _, v := range values {
+				if !e.match(v) {
+					matched = false
+				}
+			}
+			if matched {
+				return values, nil
+			}
+			err = fmt.Errorf("expected %v, got %v", e, formatValues(values))
+		}
+
+		if timeout == 0 {
+			return values, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return values, fmt.Errorf("timed out after %v: %w", timeout, err)
+		}
+		time.Sleep(min(interval, remaining))
+	}
+}
+
+// formatValues will format the values given as a comma separated list.
+func formatValues(values []float64) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestExpectation(t *testing.T) {
+	tests := []struct {
+		s      string
+		v      float64
+		expect bool
+	}{
+		{"7", 7, true},
+		{"7", 7.5, false},
+		{"==7", 7, true},
+		{"=7", 7, true},
+		{"!=0", 1, true},
+		{"!=0", 0, false},
+		{"<5", 4.9, true},
+		{"<=5", 5, true},
+		{">20.5", 20.5, false},
+		{">=20.5", 20.5, true},
+		{"!=1", math.NaN(), true},
+		{"==1", math.NaN(), false},
+	}
+	for _, tt := range tests {
+		e, err := parseExpectation(tt.s, 0)
+		if err != nil {
+			t.Fatalf("%v: expected nil, got %v", tt.s, err)
+		}
+		if got := e.match(tt.v); got != tt.expect {
+			t.Errorf("%v %v: expected %v, got %v", tt.s, tt.v, tt.expect, got)
+		}
+	}
+
+	e, _ := parseExpectation("20", 0.5)
+	if !e.match(20.4) || e.match(20.6) {
+		t.Errorf("expected 20.4 to match and 20.6 not to match %v", e)
+	}
+
+	for _, s := range []string{"", "abc", ">>1", "<"} {
+		_, err := parseExpectation(s, 0)
+		if err == nil {
+			t.Errorf("%q: expected error, got %v", s, err)
+		}
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	e, _ := parseExpectation(">=3", 0)
+
+	// The errors and values not as expected are retried until the value
+	// is as expected.
+	reads := 0
+	read := func() ([]float64, error) {
+		reads++
+		if reads == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return []float64{float64(reads), 5}, nil
+	}
+	values, err := waitFor(read, e, time.Second, time.Millisecond)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if !isEqual([]float64{3, 5}, values) {
+		t.Errorf("expected %v, got %v", []float64{3, 5}, values)
+	}
+
+	// With a timeout of 0 the values are only read once.
+	reads = 1
+	_, err = waitFor(read, e, 0, time.Millisecond)
+	if err == nil || reads != 2 {
+		t.Errorf("expected a single read failing, got %v reads and %v", reads, err)
+	}
+
+	start := time.Now()
+	_, err = waitFor(func() ([]float64, error) { return []float64{1}, nil }, e, time.Millisecond*50, time.Millisecond*10)
+	if err == nil || time.Since(start) < time.Millisecond*50 {
+		t.Errorf("expected to time out after 50ms, got %v after %v", err, time.Since(start))
+	}
+}
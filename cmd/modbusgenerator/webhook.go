@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// webhookQueueSize is the number of write events waiting to be posted to
+// the webhook, where the events are dropped when the queue is full.
+const webhookQueueSize = 1000
+
+// webhookEvent is the JSON summary of a write request of a client posted
+// to the webhook.
+type webhookEvent struct {
+	Time     time.Time `json:"time"`
+	Device   string    `json:"device,omitempty"`
+	Unit     int       `json:"unit"`
+	Register string    `json:"register"`
+	// Address is the first address written, as given in the config
+	// files, and Values the values of the addresses written.
+	Address int      `json:"address"`
+	Values  []uint16 `json:"values"`
+	// Entries is the values of the config entries the write touched.
+	Entries []webhookEntry `json:"entries"`
+}
+
+// webhookEntry is the value of a config entry touched by a write.
+type webhookEntry struct {
+	Address int      `json:"address"`
+	Type    string   `json:"type"`
+	Name    string   `json:"name,omitempty"`
+	Value   *float64 `json:"value,omitempty"`
+	Text    string   `json:"text"`
+}
+
+// webhook posts the write events of the servers to a URL, e.g. to let a
+// test orchestrator follow what the client under test writes without a
+// message bus. The events are posted one at a time in the order they
+// happened, so a slow webhook does not slow down the clients.
+type webhook struct {
+	url    string
+	token  string
+	client http.Client
+	events chan webhookEvent
+}
+
+// newWebhook will start posting the write events to the URL given, with
+// the token given as a bearer token when not empty.
+func newWebhook(url string, token string) *webhook {
+	wh := &webhook{
+		url:    url,
+		token:  token,
+		client: http.Client{Timeout: time.Second * 10},
+		events: make(chan webhookEvent, webhookQueueSize),
+	}
+
+	go func() {
+		for ev := range wh.events {
+			if err := wh.post(ev); err != nil {
+				log.Printf("error: webhook: %v\n", err)
+			}
+		}
+	}()
+
+	return wh
+}
+
+// post will post the event given to the webhook.
+func (wh *webhook) post(ev webhookEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, wh.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.token != "" {
+		req.Header.Set("Authorization", "Bearer "+wh.token)
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected http status: %v", resp.Status)
+	}
+
+	return nil
+}
+
+// watch will wrap the write functions of the server, so every successful
+// write request is posted to the webhook with the values written and the
+// values of the config entries given it touched, at the time of the
+// simulation clock.
+func (wh *webhook) watch(serv *mbserver.Server, deviceName string, unitID int, entries map[registerType][]configEntry, addrOffset int, clk *simClock) {
+	watchWrites(serv, func(rt registerType, start int, count int) {
+		ev := webhookEvent{
+			Time:     clk.Now(),
+			Device:   deviceName,
+			Unit:     unitID,
+			Register: string(rt),
+			Address:  start - addrOffset,
+			Entries:  []webhookEntry{},
+		}
+
+		switch rt {
+		case coilType:
+			for _, b := range serv.Coils[start : start+count] {
+				ev.Values = append(ev.Values, uint16(b))
+			}
+		case holdingType:
+			ev.Values = append(ev.Values, serv.HoldingRegisters[start:start+count]...)
+		}
+
+		for _, e := range entries[rt] {
+			if !entryInRange(rt, e, start, count, addrOffset) {
+				continue
+			}
+			v, ok := entryValue(serv, rt, e, addrOffset)
+			if !ok {
+				continue
+			}
+			we := webhookEntry{
+				Address: e.enc.Address(),
+				Type:    entryType(e.enc),
+				Name:    e.meta().Name,
+				Text:    strconv.FormatFloat(v, 'g', -1, 64),
+			}
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				we.Value = &v
+			}
+			ev.Entries = append(ev.Entries, we)
+		}
+
+		select {
+		case wh.events <- ev:
+		default:
+			log.Printf("warning: webhook: the queue is full, dropping the write to %v %v\n", rt, ev.Address)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestWebhook(t *testing.T) {
+	events := make(chan webhookEvent, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("expected %v, got %v", "Bearer secret", r.Header.Get("Authorization"))
+		}
+		var ev webhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("expected nil, got %v", err)
+		}
+		events <- ev
+	}))
+	defer ts.Close()
+
+	entries := map[registerType][]configEntry{
+		holdingType: testEntries([]map[string]interface{}{
+			{"type": "float32_abcd", "number": 0.0, "regAddr": 101.0, "name": "setpoint"},
+			{"type": "uint16", "number": 0.0, "regAddr": 103.0},
+		}),
+	}
+	serv := mbserver.NewServer()
+	clk := newSimClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+	wh := newWebhook(ts.URL, "secret")
+	wh.watch(serv, "boiler", 2, entries, -1, clk)
+
+	// Writing 12.5 to 101 with Write Multiple Registers.
+	frame := &mbserver.TCPFrame{Device: 2, Function: 16, Data: []byte{0, 100, 0, 2, 4, 0x41, 0x48, 0, 0}}
+	if _, exception := serv.FunctionHandler(16)(serv, frame); exception != &mbserver.Success {
+		t.Fatalf("expected success, got %v", exception)
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Device != "boiler" || ev.Unit != 2 || ev.Register != "holding" || ev.Address != 101 || !ev.Time.Equal(clk.Now()) {
+			t.Errorf("expected the write of boiler unit 2 to holding 101, got %+v", ev)
+		}
+		if !isEqual([]uint16{0x4148, 0}, ev.Values) {
+			t.Errorf("expected %v, got %v", []uint16{0x4148, 0}, ev.Values)
+		}
+		if len(ev.Entries) != 1 || ev.Entries[0].Name != "setpoint" || ev.Entries[0].Text != "12.5" {
+			t.Errorf("expected setpoint written with 12.5, got %+v", ev.Entries)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the write posted to the webhook")
+	}
+
+	// A write that failed is not posted.
+	frame = &mbserver.TCPFrame{Device: 2, Function: 6, Data: []byte{0xff, 0xff}}
+	serv.FunctionHandler(6)(serv, frame)
+	select {
+	case ev := <-events:
+		t.Errorf("expected nothing posted, got %+v", ev)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
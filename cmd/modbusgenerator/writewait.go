@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+// defaultWriteWaitTimeout is how long a wait for a write waits when no
+// timeout is given.
+const defaultWriteWaitTimeout = time.Second * 30
+
+// writtenRange will return the register type and the range of the
+// addresses written by the write request given, and false if the request
+// is not a write request.
+func writtenRange(fc uint8, data []byte) (registerType, int, int, bool) {
+	if len(data) < 4 {
+		return "", 0, 0, false
+	}
+
+	var rt registerType
+	count := 1
+	switch fc {
+	case 5:
+		rt = coilType
+	case 6:
+		rt = holdingType
+	case 15:
+		rt = coilType
+		count = int(binary.BigEndian.Uint16(data[2:4]))
+	case 16:
+		rt = holdingType
+		count = int(binary.BigEndian.Uint16(data[2:4]))
+	default:
+		return "", 0, 0, false
+	}
+
+	return rt, int(binary.BigEndian.Uint16(data[0:2])), count, true
+}
+
+// watchWrites will wrap the write functions of the server, so fn is called
+// with the register type and the range of the addresses written after
+// every successful write request. It must be called with the server
+// locked, and fn is called with the server locked.
+func watchWrites(serv *mbserver.Server, fn func(rt registerType, start int, count int)) {
+	for _, fc := range writeFunctions {
+		function := serv.FunctionHandler(fc)
+		if function == nil {
+			continue
+		}
+
+		fc := fc
+		serv.RegisterFunctionHandler(fc, func(s *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+			res, exception := function(s, frame)
+			if exception == &mbserver.Success {
+				if rt, start, count, ok := writtenRange(fc, frame.GetData()); ok {
+					fn(rt, start, count)
+				}
+			}
+			return res, exception
+		})
+	}
+}
+
+// entryInRange will return true if the range of the addresses given
+// overlaps the registers of the config entry given.
+func entryInRange(rt registerType, e configEntry, start int, count int, addrOffset int) bool {
+	addr := e.enc.Address() + addrOffset
+	size := len(e.enc.Encode())
+	if rt == coilType {
+		size = coilCount(e.enc)
+	}
+	return start < addr+size && start+count > addr
+}
+
+// writeWaiter is a request waiting for a client to write a register of a
+// server.
+type writeWaiter struct {
+	device *device
+	unit   int
+	serv   *mbserver.Server
+	rt     registerType
+	addr   int
+	entry  configEntry
+	// expect is nil when any value written is waited for.
+	expect *expectation
+	// written receives the value written, and is shared by the waiters of
+	// the same request.
+	written chan registerValue
+}
+
+// writeWatch watches the write requests of the clients to all the servers
+// of the devices, so a request can wait for a client to write a register,
+// e.g. to make a handshake with the client in a test of a sequence of
+// commands.
+type writeWatch struct {
+	mu         sync.Mutex
+	waiters    map[*writeWaiter]bool
+	devices    []*device
+	addrOffset int
+}
+
+// newWriteWatch will start watching the write requests to all the servers
+// of the devices given.
+func newWriteWatch(devices []*device, addrOffset int) *writeWatch {
+	ww := &writeWatch{
+		waiters:    make(map[*writeWaiter]bool),
+		devices:    devices,
+		addrOffset: addrOffset,
+	}
+
+	for _, d := range devices {
+		d.serv.Lock()
+		for _, s := range d.servers {
+			serv := s
+			watchWrites(serv, func(rt registerType, start int, count int) {
+				ww.written(serv, rt, start, count)
+			})
+		}
+		d.serv.Unlock()
+	}
+
+	return ww
+}
+
+// written will hand the value written to the waiters of the server given
+// the write request satisfies, which is called with the server locked.
+func (ww *writeWatch) written(serv *mbserver.Server, rt registerType, start int, count int) {
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+
+	for w := range ww.waiters {
+		if w.serv != serv || w.rt != rt || !entryInRange(rt, w.entry, start, count, ww.addrOffset) {
+			continue
+		}
+		v, ok := entryValue(serv, rt, w.entry, ww.addrOffset)
+		if !ok || (w.expect != nil && !w.expect.match(v)) {
+			continue
+		}
+
+		rv := registerValue{
+			Device:   w.device.name,
+			Unit:     w.unit,
+			Register: string(rt),
+			Address:  w.addr,
+			Type:     entryType(w.entry.enc),
+			Text:     strconv.FormatFloat(v, 'g', -1, 64),
+		}
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			rv.Value = &v
+		}
+		// The channel has room for a value from each of the waiters of
+		// the request, so the send never blocks.
+		w.written <- rv
+		delete(ww.waiters, w)
+	}
+}
+
+// remove will stop the waiters given.
+func (ww *writeWatch) remove(waiters []*writeWaiter) {
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+
+	for _, w := range waiters {
+		delete(ww.waiters, w)
+	}
+}
+
+// handleWaitWrite waits for a client to write the address of the coil or
+// holding register given, and answers with the value written, e.g.
+// /registers/wait-write?register=coil&address=10&value=1. The value query
+// parameter is the value expected like ">=20.5", as for wait-for, and any
+// value written is waited for when not given. The address, type, device
+// and unit query parameters are as for /registers, where a write to any
+// of the devices selected ends the wait. It answers with 408 Request
+// Timeout when nothing is written within the timeout query parameter,
+// which is 30s when not given. Only the writes made after the request is
+// received are waited for, so the request must be made before the client
+// is triggered to write.
+func (ww *writeWatch) handleWaitWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed, use GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	devices, err := selectDevices(ww.devices, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	rt, addr, err := parseRegisterQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rt != coilType && rt != holdingType {
+		http.Error(w, fmt.Sprintf("the clients can only write the coil and holding registers, got %q", rt), http.StatusBadRequest)
+		return
+	}
+
+	unit, err := parseUnitQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var expect *expectation
+	if s := r.URL.Query().Get("value"); s != "" {
+		var tolerance float64
+		if t := r.URL.Query().Get("tolerance"); t != "" {
+			tolerance, err = strconv.ParseFloat(t, 64)
+			if err != nil || tolerance < 0 {
+				http.Error(w, fmt.Sprintf("tolerance must be a positive number, got %q", t), http.StatusBadRequest)
+				return
+			}
+		}
+		e, err := parseExpectation(s, tolerance)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("value: %v", err), http.StatusBadRequest)
+			return
+		}
+		expect = &e
+	}
+
+	timeout := defaultWriteWaitTimeout
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		timeout, err = time.ParseDuration(s)
+		if err != nil || timeout <= 0 {
+			http.Error(w, fmt.Sprintf("timeout must be a duration like 30s, got %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var waiters []*writeWaiter
+	written := make(chan registerValue, len(devices))
+	for _, d := range devices {
+		e, err := registerEntry(d.profile, rt, addr, r.URL.Query().Get("type"), 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		serv := d.serv
+		if s, ok := d.serv.Units()[uint8(unit)]; ok {
+			serv = s
+		}
+
+		waiters = append(waiters, &writeWaiter{
+			device:  d,
+			unit:    unit,
+			serv:    serv,
+			rt:      rt,
+			addr:    addr,
+			entry:   e,
+			expect:  expect,
+			written: written,
+		})
+	}
+
+	ww.mu.Lock()
+	for _, wt := range waiters {
+		ww.waiters[wt] = true
+	}
+	ww.mu.Unlock()
+	defer ww.remove(waiters)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case rv := <-written:
+		writeJSON(w, http.StatusOK, []registerValue{rv})
+	case <-timer.C:
+		http.Error(w, fmt.Sprintf("%v %v was not written within %v", rt, addr, timeout), http.StatusRequestTimeout)
+	case <-r.Context().Done():
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mbserver "github.com/postmannen/modbusgenerator"
+)
+
+func TestWaitWrite(t *testing.T) {
+	serv := mbserver.NewServer()
+	d := &device{serv: serv, servers: []*mbserver.Server{serv}}
+	ww := newWriteWatch([]*device{d}, -1)
+
+	err := serv.ListenRTUTCP("127.0.0.1:3407")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v", err)
+	}
+	defer serv.Close()
+	c, err := newClient("127.0.0.1:3407", 1, time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v", err)
+	}
+	defer c.Close()
+
+	// Writing the coil 0 does not end the wait for 1, but writing 1 with
+	// the coils around it does.
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/registers/wait-write?register=coil&address=10&value=1", nil)
+		ww.handleWaitWrite(rec, req)
+		close(done)
+	}()
+	for {
+		ww.mu.Lock()
+		n := len(ww.waiters)
+		ww.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	c.write(coilType, 9, []uint16{0})
+	select {
+	case <-done:
+		t.Fatalf("expected the wait to go on after writing 0")
+	case <-time.After(time.Millisecond * 100):
+	}
+	c.write(coilType, 8, []uint16{0, 1, 0})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the wait to end after writing 1")
+	}
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected %v, got %v: %v", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var values []registerValue
+	json.Unmarshal(rec.Body.Bytes(), &values)
+	if len(values) != 1 || values[0].Address != 10 || values[0].Text != "1" {
+		t.Errorf("expected coil 10 written with 1, got %+v", values)
+	}
+	if len(ww.waiters) != 0 {
+		t.Errorf("expected %v, got %v", 0, len(ww.waiters))
+	}
+
+	// Nothing written gives a timeout.
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/registers/wait-write?register=holding&address=5&timeout=50ms", nil)
+	ww.handleWaitWrite(rec, req)
+	if rec.Code != http.StatusRequestTimeout {
+		t.Errorf("expected %v, got %v", http.StatusRequestTimeout, rec.Code)
+	}
+	if len(ww.waiters) != 0 {
+		t.Errorf("expected %v, got %v", 0, len(ww.waiters))
+	}
+}
+
+func TestWaitWriteInvalid(t *testing.T) {
+	d := &device{serv: mbserver.NewServer()}
+	ww := newWriteWatch([]*device{d}, -1)
+
+	for _, query := range []string{
+		"register=input&address=1",
+		"register=coil&address=foo",
+		"register=coil&address=1&value=~1",
+		"register=coil&address=1&timeout=soon",
+		"register=coil&address=1&unit=300",
+	} {
+		rec := httptest.NewRecorder()
+		ww.handleWaitWrite(rec, httptest.NewRequest(http.MethodGet, "/registers/wait-write?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected %v for %v, got %v", http.StatusBadRequest, query, rec.Code)
+		}
+	}
+}
+
+func TestEntryInRange(t *testing.T) {
+	e := testEntries([]map[string]interface{}{
+		{"type": "float32_abcd", "number": 0.0, "regAddr": 201.0},
+	})[0]
+
+	for _, v := range []struct {
+		start, count int
+		expected     bool
+	}{
+		{199, 1, false},
+		{199, 2, true},
+		{201, 1, true},
+		{202, 5, false},
+	} {
+		if got := entryInRange(holdingType, e, v.start, v.count, -1); got != v.expected {
+			t.Errorf("expected %v for %v+%v, got %v", v.expected, v.start, v.count, got)
+		}
+	}
+}
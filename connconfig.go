@@ -0,0 +1,72 @@
+package mbserver
+
+import "time"
+
+// ConnConfig holds the settings of the TCP connections accepted by a
+// listener, so the half-open connections of clients that crashed or lost
+// the network are closed instead of piling up during long runs, and the
+// listener can behave like the TCP port of a serial device server.
+type ConnConfig struct {
+	// KeepAlive is the period between the TCP keep-alive probes of the
+	// connections. Zero uses the default of the Go runtime, and a
+	// negative value disables the keep-alive probes.
+	KeepAlive time.Duration
+	// IdleTimeout closes a connection when no request is received for
+	// the duration given. Zero disables the timeout.
+	IdleTimeout time.Duration
+	// MaxSessionDuration closes a connection when it has been open for
+	// the duration given, also when it is busy. Zero disables the limit.
+	MaxSessionDuration time.Duration
+	// MaxConnections is the number of connections the listener keeps
+	// open at the same time, where the connections accepted beyond it are
+	// closed at once. 1 emulates a serial device server, which only serves
+	// a single client. Zero allows any number of connections.
+	MaxConnections int
+	// UnitMap maps the unit IDs of the requests received on the listener
+	// to the unit IDs handling them, like a gateway mapping the unit IDs
+	// of each of its TCP ports to the addresses of the devices on its
+	// serial side. The responses are sent back with the unit ID of the
+	// request. The unit IDs not in the map are not changed.
+	UnitMap map[uint8]uint8
+	// Nagle enables Nagle's algorithm on the connections, which the Go
+	// runtime disables, so the kernel may hold back small writes and send
+	// them together in a single TCP segment.
+	Nagle bool
+	// Coalesce holds the responses written to a connection for the
+	// duration given and sends them with a single write, so the responses
+	// to pipelined requests arrive together like from a stack coalescing
+	// its writes. Zero writes each response at once.
+	Coalesce time.Duration
+	// FragmentSize splits each write to a connection in writes of at most
+	// the number of bytes given, with FragmentDelay between them, so a
+	// response spans several TCP segments. Zero disables the splitting.
+	FragmentSize  int
+	FragmentDelay time.Duration
+}
+
+// readDeadline will return the deadline of the next read of a connection
+// opened at the time connected, or the zero time if the connection has no
+// deadline.
+func (c ConnConfig) readDeadline(connected time.Time, now time.Time) time.Time {
+	var deadline time.Time
+	if c.IdleTimeout > 0 {
+		deadline = now.Add(c.IdleTimeout)
+	}
+	if c.MaxSessionDuration > 0 {
+		end := connected.Add(c.MaxSessionDuration)
+		if deadline.IsZero() || end.Before(deadline) {
+			deadline = end
+		}
+	}
+
+	return deadline
+}
+
+// closeReason will return why a connection opened at the time connected
+// was closed when its read deadline was reached.
+func (c ConnConfig) closeReason(connected time.Time, now time.Time) string {
+	if c.MaxSessionDuration > 0 && !now.Before(connected.Add(c.MaxSessionDuration)) {
+		return "max session duration"
+	}
+	return "idle timeout"
+}
@@ -0,0 +1,187 @@
+package mbserver
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadDeadline(t *testing.T) {
+	connected := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	now := connected.Add(time.Minute)
+
+	tests := []struct {
+		config ConnConfig
+		expect time.Time
+	}{
+		{ConnConfig{}, time.Time{}},
+		{ConnConfig{IdleTimeout: time.Second}, now.Add(time.Second)},
+		{ConnConfig{MaxSessionDuration: time.Hour}, connected.Add(time.Hour)},
+		{ConnConfig{IdleTimeout: time.Second, MaxSessionDuration: time.Hour}, now.Add(time.Second)},
+		{ConnConfig{IdleTimeout: time.Hour, MaxSessionDuration: time.Hour}, connected.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		got := tt.config.readDeadline(connected, now)
+		if !got.Equal(tt.expect) {
+			t.Errorf("%+v: expected %v, got %v", tt.config, tt.expect, got)
+		}
+	}
+}
+
+// closedSession will start a server listening on the address given with
+// the connection settings given, connect to it, write the requests given
+// at the interval given, and return the statistics of the session when
+// the server closes the connection.
+func closedSession(t *testing.T, addr string, config ConnConfig, requests int, interval time.Duration) SessionStats {
+	s := NewServer()
+	closed := make(chan SessionStats, 1)
+	s.SessionHook = func(st SessionStats) {
+		if !st.Disconnected.IsZero() {
+			closed <- st
+		}
+	}
+	err := s.ListenTCPConfig(addr, config)
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+
+	frame := &TCPFrame{TransactionIdentifier: 1, Device: 1, Function: 3}
+	SetDataWithRegisterAndNumber(frame, 0, 1)
+	response := make([]byte, 11)
+	for i := 0; i < requests; i++ {
+		conn.Write(frame.Bytes())
+		_, err := io.ReadFull(conn, response)
+		if err != nil {
+			break
+		}
+		time.Sleep(interval)
+	}
+
+	select {
+	case st := <-closed:
+		return st
+	case <-time.After(time.Second * 2):
+		t.Fatalf("expected the server to close the connection")
+	}
+	return SessionStats{}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	st := closedSession(t, "127.0.0.1:3340", ConnConfig{IdleTimeout: time.Millisecond * 50}, 1, 0)
+	if st.Reason != "idle timeout" {
+		t.Errorf("expected idle timeout, got %q", st.Reason)
+	}
+	if st.Requests[3] != 1 {
+		t.Errorf("expected 1 request, got %v", st.Requests[3])
+	}
+}
+
+func TestMaxSessionDuration(t *testing.T) {
+	// The connection is closed while it is busy.
+	config := ConnConfig{IdleTimeout: time.Second, MaxSessionDuration: time.Millisecond * 100}
+	st := closedSession(t, "127.0.0.1:3341", config, 100, time.Millisecond*10)
+	if st.Reason != "max session duration" {
+		t.Errorf("expected max session duration, got %q", st.Reason)
+	}
+	if st.Requests[3] < 5 {
+		t.Errorf("expected the requests until the connection was closed, got %v", st.Requests[3])
+	}
+}
+
+// segmentSizes will start a server listening on the address given with
+// the connection settings given, write the requests given at once, and
+// return the size of each read of the responses, with the time until the
+// first read.
+func segmentSizes(t *testing.T, addr string, config ConnConfig, requests int) ([]int, time.Duration) {
+	s := NewServer()
+	err := s.ListenTCPConfig(addr, config)
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 2))
+
+	var b []byte
+	for i := 0; i < requests; i++ {
+		frame := &TCPFrame{TransactionIdentifier: uint16(i), Device: 1, Function: 3}
+		SetDataWithRegisterAndNumber(frame, 0, 1)
+		b = append(b, frame.Bytes()...)
+	}
+	start := time.Now()
+	conn.Write(b)
+
+	var sizes []int
+	var first time.Duration
+	packet := make([]byte, 512)
+	for total := 0; total < requests*11; {
+		n, err := conn.Read(packet)
+		if err != nil {
+			t.Fatalf("failed to read the responses, got %v\n", err)
+		}
+		if sizes == nil {
+			first = time.Since(start)
+		}
+		sizes = append(sizes, n)
+		total += n
+	}
+	return sizes, first
+}
+
+func TestTunedConn(t *testing.T) {
+	sizes, _ := segmentSizes(t, "127.0.0.1:3351", ConnConfig{FragmentSize: 4, FragmentDelay: time.Millisecond * 50}, 1)
+	expect := []int{4, 4, 3}
+	if !isEqual(expect, sizes) {
+		t.Errorf("expected %v, got %v", expect, sizes)
+	}
+
+	sizes, first := segmentSizes(t, "127.0.0.1:3352", ConnConfig{Coalesce: time.Millisecond * 100}, 2)
+	expect = []int{22}
+	if !isEqual(expect, sizes) {
+		t.Errorf("expected %v, got %v", expect, sizes)
+	}
+	if first < time.Millisecond*100 {
+		t.Errorf("expected the responses to be held for 100ms, got %v", first)
+	}
+}
+
+func TestTunedConnWriter(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := ConnConfig{FragmentSize: 2, FragmentDelay: time.Millisecond * 100}.tune(server)
+
+	// The write returns before the fragments are sent.
+	start := time.Now()
+	b := []byte{1, 2, 3, 4}
+	n, err := conn.Write(b)
+	if err != nil || n != 4 || time.Since(start) > time.Millisecond*50 {
+		t.Errorf("expected the write to return at once, got %v and %v after %v", n, err, time.Since(start))
+	}
+	// The bytes given are copied, so the caller can reuse them.
+	b[0] = 9
+
+	got := make([]byte, 4)
+	_, err = io.ReadFull(client, got)
+	if err != nil || !isEqual([]byte{1, 2, 3, 4}, got) {
+		t.Errorf("expected %v, got %v and %v", []byte{1, 2, 3, 4}, got, err)
+	}
+
+	conn.Close()
+	_, err = conn.Write(b)
+	if err == nil {
+		t.Errorf("expected error not nil after close, got %v", err)
+	}
+}
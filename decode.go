@@ -0,0 +1,147 @@
+package mbserver
+
+import (
+	"fmt"
+	"math"
+)
+
+// The Decode functions decode the registers of a value back into the
+// number it holds, with the same word and byte order rules as the
+// encoder types of the modbusgenerator config files with the same name,
+// so tests and clients can convert the registers they read to values.
+// An error is returned if the registers given are fewer than the words
+// of the type, and the registers beyond them are ignored.
+
+// checkWords will return an error if u has fewer than n words.
+func checkWords(u []uint16, n int, typeName string) error {
+	if len(u) < n {
+		return fmt.Errorf("%v needs %v words, got %v", typeName, n, len(u))
+	}
+	return nil
+}
+
+// swapBytes will swap the two bytes of the word given.
+func swapBytes(u uint16) uint16 {
+	return u<<8 | u>>8
+}
+
+// float32FromBits will return the number of the float32 bits given,
+// keeping the sign and payload of a NaN, which a conversion with
+// float64() can lose for a signaling NaN.
+func float32FromBits(b uint32) float64 {
+	f := math.Float32frombits(b)
+	if !math.IsNaN(float64(f)) {
+		return float64(f)
+	}
+
+	sign := uint64(b>>31) << 63
+	payload := uint64(b&0x7fffff) << 29
+	return math.Float64frombits(sign | 0x7ff<<52 | payload)
+}
+
+// DecodeFloat32LittleWordBigEndian decodes a float32 where the two words
+// have swapped order, and the byte order within each word is normal.
+func DecodeFloat32LittleWordBigEndian(u []uint16) (float64, error) {
+	if err := checkWords(u, 2, "float32LittleWordBigEndian"); err != nil {
+		return 0, err
+	}
+	return float32FromBits(uint32(u[1])<<16 | uint32(u[0])), nil
+}
+
+// DecodeFloat32BigWordBigEndian decodes a float32 where the two words are
+// in normal order, and the byte order within each word is normal.
+func DecodeFloat32BigWordBigEndian(u []uint16) (float64, error) {
+	if err := checkWords(u, 2, "float32BigWordBigEndian"); err != nil {
+		return 0, err
+	}
+	return float32FromBits(uint32(u[0])<<16 | uint32(u[1])), nil
+}
+
+// DecodeFloat32LittleWordLittleEndian decodes a float32 where the two
+// words have swapped order, and the byte order within each word is
+// swapped.
+func DecodeFloat32LittleWordLittleEndian(u []uint16) (float64, error) {
+	if err := checkWords(u, 2, "float32LittleWordLittleEndian"); err != nil {
+		return 0, err
+	}
+	return float32FromBits(uint32(swapBytes(u[1]))<<16 | uint32(swapBytes(u[0]))), nil
+}
+
+// DecodeFloat32BigWordLittleEndian decodes a float32 where the two words
+// are in normal order, and the byte order within each word is swapped.
+func DecodeFloat32BigWordLittleEndian(u []uint16) (float64, error) {
+	if err := checkWords(u, 2, "float32BigWordLittleEndian"); err != nil {
+		return 0, err
+	}
+	return float32FromBits(uint32(swapBytes(u[0]))<<16 | uint32(swapBytes(u[1]))), nil
+}
+
+// DecodeWordInt16BigEndian decodes a single word where the value is held
+// in the 8MSB, so the 8LSB are ignored.
+func DecodeWordInt16BigEndian(u []uint16) (float64, error) {
+	if err := checkWords(u, 1, "wordInt16BigEndian"); err != nil {
+		return 0, err
+	}
+	return float64(u[0] >> 8), nil
+}
+
+// DecodeWordInt16LittleEndian decodes a single word where the byte order
+// is swapped.
+func DecodeWordInt16LittleEndian(u []uint16) (float64, error) {
+	if err := checkWords(u, 1, "wordInt16LittleEndian"); err != nil {
+		return 0, err
+	}
+	return float64(swapBytes(u[0])), nil
+}
+
+// DecodeInt16BigEndian decodes a signed single word in two's complement,
+// where the byte order is normal.
+func DecodeInt16BigEndian(u []uint16) (float64, error) {
+	if err := checkWords(u, 1, "int16BigEndian"); err != nil {
+		return 0, err
+	}
+	return float64(int16(u[0])), nil
+}
+
+// DecodeInt16LittleEndian decodes a signed single word in two's
+// complement, where the byte order is swapped.
+func DecodeInt16LittleEndian(u []uint16) (float64, error) {
+	if err := checkWords(u, 1, "int16LittleEndian"); err != nil {
+		return 0, err
+	}
+	return float64(int16(swapBytes(u[0]))), nil
+}
+
+// DecodeUint16BigEndian decodes an unsigned single word, where the byte
+// order is normal.
+func DecodeUint16BigEndian(u []uint16) (float64, error) {
+	if err := checkWords(u, 1, "uint16BigEndian"); err != nil {
+		return 0, err
+	}
+	return float64(u[0]), nil
+}
+
+// DecodeBit decodes a single coil or discrete input into 0 or 1.
+func DecodeBit(u []uint16) (float64, error) {
+	if err := checkWords(u, 1, "bit"); err != nil {
+		return 0, err
+	}
+	if u[0] != 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Decoders maps the name of each encoder type to its Decode function.
+var Decoders = map[string]func([]uint16) (float64, error){
+	"float32LittleWordBigEndian":    DecodeFloat32LittleWordBigEndian,
+	"float32BigWordBigEndian":       DecodeFloat32BigWordBigEndian,
+	"float32LittleWordLittleEndian": DecodeFloat32LittleWordLittleEndian,
+	"float32BigWordLittleEndian":    DecodeFloat32BigWordLittleEndian,
+	"wordInt16BigEndian":            DecodeWordInt16BigEndian,
+	"wordInt16LittleEndian":         DecodeWordInt16LittleEndian,
+	"int16BigEndian":                DecodeInt16BigEndian,
+	"int16LittleEndian":             DecodeInt16LittleEndian,
+	"uint16BigEndian":               DecodeUint16BigEndian,
+	"bit":                           DecodeBit,
+}
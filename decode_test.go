@@ -0,0 +1,45 @@
+package mbserver
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecoders(t *testing.T) {
+	tests := []struct {
+		typeName string
+		words    []uint16
+		expect   float64
+	}{
+		{"float32LittleWordBigEndian", []uint16{0x0e56, 0x4049}, float64(float32(3.1415))},
+		{"float32BigWordBigEndian", []uint16{0x4049, 0x0e56}, float64(float32(3.1415))},
+		{"float32LittleWordLittleEndian", []uint16{0x560e, 0x4940}, float64(float32(3.1415))},
+		{"float32BigWordLittleEndian", []uint16{0x4940, 0x560e}, float64(float32(3.1415))},
+		{"wordInt16BigEndian", []uint16{0x0101}, 1},
+		{"wordInt16LittleEndian", []uint16{0x0100}, 1},
+		{"int16BigEndian", []uint16{0xfffe}, -2},
+		{"int16LittleEndian", []uint16{0xfeff}, -2},
+		{"uint16BigEndian", []uint16{0xfffe}, 65534},
+		{"bit", []uint16{1}, 1},
+	}
+
+	for _, tt := range tests {
+		decode := Decoders[tt.typeName]
+		got, err := decode(tt.words)
+		if err != nil || got != tt.expect {
+			t.Errorf("%v: expected %v, got %v and %v", tt.typeName, tt.expect, got, err)
+		}
+
+		// Too few words is an error instead of a panic.
+		got, err = decode(tt.words[:len(tt.words)-1])
+		if err == nil || got != 0 {
+			t.Errorf("%v: expected an error, got %v and %v", tt.typeName, got, err)
+		}
+	}
+
+	// The payload of a signaling NaN is kept.
+	got, _ := DecodeFloat32BigWordBigEndian([]uint16{0x7f80, 0x0001})
+	if !math.IsNaN(got) || math.Float64bits(got)&(1<<51) != 0 {
+		t.Errorf("expected a signaling NaN, got %x", math.Float64bits(got))
+	}
+}
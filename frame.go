@@ -7,6 +7,7 @@ type Framer interface {
 	Bytes() []byte
 	Copy() Framer
 	GetData() []byte
+	GetDevice() uint8
 	GetFunction() uint8
 	SetException(exception *Exception)
 	SetData(data []byte)
@@ -15,7 +16,7 @@ type Framer interface {
 // GetException retunrns the Modbus exception or Success (indicating not exception).
 func GetException(frame Framer) (exception Exception) {
 	function := frame.GetFunction()
-	if (function & 0x80) != 0 {
+	if (function&0x80) != 0 && len(frame.GetData()) > 0 {
 		exception = Exception(frame.GetData()[0])
 	}
 	return exception
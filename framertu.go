@@ -15,9 +15,22 @@ type RTUFrame struct {
 
 // NewRTUFrame converts a packet to a Modbus TCP frame.
 func NewRTUFrame(packet []byte) (*RTUFrame, error) {
+	frame := &RTUFrame{}
+	err := frame.parse(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// parse sets the fields of the frame from the packet, so the frame of a
+// connection can be reused for each request. The data of the frame is
+// held by the packet.
+func (frame *RTUFrame) parse(packet []byte) error {
 	// Check the that the packet length.
 	if len(packet) < 5 {
-		return nil, fmt.Errorf("RTU Frame error: packet less than 5 bytes: %v", packet)
+		return fmt.Errorf("RTU Frame error: packet less than 5 bytes: %v", packet)
 	}
 
 	// Check the CRC.
@@ -25,16 +38,16 @@ func NewRTUFrame(packet []byte) (*RTUFrame, error) {
 	crcExpect := binary.LittleEndian.Uint16(packet[pLen-2 : pLen])
 	crcCalc := crcModbus(packet[0 : pLen-2])
 	if crcCalc != crcExpect {
-		return nil, fmt.Errorf("RTU Frame error: CRC (expected 0x%x, got 0x%x)", crcExpect, crcCalc)
+		return fmt.Errorf("RTU Frame error: CRC (expected 0x%x, got 0x%x)", crcExpect, crcCalc)
 	}
 
-	frame := &RTUFrame{
+	*frame = RTUFrame{
 		Address:  uint8(packet[0]),
 		Function: uint8(packet[1]),
 		Data:     packet[2 : pLen-2],
 	}
 
-	return frame, nil
+	return nil
 }
 
 // Copy the RTUFrame.
@@ -45,21 +58,24 @@ func (frame *RTUFrame) Copy() Framer {
 
 // Bytes returns the Modbus byte stream based on the RTUFrame fields
 func (frame *RTUFrame) Bytes() []byte {
-	bytes := make([]byte, 2)
-
-	bytes[0] = frame.Address
-	bytes[1] = frame.Function
-	bytes = append(bytes, frame.Data...)
+	return frame.appendBytes(make([]byte, 0, 4+len(frame.Data)))
+}
 
-	// Calculate the CRC.
-	pLen := len(bytes)
-	crc := crcModbus(bytes[0:pLen])
+// appendBytes appends the Modbus byte stream of the frame to b, so the
+// buffer of the responses can be reused.
+func (frame *RTUFrame) appendBytes(b []byte) []byte {
+	start := len(b)
+	b = append(b, frame.Address, frame.Function)
+	b = append(b, frame.Data...)
 
-	// Add the CRC.
-	bytes = append(bytes, []byte{0, 0}...)
-	binary.LittleEndian.PutUint16(bytes[pLen:pLen+2], crc)
+	// Calculate and add the CRC.
+	crc := crcModbus(b[start:])
+	return binary.LittleEndian.AppendUint16(b, crc)
+}
 
-	return bytes
+// GetDevice returns the Modbus slave address.
+func (frame *RTUFrame) GetDevice() uint8 {
+	return frame.Address
 }
 
 // GetFunction returns the Modbus function code.
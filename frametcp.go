@@ -17,12 +17,25 @@ type TCPFrame struct {
 
 // NewTCPFrame converts a packet to a Modbus TCP frame.
 func NewTCPFrame(packet []byte) (*TCPFrame, error) {
+	frame := &TCPFrame{}
+	err := frame.parse(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// parse sets the fields of the frame from the packet, so the frame of a
+// connection can be reused for each request. The data of the frame is
+// held by the packet.
+func (frame *TCPFrame) parse(packet []byte) error {
 	// Check if the packet is too short.
 	if len(packet) < 9 {
-		return nil, fmt.Errorf("TCP Frame error: packet less than 9 bytes")
+		return fmt.Errorf("TCP Frame error: packet less than 9 bytes")
 	}
 
-	frame := &TCPFrame{
+	*frame = TCPFrame{
 		TransactionIdentifier: binary.BigEndian.Uint16(packet[0:2]),
 		ProtocolIdentifier:    binary.BigEndian.Uint16(packet[2:4]),
 		Length:                binary.BigEndian.Uint16(packet[4:6]),
@@ -33,10 +46,10 @@ func NewTCPFrame(packet []byte) (*TCPFrame, error) {
 
 	// Check expected vs actual packet length.
 	if int(frame.Length) != len(frame.Data)+2 {
-		return nil, fmt.Errorf("specified packet length does not match actual packet length")
+		return fmt.Errorf("specified packet length does not match actual packet length")
 	}
 
-	return frame, nil
+	return nil
 }
 
 // Copy the TCPFrame.
@@ -47,16 +60,22 @@ func (frame *TCPFrame) Copy() Framer {
 
 // Bytes returns the Modbus byte stream based on the TCPFrame fields
 func (frame *TCPFrame) Bytes() []byte {
-	bytes := make([]byte, 8)
+	return frame.appendBytes(make([]byte, 0, 8+len(frame.Data)))
+}
 
-	binary.BigEndian.PutUint16(bytes[0:2], frame.TransactionIdentifier)
-	binary.BigEndian.PutUint16(bytes[2:4], frame.ProtocolIdentifier)
-	binary.BigEndian.PutUint16(bytes[4:6], uint16(2+len(frame.Data)))
-	bytes[6] = frame.Device
-	bytes[7] = frame.Function
-	bytes = append(bytes, frame.Data...)
+// appendBytes appends the Modbus byte stream of the frame to b, so the
+// buffer of the responses can be reused.
+func (frame *TCPFrame) appendBytes(b []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, frame.TransactionIdentifier)
+	b = binary.BigEndian.AppendUint16(b, frame.ProtocolIdentifier)
+	b = binary.BigEndian.AppendUint16(b, uint16(2+len(frame.Data)))
+	b = append(b, frame.Device, frame.Function)
+	return append(b, frame.Data...)
+}
 
-	return bytes
+// GetDevice returns the Modbus unit identifier.
+func (frame *TCPFrame) GetDevice() uint8 {
+	return frame.Device
 }
 
 // GetFunction returns the Modbus function code.
@@ -0,0 +1,119 @@
+package mbserver
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// frameTimeout is how long a connection waits for the rest of a frame
+// when only the first part of it has been read. The bytes read are then
+// handled as a malformed frame, so a client sending garbage does not
+// block the connection.
+const frameTimeout = time.Second
+
+// maxTCPLength is the largest length allowed in the header of a TCP
+// frame, which is the unit identifier and a PDU of 253 bytes.
+const maxTCPLength = 254
+
+// frameStream holds the bytes read from a connection that are not yet
+// handled, and splits them into frames. A frame split across several TCP
+// segments is read as a single frame, and several frames sent in the same
+// segment are read one at a time. Each connection has its own, so the
+// framing of concurrent clients is independent.
+type frameStream struct {
+	buf [1024]byte
+	n   int
+}
+
+// pending will return the bytes read that are not yet handled.
+func (f *frameStream) pending() []byte {
+	return f.buf[:f.n]
+}
+
+// free will return the part of the buffer to read more bytes into.
+func (f *frameStream) free() []byte {
+	return f.buf[f.n:]
+}
+
+// next will copy the first frame of the bytes read into the packet given,
+// and return the packet, or nil if more bytes must be read to complete the
+// frame. When the buffer is full, all of it is returned as a frame, which
+// will then fail to parse.
+func (f *frameStream) next(packet []byte, rtu bool) []byte {
+	n := frameLength(f.pending(), rtu)
+	if n == 0 && f.n == len(f.buf) {
+		n = f.n
+	}
+	if n == 0 {
+		return nil
+	}
+	return f.take(packet, n)
+}
+
+// take will copy the first n bytes read into the packet given, and
+// remove them from the stream. n is capped to the size of the packet.
+func (f *frameStream) take(packet []byte, n int) []byte {
+	n = min(n, f.n, len(packet))
+	copy(packet, f.buf[:n])
+	f.n = copy(f.buf[:], f.buf[n:f.n])
+	return packet[:n]
+}
+
+// reset will drop all the bytes read, so the stream is in sync again
+// with the frames of the client after a malformed frame.
+func (f *frameStream) reset() {
+	f.n = 0
+}
+
+// frameLength will return the length of the first frame of the bytes
+// given, or 0 if more bytes are needed to tell the length or complete the
+// frame. The length of a TCP frame is given by its header. An RTU frame
+// has no length field, so the length is given by the function code, and
+// for the function codes where it is not known all the bytes given are
+// taken as the frame, like before the frames were split.
+func frameLength(b []byte, rtu bool) int {
+	if !rtu {
+		if len(b) < 6 {
+			return 0
+		}
+		length := int(binary.BigEndian.Uint16(b[4:6]))
+		// A length outside the specification can not be trusted, so
+		// all the bytes are taken as a malformed frame.
+		if length < 2 || length > maxTCPLength {
+			return len(b)
+		}
+		if len(b) < 6+length {
+			return 0
+		}
+		return 6 + length
+	}
+
+	if len(b) < 2 {
+		return 0
+	}
+	var n int
+	switch b[1] {
+	case 1, 2, 3, 4, 5, 6, 8:
+		n = 8
+	case 7, 11, 12, 17:
+		n = 4
+	case 15, 16:
+		if len(b) < 7 {
+			return 0
+		}
+		n = 9 + int(b[6])
+	case 22:
+		n = 10
+	case 23:
+		if len(b) < 11 {
+			return 0
+		}
+		n = 13 + int(b[10])
+	default:
+		return len(b)
+	}
+	if len(b) < n {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,146 @@
+package mbserver
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFrameLength(t *testing.T) {
+	tests := []struct {
+		packet []byte
+		rtu    bool
+		expect int
+	}{
+		{[]byte{0, 1, 0, 0, 0}, false, 0},
+		{[]byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0}, false, 0},
+		{[]byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1}, false, 12},
+		{[]byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1, 0, 2}, false, 12},
+		{[]byte{0, 1, 0, 0, 1, 0, 1, 3}, false, 8},
+		{[]byte{0, 1, 0, 0, 0, 0, 1, 3}, false, 8},
+		{[]byte{1}, true, 0},
+		{[]byte{1, 3, 0, 0, 0}, true, 0},
+		{[]byte{1, 3, 0, 0, 0, 1, 0x84, 0x0a, 1}, true, 8},
+		{[]byte{1, 16, 0, 0, 0}, true, 0},
+		{[]byte{1, 16, 0, 0, 0, 1, 2, 0, 7}, true, 0},
+		{[]byte{1, 16, 0, 0, 0, 1, 2, 0, 7, 0, 0}, true, 11},
+		{[]byte{1, 23, 0, 0, 0, 1, 0, 0, 0, 1, 2, 0, 7, 0, 0}, true, 15},
+		{[]byte{1, 43, 14, 1, 0, 0, 0}, true, 7},
+	}
+
+	for _, v := range tests {
+		got := frameLength(v.packet, v.rtu)
+		if v.expect != got {
+			t.Errorf("expected %v, got %v for %v", v.expect, got, v.packet)
+		}
+	}
+}
+
+// readRTUResponse will read the response to a read holding registers
+// request of the number of registers given from the connection.
+func readRTUResponse(t *testing.T, conn net.Conn, registers int) *RTUFrame {
+	packet := make([]byte, 5+2*registers)
+	_, err := io.ReadFull(conn, packet)
+	if err != nil {
+		t.Fatalf("failed to read the response, got %v\n", err)
+	}
+	frame, err := NewRTUFrame(packet)
+	if err != nil {
+		t.Fatalf("expected a valid response, got %v\n", err)
+	}
+	return frame
+}
+
+func TestRTUOverTCPClients(t *testing.T) {
+	s := NewServer()
+	s.HoldingRegisters[1] = 11
+	s.HoldingRegisters[2] = 22
+	err := s.ListenRTUTCP("127.0.0.1:3345")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	var conns [2]net.Conn
+	for i := range conns {
+		conns[i], err = net.Dial("tcp", "127.0.0.1:3345")
+		if err != nil {
+			t.Fatalf("failed to connect, got %v\n", err)
+		}
+		defer conns[i].Close()
+		conns[i].SetDeadline(time.Now().Add(time.Second * 2))
+	}
+
+	request := func(register uint16) []byte {
+		frame := &RTUFrame{Address: 1, Function: 3}
+		SetDataWithRegisterAndNumber(frame, register, 1)
+		return frame.Bytes()
+	}
+
+	// The first client sends its request split in two, and the second
+	// client sends two requests at once between the two parts.
+	first := request(1)
+	conns[0].Write(first[:3])
+	time.Sleep(time.Millisecond * 20)
+	conns[1].Write(append(request(2), request(1)...))
+	time.Sleep(time.Millisecond * 20)
+	conns[0].Write(first[3:])
+
+	expect := [][]byte{{2, 0, 11}, {2, 0, 22}, {2, 0, 11}}
+	got := [][]byte{
+		readRTUResponse(t, conns[0], 1).Data,
+		readRTUResponse(t, conns[1], 1).Data,
+		readRTUResponse(t, conns[1], 1).Data,
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	s := NewServer()
+	err := s.ListenRTUTCPConfig("127.0.0.1:3346", ConnConfig{MaxConnections: 1})
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	first, err := net.Dial("tcp", "127.0.0.1:3346")
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	// Allow the server to accept the connection.
+	time.Sleep(10 * time.Millisecond)
+
+	second, err := net.Dial("tcp", "127.0.0.1:3346")
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second * 2))
+	_, err = second.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Errorf("expected the second connection to be closed, got %v", err)
+	}
+
+	expect := []ListenerStats{{Address: "127.0.0.1:3346", Connections: 1, Rejected: 1}}
+	got := s.ListenerStats()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// A new client is served when the first client is gone.
+	first.Close()
+	time.Sleep(10 * time.Millisecond)
+	third, err := net.Dial("tcp", "127.0.0.1:3346")
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer third.Close()
+	third.SetDeadline(time.Now().Add(time.Second * 2))
+	frame := &RTUFrame{Address: 1, Function: 3}
+	SetDataWithRegisterAndNumber(frame, 0, 1)
+	third.Write(frame.Bytes())
+	readRTUResponse(t, third, 1)
+}
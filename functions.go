@@ -4,8 +4,11 @@ import (
 	"encoding/binary"
 )
 
-// ReadCoils function 1, reads coils from internal memory.
+// ReadCoils function 1, reads coils from the register store.
 func ReadCoils(s *Server, frame Framer) ([]byte, *Exception) {
+	if !validQuantity(frame, 4, maxReadBits) {
+		return []byte{}, &IllegalDataValue
+	}
 	register, numRegs, endRegister := registerAddressAndNumber(frame)
 	if endRegister > 65535 {
 		return []byte{}, &IllegalDataAddress
@@ -14,9 +17,13 @@ func ReadCoils(s *Server, frame Framer) ([]byte, *Exception) {
 	if (numRegs % 8) != 0 {
 		dataSize++
 	}
-	data := make([]byte, 1+dataSize)
+	values, err := s.store().ReadCoils(register, numRegs)
+	if err != nil {
+		return []byte{}, storeException(err)
+	}
+	data := grow(&s.scratch.data, 1+dataSize)
 	data[0] = byte(dataSize)
-	for i, value := range s.Coils[register:endRegister] {
+	for i, value := range values {
 		if value != 0 {
 			shift := uint(i) % 8
 			data[1+i/8] |= byte(1 << shift)
@@ -25,8 +32,11 @@ func ReadCoils(s *Server, frame Framer) ([]byte, *Exception) {
 	return data, &Success
 }
 
-// ReadDiscreteInputs function 2, reads discrete inputs from internal memory.
+// ReadDiscreteInputs function 2, reads discrete inputs from the register store.
 func ReadDiscreteInputs(s *Server, frame Framer) ([]byte, *Exception) {
+	if !validQuantity(frame, 4, maxReadBits) {
+		return []byte{}, &IllegalDataValue
+	}
 	register, numRegs, endRegister := registerAddressAndNumber(frame)
 	if endRegister > 65535 {
 		return []byte{}, &IllegalDataAddress
@@ -35,9 +45,13 @@ func ReadDiscreteInputs(s *Server, frame Framer) ([]byte, *Exception) {
 	if (numRegs % 8) != 0 {
 		dataSize++
 	}
-	data := make([]byte, 1+dataSize)
+	values, err := s.store().ReadDiscreteInputs(register, numRegs)
+	if err != nil {
+		return []byte{}, storeException(err)
+	}
+	data := grow(&s.scratch.data, 1+dataSize)
 	data[0] = byte(dataSize)
-	for i, value := range s.DiscreteInputs[register:endRegister] {
+	for i, value := range values {
 		if value != 0 {
 			shift := uint(i) % 8
 			data[1+i/8] |= byte(1 << shift)
@@ -46,95 +60,191 @@ func ReadDiscreteInputs(s *Server, frame Framer) ([]byte, *Exception) {
 	return data, &Success
 }
 
-// ReadHoldingRegisters function 3, reads holding registers from internal memory.
+// ReadHoldingRegisters function 3, reads holding registers from the register store.
 func ReadHoldingRegisters(s *Server, frame Framer) ([]byte, *Exception) {
+	if !validQuantity(frame, 4, maxReadRegisters) {
+		return []byte{}, &IllegalDataValue
+	}
 	register, numRegs, endRegister := registerAddressAndNumber(frame)
 	if endRegister > 65536 {
 		return []byte{}, &IllegalDataAddress
 	}
-	return append([]byte{byte(numRegs * 2)}, Uint16ToBytes(s.HoldingRegisters[register:endRegister])...), &Success
+	values, err := s.store().ReadHoldingRegisters(register, numRegs)
+	if err != nil {
+		return []byte{}, storeException(err)
+	}
+	return registerData(s, values), &Success
 }
 
-// ReadInputRegisters function 4, reads input registers from internal memory.
+// ReadInputRegisters function 4, reads input registers from the register store.
 func ReadInputRegisters(s *Server, frame Framer) ([]byte, *Exception) {
+	if !validQuantity(frame, 4, maxReadRegisters) {
+		return []byte{}, &IllegalDataValue
+	}
 	register, numRegs, endRegister := registerAddressAndNumber(frame)
 	if endRegister > 65536 {
 		return []byte{}, &IllegalDataAddress
 	}
-	return append([]byte{byte(numRegs * 2)}, Uint16ToBytes(s.InputRegisters[register:endRegister])...), &Success
+	values, err := s.store().ReadInputRegisters(register, numRegs)
+	if err != nil {
+		return []byte{}, storeException(err)
+	}
+	return registerData(s, values), &Success
 }
 
-// WriteSingleCoil function 5, write a coil to internal memory.
+// WriteSingleCoil function 5, write a coil to the register store.
 func WriteSingleCoil(s *Server, frame Framer) ([]byte, *Exception) {
+	if len(frame.GetData()) < 4 {
+		return []byte{}, &IllegalDataValue
+	}
 	register, value := registerAddressAndValue(frame)
 	// TODO Should we use 0 for off and 65,280 (FF00 in hexadecimal) for on?
 	if value != 0 {
 		value = 1
 	}
-	s.Coils[register] = byte(value)
+	values := grow(&s.scratch.bits, 1)
+	values[0] = byte(value)
+	err := s.store().WriteCoils(register, values)
+	if err != nil {
+		return []byte{}, storeException(err)
+	}
 	return frame.GetData()[0:4], &Success
 }
 
-// WriteHoldingRegister function 6, write a holding register to internal memory.
+// WriteHoldingRegister function 6, write a holding register to the register store.
 func WriteHoldingRegister(s *Server, frame Framer) ([]byte, *Exception) {
+	if len(frame.GetData()) < 4 {
+		return []byte{}, &IllegalDataValue
+	}
 	register, value := registerAddressAndValue(frame)
-	s.HoldingRegisters[register] = value
+	values := grow(&s.scratch.words, 1)
+	values[0] = value
+	err := s.store().WriteHoldingRegisters(register, values)
+	if err != nil {
+		return []byte{}, storeException(err)
+	}
 	return frame.GetData()[0:4], &Success
 }
 
-// WriteMultipleCoils function 15, writes holding registers to internal memory.
+// WriteMultipleCoils function 15, writes holding registers to the register store.
 func WriteMultipleCoils(s *Server, frame Framer) ([]byte, *Exception) {
+	if !validQuantity(frame, 5, maxWriteBits) {
+		return []byte{}, &IllegalDataValue
+	}
 	register, numRegs, endRegister := registerAddressAndNumber(frame)
 	valueBytes := frame.GetData()[5:]
 
 	if endRegister > 65536 {
 		return []byte{}, &IllegalDataAddress
 	}
+	if len(valueBytes) < (numRegs+7)/8 {
+		return []byte{}, &IllegalDataValue
+	}
 
 	// TODO This is not correct, bits and bytes do not always align
 	//if len(valueBytes)/2 != numRegs {
 	//	return []byte{}, &IllegalDataAddress
 	//}
 
-	bitCount := 0
-	for i, value := range valueBytes {
+	values := s.scratch.bits[:0]
+	for _, value := range valueBytes {
 		for bitPos := uint(0); bitPos < 8; bitPos++ {
-			s.Coils[register+(i*8)+int(bitPos)] = bitAtPosition(value, bitPos)
-			bitCount++
-			if bitCount >= numRegs {
+			values = append(values, bitAtPosition(value, bitPos))
+			if len(values) >= numRegs {
 				break
 			}
 		}
-		if bitCount >= numRegs {
+		if len(values) >= numRegs {
 			break
 		}
 	}
 
+	s.scratch.bits = values
+
+	err := s.store().WriteCoils(register, values)
+	if err != nil {
+		return []byte{}, storeException(err)
+	}
 	return frame.GetData()[0:4], &Success
 }
 
-// WriteHoldingRegisters function 16, writes holding registers to internal memory.
+// WriteHoldingRegisters function 16, writes holding registers to the register store.
 func WriteHoldingRegisters(s *Server, frame Framer) ([]byte, *Exception) {
+	if !validQuantity(frame, 5, maxWriteRegisters) {
+		return []byte{}, &IllegalDataValue
+	}
 	register, numRegs, _ := registerAddressAndNumber(frame)
 	valueBytes := frame.GetData()[5:]
-	var exception *Exception
-	var data []byte
 
 	if len(valueBytes)/2 != numRegs {
-		exception = &IllegalDataAddress
+		return []byte{}, &IllegalDataAddress
 	}
 
 	// Copy data to memroy
-	values := BytesToUint16(valueBytes)
-	valuesUpdated := copy(s.HoldingRegisters[register:], values)
-	if valuesUpdated == numRegs {
-		exception = &Success
-		data = frame.GetData()[0:4]
-	} else {
-		exception = &IllegalDataAddress
+	values := grow(&s.scratch.words, numRegs)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(valueBytes[i*2:])
+	}
+	err := s.store().WriteHoldingRegisters(register, values)
+	if err != nil {
+		return []byte{}, storeException(err)
+	}
+
+	return frame.GetData()[0:4], &Success
+}
+
+// The largest number of coils and registers of a single request given by
+// the specification, which is what fits in the byte count of the requests
+// and responses.
+const (
+	maxReadBits       = 2000
+	maxReadRegisters  = 125
+	maxWriteBits      = 1968
+	maxWriteRegisters = 123
+)
+
+// validQuantity will return true if the data of the frame holds at least
+// the n bytes of the fields of the request, and the quantity of coils or
+// registers in the request is from 1 to the max given. Requests that are
+// malformed or too large are answered with Illegal Data Value.
+func validQuantity(frame Framer, n int, max int) bool {
+	if len(frame.GetData()) < n {
+		return false
 	}
+	_, numRegs, _ := registerAddressAndNumber(frame)
+	return numRegs >= 1 && numRegs <= max
+}
 
-	return data, exception
+// scratch holds the buffers the default functions build the data of the
+// responses and the values written in. The buffers are reused for each
+// request, so the data returned by the default functions is only valid
+// until the next request is handled by the server.
+type scratch struct {
+	data  []byte
+	bits  []byte
+	words []uint16
+}
+
+// grow will return the buffer given resized to n zeroed values, and only
+// allocate when the buffer is too small.
+func grow[T byte | uint16](buf *[]T, n int) []T {
+	if cap(*buf) < n {
+		*buf = make([]T, n)
+	}
+	*buf = (*buf)[:n]
+	clear(*buf)
+	return *buf
+}
+
+// registerData will return the data of the response to a read of the
+// registers given, which is the byte count followed by the registers.
+func registerData(s *Server, values []uint16) []byte {
+	data := grow(&s.scratch.data, 1+len(values)*2)
+	data[0] = byte(len(values) * 2)
+	for i, value := range values {
+		binary.BigEndian.PutUint16(data[1+i*2:], value)
+	}
+	return data
 }
 
 // BytesToUint16 converts a big endian array of bytes to an array of unit16s
@@ -329,3 +329,32 @@ func TestOutOfBounds(t *testing.T) {
 		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
 	}
 }
+
+func TestMalformedRequests(t *testing.T) {
+	s := NewServer()
+
+	tests := []struct {
+		function uint8
+		data     []byte
+	}{
+		{1, []byte{0, 1}},
+		{2, []byte{0, 1, 0, 0}},
+		{3, []byte{0}},
+		{3, []byte{0, 0, 0, 126}},
+		{4, []byte{0, 0, 255, 255}},
+		{5, []byte{0, 1, 255}},
+		{6, []byte{}},
+		{15, []byte{0, 1, 0, 10}},
+		{15, []byte{0, 1, 0, 10, 1, 255}},
+		{16, []byte{0, 1, 0, 2}},
+		{16, []byte{0, 1, 0, 124, 248}},
+	}
+	for _, tt := range tests {
+		frame := TCPFrame{TransactionIdentifier: 1, Device: 1, Function: tt.function, Data: tt.data}
+		response := s.handle(&Request{frame: &frame})
+		exception := GetException(response)
+		if exception != IllegalDataValue {
+			t.Errorf("fc%v %v: expected IllegalDataValue, got %v", tt.function, tt.data, exception.String())
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package mbserver
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fuzzSeeds are valid and malformed requests of each of the supported
+// function codes, without the TCP header and RTU CRC.
+var fuzzSeeds = [][]byte{
+	{1, 1, 0, 0, 0, 8},
+	{1, 2, 0, 0, 7, 208},
+	{1, 3, 0, 1, 0, 125},
+	{1, 4, 255, 255, 0, 2},
+	{1, 5, 0, 1, 255, 0},
+	{1, 6, 0, 1, 0, 7},
+	{1, 15, 0, 1, 0, 10, 2, 255, 3},
+	{1, 16, 0, 1, 0, 2, 4, 0, 1, 0, 2},
+	{1, 16, 0, 1, 0, 2},
+	{1, 3, 0},
+	{0, 6, 0, 1, 0, 7},
+	{1, 0x83, 2},
+}
+
+func FuzzNewTCPFrame(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(append([]byte{0, 1, 0, 0, 0, byte(len(seed))}, seed...))
+	}
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		frame, err := NewTCPFrame(packet)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(packet, frame.Bytes()) {
+			t.Errorf("expected %v, got %v", packet, frame.Bytes())
+		}
+	})
+}
+
+func FuzzNewRTUFrame(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add((&RTUFrame{Address: seed[0], Function: seed[1], Data: seed[2:]}).Bytes())
+	}
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		frame, err := NewRTUFrame(packet)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(packet, frame.Bytes()) {
+			t.Errorf("expected %v, got %v", packet, frame.Bytes())
+		}
+	})
+}
+
+// FuzzHandle will handle the requests with a server with a unit, a unit
+// that is offline and broadcasts, so all the paths of the handler are
+// reached. A request must never make the server panic, and must be
+// answered with a response that can be parsed.
+func FuzzHandle(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed[0], seed[1], seed[2:])
+	}
+
+	s := NewServer()
+	defer s.Close()
+	s.Broadcast = true
+	s.AddUnit(2)
+	s.SetUnitOffline(3, &GatewayPathUnavailable)
+
+	f.Fuzz(func(t *testing.T, device uint8, function uint8, data []byte) {
+		frame := &TCPFrame{TransactionIdentifier: 1, Device: device, Function: function, Data: data}
+		response := s.handle(&Request{frame: frame})
+		if response == nil {
+			return
+		}
+
+		var buf bytes.Buffer
+		s.write(&buf, response)
+		if _, err := NewTCPFrame(buf.Bytes()); err != nil {
+			t.Errorf("expected a valid response, got %v: %v", err, buf.Bytes())
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package mbserver
+
+import "time"
+
+// SerialSide is the state of the serial side of a gateway simulated by
+// the server, to reproduce how a serial device server behaves when the
+// devices behind it are slow or can not be reached at all.
+type SerialSide struct {
+	// Down makes the gateway answer all the requests with DownException,
+	// or not answer them at all when DownException is nil, like a gateway
+	// with the serial cable pulled. Broadcasts are not applied.
+	Down          bool
+	DownException *Exception
+	// Delay is added to the time each request holds the serial side, like
+	// a slow device or a congested bus. The requests of all the
+	// connections wait for each other. The delay is also added to the
+	// answers when the serial side is down, like a gateway waiting for its
+	// serial timeout before answering.
+	Delay time.Duration
+}
+
+// SetSerialSide sets the state of the serial side of the gateway simulated
+// by the server. The server must be locked with Lock when the state is set
+// while the server is running.
+func (s *Server) SetSerialSide(side SerialSide) {
+	s.serial = side
+}
+
+// SerialSide returns the state of the serial side of the gateway simulated
+// by the server.
+func (s *Server) SerialSide() SerialSide {
+	return s.serial
+}
+
+// setDevice will set the unit ID of the frame given, for the frames read
+// from the connections of the server.
+func setDevice(frame Framer, device uint8) {
+	switch f := frame.(type) {
+	case *TCPFrame:
+		f.Device = device
+	case *RTUFrame:
+		f.Address = device
+	}
+}
+
+// mapUnit will map the unit ID of the request given with the unit map of
+// the listener, keeping the unit ID the client sent so the response can be
+// sent back with it.
+func (r *Request) mapUnit(units map[uint8]uint8) {
+	device := r.frame.GetDevice()
+	unit, ok := units[device]
+	if !ok {
+		return
+	}
+	r.mapped = true
+	r.clientDevice = device
+	setDevice(r.frame, unit)
+}
+
+// forwardQueueSize is the number of requests waiting to be forwarded by a
+// unit, before the requests are answered with SlaveDeviceBusy.
+const forwardQueueSize = 64
+
+// ForwardFunction answers a request forwarded by a unit, like a gateway
+// forwarding the request to a remote device, with the data of the
+// response and the exception.
+type ForwardFunction func(frame Framer) ([]byte, *Exception)
+
+// forwarder holds the requests waiting to be forwarded by a unit.
+type forwarder struct {
+	forward ForwardFunction
+	queue   chan forwardRequest
+}
+
+// forwardRequest is a request waiting to be forwarded, with a copy of the
+// frame since the buffers of the request are reused by the connection.
+type forwardRequest struct {
+	request Request
+	// server is the server the request was received by, which writes
+	// the response.
+	server *Server
+	// broadcast is true for the broadcasts, which are forwarded but not
+	// answered.
+	broadcast bool
+}
+
+// Forward makes the requests of the unit be answered by the function
+// given, like a gateway forwarding the requests to a remote device. The
+// requests are forwarded by a goroutine of the unit one at a time in the
+// order received, outside the lock of the server, so a slow or
+// unreachable device does not hold up the requests of the other units.
+// Broadcasts are forwarded too, but not answered.
+// Up to 64 requests wait to be forwarded, and the requests beyond that
+// are answered with SlaveDeviceBusy. The function is given a copy of the
+// frame of the request, and the response is written when it returns.
+// Forward must be called before the server is started.
+func (s *Server) Forward(f ForwardFunction) {
+	s.forwarder = &forwarder{forward: f, queue: make(chan forwardRequest, forwardQueueSize)}
+	go s.forwarder.run()
+}
+
+// enqueue will add the request given to the requests waiting to be
+// forwarded, and return false if the queue is full.
+func (f *forwarder) enqueue(s *Server, request *Request) bool {
+	r := forwardRequest{request: *request, server: s}
+	r.request.frame = request.frame.Copy()
+	select {
+	case f.queue <- r:
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueueBroadcast will add the broadcast given to the requests waiting
+// to be forwarded, or drop it if the queue is full.
+func (f *forwarder) enqueueBroadcast(s *Server, request *Request) {
+	r := forwardRequest{request: *request, server: s, broadcast: true}
+	r.request.frame = request.frame.Copy()
+	select {
+	case f.queue <- r:
+	default:
+	}
+}
+
+// run will forward the requests of the queue one at a time, and write the
+// responses.
+func (f *forwarder) run() {
+	for r := range f.queue {
+		handled := time.Now()
+		data, exception := f.forward(r.request.frame)
+		processed := time.Now()
+		if r.broadcast {
+			continue
+		}
+
+		response := r.request.frame.Copy()
+		response.SetData(data)
+		if exception != &Success {
+			response.SetException(exception)
+		}
+		if r.request.mapped {
+			setDevice(response, r.request.clientDevice)
+		}
+		r.server.respond(&r.request, handled, processed, response, response.Bytes())
+	}
+}
+
+// respond will write the response given to the connection of the
+// request, and call the TransactionHook. The responses of the handler and
+// the forwarding units are written one at a time, so they are not mixed
+// on a connection.
+func (s *Server) respond(request *Request, handled time.Time, processed time.Time, response Framer, b []byte) {
+	s.respondMu.Lock()
+	defer s.respondMu.Unlock()
+
+	if response != nil {
+		s.writeBytes(request.conn, response, b)
+	}
+	if s.TransactionHook != nil {
+		s.TransactionHook(request.transaction(handled, processed, response))
+	}
+}
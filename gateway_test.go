@@ -0,0 +1,127 @@
+package mbserver
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSerialSide(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Broadcast = true
+	s.HoldingRegisters[0] = 7
+
+	read := &TCPFrame{Device: 1, Function: 3}
+	SetDataWithRegisterAndNumber(read, 0, 1)
+	write := &TCPFrame{Device: 0, Function: 6}
+	SetDataWithRegisterAndNumber(write, 0, 8)
+
+	s.SetSerialSide(SerialSide{Down: true, DownException: &GatewayTargetDeviceFailedtoRespond})
+	response := s.handle(&Request{frame: read})
+	if response == nil || GetException(response) != GatewayTargetDeviceFailedtoRespond {
+		t.Errorf("expected %v, got %v", GatewayTargetDeviceFailedtoRespond, response)
+	}
+	if response := s.handle(&Request{frame: write}); response != nil || s.HoldingRegisters[0] != 7 {
+		t.Errorf("expected the broadcast not to be applied, got %v and %v", response, s.HoldingRegisters[0])
+	}
+
+	s.SetSerialSide(SerialSide{Down: true})
+	if response := s.handle(&Request{frame: read}); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+
+	s.SetSerialSide(SerialSide{})
+	response = s.handle(&Request{frame: read})
+	if !isEqual([]byte{2, 0, 7}, response.GetData()) {
+		t.Errorf("expected %v, got %v", []byte{2, 0, 7}, response.GetData())
+	}
+}
+
+func TestUnitMap(t *testing.T) {
+	s := NewServer()
+	s.AddUnit(12).HoldingRegisters[0] = 12
+	err := s.ListenTCPConfig("127.0.0.1:3347", ConnConfig{UnitMap: map[uint8]uint8{2: 12}})
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	s.Lock()
+	s.SetSerialSide(SerialSide{Delay: time.Millisecond * 50})
+	s.Unlock()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:3347")
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 2))
+
+	frame := &TCPFrame{TransactionIdentifier: 1, Device: 2, Function: 3}
+	SetDataWithRegisterAndNumber(frame, 0, 1)
+	start := time.Now()
+	conn.Write(frame.Bytes())
+
+	packet := make([]byte, 11)
+	_, err = io.ReadFull(conn, packet)
+	if err != nil {
+		t.Fatalf("failed to read the response, got %v\n", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*50 {
+		t.Errorf("expected the response to be delayed by the serial side, got %v", elapsed)
+	}
+
+	// The response has the unit ID of the request, and the registers of
+	// the unit it is mapped to.
+	expect := []byte{0, 1, 0, 0, 0, 5, 2, 3, 2, 0, 12}
+	if !isEqual(expect, packet) {
+		t.Errorf("expected %v, got %v", expect, packet)
+	}
+}
+
+func TestForward(t *testing.T) {
+	s := NewServer()
+	s.AddUnit(1).HoldingRegisters[0] = 1
+	release := make(chan struct{})
+	s.AddUnit(2).Forward(func(frame Framer) ([]byte, *Exception) {
+		<-release
+		return []byte{2, 0, 42}, &Success
+	})
+	err := s.ListenTCP("127.0.0.1:3354")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", "127.0.0.1:3354")
+		if err != nil {
+			t.Fatalf("failed to connect, got %v\n", err)
+		}
+		conn.SetDeadline(time.Now().Add(time.Second * 2))
+		return conn
+	}
+	forwarded := dial()
+	defer forwarded.Close()
+	local := dial()
+	defer local.Close()
+
+	// The request of unit 2 waits for the forward function, while the
+	// request of unit 1 is answered.
+	forwarded.Write([]byte{0, 1, 0, 0, 0, 6, 2, 3, 0, 0, 0, 1})
+	local.Write([]byte{0, 2, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1})
+	got := make([]byte, 11)
+	_, err = io.ReadFull(local, got)
+	expect := []byte{0, 2, 0, 0, 0, 5, 1, 3, 2, 0, 1}
+	if err != nil || !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v and %v", expect, got, err)
+	}
+
+	close(release)
+	_, err = io.ReadFull(forwarded, got)
+	expect = []byte{0, 1, 0, 0, 0, 5, 2, 3, 2, 0, 42}
+	if err != nil || !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v and %v", expect, got, err)
+	}
+}
@@ -0,0 +1,112 @@
+package mbserver
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"sync/atomic"
+)
+
+// MalformedPolicy is how the server handles the frames received that can
+// not be parsed, like frames that are too short, TCP frames where the
+// length in the header does not match the frame, and RTU frames with a
+// bad CRC.
+type MalformedPolicy int
+
+const (
+	// MalformedClose closes the connection the frame was received on,
+	// which is the default. The frames received on a serial port are
+	// dropped, since the port can not be closed and opened again by the
+	// client.
+	MalformedClose MalformedPolicy = iota
+	// MalformedDrop drops the frame, and keeps reading the requests of
+	// the connection.
+	MalformedDrop
+	// MalformedException answers the TCP frames with the Illegal Data
+	// Value exception when the header of the frame can be read, and drops
+	// the other frames. The RTU frames are always dropped, since the
+	// specification does not allow answering a frame with a bad CRC.
+	MalformedException
+)
+
+// String returns the name of the policy, which is close, drop or
+// exception.
+func (p MalformedPolicy) String() string {
+	switch p {
+	case MalformedDrop:
+		return "drop"
+	case MalformedException:
+		return "exception"
+	default:
+		return "close"
+	}
+}
+
+// MalformedStats holds the number of malformed frames received by the
+// server, by how they were handled.
+type MalformedStats struct {
+	// Dropped is the number of frames dropped.
+	Dropped uint64
+	// Closed is the number of connections closed because of a malformed
+	// frame.
+	Closed uint64
+	// Answered is the number of frames answered with an exception.
+	Answered uint64
+}
+
+// malformedCounters counts the malformed frames received on all the
+// connections of the server.
+type malformedCounters struct {
+	dropped  atomic.Uint64
+	closed   atomic.Uint64
+	answered atomic.Uint64
+}
+
+// MalformedStats returns the number of malformed frames received by the
+// server since it was started.
+func (s *Server) MalformedStats() MalformedStats {
+	return MalformedStats{
+		Dropped:  s.malformed.dropped.Load(),
+		Closed:   s.malformed.closed.Load(),
+		Answered: s.malformed.answered.Load(),
+	}
+}
+
+// handleMalformed handles the malformed packet given with the policy of
+// the server, and returns false if the connection should be closed. The
+// packet is an RTU frame if rtu is true and a TCP frame otherwise, and
+// serial is true for the packets received on a serial port.
+func (s *Server) handleMalformed(conn io.Writer, packet []byte, rtu bool, serial bool, err error) bool {
+	policy := s.Malformed
+	if policy == MalformedClose && serial {
+		policy = MalformedDrop
+	}
+	// The header of a TCP frame holds the transaction identifier and the
+	// function code needed for the response.
+	if policy == MalformedException && (rtu || len(packet) < 8) {
+		policy = MalformedDrop
+	}
+
+	switch policy {
+	case MalformedDrop:
+		s.malformed.dropped.Add(1)
+		log.Printf("bad packet error %v, dropped\n", err)
+		return true
+	case MalformedException:
+		s.malformed.answered.Add(1)
+		log.Printf("bad packet error %v, answered with IllegalDataValue\n", err)
+		response := &TCPFrame{
+			TransactionIdentifier: binary.BigEndian.Uint16(packet[0:2]),
+			ProtocolIdentifier:    binary.BigEndian.Uint16(packet[2:4]),
+			Device:                packet[6],
+			Function:              packet[7],
+		}
+		response.SetException(&IllegalDataValue)
+		s.writeBytes(conn, response, response.Bytes())
+		return true
+	default:
+		s.malformed.closed.Add(1)
+		log.Printf("bad packet error %v, closing the connection\n", err)
+		return false
+	}
+}
@@ -0,0 +1,83 @@
+package mbserver
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// malformedSession will start a server with the policy given listening on
+// the address given, write a TCP frame with a length larger than the
+// specification allows followed by a valid request, and return the frames
+// read back until the connection is closed or a valid response is read,
+// and the statistics of the server.
+func malformedSession(t *testing.T, addr string, policy MalformedPolicy) ([][]byte, MalformedStats) {
+	s := NewServer()
+	s.Malformed = policy
+	err := s.ListenTCP(addr)
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 2))
+
+	conn.Write([]byte{0, 7, 0, 0, 1, 0, 1, 3, 0, 0, 0, 1})
+	time.Sleep(time.Millisecond * 20)
+	frame := &TCPFrame{TransactionIdentifier: 8, Device: 1, Function: 3}
+	SetDataWithRegisterAndNumber(frame, 0, 1)
+	conn.Write(frame.Bytes())
+
+	var frames [][]byte
+	for {
+		header := make([]byte, 6)
+		_, err := io.ReadFull(conn, header)
+		if err != nil {
+			break
+		}
+		body := make([]byte, int(header[5]))
+		_, err = io.ReadFull(conn, body)
+		if err != nil {
+			break
+		}
+		frames = append(frames, append(header, body...))
+		if body[1] == 3 {
+			break
+		}
+	}
+
+	return frames, s.MalformedStats()
+}
+
+func TestMalformedPolicy(t *testing.T) {
+	frames, stats := malformedSession(t, "127.0.0.1:3342", MalformedClose)
+	if len(frames) != 0 {
+		t.Errorf("expected the connection to be closed, got %v", frames)
+	}
+	if !isEqual(MalformedStats{Closed: 1}, stats) {
+		t.Errorf("expected %v, got %v", MalformedStats{Closed: 1}, stats)
+	}
+
+	frames, stats = malformedSession(t, "127.0.0.1:3343", MalformedDrop)
+	if len(frames) != 1 || frames[0][1] != 8 {
+		t.Errorf("expected only the response to the valid request, got %v", frames)
+	}
+	if !isEqual(MalformedStats{Dropped: 1}, stats) {
+		t.Errorf("expected %v, got %v", MalformedStats{Dropped: 1}, stats)
+	}
+
+	frames, stats = malformedSession(t, "127.0.0.1:3344", MalformedException)
+	expect := []byte{0, 7, 0, 0, 0, 3, 1, 0x83, byte(IllegalDataValue)}
+	if len(frames) != 2 || !isEqual(expect, frames[0]) || frames[1][1] != 8 {
+		t.Errorf("expected the exception and the response, got %v", frames)
+	}
+	if !isEqual(MalformedStats{Answered: 1}, stats) {
+		t.Errorf("expected %v, got %v", MalformedStats{Answered: 1}, stats)
+	}
+}
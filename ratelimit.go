@@ -0,0 +1,151 @@
+package mbserver
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimit limits the rate of the requests received on the TCP
+// connections of the server, to emulate a constrained device or protect a
+// shared server. The zero value disables the limits.
+type RateLimit struct {
+	// PerConnection is the number of requests per second allowed on each
+	// connection. 0 means no limit.
+	PerConnection float64
+	// Global is the number of requests per second allowed on all the
+	// connections together. 0 means no limit.
+	Global float64
+	// Burst is the number of requests allowed at once before the rate
+	// applies. Values below 1 are treated as 1.
+	Burst int
+	// Busy answers the requests over the limit with the Slave Device Busy
+	// exception. Otherwise the requests over the limit are delayed until
+	// the rate allows them.
+	Busy bool
+}
+
+// tokenBucket allows a rate of requests per second, with bursts of up to
+// burst requests.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// refill adds the tokens for the time passed since the last refill.
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// allowBoth takes a token from both the bucket of the connection and
+// the global bucket, and returns true if both have one available at the
+// time given. No token is taken from either bucket otherwise, so a
+// request rejected by one limit does not count against the other. Either
+// bucket can be nil. The bucket of the connection is always locked
+// before the global bucket.
+func allowBoth(now time.Time, conn *tokenBucket, global *tokenBucket) bool {
+	if conn != nil {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		conn.refill(now)
+		if conn.tokens < 1 {
+			return false
+		}
+	}
+	if global != nil {
+		global.mu.Lock()
+		defer global.mu.Unlock()
+		global.refill(now)
+		if global.tokens < 1 {
+			return false
+		}
+	}
+
+	if conn != nil {
+		conn.tokens--
+	}
+	if global != nil {
+		global.tokens--
+	}
+	return true
+}
+
+// reserve takes a token, and returns how long to wait from the time
+// given until the token is available.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// limiter holds the token buckets of the rate limit of the server.
+type limiter struct {
+	once   sync.Once
+	global *tokenBucket
+}
+
+// limitRequest applies the rate limit of the server to a request received
+// on the session given. It sleeps until the request is allowed, or
+// returns false if the request should be answered with Slave Device Busy.
+func (s *Server) limitRequest(sess *session) bool {
+	l := s.RateLimit
+	if l.PerConnection <= 0 && l.Global <= 0 {
+		return true
+	}
+
+	s.limiter.once.Do(func() {
+		if l.Global > 0 {
+			s.limiter.global = newTokenBucket(l.Global, l.Burst)
+		}
+	})
+	if sess.limit == nil && l.PerConnection > 0 {
+		sess.limit = newTokenBucket(l.PerConnection, l.Burst)
+	}
+
+	now := time.Now()
+	if l.Busy {
+		return allowBoth(now, sess.limit, s.limiter.global)
+	}
+
+	// Wait for the token of the limit that is furthest away.
+	var wait time.Duration
+	if sess.limit != nil {
+		wait = sess.limit.reserve(now)
+	}
+	if s.limiter.global != nil {
+		wait = max(wait, s.limiter.global.reserve(now))
+	}
+	time.Sleep(wait)
+
+	return true
+}
+
+// answerBusy answers the request on the connection given with the Slave
+// Device Busy exception.
+func (s *Server) answerBusy(conn io.Writer, frame Framer) {
+	response := frame.Copy()
+	response.SetException(&SlaveDeviceBusy)
+	s.writeBytes(conn, response, response.Bytes())
+}
@@ -0,0 +1,88 @@
+package mbserver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newTokenBucket(10, 2)
+
+	// The burst is allowed at once, and then one request every 100ms.
+	got := []bool{allowBoth(now, b, nil), allowBoth(now, b, nil), allowBoth(now, b, nil), allowBoth(now.Add(time.Millisecond*100), b, nil)}
+	expect := []bool{true, true, false, true}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// Reserving returns the time to wait for the tokens taken.
+	waits := []time.Duration{b.reserve(now.Add(time.Millisecond * 100)), b.reserve(now.Add(time.Millisecond * 100))}
+	expectWaits := []time.Duration{time.Millisecond * 100, time.Millisecond * 200}
+	if !isEqual(expectWaits, waits) {
+		t.Errorf("expected %v, got %v", expectWaits, waits)
+	}
+}
+
+func TestAllowBoth(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	conn := newTokenBucket(10, 2)
+	global := newTokenBucket(1, 1)
+
+	// The second request is rejected by the global limit, and does not
+	// use a token of the connection.
+	got := []bool{allowBoth(now, conn, global), allowBoth(now, conn, global)}
+	expect := []bool{true, false}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	if conn.tokens != 1 {
+		t.Errorf("expected %v, got %v", 1, conn.tokens)
+	}
+}
+
+func TestLimitRequestAllocs(t *testing.T) {
+	s := NewServer()
+	s.RateLimit = RateLimit{PerConnection: 1e9, Global: 1e9, Burst: 1e6, Busy: true}
+	sess := &session{}
+	s.limitRequest(sess)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		s.limitRequest(sess)
+	})
+	if allocs != 0 {
+		t.Errorf("expected %v, got %v", 0, allocs)
+	}
+}
+
+func TestRateLimitBusy(t *testing.T) {
+	s := NewServer()
+	s.RateLimit = RateLimit{PerConnection: 1, Busy: true}
+	err := s.ListenTCP("127.0.0.1:3336")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	handler := modbus.NewTCPClientHandler("127.0.0.1:3336")
+	err = handler.Connect()
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+
+	_, err = client.ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	_, err = client.ReadHoldingRegisters(0, 1)
+	var mbErr *modbus.ModbusError
+	if !errors.As(err, &mbErr) || mbErr.ExceptionCode != byte(SlaveDeviceBusy) {
+		t.Errorf("expected SlaveDeviceBusy, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package mbserver
+
+import (
+	"io"
+	"time"
+)
+
+// RTUTiming shapes the timing of RTU responses to simulate a serial line.
+type RTUTiming struct {
+	// BaudRate is used to calculate the time it takes to send a character
+	// on the serial line. 0 disables the timing.
+	BaudRate int
+	// CharDelay is an extra delay between each character of the response.
+	// When set, the response is written one character at the time, except
+	// on RTU over UDP, where each write is sent as a datagram, so the whole
+	// response is sent in one datagram after the time of all its
+	// characters.
+	CharDelay time.Duration
+}
+
+// charTime returns the time it takes to send a single character of 11 bits
+// (start bit, 8 data bits, parity and stop bit) at the baud rate.
+func (t RTUTiming) charTime() time.Duration {
+	return time.Second * 11 / time.Duration(t.BaudRate)
+}
+
+// silentInterval returns the silent interval of 3.5 characters required
+// between RTU frames. For baud rates above 19200 the fixed value of 1.75ms
+// recommended by the Modbus over serial line specification is used.
+func (t RTUTiming) silentInterval() time.Duration {
+	if t.BaudRate > 19200 {
+		return time.Microsecond * 1750
+	}
+	return t.charTime() * 7 / 2
+}
+
+// write writes the frame to w after the silent interval, taking the time it
+// would take to send the frame on a serial line at the baud rate.
+func (t RTUTiming) write(w io.Writer, frame []byte) error {
+	time.Sleep(t.silentInterval())
+
+	if t.CharDelay == 0 {
+		time.Sleep(t.charTime() * time.Duration(len(frame)))
+		_, err := w.Write(frame)
+		return err
+	}
+
+	for i := range frame {
+		time.Sleep(t.charTime() + t.CharDelay)
+		_, err := w.Write(frame[i : i+1])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writePacket writes the whole frame to w at once, after the silent
+// interval and the time it would take to send all the characters of the
+// frame with the CharDelay, for the writers where each write is sent as a
+// packet of its own, like the datagrams of RTU over UDP.
+func (t RTUTiming) writePacket(w io.Writer, frame []byte) error {
+	time.Sleep(t.silentInterval() + (t.charTime()+t.CharDelay)*time.Duration(len(frame)))
+	_, err := w.Write(frame)
+	return err
+}
+
+// busTime returns the time the request and the response given hold a
+// serial bus at the baud rate, including the silent interval before each
+// of them. The response is nil when the request is not answered.
+func (t RTUTiming) busTime(request Framer, response Framer) time.Duration {
+	d := t.silentInterval() + t.frameTime(request)
+	if response != nil {
+		d += t.silentInterval() + t.frameTime(response)
+	}
+	return d
+}
+
+// frameTime returns the time it takes to send the frame given as a RTU
+// frame, with the unit id, function code, data and CRC.
+func (t RTUTiming) frameTime(frame Framer) time.Duration {
+	n := time.Duration(len(frame.GetData()) + 4)
+	return (t.charTime() + t.CharDelay) * n
+}
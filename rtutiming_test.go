@@ -0,0 +1,71 @@
+package mbserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTUTimingSilentInterval(t *testing.T) {
+	timing := RTUTiming{BaudRate: 9600}
+	expect := time.Second * 11 / 9600 * 7 / 2
+	got := timing.silentInterval()
+	if expect != got {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	timing = RTUTiming{BaudRate: 38400}
+	expect = time.Microsecond * 1750
+	got = timing.silentInterval()
+	if expect != got {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+// writeRecorder records each call to Write.
+type writeRecorder struct {
+	writes [][]byte
+}
+
+func (w *writeRecorder) Write(b []byte) (int, error) {
+	w.writes = append(w.writes, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func TestRTUTimingWrite(t *testing.T) {
+	frame := []byte{0x01, 0x04, 0x02, 0xFF, 0xFF, 0xB8, 0x80}
+
+	timing := RTUTiming{BaudRate: 115200, CharDelay: time.Millisecond}
+	var w writeRecorder
+	start := time.Now()
+	err := timing.write(&w, frame)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if len(w.writes) != len(frame) {
+		t.Errorf("expected %v writes, got %v", len(frame), len(w.writes))
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*7 {
+		t.Errorf("expected write to take at least 7ms, got %v", elapsed)
+	}
+}
+
+func TestRTUTimingBusTime(t *testing.T) {
+	timing := RTUTiming{BaudRate: 9600}
+
+	// A read of one register is 8 bytes, and the response 7 bytes.
+	request := &TCPFrame{Function: 3, Data: []byte{0, 0, 0, 1}}
+	response := &TCPFrame{Function: 3, Data: []byte{2, 0, 0}}
+	expect := timing.silentInterval()*2 + timing.charTime()*15
+	got := timing.busTime(request, response)
+	if expect != got {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// A broadcast is not answered.
+	expect = timing.silentInterval() + timing.charTime()*8
+	got = timing.busTime(request, nil)
+	if expect != got {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
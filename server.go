@@ -4,6 +4,9 @@ package mbserver
 import (
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/goburrow/serial"
 )
@@ -11,26 +14,182 @@ import (
 // Server is a Modbus slave with allocated memory for discrete inputs, coils, etc.
 type Server struct {
 	// Debug enables more verbose messaging.
-	Debug            bool
-	listeners        []net.Listener
+	Debug bool
+	// Broadcast enables broadcast handling, where write requests
+	// addressed to unit 0 are applied to all units and never answered.
+	Broadcast bool
+	// RTUTiming shapes the timing of the responses to RTU requests to
+	// simulate a serial line. The zero value disables the timing.
+	RTUTiming RTUTiming
+	// RateLimit limits the rate of the requests received on the TCP
+	// connections. The zero value disables the limits.
+	RateLimit RateLimit
+	// SerialBus simulates units sharing a serial bus behind a gateway,
+	// where each request holds the bus for the time it takes to send the
+	// request and the response on the serial line, so the requests from
+	// all the connections wait for each other. The zero value disables
+	// the simulation.
+	SerialBus RTUTiming
+	// SessionHook is called when a TCP connection is accepted and when it
+	// is closed, with the statistics of the connection.
+	SessionHook func(SessionStats)
+	// TransactionHook is called after each request is handled, with the
+	// timing of the request. It is called from the goroutine handling the
+	// requests, or the goroutine of a unit forwarding its requests, one
+	// call at a time, so it should return quickly.
+	TransactionHook func(Transaction)
+	// UpdateHook is called once for each batch of updates written with
+	// ApplyUpdates, with all the updates of the batch, so the changes can
+	// be announced with a single notification.
+	UpdateHook func([]RegisterUpdate)
+	// Malformed is how the frames that can not be parsed are handled. The
+	// zero value closes the connection.
+	Malformed MalformedPolicy
+	// Store holds the registers read and written by the functions of the
+	// server. A nil store uses the memory of the DiscreteInputs, Coils,
+	// HoldingRegisters and InputRegisters fields.
+	Store            RegisterStore
+	listeners        []*listener
+	udpListeners     []*udpListener
 	ports            []serial.Port
 	requestChan      chan *Request
 	function         [256](func(*Server, Framer) ([]byte, *Exception))
+	mu               *sync.Mutex
+	units            map[uint8]*Server
+	offline          map[uint8]*Exception
+	serial           SerialSide
+	forwarder        *forwarder
+	respondMu        sync.Mutex
+	sessionsMu       sync.Mutex
+	sessions         map[*session]bool
+	limiter          limiter
+	malformed        malformedCounters
+	scratch          scratch
+	tcpResponse      TCPFrame
+	rtuResponse      RTUFrame
+	out              []byte
 	DiscreteInputs   []byte
 	Coils            []byte
 	HoldingRegisters []uint16
 	InputRegisters   []uint16
 }
 
+// listener is a network listener of the server, counting the connections
+// currently open and the connections rejected.
+type listener struct {
+	net.Listener
+	conns    atomic.Int64
+	rejected atomic.Uint64
+	config   ConnConfig
+}
+
+// ListenerStats holds the statistics of a network listener of the server.
+type ListenerStats struct {
+	// Address is the address the listener is listening on.
+	Address string
+	// Connections is the number of connections currently open.
+	Connections int
+	// Rejected is the number of connections closed at once because the
+	// MaxConnections of the listener was reached.
+	Rejected uint64
+}
+
+// ListenerStats returns the statistics of all the network listeners of the
+// server.
+func (s *Server) ListenerStats() []ListenerStats {
+	var stats []ListenerStats
+	for _, l := range s.listeners {
+		stats = append(stats, ListenerStats{
+			Address:     l.Addr().String(),
+			Connections: int(l.conns.Load()),
+			Rejected:    l.rejected.Load(),
+		})
+	}
+
+	return stats
+}
+
 // Request contains the connection and Modbus frame.
 type Request struct {
 	conn  io.ReadWriteCloser
 	frame Framer
+	// received is the time the request was read from the connection.
+	received time.Time
+	// clientDevice is the unit ID the client sent the request to, when
+	// mapped is true and the unit ID was mapped by the UnitMap of the
+	// listener.
+	clientDevice uint8
+	mapped       bool
+	// forwarded is true when the request is answered by the goroutine
+	// of a forwarding unit instead of the handler.
+	forwarded bool
+}
+
+// connBuffers holds the packets, frames and requests read from a
+// connection, which are reused so reading a request does not allocate.
+// There are two of each, used in turn, since the next request is read
+// while the handler answers the one before it. The request channel is
+// unbuffered, so when a request is handed to the handler, the handler
+// is done with the request before it.
+type connBuffers struct {
+	packets  [2][512]byte
+	tcp      [2]TCPFrame
+	rtu      [2]RTUFrame
+	requests [2]Request
+	next     int
+}
+
+// packet will return the packet to read the next request into.
+func (b *connBuffers) packet() []byte {
+	return b.packets[b.next][:]
+}
+
+// frame will parse the packet given into the frame of the next request,
+// which is an RTU frame if rtu is true and a TCP frame otherwise.
+func (b *connBuffers) frame(packet []byte, rtu bool) (Framer, error) {
+	if rtu {
+		frame := &b.rtu[b.next]
+		err := frame.parse(packet)
+		if err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+
+	frame := &b.tcp[b.next]
+	err := frame.parse(packet)
+	if err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// request will return the next request, holding the frame given, and
+// move on to the other buffers. It must only be called for the requests
+// handed to the handler, so the buffers of a request answered at once,
+// like a busy request, are used again for the next request.
+func (b *connBuffers) request(conn io.ReadWriteCloser, frame Framer, received time.Time) *Request {
+	request := &b.requests[b.next]
+	*request = Request{conn: conn, frame: frame, received: received}
+	b.next = 1 - b.next
+	return request
 }
 
 // NewServer creates a new Modbus server (slave).
 func NewServer() *Server {
+	s := newUnit()
+
+	s.requestChan = make(chan *Request)
+	go s.handler()
+
+	return s
+}
+
+// newUnit creates a server with allocated Modbus memory and the default
+// functions, without starting the handling of requests.
+func newUnit() *Server {
 	s := &Server{}
+	s.mu = &sync.Mutex{}
 
 	// Allocate Modbus memory maps.
 	s.DiscreteInputs = make([]byte, 65536)
@@ -48,26 +207,169 @@ func NewServer() *Server {
 	s.function[15] = WriteMultipleCoils
 	s.function[16] = WriteHoldingRegisters
 
-	s.requestChan = make(chan *Request)
-	go s.handler()
-
 	return s
 }
 
+// AddUnit adds a unit (slave) with the unit id given, and returns it so its
+// Modbus memory can be populated and its functions customized. The unit starts
+// with a copy of the functions currently registered with the server.
+// Requests with the unit id given are answered from the Modbus memory of the
+// unit, while requests for unit ids not added are answered from the memory of
+// the server itself. A server without any units added answers all unit ids.
+func (s *Server) AddUnit(id uint8) *Server {
+	u := newUnit()
+	u.function = s.function
+	u.mu = s.mu
+
+	if s.units == nil {
+		s.units = make(map[uint8]*Server)
+	}
+	s.units[id] = u
+
+	return u
+}
+
+// Units returns the units added with AddUnit.
+func (s *Server) Units() map[uint8]*Server {
+	return s.units
+}
+
+// SetUnitOffline makes the requests for the unit id given be answered with
+// the exception given, the way a gateway answers for a unit it can not
+// reach, e.g. with GatewayPathUnavailable or
+// GatewayTargetDeviceFailedtoRespond. With a nil exception the requests
+// are not answered at all, like a unit that has lost power. Broadcasts are
+// not applied to a unit that is offline. The server must be locked with
+// Lock when the unit is set offline while the server is running.
+func (s *Server) SetUnitOffline(id uint8, exception *Exception) {
+	if s.offline == nil {
+		s.offline = make(map[uint8]*Exception)
+	}
+	s.offline[id] = exception
+}
+
+// SetUnitOnline makes the unit id given answer requests again after being
+// set offline with SetUnitOffline. The server must be locked with Lock when
+// the unit is set online while the server is running.
+func (s *Server) SetUnitOnline(id uint8) {
+	delete(s.offline, id)
+}
+
+// UnitOffline returns the exception the unit id given is answered with
+// when it is offline, and true if the unit is offline. The exception is
+// nil when the requests for the unit are not answered.
+func (s *Server) UnitOffline(id uint8) (*Exception, bool) {
+	exception, ok := s.offline[id]
+	return exception, ok
+}
+
 // RegisterFunctionHandler override the default behavior for a given Modbus function.
 func (s *Server) RegisterFunctionHandler(funcCode uint8, function func(*Server, Framer) ([]byte, *Exception)) {
 	s.function[funcCode] = function
 }
 
+// FunctionHandler returns the function currently handling the given Modbus
+// function, or nil if the function is not supported. It can be used to wrap
+// the default behavior when overriding a function with RegisterFunctionHandler.
+func (s *Server) FunctionHandler(funcCode uint8) func(*Server, Framer) ([]byte, *Exception) {
+	return s.function[funcCode]
+}
+
+// Lock stops the server from handling requests until Unlock is called, so the
+// Modbus memory can be changed while the server is running without a request
+// seeing the change half done. Units added with AddUnit share the lock of the
+// server they were added to.
+func (s *Server) Lock() {
+	s.mu.Lock()
+}
+
+// Unlock lets the server continue handling requests after a call to Lock.
+func (s *Server) Unlock() {
+	s.mu.Unlock()
+}
+
+// isWriteFunction returns true for the functions writing to Modbus memory.
+func isWriteFunction(function uint8) bool {
+	return function == 5 || function == 6 || function == 15 || function == 16
+}
+
+// handle returns the response to the request, or nil if the request should
+// not be answered. The response is only valid until the next request is
+// handled.
 func (s *Server) handle(request *Request) Framer {
 	var exception *Exception
 	var data []byte
 
-	response := request.frame.Copy()
-
+	device := request.frame.GetDevice()
 	function := request.frame.GetFunction()
-	if s.function[function] != nil {
-		data, exception = s.function[function](s, request.frame)
+
+	// Nothing reaches the units when the serial side of the gateway is
+	// down.
+	if s.serial.Down {
+		if s.serial.DownException == nil || (s.Broadcast && device == 0) {
+			return nil
+		}
+		response := s.responseFrame(request.frame)
+		response.SetException(s.serial.DownException)
+		return response
+	}
+
+	// Broadcasts are never answered, and only writes are applied.
+	if s.Broadcast && device == 0 {
+		if !isWriteFunction(function) {
+			return nil
+		}
+		if len(s.units) == 0 {
+			if s.function[function] != nil {
+				s.function[function](s, request.frame)
+			}
+			return nil
+		}
+		for id, u := range s.units {
+			if _, ok := s.offline[id]; ok {
+				continue
+			}
+			if u.forwarder != nil {
+				u.forwarder.enqueueBroadcast(s, request)
+				continue
+			}
+			if u.function[function] != nil {
+				u.function[function](u, request.frame)
+			}
+		}
+		return nil
+	}
+
+	// Answer for the units that are offline as a gateway would.
+	if exception, ok := s.offline[device]; ok {
+		if exception == nil {
+			return nil
+		}
+		response := s.responseFrame(request.frame)
+		response.SetException(exception)
+		return response
+	}
+
+	// Answer from the memory of the unit if the unit id is added.
+	target := s
+	if u, ok := s.units[device]; ok {
+		target = u
+	}
+
+	response := s.responseFrame(request.frame)
+
+	// The requests of a forwarding unit are answered by its goroutine.
+	if target.forwarder != nil {
+		if !target.forwarder.enqueue(s, request) {
+			response.SetException(&SlaveDeviceBusy)
+			return response
+		}
+		request.forwarded = true
+		return nil
+	}
+
+	if target.function[function] != nil {
+		data, exception = target.function[function](target, request.frame)
 		response.SetData(data)
 	} else {
 		exception = &IllegalFunction
@@ -80,20 +382,97 @@ func (s *Server) handle(request *Request) Framer {
 	return response
 }
 
+// responseFrame will return the frame the response to the request given
+// is built in, which is reused for each request so the handling of a
+// request does not allocate. Frames of other types than TCPFrame and
+// RTUFrame are copied.
+func (s *Server) responseFrame(frame Framer) Framer {
+	switch f := frame.(type) {
+	case *TCPFrame:
+		s.tcpResponse = *f
+		return &s.tcpResponse
+	case *RTUFrame:
+		s.rtuResponse = *f
+		return &s.rtuResponse
+	}
+	return frame.Copy()
+}
+
 // All requests are handled synchronously to prevent modbus memory corruption.
 func (s *Server) handler() {
 	for {
 		request := <-s.requestChan
+		s.mu.Lock()
+		handled := time.Now()
 		response := s.handle(request)
-		request.conn.Write(response.Bytes())
+		processed := time.Now()
+		delay := s.serial.Delay
+		s.mu.Unlock()
+		if request.forwarded {
+			continue
+		}
+		if s.SerialBus.BaudRate > 0 {
+			delay += s.SerialBus.busTime(request.frame, response)
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		var b []byte
+		if response != nil {
+			if request.mapped {
+				setDevice(response, request.clientDevice)
+			}
+			b = s.responseBytes(response)
+		}
+		s.respond(request, handled, processed, response, b)
 	}
 }
 
-// Close stops listening to TCP/IP ports and closes serial ports.
+// write writes the response to the connection. The bytes of the response
+// are built in a buffer reused for each response, so write must only be
+// called from the handler.
+func (s *Server) write(conn io.Writer, response Framer) {
+	s.writeBytes(conn, response, s.responseBytes(response))
+}
+
+// responseBytes will return the bytes of the response, built in a buffer
+// reused for each response, so it must only be called from the handler.
+func (s *Server) responseBytes(response Framer) []byte {
+	switch f := response.(type) {
+	case *TCPFrame:
+		s.out = f.appendBytes(s.out[:0])
+	case *RTUFrame:
+		s.out = f.appendBytes(s.out[:0])
+	default:
+		s.out = append(s.out[:0], response.Bytes()...)
+	}
+	return s.out
+}
+
+// writeBytes writes the bytes of the response given to the connection,
+// using the RTU timing for RTU frames if it is enabled. A response to a
+// RTU over UDP client is always sent as a single datagram.
+func (s *Server) writeBytes(conn io.Writer, response Framer, b []byte) {
+	if _, ok := response.(*RTUFrame); ok && s.RTUTiming.BaudRate > 0 {
+		if _, ok := conn.(*udpPeer); ok {
+			s.RTUTiming.writePacket(conn, b)
+			return
+		}
+		s.RTUTiming.write(conn, b)
+		return
+	}
+
+	conn.Write(b)
+}
+
+// Close stops listening to TCP/IP and UDP ports and closes serial ports.
 func (s *Server) Close() {
 	for _, listen := range s.listeners {
 		listen.Close()
 	}
+	for _, listen := range s.udpListeners {
+		listen.conn.Close()
+	}
 	for _, port := range s.ports {
 		port.Close()
 	}
@@ -1,6 +1,7 @@
 package mbserver
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -136,3 +137,344 @@ func TestModbus(t *testing.T) {
 		t.Errorf("expected %v, got %v", expect, got)
 	}
 }
+
+func TestFunctionHandler(t *testing.T) {
+	s := NewServer()
+
+	read := s.FunctionHandler(3)
+	if read == nil {
+		t.Fatalf("expected function handler for function 3, got nil")
+	}
+
+	// Wrap the default function to always return one more register.
+	s.RegisterFunctionHandler(3, func(s *Server, frame Framer) ([]byte, *Exception) {
+		data, exception := read(s, frame)
+		return append(data, 0, 7), exception
+	})
+
+	var frame TCPFrame
+	frame.Function = 3
+	SetDataWithRegisterAndNumber(&frame, 0, 1)
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+
+	expect := []byte{2, 0, 0, 0, 7}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	if s.FunctionHandler(100) != nil {
+		t.Errorf("expected nil function handler for function 100")
+	}
+}
+
+func TestUnits(t *testing.T) {
+	s := NewServer()
+	u1 := s.AddUnit(1)
+	u2 := s.AddUnit(2)
+	u1.HoldingRegisters[0] = 1
+	u2.HoldingRegisters[0] = 2
+	s.HoldingRegisters[0] = 255
+
+	tests := []struct {
+		device uint8
+		expect []byte
+	}{
+		{1, []byte{2, 0, 1}},
+		{2, []byte{2, 0, 2}},
+		{3, []byte{2, 0, 255}},
+	}
+
+	for _, tt := range tests {
+		var frame TCPFrame
+		frame.Device = tt.device
+		frame.Function = 3
+		SetDataWithRegisterAndNumber(&frame, 0, 1)
+
+		var req Request
+		req.frame = &frame
+		response := s.handle(&req)
+
+		got := response.GetData()
+		if !isEqual(tt.expect, got) {
+			t.Errorf("unit %v: expected %v, got %v", tt.device, tt.expect, got)
+		}
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	s := NewServer()
+	s.Broadcast = true
+	u1 := s.AddUnit(1)
+	u2 := s.AddUnit(2)
+
+	var frame TCPFrame
+	frame.Device = 0
+	frame.Function = 6
+	SetDataWithRegisterAndNumber(&frame, 10, 1234)
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+	if response != nil {
+		t.Errorf("expected no response to broadcast, got %v", response)
+	}
+
+	got := []uint16{u1.HoldingRegisters[10], u2.HoldingRegisters[10], s.HoldingRegisters[10]}
+	expect := []uint16{1234, 1234, 0}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestOfflineUnits(t *testing.T) {
+	s := NewServer()
+	s.Broadcast = true
+	u1 := s.AddUnit(1)
+	u2 := s.AddUnit(2)
+	s.SetUnitOffline(2, &GatewayTargetDeviceFailedtoRespond)
+	s.SetUnitOffline(3, &GatewayPathUnavailable)
+	s.SetUnitOffline(4, nil)
+
+	tests := []struct {
+		device uint8
+		expect Exception
+	}{
+		{1, Success},
+		{2, GatewayTargetDeviceFailedtoRespond},
+		{3, GatewayPathUnavailable},
+	}
+
+	for _, tt := range tests {
+		var frame TCPFrame
+		frame.Device = tt.device
+		frame.Function = 3
+		SetDataWithRegisterAndNumber(&frame, 0, 1)
+
+		var req Request
+		req.frame = &frame
+		got := GetException(s.handle(&req))
+		if tt.expect != got {
+			t.Errorf("unit %v: expected %v, got %v", tt.device, tt.expect, got)
+		}
+	}
+
+	// A unit offline without an exception is not answered.
+	frame := TCPFrame{Device: 4, Function: 3}
+	SetDataWithRegisterAndNumber(&frame, 0, 1)
+	if response := s.handle(&Request{frame: &frame}); response != nil {
+		t.Errorf("expected no response, got %v", response)
+	}
+
+	// Broadcasts are not applied to the units offline.
+	frame = TCPFrame{Function: 6}
+	SetDataWithRegisterAndNumber(&frame, 10, 1234)
+	s.handle(&Request{frame: &frame})
+
+	got := []uint16{u1.HoldingRegisters[10], u2.HoldingRegisters[10]}
+	expect := []uint16{1234, 0}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// The unit answers again when set online.
+	s.SetUnitOnline(2)
+	if _, ok := s.UnitOffline(2); ok {
+		t.Errorf("expected unit 2 to be online")
+	}
+	frame = TCPFrame{Device: 2, Function: 3}
+	SetDataWithRegisterAndNumber(&frame, 10, 1)
+	response := s.handle(&Request{frame: &frame})
+	if !isEqual([]byte{2, 0, 0}, response.GetData()) {
+		t.Errorf("expected %v, got %v", []byte{2, 0, 0}, response.GetData())
+	}
+}
+
+func TestListenerStats(t *testing.T) {
+	s := NewServer()
+	err := s.ListenTCP("127.0.0.1:3334")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	handler := modbus.NewTCPClientHandler("127.0.0.1:3334")
+	err = handler.Connect()
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+
+	// Allow the server to accept the connection.
+	time.Sleep(10 * time.Millisecond)
+
+	expect := []ListenerStats{{Address: "127.0.0.1:3334", Connections: 1}}
+	got := s.ListenerStats()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	s := NewServer()
+	closed := make(chan SessionStats, 1)
+	s.SessionHook = func(st SessionStats) {
+		if !st.Disconnected.IsZero() {
+			closed <- st
+		}
+	}
+	err := s.ListenTCP("127.0.0.1:3335")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	handler := modbus.NewTCPClientHandler("127.0.0.1:3335")
+	err = handler.Connect()
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	client := modbus.NewClient(handler)
+
+	for i := 0; i < 2; i++ {
+		_, err = client.ReadHoldingRegisters(0, 2)
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+	}
+	_, err = client.WriteSingleRegister(0, 1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	// Allow the server to count the last response written.
+	time.Sleep(10 * time.Millisecond)
+
+	sessions := s.Sessions()
+	if len(sessions) != 1 {
+		t.Fatalf("expected 1 session, got %v", len(sessions))
+	}
+	expect := map[uint8]int{3: 2, 6: 1}
+	if !isEqual(expect, sessions[0].Requests) {
+		t.Errorf("expected %v, got %v", expect, sessions[0].Requests)
+	}
+	// Each request is 12 bytes, the responses of the reads 13 bytes, and
+	// the response of the write 12 bytes.
+	got := []int{sessions[0].BytesRead, sessions[0].BytesWritten}
+	if !isEqual([]int{36, 38}, got) {
+		t.Errorf("expected %v, got %v", []int{36, 38}, got)
+	}
+
+	handler.Close()
+	select {
+	case st := <-closed:
+		if st.Remote != sessions[0].Remote {
+			t.Errorf("expected %v, got %v", sessions[0].Remote, st.Remote)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the session hook to be called when closed")
+	}
+	if len(s.Sessions()) != 0 {
+		t.Errorf("expected no sessions, got %v", s.Sessions())
+	}
+}
+
+func TestNoTornReads(t *testing.T) {
+	s := NewServer()
+	err := s.ListenTCP("127.0.0.1:3338")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	// Update a value of two words, where both words hold the same
+	// generation, while it is read.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for gen := uint16(0); ; gen++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			s.Lock()
+			s.HoldingRegisters[100] = gen
+			s.HoldingRegisters[101] = gen
+			s.Unlock()
+			time.Sleep(time.Microsecond * 10)
+		}
+	}()
+
+	handler := modbus.NewTCPClientHandler("127.0.0.1:3338")
+	err = handler.Connect()
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+
+	for i := 0; i < 200; i++ {
+		results, err := client.ReadHoldingRegisters(100, 2)
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		if results[0] != results[2] || results[1] != results[3] {
+			t.Fatalf("expected both words from the same update, got %v", results)
+		}
+	}
+}
+
+func TestReusedBuffers(t *testing.T) {
+	s := NewServer()
+	err := s.ListenTCP("127.0.0.1:3339")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+	for i := range s.HoldingRegisters[:1000] {
+		s.HoldingRegisters[i] = uint16(i)
+	}
+
+	// The buffers of the connections and of the responses are reused
+	// for each request, so the connections read different registers at
+	// the same time, and each response must hold the registers of its
+	// own request.
+	errs := make(chan error, 4)
+	for c := 0; c < 4; c++ {
+		go func(c int) {
+			handler := modbus.NewTCPClientHandler("127.0.0.1:3339")
+			err := handler.Connect()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer handler.Close()
+			client := modbus.NewClient(handler)
+
+			for i := 0; i < 100; i++ {
+				addr := uint16(c*200 + i)
+				results, err := client.ReadHoldingRegisters(addr, 2)
+				if err != nil {
+					errs <- err
+					return
+				}
+				expect := []byte{byte(addr >> 8), byte(addr), byte((addr + 1) >> 8), byte(addr + 1)}
+				if !isEqual(expect, results) {
+					errs <- fmt.Errorf("expected %v, got %v", expect, results)
+					return
+				}
+			}
+			errs <- nil
+		}(c)
+	}
+
+	for c := 0; c < 4; c++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package mbserver
 import (
 	"io"
 	"log"
+	"time"
 
 	"github.com/goburrow/serial"
 )
@@ -20,10 +21,12 @@ func (s *Server) ListenRTU(serialConfig *serial.Config) (err error) {
 }
 
 func (s *Server) acceptSerialRequests(port serial.Port) {
+	var buffers connBuffers
 	for {
-		buffer := make([]byte, 512)
+		buffer := buffers.packet()
 
 		bytesRead, err := port.Read(buffer)
+		received := time.Now()
 		if err != nil {
 			if err != io.EOF {
 				log.Printf("serial read error %v\n", err)
@@ -36,15 +39,13 @@ func (s *Server) acceptSerialRequests(port serial.Port) {
 			// Set the length of the packet to the number of read bytes.
 			packet := buffer[:bytesRead]
 
-			frame, err := NewRTUFrame(packet)
+			frame, err := buffers.frame(packet, true)
 			if err != nil {
-				log.Printf("bad serial frame error %v\n", err)
-				return
+				s.handleMalformed(port, packet, true, true, err)
+				continue
 			}
 
-			request := &Request{port, frame}
-
-			s.requestChan <- request
+			s.requestChan <- buffers.request(port, frame, received)
 		}
 	}
 }
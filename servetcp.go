@@ -1,14 +1,18 @@
 package mbserver
 
 import (
+	"context"
+	"errors"
 	"io"
 	"log"
 	"net"
+	"os"
 	"strings"
+	"time"
 )
 
 // accept will accept TCP connections.
-func (s *Server) accept(listen net.Listener) error {
+func (s *Server) accept(listen *listener) error {
 	for {
 		conn, err := listen.Accept()
 		if err != nil {
@@ -19,45 +23,121 @@ func (s *Server) accept(listen net.Listener) error {
 			return err
 		}
 
-		go func(conn net.Conn) {
-			defer conn.Close()
-
-			for {
-				packet := make([]byte, 512)
-				bytesRead, err := conn.Read(packet)
-				if err != nil {
-					if err != io.EOF {
-						log.Printf("read error %v\n", err)
-					}
-					return
-				}
-				// Set the length of the packet to the number of read bytes.
-				packet = packet[:bytesRead]
+		if !listen.admit(conn) {
+			continue
+		}
+		go s.serveConn(listen, conn, false)
+	}
+}
 
-				frame, err := NewTCPFrame(packet)
-				if err != nil {
-					log.Printf("bad packet error %v\n", err)
-					return
-				}
+// admit will count the connection given as open, or close it and return
+// false if the listener already has the maximum number of connections
+// open.
+func (l *listener) admit(conn net.Conn) bool {
+	limit := int64(l.config.MaxConnections)
+	if limit > 0 && l.conns.Load() >= limit {
+		l.rejected.Add(1)
+		log.Printf("rejected connection from %v, %v connections already open\n", conn.RemoteAddr(), limit)
+		conn.Close()
+		return false
+	}
+
+	l.conns.Add(1)
+	return true
+}
 
-				request := &Request{conn, frame}
+// serveConn will read the requests from the connection given until it is
+// closed, as RTU frames if rtu is true and as TCP frames otherwise.
+func (s *Server) serveConn(listen *listener, conn net.Conn, rtu bool) {
+	defer listen.conns.Add(-1)
+	sess := s.openSession(listen.config.tune(conn))
+	defer s.closeSession(sess)
+
+	connected := sess.stats.Connected
+	var buffers connBuffers
+	var stream frameStream
+	var received time.Time
+	for {
+		packet := stream.next(buffers.packet(), rtu)
+		if packet == nil {
+			now := time.Now()
+			deadline := listen.config.readDeadline(connected, now)
+			// Wait for the rest of a frame only for a short while.
+			partial := false
+			if stream.n > 0 && (deadline.IsZero() || now.Add(frameTimeout).Before(deadline)) {
+				deadline = now.Add(frameTimeout)
+				partial = true
+			}
+			sess.SetReadDeadline(deadline)
+
+			bytesRead, err := sess.Read(stream.free())
+			received = time.Now()
+			switch {
+			case err == nil:
+				stream.n += bytesRead
+				continue
+			case partial && errors.Is(err, os.ErrDeadlineExceeded):
+				packet = stream.take(buffers.packet(), stream.n)
+			default:
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					sess.setReason(listen.config.closeReason(connected, received))
+				} else if err != io.EOF {
+					log.Printf("read error %v\n", err)
+				}
+				return
+			}
+		}
 
-				s.requestChan <- request
+		frame, err := buffers.frame(packet, rtu)
+		if err != nil {
+			if !s.handleMalformed(sess, packet, rtu, false, err) {
+				sess.setReason("malformed frame")
+				return
 			}
-		}(conn)
+			stream.reset()
+			continue
+		}
+
+		sess.request(frame.GetFunction())
+		if !s.limitRequest(sess) {
+			s.answerBusy(sess, frame)
+			continue
+		}
+
+		request := buffers.request(sess, frame, received)
+		request.mapUnit(listen.config.UnitMap)
+		s.requestChan <- request
 	}
 }
 
 // ListenTCP starts the Modbus server listening on "address:port".
 func (s *Server) ListenTCP(addressPort string) (err error) {
-	listen, err := net.Listen("tcp", addressPort)
+	return s.ListenTCPConfig(addressPort, ConnConfig{})
+}
+
+// ListenTCPConfig starts the Modbus server listening on "address:port",
+// with the settings given for the connections accepted.
+func (s *Server) ListenTCPConfig(addressPort string, config ConnConfig) error {
+	listen, err := s.listen(addressPort, config)
 	if err != nil {
-		log.Printf("Failed to Listen: %v\n", err)
 		return err
 	}
-	s.listeners = append(s.listeners, listen)
 	go s.accept(listen)
-	return err
+	return nil
+}
+
+// listen will start a listener of the server on "address:port", with the
+// settings given for the connections accepted.
+func (s *Server) listen(addressPort string, config ConnConfig) (*listener, error) {
+	lc := net.ListenConfig{KeepAlive: config.KeepAlive}
+	l, err := lc.Listen(context.Background(), "tcp", addressPort)
+	if err != nil {
+		log.Printf("Failed to Listen: %v\n", err)
+		return nil, err
+	}
+	listen := &listener{Listener: l, config: config}
+	s.listeners = append(s.listeners, listen)
+	return listen, nil
 }
 
 // -------------------------------------
@@ -65,18 +145,23 @@ func (s *Server) ListenTCP(addressPort string) (err error) {
 // ListenRTUTCP starts the Modbus server in RTU over TCP mode
 // listening on "address:port".
 func (s *Server) ListenRTUTCP(addressPort string) (err error) {
-	listen, err := net.Listen("tcp", addressPort)
+	return s.ListenRTUTCPConfig(addressPort, ConnConfig{})
+}
+
+// ListenRTUTCPConfig starts the Modbus server in RTU over TCP mode
+// listening on "address:port", with the settings given for the
+// connections accepted.
+func (s *Server) ListenRTUTCPConfig(addressPort string, config ConnConfig) error {
+	listen, err := s.listen(addressPort, config)
 	if err != nil {
-		log.Printf("Failed to Listen: %v\n", err)
 		return err
 	}
-	s.listeners = append(s.listeners, listen)
 	go s.acceptRTUTCP(listen)
-	return err
+	return nil
 }
 
-// accept will accept TCP connections.
-func (s *Server) acceptRTUTCP(listen net.Listener) error {
+// acceptRTUTCP will accept the TCP connections of RTU over TCP clients.
+func (s *Server) acceptRTUTCP(listen *listener) error {
 	for {
 		conn, err := listen.Accept()
 		if err != nil {
@@ -87,31 +172,9 @@ func (s *Server) acceptRTUTCP(listen net.Listener) error {
 			return err
 		}
 
-		go func(conn net.Conn) {
-			defer conn.Close()
-
-			for {
-				packet := make([]byte, 512)
-				bytesRead, err := conn.Read(packet)
-				if err != nil {
-					if err != io.EOF {
-						log.Printf("read error %v\n", err)
-					}
-					return
-				}
-				// Set the length of the packet to the number of read bytes.
-				packet = packet[:bytesRead]
-
-				frame, err := NewRTUFrame(packet)
-				if err != nil {
-					log.Printf("bad packet error %v\n", err)
-					return
-				}
-
-				request := &Request{conn, frame}
-
-				s.requestChan <- request
-			}
-		}(conn)
+		if !listen.admit(conn) {
+			continue
+		}
+		go s.serveConn(listen, conn, true)
 	}
 }
@@ -0,0 +1,163 @@
+package mbserver
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDPConfig holds the settings of an RTU over UDP listener, which can
+// simulate the lossy radio links of the gateways carrying RTU frames over
+// UDP.
+type UDPConfig struct {
+	// Duplicates is the number of extra copies sent of each response,
+	// like a radio gateway repeating its transmissions.
+	Duplicates int
+	// DropRequests is the fraction of the requests received, from 0 to 1,
+	// dropped without an answer, and DropResponses the fraction of the
+	// responses not sent, so both directions of a lossy link can be
+	// simulated.
+	DropRequests  float64
+	DropResponses float64
+	// Seed is the seed of the randomness of the drops, so a run can be
+	// reproduced. Zero uses the current time.
+	Seed int64
+	// UnitMap maps the unit IDs of the requests received to the unit IDs
+	// handling them, as for ConnConfig.
+	UnitMap map[uint8]uint8
+}
+
+// udpListener is an RTU over UDP listener of the server.
+type udpListener struct {
+	conn   *net.UDPConn
+	config UDPConfig
+	// mu guards rng, which is used both when reading the requests and
+	// when writing the responses.
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// drop will return true if a datagram should be dropped with the fraction
+// given.
+func (l *udpListener) drop(fraction float64) bool {
+	if fraction <= 0 {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rng.Float64() < fraction
+}
+
+// udpPeer is the client a request was received from on an RTU over UDP
+// listener, where each write is sent to the client as a datagram.
+type udpPeer struct {
+	listen *udpListener
+	addr   *net.UDPAddr
+}
+
+// Read is never used, since the requests are read by the listener.
+func (p *udpPeer) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+// Write will send the response to the client as a datagram, with the
+// duplicates of the listener, unless the response is dropped.
+func (p *udpPeer) Write(b []byte) (int, error) {
+	if p.listen.drop(p.listen.config.DropResponses) {
+		return len(b), nil
+	}
+
+	for i := 0; i <= p.listen.config.Duplicates; i++ {
+		_, err := p.listen.conn.WriteToUDP(b, p.addr)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Close does nothing, since the datagrams of all the clients share the
+// socket of the listener.
+func (p *udpPeer) Close() error {
+	return nil
+}
+
+// RemoteAddr returns the address of the client.
+func (p *udpPeer) RemoteAddr() net.Addr {
+	return p.addr
+}
+
+// ListenRTUUDP starts the Modbus server in RTU over UDP mode listening on
+// "address:port", where each datagram holds a single RTU frame.
+func (s *Server) ListenRTUUDP(addressPort string) error {
+	return s.ListenRTUUDPConfig(addressPort, UDPConfig{})
+}
+
+// ListenRTUUDPConfig starts the Modbus server in RTU over UDP mode
+// listening on "address:port", with the settings given. Each datagram
+// holds a single RTU frame, and the response is sent back to the address
+// the request came from.
+func (s *Server) ListenRTUUDPConfig(addressPort string, config UDPConfig) error {
+	addr, err := net.ResolveUDPAddr("udp", addressPort)
+	if err != nil {
+		log.Printf("Failed to Listen: %v\n", err)
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Printf("Failed to Listen: %v\n", err)
+		return err
+	}
+
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	listen := &udpListener{conn: conn, config: config, rng: rand.New(rand.NewSource(seed))}
+	s.udpListeners = append(s.udpListeners, listen)
+	go s.serveUDP(listen)
+	return nil
+}
+
+// serveUDP will read the requests of the RTU over UDP listener given
+// until it is closed. The rate limits and sessions of the server only
+// apply to the TCP connections.
+func (s *Server) serveUDP(listen *udpListener) {
+	var buffers connBuffers
+	var peers [2]udpPeer
+	for {
+		buffer := buffers.packet()
+
+		n, addr, err := listen.conn.ReadFromUDP(buffer)
+		received := time.Now()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("udp read error %v\n", err)
+			}
+			return
+		}
+		if listen.drop(listen.config.DropRequests) {
+			continue
+		}
+
+		peer := &peers[buffers.next]
+		*peer = udpPeer{listen: listen, addr: addr}
+
+		packet := buffer[:n]
+		frame, err := buffers.frame(packet, true)
+		if err != nil {
+			// There is no connection to close, so the frame is dropped.
+			s.handleMalformed(peer, packet, true, true, err)
+			continue
+		}
+
+		request := buffers.request(peer, frame, received)
+		request.mapUnit(listen.config.UnitMap)
+		s.requestChan <- request
+	}
+}
@@ -0,0 +1,126 @@
+package mbserver
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// udpResponses will send the RTU frame given to the server at the address
+// given, and return the datagrams received until the timeout.
+func udpResponses(t *testing.T, address string, frame *RTUFrame, timeout time.Duration) [][]byte {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(frame.Bytes())
+	if err != nil {
+		t.Fatalf("failed to send the request, got %v\n", err)
+	}
+
+	var responses [][]byte
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		packet := make([]byte, 512)
+		n, err := conn.Read(packet)
+		if err != nil {
+			return responses
+		}
+		responses = append(responses, packet[:n])
+	}
+}
+
+func TestRTUOverUDP(t *testing.T) {
+	s := NewServer()
+	s.HoldingRegisters[1] = 11
+	s.AddUnit(12).HoldingRegisters[1] = 12
+	err := s.ListenRTUUDPConfig("127.0.0.1:3348", UDPConfig{Duplicates: 1, UnitMap: map[uint8]uint8{2: 12}})
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	err = s.ListenRTUUDPConfig("127.0.0.1:3349", UDPConfig{DropRequests: 1})
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	err = s.ListenRTUUDPConfig("127.0.0.1:3350", UDPConfig{DropResponses: 1})
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	read := func(unit uint8) *RTUFrame {
+		frame := &RTUFrame{Address: unit, Function: 3}
+		SetDataWithRegisterAndNumber(frame, 1, 1)
+		return frame
+	}
+
+	// Each response is sent twice, with the unit ID of the request.
+	responses := udpResponses(t, "127.0.0.1:3348", read(2), time.Millisecond*200)
+	if len(responses) != 2 {
+		t.Fatalf("expected 2, got %v", len(responses))
+	}
+	for _, packet := range responses {
+		frame, err := NewRTUFrame(packet)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if frame.Address != 2 || !isEqual([]byte{2, 0, 12}, frame.Data) {
+			t.Errorf("expected unit 2 with %v, got unit %v with %v", []byte{2, 0, 12}, frame.Address, frame.Data)
+		}
+	}
+
+	// A malformed datagram is dropped without closing the listener.
+	conn, err := net.Dial("udp", "127.0.0.1:3348")
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	conn.Write([]byte{1, 3, 0})
+	conn.Close()
+	responses = udpResponses(t, "127.0.0.1:3348", read(1), time.Millisecond*200)
+	if len(responses) != 2 {
+		t.Fatalf("expected 2, got %v", len(responses))
+	}
+
+	// The requests or the responses are all dropped.
+	for _, address := range []string{"127.0.0.1:3349", "127.0.0.1:3350"} {
+		responses := udpResponses(t, address, read(1), time.Millisecond*200)
+		if len(responses) != 0 {
+			t.Errorf("%v: expected no responses, got %v", address, responses)
+		}
+	}
+
+	stats := s.MalformedStats()
+	if stats.Dropped != 1 {
+		t.Errorf("expected 1, got %v", stats.Dropped)
+	}
+}
+
+func TestRTUOverUDPTiming(t *testing.T) {
+	s := NewServer()
+	s.HoldingRegisters[1] = 11
+	s.RTUTiming = RTUTiming{BaudRate: 9600, CharDelay: time.Millisecond * 2}
+	err := s.ListenRTUUDP("127.0.0.1:3353")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	frame := &RTUFrame{Address: 1, Function: 3}
+	SetDataWithRegisterAndNumber(frame, 1, 1)
+
+	// The response is sent as a single datagram after the time of all
+	// its characters, instead of one datagram for each character.
+	responses := udpResponses(t, "127.0.0.1:3353", frame, time.Millisecond*200)
+	if len(responses) != 1 {
+		t.Fatalf("expected 1, got %v", len(responses))
+	}
+	response, err := NewRTUFrame(responses[0])
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if !isEqual([]byte{2, 0, 11}, response.Data) {
+		t.Errorf("expected %v, got %v", []byte{2, 0, 11}, response.Data)
+	}
+}
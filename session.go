@@ -0,0 +1,158 @@
+package mbserver
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionStats holds the statistics of a single TCP connection to the
+// server.
+type SessionStats struct {
+	// Remote is the address of the client.
+	Remote string
+	// Local is the address the client connected to.
+	Local string
+	// Connected is the time the connection was accepted.
+	Connected time.Time
+	// Disconnected is the time the connection was closed, and the zero
+	// time while the connection is open.
+	Disconnected time.Time
+	// Requests is the number of requests received by function code.
+	Requests map[uint8]int
+	// BytesRead and BytesWritten is the number of bytes received from
+	// and sent to the client.
+	BytesRead    int
+	BytesWritten int
+	// Reason is why the server closed the connection, which is "idle
+	// timeout" or "max session duration" for the limits of the ConnConfig
+	// of the listener, "malformed frame" for the Malformed policy of the
+	// server, and empty when the connection was closed by the client or
+	// failed.
+	Reason string
+}
+
+// session is a TCP connection to the server, counting the requests and
+// the bytes transferred.
+type session struct {
+	net.Conn
+	mu    sync.Mutex
+	stats SessionStats
+	// limit is the rate limit of the requests on the connection.
+	limit *tokenBucket
+}
+
+// Read reads from the connection, counting the bytes read.
+func (c *session) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	c.mu.Lock()
+	c.stats.BytesRead += n
+	c.mu.Unlock()
+
+	return n, err
+}
+
+// Write writes to the connection, counting the bytes written.
+func (c *session) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+
+	c.mu.Lock()
+	c.stats.BytesWritten += n
+	c.mu.Unlock()
+
+	return n, err
+}
+
+// setReason sets why the server closed the connection.
+func (c *session) setReason(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Reason = reason
+}
+
+// request counts a request with the function code given.
+func (c *session) request(function uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Requests[function]++
+}
+
+// Stats returns a copy of the current statistics of the session.
+func (c *session) Stats() SessionStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.stats
+	st.Requests = make(map[uint8]int, len(c.stats.Requests))
+	for fc, n := range c.stats.Requests {
+		st.Requests[fc] = n
+	}
+
+	return st
+}
+
+// openSession starts a session for the connection given, and calls the
+// session hook of the server if set.
+func (s *Server) openSession(conn net.Conn) *session {
+	sess := &session{
+		Conn: conn,
+		stats: SessionStats{
+			Remote:    conn.RemoteAddr().String(),
+			Local:     conn.LocalAddr().String(),
+			Connected: time.Now(),
+			Requests:  make(map[uint8]int),
+		},
+	}
+
+	s.sessionsMu.Lock()
+	if s.sessions == nil {
+		s.sessions = make(map[*session]bool)
+	}
+	s.sessions[sess] = true
+	s.sessionsMu.Unlock()
+
+	if s.SessionHook != nil {
+		s.SessionHook(sess.Stats())
+	}
+
+	return sess
+}
+
+// closeSession closes the connection of the session, and calls the
+// session hook of the server if set.
+func (s *Server) closeSession(sess *session) {
+	sess.Close()
+
+	sess.mu.Lock()
+	sess.stats.Disconnected = time.Now()
+	sess.mu.Unlock()
+
+	s.sessionsMu.Lock()
+	delete(s.sessions, sess)
+	s.sessionsMu.Unlock()
+
+	if s.SessionHook != nil {
+		s.SessionHook(sess.Stats())
+	}
+}
+
+// Sessions returns the statistics of the TCP connections currently open,
+// sorted by the time they were connected.
+func (s *Server) Sessions() []SessionStats {
+	s.sessionsMu.Lock()
+	var stats []SessionStats
+	for sess := range s.sessions {
+		stats = append(stats, sess.Stats())
+	}
+	s.sessionsMu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Connected.Before(stats[j].Connected)
+	})
+
+	return stats
+}
@@ -0,0 +1,112 @@
+package mbserver
+
+// RegisterStore holds the coils, discrete inputs, holding registers and
+// input registers of a server. The functions of the server read and
+// write the registers through the store, so the memory can be replaced
+// by another backend, like a sparse map, a database or a remote device,
+// without changing the handling of the protocol.
+//
+// The coils and discrete inputs are given with one byte for each coil,
+// which is 1 when the coil is on. An error that is an Exception, like
+// IllegalDataAddress, is answered with that exception, and any other
+// error is answered with SlaveDeviceFailure. The values given to the
+// writes, and the values returned by the reads, are only used until the
+// call returns and the response is built, so the buffers of the requests
+// can be reused.
+type RegisterStore interface {
+	ReadCoils(address int, quantity int) ([]byte, error)
+	ReadDiscreteInputs(address int, quantity int) ([]byte, error)
+	ReadHoldingRegisters(address int, quantity int) ([]uint16, error)
+	ReadInputRegisters(address int, quantity int) ([]uint16, error)
+	WriteCoils(address int, values []byte) error
+	WriteHoldingRegisters(address int, values []uint16) error
+}
+
+// InputStore is implemented by the register stores that can also write
+// the discrete inputs and input registers, which the clients can only
+// read, so the simulation can update them with ApplyUpdates.
+type InputStore interface {
+	WriteDiscreteInputs(address int, values []byte) error
+	WriteInputRegisters(address int, values []uint16) error
+}
+
+// store will return the register store of the server, which is the
+// memory of the server if no store is set.
+func (s *Server) store() RegisterStore {
+	if s.Store != nil {
+		return s.Store
+	}
+	return memoryStore{s}
+}
+
+// memoryStore is the default register store, holding the registers in
+// the DiscreteInputs, Coils, HoldingRegisters and InputRegisters fields
+// of the server.
+type memoryStore struct {
+	s *Server
+}
+
+// readRange will return the values from the address given, or
+// IllegalDataAddress if the range is outside the memory. The values are
+// the memory itself and not a copy, which is only read while the server
+// is locked. The memory is used up to its capacity, since the length can
+// be cut after the last register populated.
+func readRange[T byte | uint16](memory []T, address int, quantity int) ([]T, error) {
+	memory = memory[:cap(memory)]
+	if address < 0 || quantity < 0 || address+quantity > len(memory) {
+		return nil, IllegalDataAddress
+	}
+	return memory[address : address+quantity : address+quantity], nil
+}
+
+// writeRange will write the values to the address given, or return
+// IllegalDataAddress without writing if the range is outside the memory.
+func writeRange[T byte | uint16](memory []T, address int, values []T) error {
+	memory = memory[:cap(memory)]
+	if address < 0 || address+len(values) > len(memory) {
+		return IllegalDataAddress
+	}
+	copy(memory[address:], values)
+	return nil
+}
+
+func (m memoryStore) ReadCoils(address int, quantity int) ([]byte, error) {
+	return readRange(m.s.Coils, address, quantity)
+}
+
+func (m memoryStore) ReadDiscreteInputs(address int, quantity int) ([]byte, error) {
+	return readRange(m.s.DiscreteInputs, address, quantity)
+}
+
+func (m memoryStore) ReadHoldingRegisters(address int, quantity int) ([]uint16, error) {
+	return readRange(m.s.HoldingRegisters, address, quantity)
+}
+
+func (m memoryStore) ReadInputRegisters(address int, quantity int) ([]uint16, error) {
+	return readRange(m.s.InputRegisters, address, quantity)
+}
+
+func (m memoryStore) WriteCoils(address int, values []byte) error {
+	return writeRange(m.s.Coils, address, values)
+}
+
+func (m memoryStore) WriteHoldingRegisters(address int, values []uint16) error {
+	return writeRange(m.s.HoldingRegisters, address, values)
+}
+
+func (m memoryStore) WriteDiscreteInputs(address int, values []byte) error {
+	return writeRange(m.s.DiscreteInputs, address, values)
+}
+
+func (m memoryStore) WriteInputRegisters(address int, values []uint16) error {
+	return writeRange(m.s.InputRegisters, address, values)
+}
+
+// storeException will return the exception answered for the error
+// returned by a register store.
+func storeException(err error) *Exception {
+	if e, ok := err.(Exception); ok {
+		return &e
+	}
+	return &SlaveDeviceFailure
+}
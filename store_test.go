@@ -0,0 +1,104 @@
+package mbserver
+
+import (
+	"errors"
+	"testing"
+)
+
+// sparseStore is a register store holding only the holding registers
+// written, and failing for the other registers.
+type sparseStore struct {
+	holding map[int]uint16
+}
+
+func (m *sparseStore) ReadCoils(address int, quantity int) ([]byte, error) {
+	return nil, IllegalDataAddress
+}
+
+func (m *sparseStore) ReadDiscreteInputs(address int, quantity int) ([]byte, error) {
+	return nil, IllegalDataAddress
+}
+
+func (m *sparseStore) ReadHoldingRegisters(address int, quantity int) ([]uint16, error) {
+	values := make([]uint16, quantity)
+	for i := range values {
+		values[i] = m.holding[address+i]
+	}
+	return values, nil
+}
+
+func (m *sparseStore) ReadInputRegisters(address int, quantity int) ([]uint16, error) {
+	return nil, errors.New("backend unavailable")
+}
+
+func (m *sparseStore) WriteCoils(address int, values []byte) error {
+	return IllegalDataAddress
+}
+
+func (m *sparseStore) WriteHoldingRegisters(address int, values []uint16) error {
+	for i, v := range values {
+		m.holding[address+i] = v
+	}
+	return nil
+}
+
+func TestRegisterStore(t *testing.T) {
+	s := NewServer()
+	store := &sparseStore{holding: make(map[int]uint16)}
+	s.Store = store
+
+	var frame TCPFrame
+	frame.Device = 1
+	var req Request
+	req.frame = &frame
+
+	frame.Function = 16
+	SetDataWithRegisterAndNumberAndValues(&frame, 1000, 2, []uint16{3, 4})
+	response := s.handle(&req)
+	if exception := GetException(response); exception != Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	if store.holding[1000] != 3 || store.holding[1001] != 4 || s.HoldingRegisters[1000] != 0 {
+		t.Errorf("expected the write in the store only, got %v", store.holding)
+	}
+
+	frame.Function = 3
+	SetDataWithRegisterAndNumber(&frame, 1000, 2)
+	response = s.handle(&req)
+	expect := []byte{4, 0, 3, 0, 4}
+	if got := response.GetData(); !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// The errors of the store are answered as exceptions.
+	frame.Function = 1
+	response = s.handle(&req)
+	if exception := GetException(response); exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+	}
+	frame.Function = 4
+	response = s.handle(&req)
+	if exception := GetException(response); exception != SlaveDeviceFailure {
+		t.Errorf("expected SlaveDeviceFailure, got %v", exception.String())
+	}
+}
+
+func TestMemoryStoreCutMemory(t *testing.T) {
+	s := NewServer()
+	// The memory can be cut after the last register populated, and is
+	// still written up to its capacity.
+	s.HoldingRegisters = s.HoldingRegisters[:10]
+
+	err := s.store().WriteHoldingRegisters(100, []uint16{1})
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if got := s.HoldingRegisters[:cap(s.HoldingRegisters)][100]; got != 1 {
+		t.Errorf("expected 1, got %v", got)
+	}
+
+	err = s.store().WriteHoldingRegisters(65535, []uint16{1, 2})
+	if err != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package mbserver
+
+import (
+	"net"
+	"time"
+)
+
+// Transaction holds the timing of a single request handled by the
+// server, so the latency added by the server can be told apart from the
+// latency of the network.
+type Transaction struct {
+	// Remote is the address of the client, and empty for serial ports.
+	Remote string
+	// UnitID and Function is the unit ID and function code of the
+	// request.
+	UnitID   uint8
+	Function uint8
+	// Exception is the exception answered, or Success if the request
+	// was answered normally or not answered.
+	Exception Exception
+	// Answered is false for requests that are not answered, like
+	// broadcasts and requests to units that are offline.
+	Answered bool
+	// Received is the time the request was read from the connection.
+	Received time.Time
+	// Queue is the time from the request was received until it was
+	// handled, which includes the delay of the rate limits and the time
+	// waiting for the requests of other connections.
+	Queue time.Duration
+	// Processing is the time handling the request.
+	Processing time.Duration
+	// Total is the time from the request was received until the
+	// response was written, which also includes the simulated serial
+	// bus and RTU timing.
+	Total time.Duration
+}
+
+// transaction will return the transaction of the request, where handled
+// is the time the handling of the request started, and processed the
+// time it ended.
+func (request *Request) transaction(handled time.Time, processed time.Time, response Framer) Transaction {
+	t := Transaction{
+		UnitID:     request.frame.GetDevice(),
+		Function:   request.frame.GetFunction(),
+		Received:   request.received,
+		Queue:      handled.Sub(request.received),
+		Processing: processed.Sub(handled),
+		Total:      time.Since(request.received),
+	}
+	if c, ok := request.conn.(interface{ RemoteAddr() net.Addr }); ok {
+		t.Remote = c.RemoteAddr().String()
+	}
+	if response != nil {
+		t.Answered = true
+		t.Exception = GetException(response)
+	}
+
+	return t
+}
@@ -0,0 +1,58 @@
+package mbserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestTransactionHook(t *testing.T) {
+	s := NewServer()
+	s.SerialBus = RTUTiming{BaudRate: 9600}
+	transactions := make(chan Transaction, 2)
+	s.TransactionHook = func(tr Transaction) {
+		transactions <- tr
+	}
+	err := s.ListenTCP("127.0.0.1:3337")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	handler := modbus.NewTCPClientHandler("127.0.0.1:3337")
+	handler.SlaveId = 2
+	err = handler.Connect()
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+
+	_, err = client.ReadHoldingRegisters(0, 1)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	_, err = client.ReadHoldingRegisters(65535, 2)
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v\n", err)
+	}
+
+	for _, expect := range []Exception{Success, IllegalDataAddress} {
+		tr := <-transactions
+		if tr.UnitID != 2 || tr.Function != 3 || !tr.Answered || tr.Exception != expect {
+			t.Errorf("expected unit 2, function 3 answered with %v, got %+v", expect, tr)
+		}
+		if tr.Remote == "" {
+			t.Errorf("expected remote address, got %q", tr.Remote)
+		}
+		// The simulated serial bus is included in the total time.
+		busTime := s.SerialBus.busTime(&TCPFrame{Function: 3, Data: []byte{0, 0, 0, 1}}, nil)
+		if tr.Queue < 0 || tr.Processing < 0 || tr.Total < tr.Queue+tr.Processing+busTime {
+			t.Errorf("expected total of at least %v, got %+v", busTime, tr)
+		}
+		if time.Since(tr.Received) < tr.Total {
+			t.Errorf("expected received before the total time, got %+v", tr)
+		}
+	}
+}
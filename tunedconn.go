@@ -0,0 +1,161 @@
+package mbserver
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// tunedConnQueueSize is the number of writes waiting to be sent by the
+// writer of a tuned connection, before a write waits for the writer.
+const tunedConnQueueSize = 16
+
+// tunedConn is a connection whose writes are coalesced and split into
+// fragments with the settings of the ConnConfig of its listener, so the
+// frame reassembly of the clients can be tested. The bytes are sent by a
+// writer goroutine of the connection, so the FragmentDelay does not hold
+// the handler of the server, which is shared by all the connections.
+type tunedConn struct {
+	net.Conn
+	config ConnConfig
+	// mu guards pending, timer and closed, where pending is the bytes
+	// written but not sent yet, and timer sends them when the coalesce
+	// time is up.
+	mu      sync.Mutex
+	pending []byte
+	timer   *time.Timer
+	closed  bool
+	// errMu guards err, which is the first error of the writer, returned
+	// by the next write.
+	errMu sync.Mutex
+	err   error
+	// writes holds the bytes waiting to be sent by the writer, and done
+	// is closed when the writer has sent them all after Close.
+	writes chan []byte
+	done   chan struct{}
+}
+
+// tune will set the socket options of the ConnConfig on the connection
+// given, and return the connection wrapped in a tunedConn if its writes
+// are coalesced or split.
+func (c ConnConfig) tune(conn net.Conn) net.Conn {
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.SetNoDelay(!c.Nagle)
+	}
+	if c.Coalesce <= 0 && c.FragmentSize <= 0 {
+		return conn
+	}
+
+	tc := &tunedConn{
+		Conn:   conn,
+		config: c,
+		writes: make(chan []byte, tunedConnQueueSize),
+		done:   make(chan struct{}),
+	}
+	go tc.writer()
+	return tc
+}
+
+// Write will hand a copy of the bytes given to the writer, or hold them
+// until the coalesce time is up if the writes are coalesced. The error of
+// an earlier write by the writer is returned.
+func (c *tunedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	c.errMu.Lock()
+	err := c.err
+	c.errMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	if c.config.Coalesce <= 0 {
+		c.writes <- append([]byte(nil), b...)
+		return len(b), nil
+	}
+
+	c.pending = append(c.pending, b...)
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.config.Coalesce, c.flush)
+	}
+	return len(b), nil
+}
+
+// flush will hand the bytes held by the coalescing to the writer.
+func (c *tunedConn) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.timer = nil
+	if c.closed || len(c.pending) == 0 {
+		return
+	}
+	c.writes <- c.pending
+	c.pending = nil
+}
+
+// writer will send the bytes handed to it one write at a time, so the
+// fragments of each write are kept together.
+func (c *tunedConn) writer() {
+	defer close(c.done)
+	for b := range c.writes {
+		_, err := c.write(b)
+		if err != nil {
+			c.errMu.Lock()
+			if c.err == nil {
+				c.err = err
+			}
+			c.errMu.Unlock()
+		}
+	}
+}
+
+// write will send the bytes given in fragments of at most FragmentSize
+// bytes, waiting FragmentDelay between them. It is only called by the
+// writer.
+func (c *tunedConn) write(b []byte) (int, error) {
+	size := c.config.FragmentSize
+	if size <= 0 {
+		return c.Conn.Write(b)
+	}
+
+	n := 0
+	for n < len(b) {
+		if n > 0 && c.config.FragmentDelay > 0 {
+			time.Sleep(c.config.FragmentDelay)
+		}
+		m, err := c.Conn.Write(b[n:min(n+size, len(b))])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close will send the bytes held by the coalescing and the bytes waiting
+// for the writer before closing the connection.
+func (c *tunedConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if len(c.pending) > 0 {
+		c.writes <- c.pending
+		c.pending = nil
+	}
+	c.closed = true
+	close(c.writes)
+	c.mu.Unlock()
+
+	<-c.done
+	return c.Conn.Close()
+}